@@ -94,6 +94,11 @@ func CheckInitialSettings() error {
 		return pkgerrors.Cause(err)
 	}
 
+	err = RecoverOperations()
+	if err != nil {
+		return pkgerrors.Cause(err)
+	}
+
 	err = LoadPlugins()
 	if err != nil {
 		return pkgerrors.Cause(err)
@@ -111,9 +116,26 @@ func NewRouter(kubeconfig string) (s *mux.Router) {
 	vnfInstanceHandler.HandleFunc("/{cloudRegionID}/{namespace}", ListHandler).Methods("GET")
 	vnfInstanceHandler.HandleFunc("/{cloudRegionID}/{namespace}/{externalVNFID}", DeleteHandler).Methods("DELETE")
 	vnfInstanceHandler.HandleFunc("/{cloudRegionID}/{namespace}/{externalVNFID}", GetHandler).Methods("GET")
-
-	// (TODO): Fix update method
-	// vnfInstanceHandler.HandleFunc("/{vnfInstanceId}", UpdateHandler).Methods("PUT")
+	vnfInstanceHandler.HandleFunc("/{cloudRegionID}/{namespace}/{externalVNFID}", UpdateHandler).Methods("PUT")
+	vnfInstanceHandler.HandleFunc("/{cloudRegionID}/{namespace}/{externalVNFID}/status", StatusHandler).Methods("GET")
+
+	csarHandler := router.PathPrefix("/v1/csars").Subrouter()
+	csarHandler.HandleFunc("", UploadCsarHandler).Methods("POST")
+	csarHandler.HandleFunc("", ListCsarsHandler).Methods("GET")
+	csarHandler.HandleFunc("/{csarID}", GetCsarHandler).Methods("GET")
+	csarHandler.HandleFunc("/{csarID}", DeleteCsarHandler).Methods("DELETE")
+	csarHandler.HandleFunc("/{csarID}/validate", ValidateCsarHandler).Methods("POST")
+
+	operationHandler := router.PathPrefix("/v1/operations").Subrouter()
+	operationHandler.HandleFunc("/{operationID}", GetOperationHandler).Methods("GET")
+
+	driftHandler := router.PathPrefix("/v1/drift_reports").Subrouter()
+	driftHandler.HandleFunc("/{cloudRegionID}", DriftReportHandler).Methods("GET")
+	driftHandler.HandleFunc("/{cloudRegionID}/heal", HealDriftHandler).Methods("POST")
+
+	adminHandler := router.PathPrefix("/v1/admin").Subrouter()
+	adminHandler.HandleFunc("/gc", GarbageCollectionHandler).Methods("POST")
+	adminHandler.HandleFunc("/retained_volumes/{cloudRegionID}", RetainedVolumesHandler).Methods("GET")
 
 	return router
 }
@@ -0,0 +1,313 @@
+/*
+Copyright 2018 Intel Corporation.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+	pkgerrors "github.com/pkg/errors"
+	"k8s.io/apimachinery/pkg/util/uuid"
+
+	"k8-plugin-multicloud/csar"
+	"k8-plugin-multicloud/db"
+)
+
+// csarKeyPrefix is prepended to the CSAR ID to build the database key of its CsarRecord
+const csarKeyPrefix = "csars/"
+
+// maxCsarMemory is the part of an uploaded CSAR kept in memory, the rest is
+// buffered in temporary files
+const maxCsarMemory = 32 << 20
+
+func saveCsarRecord(record CsarRecord) error {
+	out, err := json.Marshal(record)
+	if err != nil {
+		return pkgerrors.Wrap(err, "Serialize CSAR record error")
+	}
+
+	return db.DBconn.CreateEntry(csarKeyPrefix+record.CsarID, string(out))
+}
+
+func readCsarRecord(csarID string) (CsarRecord, bool, error) {
+	var record CsarRecord
+
+	value, found, err := db.DBconn.ReadEntry(csarKeyPrefix + csarID)
+	if err != nil || found == false {
+		return record, found, err
+	}
+
+	err = json.Unmarshal([]byte(value), &record)
+	if err != nil {
+		return record, true, pkgerrors.Wrap(err, "Deserialize CSAR record error")
+	}
+
+	return record, true, nil
+}
+
+// UploadCsarHandler stores the CSAR archive sent in the "file" field of a
+// multipart form under CSAR_DIR and records it in the database
+func UploadCsarHandler(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseMultipartForm(maxCsarMemory)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	file, header, err := r.FormFile("file")
+	if err != nil {
+		werr := pkgerrors.Wrap(err, "CSAR file missing")
+		http.Error(w, werr.Error(), http.StatusBadRequest)
+		return
+	}
+	defer file.Close()
+
+	csarID := string(uuid.NewUUID())
+
+	size, err := csar.StorePackage(csarID, file)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	csarPackage, err := csar.OpenPackage(csarID)
+	if err == nil {
+		_, err = csarPackage.Metadata()
+		csarPackage.Close()
+	}
+	if err != nil {
+		csar.RemovePackage(csarID)
+		http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+		return
+	}
+
+	record := CsarRecord{
+		CsarID:     csarID,
+		Name:       header.Filename,
+		Size:       size,
+		UploadTime: time.Now().UTC().Format(time.RFC3339),
+	}
+
+	err = saveCsarRecord(record)
+	if err != nil {
+		csar.RemovePackage(csarID)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Location", "/v1/csars/"+csarID)
+	w.WriteHeader(http.StatusCreated)
+
+	err = json.NewEncoder(w).Encode(record)
+	if err != nil {
+		werr := pkgerrors.Wrap(err, "Parsing output of uploaded CSAR error")
+		http.Error(w, werr.Error(), http.StatusInternalServerError)
+	}
+}
+
+// ListCsarsHandler returns the records of all the uploaded CSARs
+func ListCsarsHandler(w http.ResponseWriter, r *http.Request) {
+	keys, err := db.DBconn.ReadAll(csarKeyPrefix)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	resp := ListCsarsResponse{
+		Csars: []CsarRecord{},
+	}
+	for _, key := range keys {
+		if !strings.HasPrefix(key, csarKeyPrefix) {
+			continue
+		}
+
+		record, found, err := readCsarRecord(key[len(csarKeyPrefix):])
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		if found {
+			resp.Csars = append(resp.Csars, record)
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+
+	err = json.NewEncoder(w).Encode(resp)
+	if err != nil {
+		werr := pkgerrors.Wrap(err, "Parsing output CSAR list error")
+		http.Error(w, werr.Error(), http.StatusInternalServerError)
+	}
+}
+
+// GetCsarHandler returns an uploaded CSAR with its parsed metadata and files
+func GetCsarHandler(w http.ResponseWriter, r *http.Request) {
+	csarID := mux.Vars(r)["csarID"]
+
+	record, found, err := readCsarRecord(csarID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	if found == false {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	csarPackage, err := csar.OpenPackage(csarID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer csarPackage.Close()
+
+	seqFile, err := csarPackage.Metadata()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	files, err := csarPackage.Files()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	resp := GetCsarResponse{
+		CsarRecord: record,
+		Resources:  []CsarResource{},
+		Files:      files,
+	}
+	for _, resource := range seqFile.Resources {
+		resp.Resources = append(resp.Resources, CsarResource{
+			Name:       resource.Name,
+			Type:       resource.Type,
+			Files:      resource.Files,
+			Chart:      resource.Chart,
+			ValuesFile: resource.ValuesFile,
+			DataFiles:  resource.DataFiles,
+			DependsOn:  resource.DependsOn,
+		})
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+
+	err = json.NewEncoder(w).Encode(resp)
+	if err != nil {
+		werr := pkgerrors.Wrap(err, "Parsing output of CSAR error")
+		http.Error(w, werr.Error(), http.StatusInternalServerError)
+	}
+}
+
+// ValidateCsarHandler checks an uploaded CSAR and returns every problem found
+func ValidateCsarHandler(w http.ResponseWriter, r *http.Request) {
+	csarID := mux.Vars(r)["csarID"]
+
+	_, found, err := readCsarRecord(csarID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	if found == false {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	problems := csar.ValidateCSAR(csarID)
+
+	resp := ValidateCsarResponse{
+		CsarID:   csarID,
+		Valid:    len(problems) == 0,
+		Problems: []string{},
+	}
+	resp.Problems = append(resp.Problems, problems...)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+
+	err = json.NewEncoder(w).Encode(resp)
+	if err != nil {
+		werr := pkgerrors.Wrap(err, "Parsing output of CSAR validation error")
+		http.Error(w, werr.Error(), http.StatusInternalServerError)
+	}
+}
+
+// DeleteCsarHandler removes an uploaded CSAR unless a VNF instance or a pending
+// or running operation uses it
+func DeleteCsarHandler(w http.ResponseWriter, r *http.Request) {
+	csarID := mux.Vars(r)["csarID"]
+
+	_, found, err := readCsarRecord(csarID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	if found == false {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	// No operation starts using the CSAR while it is removed
+	csarUsersMutex.Lock()
+	defer csarUsersMutex.Unlock()
+
+	if csarUsers[csarID] > 0 {
+		http.Error(w, "CSAR "+csarID+" is used by pending or running operations", http.StatusConflict)
+		return
+	}
+
+	records, err := readAllVNFRecords()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	var users []string
+	for _, record := range records {
+		if record.CsarID == csarID {
+			users = append(users, record.CloudRegionID+"/"+record.Namespace+"/"+record.VNFID)
+		}
+	}
+	if len(users) > 0 {
+		sort.Strings(users)
+		http.Error(w, "CSAR "+csarID+" is used by the VNF instances "+strings.Join(users, ", "),
+			http.StatusConflict)
+		return
+	}
+
+	err = csar.RemovePackage(csarID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	err = db.DBconn.DeleteEntry(csarKeyPrefix + csarID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
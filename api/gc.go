@@ -0,0 +1,302 @@
+/*
+Copyright 2018 Intel Corporation.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/mux"
+	pkgerrors "github.com/pkg/errors"
+	metaV1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+
+	"k8-plugin-multicloud/db"
+	"k8-plugin-multicloud/krd"
+)
+
+// gcGracePeriod protects recently created resources whose VNF creation may
+// still be in progress, and so not recorded in the database yet
+var gcGracePeriod = 30 * time.Minute
+
+// StartGarbageCollectors starts a background garbage collector for every cloud
+// region with a kubeconfig file in KUBE_CONFIG_DIR. The collectors run every
+// GC_INTERVAL seconds, they are disabled when the variable is not set.
+func StartGarbageCollectors() error {
+	value, ok := os.LookupEnv("GC_INTERVAL")
+	if !ok {
+		return nil
+	}
+
+	seconds, err := strconv.Atoi(value)
+	if err != nil || seconds <= 0 {
+		return pkgerrors.New("GC_INTERVAL must be a positive number of seconds")
+	}
+
+	cloudRegionIDs, err := listCloudRegions()
+	if err != nil {
+		return err
+	}
+
+	for _, cloudRegionID := range cloudRegionIDs {
+		log.Println("Starting garbage collector for cloud region " + cloudRegionID)
+		go runGarbageCollector(cloudRegionID, time.Duration(seconds)*time.Second)
+	}
+
+	return nil
+}
+
+func runGarbageCollector(cloudRegionID string, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		_, err := collectGarbage(cloudRegionID, false)
+		if err != nil {
+			log.Println("Garbage collection of cloud region " + cloudRegionID + " failed: " + err.Error())
+		}
+	}
+}
+
+// collectGarbage finds the resources labeled as created by the plugin in a
+// cloud region whose VNF has no database record and deletes them unless dryRun
+var collectGarbage = func(cloudRegionID string, dryRun bool) (GarbageCollectionReport, error) {
+	report := GarbageCollectionReport{
+		CloudRegionID: cloudRegionID,
+		DryRun:        dryRun,
+		Orphans:       []OrphanedResource{},
+	}
+
+	kubeclient, err := GetVNFClient(os.Getenv("KUBE_CONFIG_DIR") + "/" + cloudRegionID)
+	if err != nil {
+		return report, err
+	}
+
+	// Sorted to produce a stable report
+	var resourceTypes []string
+	for resourceType := range krd.LoadedPlugins {
+		resourceTypes = append(resourceTypes, resourceType)
+	}
+	sort.Strings(resourceTypes)
+
+	for _, resourceType := range resourceTypes {
+		typePlugin := krd.LoadedPlugins[resourceType]
+
+		symListOwnedFunc, err := typePlugin.Lookup("ListOwnedResources")
+		if err != nil {
+			// The plugin does not create labeled resources
+			continue
+		}
+
+		symDeleteResourceFunc, err := typePlugin.Lookup("DeleteResource")
+		if err != nil {
+			return report, pkgerrors.Wrap(err, "Error fetching "+resourceType+" plugin")
+		}
+
+		items, err := symListOwnedFunc.(func(*kubernetes.Clientset) ([]metaV1.ObjectMeta, error))(&kubeclient)
+		if err != nil {
+			return report, pkgerrors.Wrap(err, "Error in plugin "+resourceType+" plugin")
+		}
+
+		for _, item := range items {
+			orphan, err := isOrphaned(item, cloudRegionID)
+			if err != nil {
+				return report, err
+			}
+			if !orphan {
+				continue
+			}
+
+			resource := OrphanedResource{
+				ResourceType: resourceType,
+				Name:         item.Name,
+				Namespace:    item.Namespace,
+				VNFID:        item.Labels[krd.VNFIDLabel],
+			}
+
+			if !dryRun {
+				log.Println("Deleting orphaned resource: " + item.Name)
+				err = symDeleteResourceFunc.(func(string, string, *kubernetes.Clientset) error)(
+					item.Name, item.Namespace, &kubeclient)
+				if err != nil {
+					resource.Error = err.Error()
+				} else {
+					resource.Deleted = true
+				}
+			}
+
+			report.Orphans = append(report.Orphans, resource)
+		}
+	}
+
+	return report, nil
+}
+
+// isOrphaned checks if a resource labeled by the plugin belongs to a VNF of the
+// cloud region which has no database record
+func isOrphaned(item metaV1.ObjectMeta, cloudRegionID string) (bool, error) {
+	if item.Labels[krd.CloudRegionIDLabel] != cloudRegionID {
+		return false, nil
+	}
+
+	if time.Since(item.CreationTimestamp.Time) < gcGracePeriod {
+		return false, nil
+	}
+
+	return isVNFDeleted(item, cloudRegionID)
+}
+
+// isVNFDeleted checks if the VNF labeled on a resource has no database record.
+// Resources which are not namespaced are matched with the annotated internal VNF ID.
+func isVNFDeleted(item metaV1.ObjectMeta, cloudRegionID string) (bool, error) {
+	// cloud1-default-uuid
+	internalVNFID := item.Annotations[krd.InternalVNFIDAnnotation]
+	if internalVNFID == "" {
+		internalVNFID = cloudRegionID + "-" + item.Namespace + "-" + item.Labels[krd.VNFIDLabel]
+	}
+
+	_, found, err := db.DBconn.ReadEntry(internalVNFID)
+	if err != nil {
+		return false, pkgerrors.Wrap(err, "Read VNF error")
+	}
+
+	return found == false, nil
+}
+
+// listRetainedVolumes finds the volumes kept in a cloud region by plugins
+// exporting ListRetainedResources whose VNF has no database record anymore
+var listRetainedVolumes = func(cloudRegionID string) (RetainedVolumesReport, error) {
+	report := RetainedVolumesReport{
+		CloudRegionID: cloudRegionID,
+		Volumes:       []RetainedVolume{},
+	}
+
+	kubeclient, err := GetVNFClient(os.Getenv("KUBE_CONFIG_DIR") + "/" + cloudRegionID)
+	if err != nil {
+		return report, err
+	}
+
+	// Sorted to produce a stable report
+	var resourceTypes []string
+	for resourceType := range krd.LoadedPlugins {
+		resourceTypes = append(resourceTypes, resourceType)
+	}
+	sort.Strings(resourceTypes)
+
+	for _, resourceType := range resourceTypes {
+		symListRetainedFunc, err := krd.LoadedPlugins[resourceType].Lookup("ListRetainedResources")
+		if err != nil {
+			// The plugin does not retain resources
+			continue
+		}
+
+		items, err := symListRetainedFunc.(func(*kubernetes.Clientset) ([]metaV1.ObjectMeta, error))(&kubeclient)
+		if err != nil {
+			return report, pkgerrors.Wrap(err, "Error in plugin "+resourceType+" plugin")
+		}
+
+		for _, item := range items {
+			if item.Labels[krd.CloudRegionIDLabel] != cloudRegionID {
+				continue
+			}
+
+			deleted, err := isVNFDeleted(item, cloudRegionID)
+			if err != nil {
+				return report, err
+			}
+			if !deleted {
+				continue
+			}
+
+			report.Volumes = append(report.Volumes, RetainedVolume{
+				ResourceType: resourceType,
+				Name:         item.Name,
+				Namespace:    item.Namespace,
+				VNFID:        item.Labels[krd.VNFIDLabel],
+				CsarID:       item.Labels[krd.CSARIDLabel],
+				ReleasedAt:   item.Annotations[krd.ReleasedAnnotation],
+			})
+		}
+	}
+
+	return report, nil
+}
+
+// GarbageCollectionHandler lists, and deletes unless dry_run is set, the
+// resources created by the plugin whose VNF has no database record
+func GarbageCollectionHandler(w http.ResponseWriter, r *http.Request) {
+	var resource GarbageCollectionRequest
+
+	if r.Body == nil {
+		http.Error(w, "Body empty", http.StatusBadRequest)
+		return
+	}
+
+	err := json.NewDecoder(r.Body).Decode(&resource)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+		return
+	}
+
+	if resource.CloudRegionID == "" {
+		werr := pkgerrors.Wrap(errors.New("Invalid/Missing CloudRegionID in POST request"), "GarbageCollectionRequest bad request")
+		http.Error(w, werr.Error(), http.StatusUnprocessableEntity)
+		return
+	}
+
+	report, err := collectGarbage(resource.CloudRegionID, resource.DryRun)
+	if err != nil {
+		werr := pkgerrors.Wrap(err, "Garbage collection error")
+		http.Error(w, werr.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+
+	err = json.NewEncoder(w).Encode(report)
+	if err != nil {
+		werr := pkgerrors.Wrap(err, "Parsing output of garbage collection error")
+		http.Error(w, werr.Error(), http.StatusInternalServerError)
+	}
+}
+
+// RetainedVolumesHandler lists the volumes kept in a cloud region after the
+// deletion of their VNF
+func RetainedVolumesHandler(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	cloudRegionID := vars["cloudRegionID"]
+
+	report, err := listRetainedVolumes(cloudRegionID)
+	if err != nil {
+		werr := pkgerrors.Wrap(err, "Retained volumes error")
+		http.Error(w, werr.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+
+	err = json.NewEncoder(w).Encode(report)
+	if err != nil {
+		werr := pkgerrors.Wrap(err, "Parsing output of retained volumes error")
+		http.Error(w, werr.Error(), http.StatusInternalServerError)
+	}
+}
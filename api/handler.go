@@ -19,11 +19,12 @@ import (
 	"log"
 	"net/http"
 	"os"
+	"sort"
 	"strings"
+	"time"
 
 	"github.com/gorilla/mux"
 	pkgerrors "github.com/pkg/errors"
-	// "k8s.io/apimachinery/pkg/types"
 	"k8s.io/client-go/kubernetes"
 
 	"k8-plugin-multicloud/csar"
@@ -31,6 +32,10 @@ import (
 	"k8-plugin-multicloud/krd"
 )
 
+// defaultWaitTimeout is the time to wait for the VNF components to be ready when
+// the request does not specify it
+const defaultWaitTimeout = 5 * time.Minute
+
 // GetVNFClient retrieve the client used to communicate with a Kubernetes Cluster
 var GetVNFClient = func(kubeConfigPath string) (kubernetes.Clientset, error) {
 	client, err := krd.GetKubeClient(kubeConfigPath)
@@ -40,6 +45,33 @@ var GetVNFClient = func(kubeConfigPath string) (kubernetes.Clientset, error) {
 	return client, nil
 }
 
+// instanceParameters returns the values the CSAR templates of a VNF are rendered with
+func instanceParameters(inputs map[string]interface{}, oofParams []map[string]interface{},
+	networkParams NetworkParameters) csar.InstanceParameters {
+
+	var networks []csar.NetworkAttachment
+	for _, network := range networkParams.Networks {
+		networks = append(networks, csar.NetworkAttachment{
+			Name:         network.Name,
+			Interface:    network.Interface,
+			IPAddress:    network.IPAddress,
+			MACAddress:   network.MACAddress,
+			WorkloadName: network.WorkloadName,
+		})
+	}
+
+	return csar.InstanceParameters{
+		Inputs:    inputs,
+		OOFParams: oofParams,
+		OAMIP: csar.OAMIPAddress{
+			ConnectionPoint: networkParams.OAMI.ConnectionPoint,
+			IPAddress:       networkParams.OAMI.IPAddress,
+			WorkloadName:    networkParams.OAMI.WorkLoadName,
+		},
+		Networks: networks,
+	}
+}
+
 func validateBody(body interface{}) error {
 	switch b := body.(type) {
 	case CreateVnfRequest:
@@ -56,8 +88,8 @@ func validateBody(body interface{}) error {
 			return werr
 		}
 	case UpdateVnfRequest:
-		if b.CloudRegionID == "" || b.CsarID == "" {
-			werr := pkgerrors.Wrap(errors.New("Invalid/Missing Data in PUT request"), "UpdateVnfRequest bad request")
+		if b.CsarID == "" {
+			werr := pkgerrors.Wrap(errors.New("Invalid/Missing CsarID in PUT request"), "UpdateVnfRequest bad request")
 			return werr
 		}
 	}
@@ -85,6 +117,21 @@ func CreateHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	err = csar.CheckInputs(resource.CsarID, resource.Inputs)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+		return
+	}
+
+	params := instanceParameters(resource.Inputs, resource.OOFParams, resource.NetworkParams)
+
+	recordParams, redactedInputs, err := redactParameters(resource.CsarID, params)
+	if err != nil {
+		werr := pkgerrors.Wrap(err, "Create VNF deployment error")
+		http.Error(w, werr.Error(), http.StatusInternalServerError)
+		return
+	}
+
 	// (TODO): Read kubeconfig for specific Cloud Region from local file system
 	// if present or download it from AAI
 	// err := DownloadKubeConfigFromAAI(resource.CloudRegionID, os.Getenv("KUBE_CONFIG_DIR")
@@ -94,63 +141,110 @@ func CreateHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	/*
-		uuid,
-		{
-			"deployment": ["cloud1-default-uuid-sisedeploy1", "cloud1-default-uuid-sisedeploy2", ... ]
-			"service": ["cloud1-default-uuid-sisesvc1", "cloud1-default-uuid-sisesvc2", ... ]
-		},
-		nil
-	*/
-	externalVNFID, resourceNameMap, err := csar.CreateVNF(resource.CsarID, resource.CloudRegionID, resource.Namespace, &kubeclient)
+	t, err := newOperation(OperationCreate, resource.CloudRegionID, resource.Namespace, "")
 	if err != nil {
-		werr := pkgerrors.Wrap(err, "Read Kubernetes Data information error")
-		http.Error(w, werr.Error(), http.StatusInternalServerError)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
+	t.useCsar(resource.CsarID)
+
+	runOperation(t, func(t *operationTracker) error {
+		/*
+			uuid,
+			{
+				"deployment": ["cloud1-default-uuid-sisedeploy1", "cloud1-default-uuid-sisedeploy2", ... ]
+				"service": ["cloud1-default-uuid-sisesvc1", "cloud1-default-uuid-sisesvc2", ... ]
+			},
+			nil
+		*/
+		externalVNFID, resourceNameMap, dependencies, objects, err := csar.CreateVNF(resource.CsarID, resource.CloudRegionID, resource.Namespace,
+			params, t.progress, &kubeclient)
+		if err != nil {
+			return pkgerrors.Wrap(err, "Read Kubernetes Data information error")
+		}
 
-	// cloud1-default-uuid
-	internalVNFID := resource.CloudRegionID + "-" + resource.Namespace + "-" + externalVNFID
+		// cloud1-default-uuid
+		internalVNFID := resource.CloudRegionID + "-" + resource.Namespace + "-" + externalVNFID
 
-	// Persist in AAI database.
-	log.Printf("Cloud Region ID: %s, Namespace: %s, VNF ID: %s ", resource.CloudRegionID, resource.Namespace, externalVNFID)
+		// The VNF is not healed nor changed by other operations until it is persisted
+		t.lockVNF(internalVNFID)
 
-	// krd.AddNetworkAnnotationsToPod(kubeData, resource.Networks)
+		// Persist in AAI database.
+		log.Printf("Cloud Region ID: %s, Namespace: %s, VNF ID: %s ", resource.CloudRegionID, resource.Namespace, externalVNFID)
 
-	// "{"deployment":<>,"service":<>}"
-	out, err := json.Marshal(resourceNameMap)
-	if err != nil {
-		werr := pkgerrors.Wrap(err, "Create VNF deployment error")
-		http.Error(w, werr.Error(), http.StatusInternalServerError)
-		return
-	}
-	serializedResourceNameMap := string(out)
-	log.Println(serializedResourceNameMap)
+		// "{"deployment":<>,"service":<>}"
+		out, err := json.Marshal(resourceNameMap)
+		if err != nil {
+			return pkgerrors.Wrap(err, "Create VNF deployment error")
+		}
+		serializedResourceNameMap := string(out)
+		log.Println(serializedResourceNameMap)
+
+		// key: cloud1-default-uuid
+		// value: "{"deployment":<>,"service":<>}"
+		err = db.DBconn.CreateEntry(internalVNFID, serializedResourceNameMap)
+		if err != nil {
+			return pkgerrors.Wrap(err, "Create VNF deployment error")
+		}
 
-	// key: cloud1-default-uuid
-	// value: "{"deployment":<>,"service":<>}"
-	err = db.DBconn.CreateEntry(internalVNFID, serializedResourceNameMap)
-	if err != nil {
-		werr := pkgerrors.Wrap(err, "Create VNF deployment error")
-		http.Error(w, werr.Error(), http.StatusInternalServerError)
-		return
-	}
+		err = saveVNFRecord(VNFRecord{
+			VNFID:          externalVNFID,
+			CloudRegionID:  resource.CloudRegionID,
+			Namespace:      resource.Namespace,
+			CsarID:         resource.CsarID,
+			Dependencies:   dependencies,
+			Parameters:     recordParams,
+			RedactedInputs: redactedInputs,
+		})
+		if err != nil {
+			return pkgerrors.Wrap(err, "Create VNF deployment error")
+		}
 
-	resp := CreateVnfResponse{
-		VNFID:         externalVNFID,
-		CloudRegionID: resource.CloudRegionID,
-		Namespace:     resource.Namespace,
-		VNFComponents: resourceNameMap,
-	}
+		t.update(func(op *Operation) {
+			op.VNFID = externalVNFID
+			op.VNFComponents = resourceNameMap
+		})
 
-	w.Header().Set("Content-Type", "application/json")
-	w.WriteHeader(http.StatusCreated)
+		if resource.Wait == false {
+			return nil
+		}
 
-	err = json.NewEncoder(w).Encode(resp)
-	if err != nil {
-		werr := pkgerrors.Wrap(err, "Parsing output of new VNF error")
-		http.Error(w, werr.Error(), http.StatusInternalServerError)
-	}
+		timeout := defaultWaitTimeout
+		if resource.WaitTimeout > 0 {
+			timeout = time.Duration(resource.WaitTimeout) * time.Second
+		}
+
+		readiness, err := csar.WaitForVNF(resource.CsarID, objects, resource.Namespace, timeout, &kubeclient)
+		if err != nil {
+			return pkgerrors.Wrap(err, "Wait for VNF error")
+		}
+
+		t.update(func(op *Operation) {
+			op.Readiness = readiness
+		})
+
+		var failed, notReady []string
+		for name, state := range readiness {
+			switch state {
+			case csar.ResourceFailed:
+				failed = append(failed, name)
+			case csar.ResourceNotReady:
+				notReady = append(notReady, name)
+			}
+		}
+		if len(failed) > 0 {
+			sort.Strings(failed)
+			return pkgerrors.New("VNF components failed: " + strings.Join(failed, ", "))
+		}
+		if len(notReady) > 0 {
+			sort.Strings(notReady)
+			return pkgerrors.New("VNF components not ready before timeout: " + strings.Join(notReady, ", "))
+		}
+
+		return nil
+	})
+
+	writeOperationAccepted(w, t)
 }
 
 // ListHandler the existing VNF instances created in a given Kubernetes cluster
@@ -224,11 +318,10 @@ func DeleteHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// key: cloud1-default-uuid
-	// value: "{"deployment":<>,"service":<>}"
-	serializedResourceNameMap, found, err := db.DBconn.ReadEntry(internalVNFID)
+	_, _, found, err := readVNFResources(internalVNFID)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		werr := pkgerrors.Wrap(err, "Delete VNF error")
+		http.Error(w, werr.Error(), http.StatusInternalServerError)
 		return
 	}
 
@@ -237,106 +330,163 @@ func DeleteHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	/*
-		{
-			"deployment": ["cloud1-default-uuid-sisedeploy1", "cloud1-default-uuid-sisedeploy2", ... ]
-			"service": ["cloud1-default-uuid-sisesvc1", "cloud1-default-uuid-sisesvc2", ... ]
-		},
-	*/
-	deserializedResourceNameMap := make(map[string][]string)
-	err = json.Unmarshal([]byte(serializedResourceNameMap), &deserializedResourceNameMap)
+	t, err := newOperation(OperationDelete, cloudRegionID, namespace, externalVNFID)
 	if err != nil {
-		werr := pkgerrors.Wrap(err, "Delete VNF error")
-		http.Error(w, werr.Error(), http.StatusInternalServerError)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
 
-	err = csar.DestroyVNF(deserializedResourceNameMap, namespace, &kubeclient)
+	runOperation(t, func(t *operationTracker) error {
+		// The VNF may have been changed by another operation in the meantime
+		deserializedResourceNameMap, record, found, err := readVNFResources(internalVNFID)
+		if err != nil {
+			return pkgerrors.Wrap(err, "Delete VNF error")
+		}
+		if found == false {
+			return pkgerrors.New("Delete VNF error: VNF " + internalVNFID + " not found")
+		}
+
+		err = csar.DestroyVNF(deserializedResourceNameMap, record.Dependencies, namespace, t.progress, &kubeclient)
+		if err != nil {
+			return pkgerrors.Wrap(err, "Delete VNF error")
+		}
+
+		err = db.DBconn.DeleteEntry(internalVNFID)
+		if err != nil {
+			return pkgerrors.Wrap(err, "Delete VNF error")
+		}
+
+		err = deleteVNFRecord(internalVNFID)
+		if err != nil {
+			return pkgerrors.Wrap(err, "Delete VNF error")
+		}
+
+		return nil
+	})
+
+	writeOperationAccepted(w, t)
+}
+
+// UpdateHandler method re-applies a CSAR to an existing VNF instance keeping its VNF ID.
+func UpdateHandler(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+
+	cloudRegionID := vars["cloudRegionID"] // cloud1
+	namespace := vars["namespace"]         // default
+	externalVNFID := vars["externalVNFID"] // uuid
+
+	var resource UpdateVnfRequest
+
+	if r.Body == nil {
+		http.Error(w, "Body empty", http.StatusBadRequest)
+		return
+	}
+
+	err := json.NewDecoder(r.Body).Decode(&resource)
 	if err != nil {
-		werr := pkgerrors.Wrap(err, "Delete VNF error")
+		http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+		return
+	}
+
+	err = validateBody(resource)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+		return
+	}
+
+	err = csar.CheckInputs(resource.CsarID, resource.Inputs)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+		return
+	}
+
+	params := instanceParameters(resource.Inputs, resource.OOFParams, resource.NetworkParams)
+
+	recordParams, redactedInputs, err := redactParameters(resource.CsarID, params)
+	if err != nil {
+		werr := pkgerrors.Wrap(err, "Update VNF error")
 		http.Error(w, werr.Error(), http.StatusInternalServerError)
 		return
 	}
 
-	err = db.DBconn.DeleteEntry(internalVNFID)
+	// cloud1-default-uuid
+	internalVNFID := cloudRegionID + "-" + namespace + "-" + externalVNFID
+
+	// (TODO): Read kubeconfig for specific Cloud Region from local file system
+	// if present or download it from AAI
+	// err := DownloadKubeConfigFromAAI(resource.CloudRegionID, os.Getenv("KUBE_CONFIG_DIR")
+	kubeclient, err := GetVNFClient(os.Getenv("KUBE_CONFIG_DIR") + "/" + cloudRegionID)
 	if err != nil {
-		werr := pkgerrors.Wrap(err, "Delete VNF error")
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	_, _, found, err := readVNFResources(internalVNFID)
+	if err != nil {
+		werr := pkgerrors.Wrap(err, "Update VNF error")
 		http.Error(w, werr.Error(), http.StatusInternalServerError)
 		return
 	}
 
-	w.Header().Set("Content-Type", "application/json")
-	w.WriteHeader(http.StatusAccepted)
-}
+	if found == false {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
 
-// // UpdateHandler method to update a VNF instance.
-// func UpdateHandler(w http.ResponseWriter, r *http.Request) {
-// 	vars := mux.Vars(r)
-// 	id := vars["vnfInstanceId"]
-
-// 	var resource UpdateVnfRequest
-
-// 	if r.Body == nil {
-// 		http.Error(w, "Body empty", http.StatusBadRequest)
-// 		return
-// 	}
-
-// 	err := json.NewDecoder(r.Body).Decode(&resource)
-// 	if err != nil {
-// 		http.Error(w, err.Error(), http.StatusUnprocessableEntity)
-// 		return
-// 	}
-
-// 	err = validateBody(resource)
-// 	if err != nil {
-// 		http.Error(w, err.Error(), http.StatusUnprocessableEntity)
-// 		return
-// 	}
-
-// 	kubeData, err := utils.ReadCSARFromFileSystem(resource.CsarID)
-
-// 	if kubeData.Deployment == nil {
-// 		werr := pkgerrors.Wrap(err, "Update VNF deployment error")
-// 		http.Error(w, werr.Error(), http.StatusInternalServerError)
-// 		return
-// 	}
-// 	kubeData.Deployment.SetUID(types.UID(id))
-
-// 	if err != nil {
-// 		werr := pkgerrors.Wrap(err, "Update VNF deployment information error")
-// 		http.Error(w, werr.Error(), http.StatusInternalServerError)
-// 		return
-// 	}
-
-// 	// (TODO): Read kubeconfig for specific Cloud Region from local file system
-// 	// if present or download it from AAI
-// 	s, err := NewVNFInstanceService("../kubeconfig/config")
-// 	if err != nil {
-// 		http.Error(w, err.Error(), http.StatusInternalServerError)
-// 		return
-// 	}
-
-// 	err = s.Client.UpdateDeployment(kubeData.Deployment, resource.Namespace)
-// 	if err != nil {
-// 		werr := pkgerrors.Wrap(err, "Update VNF error")
-
-// 		http.Error(w, werr.Error(), http.StatusInternalServerError)
-// 		return
-// 	}
-
-// 	resp := UpdateVnfResponse{
-// 		DeploymentID: id,
-// 	}
-
-// 	w.Header().Set("Content-Type", "application/json")
-// 	w.WriteHeader(http.StatusCreated)
-
-// 	err = json.NewEncoder(w).Encode(resp)
-// 	if err != nil {
-// 		werr := pkgerrors.Wrap(err, "Parsing output of new VNF error")
-// 		http.Error(w, werr.Error(), http.StatusInternalServerError)
-// 	}
-// }
+	t, err := newOperation(OperationUpdate, cloudRegionID, namespace, externalVNFID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	t.useCsar(resource.CsarID)
+
+	runOperation(t, func(t *operationTracker) error {
+		// The VNF may have been changed by another operation in the meantime
+		deserializedResourceNameMap, record, found, err := readVNFResources(internalVNFID)
+		if err != nil {
+			return pkgerrors.Wrap(err, "Update VNF error")
+		}
+		if found == false {
+			return pkgerrors.New("Update VNF error: VNF " + internalVNFID + " not found")
+		}
+
+		resourceNameMap, dependencies, err := csar.UpdateVNF(resource.CsarID, cloudRegionID, namespace, externalVNFID,
+			params, deserializedResourceNameMap, record.Dependencies, t.progress, &kubeclient)
+		if err != nil {
+			return pkgerrors.Wrap(err, "Update VNF error")
+		}
+
+		out, err := json.Marshal(resourceNameMap)
+		if err != nil {
+			return pkgerrors.Wrap(err, "Update VNF error")
+		}
+
+		err = db.DBconn.CreateEntry(internalVNFID, string(out))
+		if err != nil {
+			return pkgerrors.Wrap(err, "Update VNF error")
+		}
+
+		err = saveVNFRecord(VNFRecord{
+			VNFID:          externalVNFID,
+			CloudRegionID:  cloudRegionID,
+			Namespace:      namespace,
+			CsarID:         resource.CsarID,
+			Dependencies:   dependencies,
+			Parameters:     recordParams,
+			RedactedInputs: redactedInputs,
+		})
+		if err != nil {
+			return pkgerrors.Wrap(err, "Update VNF error")
+		}
+
+		t.update(func(op *Operation) {
+			op.VNFComponents = resourceNameMap
+		})
+		return nil
+	})
+
+	writeOperationAccepted(w, t)
+}
 
 // GetHandler retrieves information about a VNF instance by reading an individual VNF instance resource.
 func GetHandler(w http.ResponseWriter, r *http.Request) {
@@ -392,3 +542,66 @@ func GetHandler(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, werr.Error(), http.StatusInternalServerError)
 	}
 }
+
+// StatusHandler retrieves the live state of the components of a VNF instance from the cluster.
+func StatusHandler(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+
+	cloudRegionID := vars["cloudRegionID"] // cloud1
+	namespace := vars["namespace"]         // default
+	externalVNFID := vars["externalVNFID"] // uuid
+
+	// cloud1-default-uuid
+	internalVNFID := cloudRegionID + "-" + namespace + "-" + externalVNFID
+
+	kubeclient, err := GetVNFClient(os.Getenv("KUBE_CONFIG_DIR") + "/" + cloudRegionID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	// key: cloud1-default-uuid
+	// value: "{"deployment":<>,"service":<>}"
+	serializedResourceNameMap, found, err := db.DBconn.ReadEntry(internalVNFID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	if found == false {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	deserializedResourceNameMap := make(map[string][]string)
+	err = json.Unmarshal([]byte(serializedResourceNameMap), &deserializedResourceNameMap)
+	if err != nil {
+		werr := pkgerrors.Wrap(err, "Get VNF status error")
+		http.Error(w, werr.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	state, components, err := csar.GetVNFStatus(deserializedResourceNameMap, namespace, &kubeclient)
+	if err != nil {
+		werr := pkgerrors.Wrap(err, "Get VNF status error")
+		http.Error(w, werr.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	resp := GetVnfStatusResponse{
+		VNFID:         externalVNFID,
+		CloudRegionID: cloudRegionID,
+		Namespace:     namespace,
+		State:         state,
+		VNFComponents: components,
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+
+	err = json.NewEncoder(w).Encode(resp)
+	if err != nil {
+		werr := pkgerrors.Wrap(err, "Parsing output of VNF status error")
+		http.Error(w, werr.Error(), http.StatusInternalServerError)
+	}
+}
@@ -14,20 +14,32 @@ limitations under the License.
 package api
 
 import (
+	"archive/zip"
 	"bytes"
 	"encoding/json"
+	"errors"
+	"io/ioutil"
+	metaV1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/client-go/kubernetes"
+	"mime/multipart"
 	"net/http"
 	"net/http/httptest"
+	"os"
 	"reflect"
+	"sort"
+	"strings"
 	"testing"
+	"time"
 
 	"k8-plugin-multicloud/csar"
 	"k8-plugin-multicloud/db"
+	"k8-plugin-multicloud/krd"
 )
 
 type mockDB struct {
 	db.DatabaseConnection
+	items   map[string]string
+	readAll []string
 }
 
 func (c *mockDB) InitializeDatabase() error {
@@ -39,10 +51,21 @@ func (c *mockDB) CheckDatabase() error {
 }
 
 func (c *mockDB) CreateEntry(key string, value string) error {
+	if c.items == nil {
+		c.items = make(map[string]string)
+	}
+	c.items[key] = value
 	return nil
 }
 
 func (c *mockDB) ReadEntry(key string) (string, bool, error) {
+	if value, ok := c.items[key]; ok {
+		return value, true, nil
+	}
+	// Operations and VNF records are only found once created
+	if strings.Contains(key, "/") {
+		return "", false, nil
+	}
 	str := "{\"deployment\":[\"cloud1-default-uuid-sisedeploy\"],\"service\":[\"cloud1-default-uuid-sisesvc\"]}"
 	return str, true, nil
 }
@@ -52,10 +75,30 @@ func (c *mockDB) DeleteEntry(key string) error {
 }
 
 func (c *mockDB) ReadAll(key string) ([]string, error) {
+	if c.readAll != nil {
+		return c.readAll, nil
+	}
+	var keys []string
+	for itemKey := range c.items {
+		if strings.HasSuffix(key, "/") && strings.HasPrefix(itemKey, key) {
+			keys = append(keys, itemKey)
+		}
+	}
+	if len(keys) > 0 {
+		sort.Strings(keys)
+		return keys, nil
+	}
 	returnVal := []string{"cloud1-default-uuid1", "cloud1-default-uuid2"}
 	return returnVal, nil
 }
 
+func init() {
+	// Run the operations synchronously to check their final state
+	runOperation = func(t *operationTracker, fn func(t *operationTracker) error) {
+		executeOperation(t, fn)
+	}
+}
+
 func executeRequest(req *http.Request) *httptest.ResponseRecorder {
 	router := NewRouter("")
 	recorder := httptest.NewRecorder()
@@ -71,6 +114,13 @@ func checkResponseCode(t *testing.T, expected, actual int) {
 }
 
 func TestVNFInstanceCreation(t *testing.T) {
+	csar.CheckInputs = func(id string, inputs map[string]interface{}) error {
+		return nil
+	}
+	csar.SensitiveInputs = func(id string) ([]string, error) {
+		return nil, nil
+	}
+
 	t.Run("Succesful create a VNF", func(t *testing.T) {
 		payload := []byte(`{
 			"cloud_region_id": "region1",
@@ -86,7 +136,13 @@ func TestVNFInstanceCreation(t *testing.T) {
 					"connection_point": "string",
 					"ip_address": "string",
 					"workload_name": "string"
-				}
+				},
+				"networks": [{
+					"name": "sise-net",
+					"interface": "net1",
+					"ip_address": "10.10.20.5/24",
+					"workload_name": "sisedeploy"
+				}]
 			}
 		}`)
 
@@ -95,14 +151,16 @@ func TestVNFInstanceCreation(t *testing.T) {
 			"service":    []string{"cloud1-default-uuid-sisesvc"},
 		}
 
-		expected := &CreateVnfResponse{
-			VNFID:         "test_UUID",
+		expected := Operation{
+			Type:          OperationCreate,
+			State:         OperationSucceeded,
 			CloudRegionID: "region1",
 			Namespace:     "test",
+			VNFID:         "externaluuid",
 			VNFComponents: data,
 		}
 
-		var result CreateVnfResponse
+		var result Operation
 
 		req, _ := http.NewRequest("POST", "/v1/vnf_instances/", bytes.NewBuffer(payload))
 
@@ -110,19 +168,195 @@ func TestVNFInstanceCreation(t *testing.T) {
 			return kubernetes.Clientset{}, nil
 		}
 
-		csar.CreateVNF = func(id string, r string, n string, kubeclient *kubernetes.Clientset) (string, map[string][]string, error) {
-			return "externaluuid", data, nil
+		var createParams csar.InstanceParameters
+		csar.CreateVNF = func(id string, r string, n string, params csar.InstanceParameters, progress csar.ProgressFunc,
+			kubeclient *kubernetes.Clientset) (string, map[string][]string, csar.ResourceDependencies, csar.ResourceObjects, error) {
+			createParams = params
+			progress("deployment", "cloud1-default-uuid-sisedeploy", nil)
+			progress("service", "cloud1-default-uuid-sisesvc", nil)
+			return "externaluuid", data, nil, nil, nil
 		}
 
 		db.DBconn = &mockDB{}
 
 		response := executeRequest(req)
-		checkResponseCode(t, http.StatusCreated, response.Code)
+		checkResponseCode(t, http.StatusAccepted, response.Code)
 
 		err := json.NewDecoder(response.Body).Decode(&result)
 		if err != nil {
 			t.Fatalf("TestVNFInstanceCreation returned:\n result=%v\n expected=%v", err, expected.VNFComponents)
 		}
+
+		if location := response.Header().Get("Location"); location != "/v1/operations/"+result.ID {
+			t.Fatalf("TestVNFInstanceCreation returned an unexpected location (%s)", location)
+		}
+
+		req, _ = http.NewRequest("GET", "/v1/operations/"+result.ID, nil)
+		response = executeRequest(req)
+		checkResponseCode(t, http.StatusOK, response.Code)
+
+		result = Operation{}
+		err = json.NewDecoder(response.Body).Decode(&result)
+		if err != nil {
+			t.Fatalf("TestVNFInstanceCreation returned:\n result=%v\n expected=%v", err, expected)
+		}
+
+		if result.State != expected.State || result.VNFID != expected.VNFID || len(result.Resources) != 2 ||
+			!reflect.DeepEqual(result.VNFComponents, expected.VNFComponents) {
+			t.Fatalf("TestVNFInstanceCreation returned:\n result=%v\n expected=%v", result, expected)
+		}
+
+		if len(createParams.OOFParams) != 1 || createParams.OAMIP.IPAddress != "string" ||
+			len(createParams.Networks) != 1 || createParams.Networks[0].IPAddress != "10.10.20.5/24" {
+			t.Fatalf("TestVNFInstanceCreation rendered the CSAR with unexpected parameters (%v)", createParams)
+		}
+	})
+	t.Run("Sensitive inputs are not stored", func(t *testing.T) {
+		payload := []byte(`{
+			"cloud_region_id": "region1",
+			"namespace": "test",
+			"csar_id": "UUID-1",
+			"inputs": {
+				"replicas": 2,
+				"password": "secret"
+			}
+		}`)
+
+		req, _ := http.NewRequest("POST", "/v1/vnf_instances/", bytes.NewBuffer(payload))
+
+		GetVNFClient = func(configPath string) (kubernetes.Clientset, error) {
+			return kubernetes.Clientset{}, nil
+		}
+
+		csar.SensitiveInputs = func(id string) ([]string, error) {
+			return []string{"password"}, nil
+		}
+		defer func() {
+			csar.SensitiveInputs = func(id string) ([]string, error) {
+				return nil, nil
+			}
+		}()
+
+		var createParams csar.InstanceParameters
+		csar.CreateVNF = func(id string, r string, n string, params csar.InstanceParameters, progress csar.ProgressFunc,
+			kubeclient *kubernetes.Clientset) (string, map[string][]string, csar.ResourceDependencies, csar.ResourceObjects, error) {
+			createParams = params
+			return "externaluuid", map[string][]string{}, nil, nil, nil
+		}
+
+		db.DBconn = &mockDB{}
+
+		response := executeRequest(req)
+		checkResponseCode(t, http.StatusAccepted, response.Code)
+
+		if createParams.Inputs["password"] != "secret" {
+			t.Fatalf("TestVNFInstanceCreation rendered the CSAR without its sensitive input (%v)", createParams.Inputs)
+		}
+
+		record, found, err := readVNFRecord("region1-test-externaluuid")
+		if err != nil || !found {
+			t.Fatalf("TestVNFInstanceCreation did not store the VNF record (%v)", err)
+		}
+
+		if _, ok := record.Parameters.Inputs["password"]; ok || record.Parameters.Inputs["replicas"] == nil ||
+			!reflect.DeepEqual(record.RedactedInputs, []string{"password"}) {
+			t.Fatalf("TestVNFInstanceCreation stored:\n result=%v\n expected the inputs without password", record)
+		}
+	})
+	t.Run("Rollback of a failed VNF creation", func(t *testing.T) {
+		payload := []byte(`{
+			"cloud_region_id": "region1",
+			"namespace": "test",
+			"csar_id": "UUID-1"
+		}`)
+
+		req, _ := http.NewRequest("POST", "/v1/vnf_instances/", bytes.NewBuffer(payload))
+
+		GetVNFClient = func(configPath string) (kubernetes.Clientset, error) {
+			return kubernetes.Clientset{}, nil
+		}
+
+		csar.CreateVNF = func(id string, r string, n string, params csar.InstanceParameters, progress csar.ProgressFunc,
+			kubeclient *kubernetes.Clientset) (string, map[string][]string, csar.ResourceDependencies, csar.ResourceObjects, error) {
+			return "", nil, nil, nil, &csar.RollbackError{
+				Err:            errors.New("create service failed"),
+				RollbackErrors: []error{errors.New("delete deployment failed")},
+			}
+		}
+
+		db.DBconn = &mockDB{}
+
+		response := executeRequest(req)
+		checkResponseCode(t, http.StatusAccepted, response.Code)
+
+		var result Operation
+		err := json.NewDecoder(response.Body).Decode(&result)
+		if err != nil {
+			t.Fatalf("TestVNFInstanceCreation returned an error (%s)", err)
+		}
+
+		if result.State != OperationFailed {
+			t.Fatalf("TestVNFInstanceCreation returned:\n result=%v\n expected=%v", result.State, OperationFailed)
+		}
+
+		if !strings.Contains(result.Error, "create service failed") || !strings.Contains(result.Error, "delete deployment failed") {
+			t.Fatalf("TestVNFInstanceCreation returned:\n result=%v\n expected both errors", result.Error)
+		}
+	})
+	t.Run("VNF components not ready", func(t *testing.T) {
+		payload := []byte(`{
+			"cloud_region_id": "region1",
+			"namespace": "test",
+			"csar_id": "UUID-1",
+			"wait": true,
+			"wait_timeout": 10
+		}`)
+
+		data := map[string][]string{
+			"deployment": []string{"cloud1-default-uuid-sisedeploy"},
+			"service":    []string{"cloud1-default-uuid-sisesvc"},
+		}
+		readiness := map[string]string{
+			"deployment/cloud1-default-uuid-sisedeploy": csar.ResourceNotReady,
+			"service/cloud1-default-uuid-sisesvc":       csar.ResourceReady,
+		}
+
+		req, _ := http.NewRequest("POST", "/v1/vnf_instances/", bytes.NewBuffer(payload))
+
+		GetVNFClient = func(configPath string) (kubernetes.Clientset, error) {
+			return kubernetes.Clientset{}, nil
+		}
+
+		csar.CreateVNF = func(id string, r string, n string, params csar.InstanceParameters, progress csar.ProgressFunc,
+			kubeclient *kubernetes.Clientset) (string, map[string][]string, csar.ResourceDependencies, csar.ResourceObjects, error) {
+			return "externaluuid", data, nil, nil, nil
+		}
+
+		var waitTimeout time.Duration
+		csar.WaitForVNF = func(id string, o csar.ResourceObjects, n string, timeout time.Duration,
+			kubeclient *kubernetes.Clientset) (map[string]string, error) {
+			waitTimeout = timeout
+			return readiness, nil
+		}
+
+		db.DBconn = &mockDB{}
+
+		response := executeRequest(req)
+		checkResponseCode(t, http.StatusAccepted, response.Code)
+
+		var result Operation
+		err := json.NewDecoder(response.Body).Decode(&result)
+		if err != nil {
+			t.Fatalf("TestVNFInstanceCreation returned an error (%s)", err)
+		}
+
+		if waitTimeout != 10*time.Second {
+			t.Fatalf("TestVNFInstanceCreation waited:\n result=%v\n expected=%v", waitTimeout, 10*time.Second)
+		}
+
+		if result.State != OperationFailed || result.VNFID != "externaluuid" || !reflect.DeepEqual(result.Readiness, readiness) {
+			t.Fatalf("TestVNFInstanceCreation returned:\n result=%v\n expected a failed operation with readiness %v", result, readiness)
+		}
 	})
 	t.Run("Missing body failure", func(t *testing.T) {
 		req, _ := http.NewRequest("POST", "/v1/vnf_instances/", nil)
@@ -152,6 +386,30 @@ func TestVNFInstanceCreation(t *testing.T) {
 		response := executeRequest(req)
 		checkResponseCode(t, http.StatusUnprocessableEntity, response.Code)
 	})
+	t.Run("Invalid inputs failure", func(t *testing.T) {
+		payload := []byte(`{
+			"cloud_region_id": "region1",
+			"namespace": "test",
+			"csar_id": "UUID-1",
+			"inputs": {
+				"replicas": "three"
+			}
+		}`)
+
+		var checkedInputs map[string]interface{}
+		csar.CheckInputs = func(id string, inputs map[string]interface{}) error {
+			checkedInputs = inputs
+			return &csar.InputError{CsarID: id, Problems: []string{"Input replicas must be of type integer"}}
+		}
+
+		req, _ := http.NewRequest("POST", "/v1/vnf_instances/", bytes.NewBuffer(payload))
+		response := executeRequest(req)
+		checkResponseCode(t, http.StatusUnprocessableEntity, response.Code)
+
+		if checkedInputs["replicas"] != "three" {
+			t.Fatalf("TestVNFInstanceCreation checked unexpected inputs (%v)", checkedInputs)
+		}
+	})
 }
 
 func TestVNFInstancesRetrieval(t *testing.T) {
@@ -192,17 +450,45 @@ func TestVNFInstanceDeletion(t *testing.T) {
 			return kubernetes.Clientset{}, nil
 		}
 
-		csar.DestroyVNF = func(d map[string][]string, n string, kubeclient *kubernetes.Clientset) error {
+		dependencies := csar.ResourceDependencies{
+			"service/cloudregion1-testnamespace-1-sisesvc": []string{"deployment/cloudregion1-testnamespace-1-sisedeploy"},
+		}
+
+		var destroyedDependencies csar.ResourceDependencies
+		csar.DestroyVNF = func(d map[string][]string, deps csar.ResourceDependencies, n string, progress csar.ProgressFunc,
+			kubeclient *kubernetes.Clientset) error {
+			destroyedDependencies = deps
 			return nil
 		}
 
-		db.DBconn = &mockDB{}
+		record, _ := json.Marshal(VNFRecord{
+			VNFID:         "1",
+			CloudRegionID: "cloudregion1",
+			Namespace:     "testnamespace",
+			CsarID:        "uuid",
+			Dependencies:  dependencies,
+		})
+		db.DBconn = &mockDB{
+			items: map[string]string{
+				vnfRecordKeyPrefix + "cloudregion1-testnamespace-1": string(record),
+			},
+		}
 
 		response := executeRequest(req)
 		checkResponseCode(t, http.StatusAccepted, response.Code)
 
-		if result := response.Body.String(); result != "" {
-			t.Fatalf("TestVNFInstanceDeletion returned:\n result=%v\n expected=%v", result, "")
+		var result Operation
+		err := json.NewDecoder(response.Body).Decode(&result)
+		if err != nil {
+			t.Fatalf("TestVNFInstanceDeletion returned an error (%s)", err)
+		}
+
+		if result.Type != OperationDelete || result.State != OperationSucceeded || result.VNFID != "1" {
+			t.Fatalf("TestVNFInstanceDeletion returned:\n result=%v\n expected a succeeded delete of VNF 1", result)
+		}
+
+		if !reflect.DeepEqual(dependencies, destroyedDependencies) {
+			t.Fatalf("TestVNFInstanceDeletion destroyed with:\n result=%v\n expected=%v", destroyedDependencies, dependencies)
 		}
 	})
 	// t.Run("Malformed delete request", func(t *testing.T) {
@@ -212,13 +498,17 @@ func TestVNFInstanceDeletion(t *testing.T) {
 	// })
 }
 
-// TODO: Update this test when the UpdateVNF endpoint is fixed.
-/*
 func TestVNFInstanceUpdate(t *testing.T) {
+	csar.CheckInputs = func(id string, inputs map[string]interface{}) error {
+		return nil
+	}
+	csar.SensitiveInputs = func(id string) ([]string, error) {
+		return nil, nil
+	}
+
 	t.Run("Succesful update a VNF", func(t *testing.T) {
 		payload := []byte(`{
-			"cloud_region_id": "region1",
-			"csar_id": "UUID-1",
+			"csar_id": "UUID-2",
 			"oof_parameters": [{
 				"key1": "value1",
 				"key2": "value2",
@@ -232,43 +522,62 @@ func TestVNFInstanceUpdate(t *testing.T) {
 				}
 			}
 		}`)
-		expected := &UpdateVnfResponse{
-			DeploymentID: "1",
+
+		data := map[string][]string{
+			"deployment": []string{"cloud1-default-1-sisedeploy"},
 		}
 
-		var result UpdateVnfResponse
+		expected := Operation{
+			Type:          OperationUpdate,
+			State:         OperationSucceeded,
+			VNFID:         "1",
+			CloudRegionID: "cloud1",
+			Namespace:     "default",
+			VNFComponents: data,
+		}
 
-		req, _ := http.NewRequest("PUT", "/v1/vnf_instances/1", bytes.NewBuffer(payload))
+		var result Operation
 
-		GetVNFClient = func(configPath string) (krd.VNFInstanceClientInterface, error) {
-			return &mockClient{
-				update: func() error {
-					return nil
-				},
-			}, nil
+		req, _ := http.NewRequest("PUT", "/v1/vnf_instances/cloud1/default/1", bytes.NewBuffer(payload))
+
+		GetVNFClient = func(configPath string) (kubernetes.Clientset, error) {
+			return kubernetes.Clientset{}, nil
 		}
-		utils.ReadCSARFromFileSystem = func(csarID string) (*krd.KubernetesData, error) {
-			kubeData := &krd.KubernetesData{
-				Deployment: &appsV1.Deployment{},
-				Service:    &coreV1.Service{},
-			}
-			return kubeData, nil
+
+		csar.UpdateVNF = func(id string, r string, n string, e string, params csar.InstanceParameters, d map[string][]string, deps csar.ResourceDependencies,
+			progress csar.ProgressFunc, kubeclient *kubernetes.Clientset) (map[string][]string, csar.ResourceDependencies, error) {
+			return data, nil, nil
 		}
 
+		db.DBconn = &mockDB{}
+
 		response := executeRequest(req)
-		checkResponseCode(t, http.StatusCreated, response.Code)
+		checkResponseCode(t, http.StatusAccepted, response.Code)
 
 		err := json.NewDecoder(response.Body).Decode(&result)
 		if err != nil {
-			t.Fatalf("TestVNFInstanceUpdate returned:\n result=%v\n expected=%v", err, expected.DeploymentID)
+			t.Fatalf("TestVNFInstanceUpdate returned:\n result=%v\n expected=%v", err, expected)
 		}
 
-		if result.DeploymentID != expected.DeploymentID {
-			t.Fatalf("TestVNFInstanceUpdate returned:\n result=%v\n expected=%v", result.DeploymentID, expected.DeploymentID)
+		// Fields generated by the operation
+		expected.ID = result.ID
+		expected.Resources = result.Resources
+		expected.StartTime = result.StartTime
+		expected.EndTime = result.EndTime
+
+		if !reflect.DeepEqual(expected, result) {
+			t.Fatalf("TestVNFInstanceUpdate returned:\n result=%v\n expected=%v", result, expected)
 		}
 	})
+	t.Run("Missing CSAR ID failure", func(t *testing.T) {
+		payload := []byte(`{
+			"vnf_instance_name": "test"
+		}`)
+		req, _ := http.NewRequest("PUT", "/v1/vnf_instances/cloud1/default/1", bytes.NewBuffer(payload))
+		response := executeRequest(req)
+		checkResponseCode(t, http.StatusUnprocessableEntity, response.Code)
+	})
 }
-*/
 
 func TestVNFInstanceRetrieval(t *testing.T) {
 	t.Run("Succesful get a VNF", func(t *testing.T) {
@@ -314,3 +623,593 @@ func TestVNFInstanceRetrieval(t *testing.T) {
 		checkResponseCode(t, http.StatusOK, response.Code)
 	})
 }
+
+func TestOperationRetrieval(t *testing.T) {
+	t.Run("Operation not found", func(t *testing.T) {
+		req, _ := http.NewRequest("GET", "/v1/operations/unknown", nil)
+		db.DBconn = &mockDB{}
+		response := executeRequest(req)
+		checkResponseCode(t, http.StatusNotFound, response.Code)
+	})
+	t.Run("Interrupted operations are marked as failed", func(t *testing.T) {
+		mdb := &mockDB{}
+		db.DBconn = mdb
+
+		err := saveOperation(&Operation{ID: "op1", Type: OperationCreate, State: OperationRunning})
+		if err != nil {
+			t.Fatalf("TestOperationRetrieval returned an error (%s)", err)
+		}
+
+		mdb.readAll = []string{operationKeyPrefix + "op1"}
+
+		err = RecoverOperations()
+		if err != nil {
+			t.Fatalf("TestOperationRetrieval returned an error (%s)", err)
+		}
+
+		op, found, err := readOperation("op1")
+		if err != nil || found == false {
+			t.Fatalf("TestOperationRetrieval could not read the operation (%v)", err)
+		}
+
+		if op.State != OperationFailed {
+			t.Fatalf("TestOperationRetrieval returned:\n result=%v\n expected=%v", op.State, OperationFailed)
+		}
+	})
+}
+
+func TestVNFInstanceStatus(t *testing.T) {
+	t.Run("Succesful get the status of a VNF", func(t *testing.T) {
+		components := map[string][]krd.ResourceStatus{
+			"deployment": []krd.ResourceStatus{
+				{Name: "cloud1-default-1-sisedeploy", Present: true, Replicas: 2, AvailableReplicas: 1},
+			},
+			"service": []krd.ResourceStatus{
+				{Name: "cloud1-default-1-sisesvc", Present: false},
+			},
+		}
+
+		expected := GetVnfStatusResponse{
+			VNFID:         "1",
+			CloudRegionID: "cloud1",
+			Namespace:     "default",
+			State:         csar.VNFDegraded,
+			VNFComponents: components,
+		}
+
+		req, _ := http.NewRequest("GET", "/v1/vnf_instances/cloud1/default/1/status", nil)
+
+		GetVNFClient = func(configPath string) (kubernetes.Clientset, error) {
+			return kubernetes.Clientset{}, nil
+		}
+
+		csar.GetVNFStatus = func(d map[string][]string, n string,
+			kubeclient *kubernetes.Clientset) (string, map[string][]krd.ResourceStatus, error) {
+			return csar.VNFDegraded, components, nil
+		}
+
+		db.DBconn = &mockDB{}
+
+		response := executeRequest(req)
+		checkResponseCode(t, http.StatusOK, response.Code)
+
+		var result GetVnfStatusResponse
+		err := json.NewDecoder(response.Body).Decode(&result)
+		if err != nil {
+			t.Fatalf("TestVNFInstanceStatus returned:\n result=%v\n expected=%v", err, expected)
+		}
+
+		if !reflect.DeepEqual(expected, result) {
+			t.Fatalf("TestVNFInstanceStatus returned:\n result=%v\n expected=%v", result, expected)
+		}
+	})
+}
+
+func TestDriftReport(t *testing.T) {
+	t.Run("Succesful heal a drifted VNF", func(t *testing.T) {
+		mdb := &mockDB{}
+		db.DBconn = mdb
+
+		err := saveVNFRecord(VNFRecord{VNFID: "1", CloudRegionID: "cloud1", Namespace: "default", CsarID: "UUID-1"})
+		if err != nil {
+			t.Fatalf("TestDriftReport returned an error (%s)", err)
+		}
+		mdb.readAll = []string{vnfRecordKeyPrefix + "cloud1-default-1"}
+
+		drifts := []krd.ResourceDrift{
+			{Name: "cloud1-default-1-sisedeploy", Missing: true},
+		}
+
+		GetVNFClient = func(configPath string) (kubernetes.Clientset, error) {
+			return kubernetes.Clientset{}, nil
+		}
+
+		csar.DetectDrift = func(id string, r string, n string, e string, params csar.InstanceParameters,
+			resourceNameMap map[string][]string, kubeclient *kubernetes.Clientset) ([]krd.ResourceDrift, error) {
+			return drifts, nil
+		}
+
+		var healedCsarID string
+		csar.UpdateVNF = func(id string, r string, n string, e string, params csar.InstanceParameters, d map[string][]string, deps csar.ResourceDependencies,
+			progress csar.ProgressFunc, kubeclient *kubernetes.Clientset) (map[string][]string, csar.ResourceDependencies, error) {
+			healedCsarID = id
+			return d, deps, nil
+		}
+
+		req, _ := http.NewRequest("POST", "/v1/drift_reports/cloud1/heal", nil)
+		response := executeRequest(req)
+		checkResponseCode(t, http.StatusOK, response.Code)
+
+		var result DriftReport
+		err = json.NewDecoder(response.Body).Decode(&result)
+		if err != nil {
+			t.Fatalf("TestDriftReport returned an error (%s)", err)
+		}
+
+		expected := []VNFDrift{
+			{VNFID: "1", Namespace: "default", CsarID: "UUID-1", Resources: drifts, Healed: true},
+		}
+		if !reflect.DeepEqual(expected, result.VNFs) {
+			t.Fatalf("TestDriftReport returned:\n result=%v\n expected=%v", result.VNFs, expected)
+		}
+
+		if healedCsarID != "UUID-1" {
+			t.Fatalf("TestDriftReport healed with CSAR %s, expected UUID-1", healedCsarID)
+		}
+	})
+	t.Run("Extra objects are reported but not healed", func(t *testing.T) {
+		mdb := &mockDB{}
+		db.DBconn = mdb
+
+		err := saveVNFRecord(VNFRecord{VNFID: "1", CloudRegionID: "cloud1", Namespace: "default", CsarID: "UUID-1"})
+		if err != nil {
+			t.Fatalf("TestDriftReport returned an error (%s)", err)
+		}
+		mdb.readAll = []string{vnfRecordKeyPrefix + "cloud1-default-1"}
+
+		drifts := []krd.ResourceDrift{
+			{ResourceType: "service", Name: "cloud1-default-1-sisesvc", Extra: true},
+		}
+
+		GetVNFClient = func(configPath string) (kubernetes.Clientset, error) {
+			return kubernetes.Clientset{}, nil
+		}
+
+		csar.DetectDrift = func(id string, r string, n string, e string, params csar.InstanceParameters,
+			resourceNameMap map[string][]string, kubeclient *kubernetes.Clientset) ([]krd.ResourceDrift, error) {
+			return drifts, nil
+		}
+
+		healed := false
+		csar.UpdateVNF = func(id string, r string, n string, e string, params csar.InstanceParameters, d map[string][]string, deps csar.ResourceDependencies,
+			progress csar.ProgressFunc, kubeclient *kubernetes.Clientset) (map[string][]string, csar.ResourceDependencies, error) {
+			healed = true
+			return d, deps, nil
+		}
+
+		req, _ := http.NewRequest("POST", "/v1/drift_reports/cloud1/heal", nil)
+		response := executeRequest(req)
+		checkResponseCode(t, http.StatusOK, response.Code)
+
+		var result DriftReport
+		err = json.NewDecoder(response.Body).Decode(&result)
+		if err != nil {
+			t.Fatalf("TestDriftReport returned an error (%s)", err)
+		}
+
+		expected := []VNFDrift{
+			{VNFID: "1", Namespace: "default", CsarID: "UUID-1", Resources: drifts},
+		}
+		if !reflect.DeepEqual(expected, result.VNFs) {
+			t.Fatalf("TestDriftReport returned:\n result=%v\n expected=%v", result.VNFs, expected)
+		}
+
+		if healed {
+			t.Fatalf("TestDriftReport re-applied the CSAR of a VNF without missing or modified resources")
+		}
+	})
+	t.Run("VNF with an operation in progress is not healed", func(t *testing.T) {
+		mdb := &mockDB{}
+		db.DBconn = mdb
+
+		err := saveVNFRecord(VNFRecord{VNFID: "1", CloudRegionID: "cloud1", Namespace: "default", CsarID: "UUID-1"})
+		if err != nil {
+			t.Fatalf("TestDriftReport returned an error (%s)", err)
+		}
+		mdb.readAll = []string{vnfRecordKeyPrefix + "cloud1-default-1"}
+
+		drifts := []krd.ResourceDrift{
+			{Name: "cloud1-default-1-sisedeploy", Missing: true},
+		}
+
+		GetVNFClient = func(configPath string) (kubernetes.Clientset, error) {
+			return kubernetes.Clientset{}, nil
+		}
+
+		csar.DetectDrift = func(id string, r string, n string, e string, params csar.InstanceParameters,
+			resourceNameMap map[string][]string, kubeclient *kubernetes.Clientset) ([]krd.ResourceDrift, error) {
+			return drifts, nil
+		}
+
+		healed := false
+		csar.UpdateVNF = func(id string, r string, n string, e string, params csar.InstanceParameters, d map[string][]string, deps csar.ResourceDependencies,
+			progress csar.ProgressFunc, kubeclient *kubernetes.Clientset) (map[string][]string, csar.ResourceDependencies, error) {
+			healed = true
+			return d, deps, nil
+		}
+
+		// A pending operation of the VNF
+		lock, _ := registerVNF("cloud1-default-1", false)
+		defer func() {
+			lock.Lock()
+			releaseVNF("cloud1-default-1", lock)
+		}()
+
+		req, _ := http.NewRequest("POST", "/v1/drift_reports/cloud1/heal", nil)
+		response := executeRequest(req)
+		checkResponseCode(t, http.StatusOK, response.Code)
+
+		var result DriftReport
+		err = json.NewDecoder(response.Body).Decode(&result)
+		if err != nil {
+			t.Fatalf("TestDriftReport returned an error (%s)", err)
+		}
+
+		if len(result.VNFs) != 1 || result.VNFs[0].Healed || result.VNFs[0].Error == "" {
+			t.Fatalf("TestDriftReport returned:\n result=%v\n expected an unhealed VNF with an error", result.VNFs)
+		}
+
+		if healed {
+			t.Fatalf("TestDriftReport healed a VNF with an operation in progress")
+		}
+	})
+	t.Run("VNF with sensitive inputs is not healed", func(t *testing.T) {
+		mdb := &mockDB{}
+		db.DBconn = mdb
+
+		err := saveVNFRecord(VNFRecord{VNFID: "1", CloudRegionID: "cloud1", Namespace: "default", CsarID: "UUID-1",
+			RedactedInputs: []string{"password"}})
+		if err != nil {
+			t.Fatalf("TestDriftReport returned an error (%s)", err)
+		}
+		mdb.readAll = []string{vnfRecordKeyPrefix + "cloud1-default-1"}
+
+		GetVNFClient = func(configPath string) (kubernetes.Clientset, error) {
+			return kubernetes.Clientset{}, nil
+		}
+
+		healed := false
+		csar.UpdateVNF = func(id string, r string, n string, e string, params csar.InstanceParameters, d map[string][]string, deps csar.ResourceDependencies,
+			progress csar.ProgressFunc, kubeclient *kubernetes.Clientset) (map[string][]string, csar.ResourceDependencies, error) {
+			healed = true
+			return d, deps, nil
+		}
+
+		req, _ := http.NewRequest("POST", "/v1/drift_reports/cloud1/heal", nil)
+		response := executeRequest(req)
+		checkResponseCode(t, http.StatusOK, response.Code)
+
+		var result DriftReport
+		err = json.NewDecoder(response.Body).Decode(&result)
+		if err != nil {
+			t.Fatalf("TestDriftReport returned an error (%s)", err)
+		}
+
+		if len(result.VNFs) != 1 || result.VNFs[0].Healed || !strings.Contains(result.VNFs[0].Error, "password") {
+			t.Fatalf("TestDriftReport returned:\n result=%v\n expected an unhealed VNF with an error", result.VNFs)
+		}
+
+		if healed {
+			t.Fatalf("TestDriftReport healed a VNF without its sensitive inputs")
+		}
+	})
+}
+
+type emptyDB struct {
+	mockDB
+}
+
+func (c *emptyDB) ReadEntry(key string) (string, bool, error) {
+	return "", false, nil
+}
+
+func TestGarbageCollection(t *testing.T) {
+	old := metaV1.NewTime(time.Now().Add(-2 * gcGracePeriod))
+	item := metaV1.ObjectMeta{
+		Name:              "cloud1-default-1-sisedeploy",
+		Namespace:         "default",
+		CreationTimestamp: old,
+		Labels: map[string]string{
+			krd.VNFIDLabel:         "1",
+			krd.CloudRegionIDLabel: "cloud1",
+		},
+	}
+
+	t.Run("Resource without VNF record is orphaned", func(t *testing.T) {
+		db.DBconn = &emptyDB{}
+		orphan, err := isOrphaned(item, "cloud1")
+		if err != nil || !orphan {
+			t.Fatalf("TestGarbageCollection returned:\n result=%v (%v)\n expected=true", orphan, err)
+		}
+	})
+	t.Run("Resource with VNF record is not orphaned", func(t *testing.T) {
+		db.DBconn = &mockDB{}
+		orphan, err := isOrphaned(item, "cloud1")
+		if err != nil || orphan {
+			t.Fatalf("TestGarbageCollection returned:\n result=%v (%v)\n expected=false", orphan, err)
+		}
+	})
+	t.Run("Recent resource is not orphaned", func(t *testing.T) {
+		db.DBconn = &emptyDB{}
+		recent := item
+		recent.CreationTimestamp = metaV1.Now()
+		orphan, err := isOrphaned(recent, "cloud1")
+		if err != nil || orphan {
+			t.Fatalf("TestGarbageCollection returned:\n result=%v (%v)\n expected=false", orphan, err)
+		}
+	})
+	t.Run("Missing cloud region failure", func(t *testing.T) {
+		payload := []byte(`{"dry_run": true}`)
+		req, _ := http.NewRequest("POST", "/v1/admin/gc", bytes.NewBuffer(payload))
+		response := executeRequest(req)
+		checkResponseCode(t, http.StatusUnprocessableEntity, response.Code)
+	})
+	t.Run("Succesful dry run", func(t *testing.T) {
+		payload := []byte(`{"cloud_region_id": "cloud1", "dry_run": true}`)
+		req, _ := http.NewRequest("POST", "/v1/admin/gc", bytes.NewBuffer(payload))
+
+		GetVNFClient = func(configPath string) (kubernetes.Clientset, error) {
+			return kubernetes.Clientset{}, nil
+		}
+
+		response := executeRequest(req)
+		checkResponseCode(t, http.StatusOK, response.Code)
+
+		var result GarbageCollectionReport
+		err := json.NewDecoder(response.Body).Decode(&result)
+		if err != nil {
+			t.Fatalf("TestGarbageCollection returned an error (%s)", err)
+		}
+
+		if !result.DryRun || len(result.Orphans) != 0 {
+			t.Fatalf("TestGarbageCollection returned an unexpected report (%v)", result)
+		}
+	})
+}
+
+func TestRetainedVolumes(t *testing.T) {
+	t.Run("Volume of a deleted VNF is listed", func(t *testing.T) {
+		db.DBconn = &emptyDB{}
+		item := metaV1.ObjectMeta{
+			Name:      "cloud1-default-sisedata",
+			Namespace: "default",
+			Labels: map[string]string{
+				krd.VNFIDLabel:         "1",
+				krd.CloudRegionIDLabel: "cloud1",
+			},
+		}
+		deleted, err := isVNFDeleted(item, "cloud1")
+		if err != nil || !deleted {
+			t.Fatalf("TestRetainedVolumes returned:\n result=%v (%v)\n expected=true", deleted, err)
+		}
+	})
+	t.Run("Succesful listing", func(t *testing.T) {
+		expected := RetainedVolumesReport{
+			CloudRegionID: "cloud1",
+			Volumes: []RetainedVolume{
+				{
+					ResourceType: "persistentvolumeclaim",
+					Name:         "cloud1-default-sisedata",
+					Namespace:    "default",
+					VNFID:        "1",
+					ReleasedAt:   "2018-07-01T10:00:00Z",
+				},
+			},
+		}
+
+		oldListRetainedVolumes := listRetainedVolumes
+		defer func() {
+			listRetainedVolumes = oldListRetainedVolumes
+		}()
+		listRetainedVolumes = func(cloudRegionID string) (RetainedVolumesReport, error) {
+			return expected, nil
+		}
+
+		req, _ := http.NewRequest("GET", "/v1/admin/retained_volumes/cloud1", nil)
+		response := executeRequest(req)
+		checkResponseCode(t, http.StatusOK, response.Code)
+
+		var result RetainedVolumesReport
+		err := json.NewDecoder(response.Body).Decode(&result)
+		if err != nil {
+			t.Fatalf("TestRetainedVolumes returned an error (%s)", err)
+		}
+
+		if !reflect.DeepEqual(expected, result) {
+			t.Fatalf("TestRetainedVolumes returned:\n result=%v\n expected=%v", result, expected)
+		}
+	})
+}
+
+func newCsarUpload(t *testing.T, files map[string]string) *http.Request {
+	archive := new(bytes.Buffer)
+	writer := zip.NewWriter(archive)
+	for name, content := range files {
+		file, err := writer.Create(name)
+		if err != nil {
+			t.Fatalf("Error creating CSAR archive (%s)", err)
+		}
+		file.Write([]byte(content))
+	}
+	writer.Close()
+
+	body := new(bytes.Buffer)
+	form := multipart.NewWriter(body)
+	part, err := form.CreateFormFile("file", "vfw.csar")
+	if err != nil {
+		t.Fatalf("Error creating CSAR upload (%s)", err)
+	}
+	part.Write(archive.Bytes())
+	form.Close()
+
+	req, _ := http.NewRequest("POST", "/v1/csars", body)
+	req.Header.Set("Content-Type", form.FormDataContentType())
+	return req
+}
+
+func TestCsarManagement(t *testing.T) {
+	oldCsarDir := os.Getenv("CSAR_DIR")
+	csarDir, err := ioutil.TempDir("", "csar")
+	if err != nil {
+		t.Fatalf("TestCsarManagement returned an error (%s)", err)
+	}
+
+	defer func() {
+		os.Setenv("CSAR_DIR", oldCsarDir)
+		os.RemoveAll(csarDir)
+	}()
+
+	os.Setenv("CSAR_DIR", csarDir)
+
+	mock := &mockDB{}
+	db.DBconn = mock
+
+	var uploaded CsarRecord
+
+	t.Run("Upload a CSAR", func(t *testing.T) {
+		req := newCsarUpload(t, map[string]string{
+			"TOSCA-Metadata/TOSCA.meta":            "Entry-Definitions: Definitions/MainServiceTemplate.yaml\n",
+			"Definitions/MainServiceTemplate.yaml": "tosca_definitions_version: tosca_simple_yaml_1_0\n",
+			"Definitions/metadata.yaml":            "resources:\n  - deployment:\n    - deployment.yaml\n",
+			"Definitions/deployment.yaml":          "kind: Deployment\n",
+		})
+
+		response := executeRequest(req)
+		checkResponseCode(t, http.StatusCreated, response.Code)
+
+		err := json.NewDecoder(response.Body).Decode(&uploaded)
+		if err != nil {
+			t.Fatalf("TestCsarManagement returned an error (%s)", err)
+		}
+
+		if uploaded.CsarID == "" || uploaded.Name != "vfw.csar" {
+			t.Fatalf("TestCsarManagement returned an unexpected record (%v)", uploaded)
+		}
+	})
+
+	t.Run("Reject an invalid CSAR", func(t *testing.T) {
+		req := newCsarUpload(t, map[string]string{
+			"metadata.yaml": "resources: []\n",
+		})
+
+		response := executeRequest(req)
+		checkResponseCode(t, http.StatusUnprocessableEntity, response.Code)
+	})
+
+	t.Run("List CSARs", func(t *testing.T) {
+		req, _ := http.NewRequest("GET", "/v1/csars", nil)
+		response := executeRequest(req)
+		checkResponseCode(t, http.StatusOK, response.Code)
+
+		var result ListCsarsResponse
+		err := json.NewDecoder(response.Body).Decode(&result)
+		if err != nil {
+			t.Fatalf("TestCsarManagement returned an error (%s)", err)
+		}
+
+		if !reflect.DeepEqual([]CsarRecord{uploaded}, result.Csars) {
+			t.Fatalf("TestCsarManagement returned:\n result=%v\n expected=%v", result.Csars, uploaded)
+		}
+	})
+
+	t.Run("Get a CSAR", func(t *testing.T) {
+		req, _ := http.NewRequest("GET", "/v1/csars/"+uploaded.CsarID, nil)
+		response := executeRequest(req)
+		checkResponseCode(t, http.StatusOK, response.Code)
+
+		var result GetCsarResponse
+		err := json.NewDecoder(response.Body).Decode(&result)
+		if err != nil {
+			t.Fatalf("TestCsarManagement returned an error (%s)", err)
+		}
+
+		expectedResources := []CsarResource{
+			{Name: "deployment-0", Type: "deployment", Files: []string{"deployment.yaml"}},
+		}
+		if !reflect.DeepEqual(expectedResources, result.Resources) || len(result.Files) != 4 {
+			t.Fatalf("TestCsarManagement returned an unexpected CSAR (%v)", result)
+		}
+	})
+
+	t.Run("Validate a CSAR", func(t *testing.T) {
+		csar.ValidateCSAR = func(id string) []string {
+			return []string{"deployment-0: No plugin for resource deployment found"}
+		}
+
+		req, _ := http.NewRequest("POST", "/v1/csars/"+uploaded.CsarID+"/validate", nil)
+		response := executeRequest(req)
+		checkResponseCode(t, http.StatusOK, response.Code)
+
+		var result ValidateCsarResponse
+		err := json.NewDecoder(response.Body).Decode(&result)
+		if err != nil {
+			t.Fatalf("TestCsarManagement returned an error (%s)", err)
+		}
+
+		if result.Valid || len(result.Problems) != 1 {
+			t.Fatalf("TestCsarManagement returned an unexpected validation (%v)", result)
+		}
+	})
+
+	t.Run("Refuse to delete a CSAR in use", func(t *testing.T) {
+		err := saveVNFRecord(VNFRecord{
+			VNFID:         "1",
+			CloudRegionID: "cloud1",
+			Namespace:     "default",
+			CsarID:        uploaded.CsarID,
+		})
+		if err != nil {
+			t.Fatalf("TestCsarManagement returned an error (%s)", err)
+		}
+
+		req, _ := http.NewRequest("DELETE", "/v1/csars/"+uploaded.CsarID, nil)
+		response := executeRequest(req)
+		checkResponseCode(t, http.StatusConflict, response.Code)
+
+		delete(mock.items, vnfRecordKeyPrefix+"cloud1-default-1")
+	})
+
+	t.Run("Refuse to delete a CSAR used by a pending operation", func(t *testing.T) {
+		op, err := newOperation(OperationCreate, "cloud1", "default", "")
+		if err != nil {
+			t.Fatalf("TestCsarManagement returned an error (%s)", err)
+		}
+		op.useCsar(uploaded.CsarID)
+
+		req, _ := http.NewRequest("DELETE", "/v1/csars/"+uploaded.CsarID, nil)
+		response := executeRequest(req)
+		checkResponseCode(t, http.StatusConflict, response.Code)
+
+		// The CSAR is released once the operation ends
+		executeOperation(op, func(t *operationTracker) error { return nil })
+		if _, ok := csarUsers[uploaded.CsarID]; ok {
+			t.Fatalf("TestCsarManagement did not release the CSAR of the operation")
+		}
+	})
+
+	t.Run("Delete a CSAR", func(t *testing.T) {
+		req, _ := http.NewRequest("DELETE", "/v1/csars/"+uploaded.CsarID, nil)
+		response := executeRequest(req)
+		checkResponseCode(t, http.StatusNoContent, response.Code)
+
+		if _, err := os.Stat(csarDir + "/" + uploaded.CsarID + ".csar"); !os.IsNotExist(err) {
+			t.Fatalf("TestCsarManagement did not remove the CSAR file (%v)", err)
+		}
+	})
+
+	t.Run("Get an unknown CSAR", func(t *testing.T) {
+		req, _ := http.NewRequest("GET", "/v1/csars/unknown", nil)
+		response := executeRequest(req)
+		checkResponseCode(t, http.StatusNotFound, response.Code)
+	})
+}
@@ -13,23 +13,25 @@ limitations under the License.
 
 package api
 
+import (
+	"k8-plugin-multicloud/csar"
+	"k8-plugin-multicloud/krd"
+)
+
 // CreateVnfRequest contains the VNF creation request parameters
 type CreateVnfRequest struct {
 	CloudRegionID string                   `json:"cloud_region_id"`
 	CsarID        string                   `json:"csar_id"`
+	Inputs        map[string]interface{}   `json:"inputs"`
 	OOFParams     []map[string]interface{} `json:"oof_parameters"`
 	NetworkParams NetworkParameters        `json:"network_parameters"`
 	Namespace     string                   `json:"namespace"`
 	Name          string                   `json:"vnf_instance_name"`
 	Description   string                   `json:"vnf_instance_description"`
-}
-
-// CreateVnfResponse contains the VNF creation response parameters
-type CreateVnfResponse struct {
-	VNFID         string              `json:"vnf_id"`
-	CloudRegionID string              `json:"cloud_region_id"`
-	Namespace     string              `json:"namespace"`
-	VNFComponents map[string][]string `json:"vnf_components"`
+	// Wait for every VNF component to be ready before completing the operation
+	Wait bool `json:"wait"`
+	// WaitTimeout in seconds, defaults to defaultWaitTimeout
+	WaitTimeout int `json:"wait_timeout"`
 }
 
 // ListVnfsResponse contains the list of VNFs response parameters
@@ -40,7 +42,18 @@ type ListVnfsResponse struct {
 // NetworkParameters contains the networking info required by the VNF instance
 type NetworkParameters struct {
 	OAMI OAMIPParams `json:"oam_ip_address"`
-	// Add other network parameters if necessary.
+	// Networks the workloads of the VNF instance are attached to with Multus
+	Networks []NetworkAttachmentParams `json:"networks"`
+}
+
+// NetworkAttachmentParams attaches the pods of a workload, or of every workload
+// when none is named, to a network
+type NetworkAttachmentParams struct {
+	Name         string `json:"name"`
+	Interface    string `json:"interface"`
+	IPAddress    string `json:"ip_address"`
+	MACAddress   string `json:"mac_address"`
+	WorkloadName string `json:"workload_name"`
 }
 
 // OAMIPParams contains the management networking info required by the VNF instance
@@ -50,23 +63,17 @@ type OAMIPParams struct {
 	WorkLoadName    string `json:"workload_name"`
 }
 
-// UpdateVnfRequest contains the VNF creation parameters
+// UpdateVnfRequest contains the VNF update parameters. The Cloud Region and
+// Namespace of the VNF instance are taken from the request URL.
 type UpdateVnfRequest struct {
-	CloudRegionID string                   `json:"cloud_region_id"`
 	CsarID        string                   `json:"csar_id"`
+	Inputs        map[string]interface{}   `json:"inputs"`
 	OOFParams     []map[string]interface{} `json:"oof_parameters"`
 	NetworkParams NetworkParameters        `json:"network_parameters"`
-	Namespace     string                   `json:"namespace"`
 	Name          string                   `json:"vnf_instance_name"`
 	Description   string                   `json:"vnf_instance_description"`
 }
 
-// UpdateVnfResponse contains the VNF update response parameters
-type UpdateVnfResponse struct {
-	DeploymentID string `json:"vnf_id"`
-	Name         string `json:"name"`
-}
-
 // GetVnfResponse returns information about a specific VNF instance
 type GetVnfResponse struct {
 	VNFID         string              `json:"vnf_id"`
@@ -75,7 +82,148 @@ type GetVnfResponse struct {
 	VNFComponents map[string][]string `json:"vnf_components"`
 }
 
+// GetVnfStatusResponse returns the live state of the components of a VNF instance
+type GetVnfStatusResponse struct {
+	VNFID         string                          `json:"vnf_id"`
+	CloudRegionID string                          `json:"cloud_region_id"`
+	Namespace     string                          `json:"namespace"`
+	State         string                          `json:"state"`
+	VNFComponents map[string][]krd.ResourceStatus `json:"vnf_components"`
+}
+
+// VNFRecord stores the information required to manage a VNF instance after its creation
+type VNFRecord struct {
+	VNFID         string `json:"vnf_id"`
+	CloudRegionID string `json:"cloud_region_id"`
+	Namespace     string `json:"namespace"`
+	CsarID        string `json:"csar_id"`
+	// Dependencies between the VNF resources, used to delete them in order
+	Dependencies csar.ResourceDependencies `json:"dependencies,omitempty"`
+	// Parameters the CSAR templates were rendered with, reused to heal the VNF
+	Parameters csar.InstanceParameters `json:"parameters"`
+	// Sensitive inputs given to the VNF, missing from its parameters
+	RedactedInputs []string `json:"redacted_inputs,omitempty"`
+}
+
+// DriftReport contains the result of comparing the VNFs of a cloud region with the cluster
+type DriftReport struct {
+	CloudRegionID string     `json:"cloud_region_id"`
+	Time          string     `json:"time"`
+	VNFs          []VNFDrift `json:"vnfs"`
+}
+
+// VNFDrift lists the components of a VNF which diverge from its CSAR
+type VNFDrift struct {
+	VNFID     string              `json:"vnf_id"`
+	Namespace string              `json:"namespace"`
+	CsarID    string              `json:"csar_id"`
+	Resources []krd.ResourceDrift `json:"resources"`
+	Healed    bool                `json:"healed"`
+	Error     string              `json:"error,omitempty"`
+}
+
+// GarbageCollectionRequest contains the parameters of a garbage collection
+type GarbageCollectionRequest struct {
+	CloudRegionID string `json:"cloud_region_id"`
+	DryRun        bool   `json:"dry_run"`
+}
+
+// GarbageCollectionReport lists the orphaned resources found in a cloud region
+type GarbageCollectionReport struct {
+	CloudRegionID string             `json:"cloud_region_id"`
+	DryRun        bool               `json:"dry_run"`
+	Orphans       []OrphanedResource `json:"orphans"`
+}
+
+// OrphanedResource is a resource created by the plugin whose VNF has no database record
+type OrphanedResource struct {
+	ResourceType string `json:"resource_type"`
+	Name         string `json:"name"`
+	Namespace    string `json:"namespace"`
+	VNFID        string `json:"vnf_id"`
+	Deleted      bool   `json:"deleted"`
+	Error        string `json:"error,omitempty"`
+}
+
+// RetainedVolumesReport lists the volumes kept in a cloud region after the deletion of their VNF
+type RetainedVolumesReport struct {
+	CloudRegionID string           `json:"cloud_region_id"`
+	Volumes       []RetainedVolume `json:"volumes"`
+}
+
+// RetainedVolume is a volume released by a VNF which has no database record anymore
+type RetainedVolume struct {
+	ResourceType string `json:"resource_type"`
+	Name         string `json:"name"`
+	Namespace    string `json:"namespace"`
+	VNFID        string `json:"vnf_id"`
+	CsarID       string `json:"csar_id"`
+	ReleasedAt   string `json:"released_at"`
+}
+
 // GeneralResponse is a generic response
 type GeneralResponse struct {
 	Response string `json:"response"`
 }
+
+// Operation describes the progress of an asynchronous VNF lifecycle operation
+type Operation struct {
+	ID            string              `json:"operation_id"`
+	Type          string              `json:"operation_type"`
+	State         string              `json:"state"`
+	CloudRegionID string              `json:"cloud_region_id"`
+	Namespace     string              `json:"namespace"`
+	VNFID         string              `json:"vnf_id,omitempty"`
+	VNFComponents map[string][]string `json:"vnf_components,omitempty"`
+	Resources     []ResourceProgress  `json:"resources"`
+	Readiness     map[string]string   `json:"readiness,omitempty"`
+	Error         string              `json:"error,omitempty"`
+	StartTime     string              `json:"start_time"`
+	EndTime       string              `json:"end_time,omitempty"`
+}
+
+// ResourceProgress contains the result of processing a single resource of an Operation
+type ResourceProgress struct {
+	ResourceType string `json:"resource_type"`
+	Name         string `json:"name"`
+	State        string `json:"state"`
+	Error        string `json:"error,omitempty"`
+}
+
+// CsarRecord stores the information of an uploaded CSAR
+type CsarRecord struct {
+	CsarID     string `json:"csar_id"`
+	Name       string `json:"name"`
+	Size       int64  `json:"size"`
+	UploadTime string `json:"upload_time"`
+}
+
+// ListCsarsResponse contains the uploaded CSARs
+type ListCsarsResponse struct {
+	Csars []CsarRecord `json:"csars"`
+}
+
+// GetCsarResponse returns an uploaded CSAR with its parsed metadata
+type GetCsarResponse struct {
+	CsarRecord
+	Resources []CsarResource `json:"resources"`
+	Files     []string       `json:"files"`
+}
+
+// CsarResource is a resource described in the metadata of a CSAR
+type CsarResource struct {
+	Name       string   `json:"name"`
+	Type       string   `json:"type"`
+	Files      []string `json:"files"`
+	Chart      string   `json:"chart,omitempty"`
+	ValuesFile string   `json:"values,omitempty"`
+	DataFiles  []string `json:"data_files,omitempty"`
+	DependsOn  []string `json:"depends_on,omitempty"`
+}
+
+// ValidateCsarResponse contains the problems found in a CSAR
+type ValidateCsarResponse struct {
+	CsarID   string   `json:"csar_id"`
+	Valid    bool     `json:"valid"`
+	Problems []string `json:"problems"`
+}
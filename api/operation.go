@@ -0,0 +1,345 @@
+/*
+Copyright 2018 Intel Corporation.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package api
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+	pkgerrors "github.com/pkg/errors"
+	"k8s.io/apimachinery/pkg/util/uuid"
+
+	"k8-plugin-multicloud/db"
+)
+
+// Types of VNF lifecycle operations
+const (
+	OperationCreate = "create"
+	OperationUpdate = "update"
+	OperationDelete = "delete"
+)
+
+// States of an operation and of the resources processed by it
+const (
+	OperationPending   = "pending"
+	OperationRunning   = "running"
+	OperationSucceeded = "succeeded"
+	OperationFailed    = "failed"
+)
+
+// operationKeyPrefix is prepended to the operation ID to build its database key
+const operationKeyPrefix = "operations/"
+
+// operationTracker serializes the updates of an operation and persists them
+type operationTracker struct {
+	sync.Mutex
+	op *Operation
+	// Internal ID of the VNF modified by the operation, and its lock
+	vnf  string
+	lock *vnfLock
+	// ID of the CSAR read by the operation, see useCsar
+	csar string
+}
+
+// vnfLock serializes the changes of a VNF, by its lifecycle operations and by
+// the reconciler healing it
+type vnfLock struct {
+	sync.Mutex
+	// Operations pending, running or healing the VNF
+	users int
+}
+
+var (
+	vnfLocksMutex sync.Mutex
+	// Locks of the VNFs with pending or running operations, by internal VNF ID
+	vnfLocks = map[string]*vnfLock{}
+)
+
+// registerVNF records a pending change of a VNF. The change waits for the
+// previous ones with lock.Lock and ends with releaseVNF. With idleOnly the VNF
+// is only registered when no change is pending, the reconciler does not heal a
+// VNF being modified.
+func registerVNF(internalVNFID string, idleOnly bool) (*vnfLock, bool) {
+	vnfLocksMutex.Lock()
+	defer vnfLocksMutex.Unlock()
+
+	lock, ok := vnfLocks[internalVNFID]
+	if !ok {
+		lock = &vnfLock{}
+		vnfLocks[internalVNFID] = lock
+	} else if idleOnly {
+		return nil, false
+	}
+
+	lock.users++
+	return lock, true
+}
+
+// releaseVNF ends a change of a VNF registered and locked
+func releaseVNF(internalVNFID string, lock *vnfLock) {
+	lock.Unlock()
+
+	vnfLocksMutex.Lock()
+	defer vnfLocksMutex.Unlock()
+
+	lock.users--
+	if lock.users == 0 {
+		delete(vnfLocks, internalVNFID)
+	}
+}
+
+var (
+	csarUsersMutex sync.Mutex
+	// Pending or running operations reading each CSAR, by CSAR ID
+	csarUsers = map[string]int{}
+)
+
+// useCsar keeps the CSAR read by an operation from being deleted until the
+// operation ends
+func (t *operationTracker) useCsar(csarID string) {
+	csarUsersMutex.Lock()
+	defer csarUsersMutex.Unlock()
+
+	t.csar = csarID
+	csarUsers[csarID]++
+}
+
+// releaseCsar ends the use of a CSAR by an operation
+func releaseCsar(csarID string) {
+	csarUsersMutex.Lock()
+	defer csarUsersMutex.Unlock()
+
+	csarUsers[csarID]--
+	if csarUsers[csarID] == 0 {
+		delete(csarUsers, csarID)
+	}
+}
+
+// newOperation creates and persists a new pending operation
+func newOperation(opType string, cloudRegionID string, namespace string, vnfID string) (*operationTracker, error) {
+	t := &operationTracker{
+		op: &Operation{
+			ID:            string(uuid.NewUUID()),
+			Type:          opType,
+			State:         OperationPending,
+			CloudRegionID: cloudRegionID,
+			Namespace:     namespace,
+			VNFID:         vnfID,
+			Resources:     []ResourceProgress{},
+			StartTime:     time.Now().UTC().Format(time.RFC3339),
+		},
+	}
+
+	err := saveOperation(t.op)
+	if err != nil {
+		return nil, pkgerrors.Wrap(err, "Create operation error")
+	}
+
+	// A VNF created by the operation is locked once its ID is known, see lockVNF
+	if vnfID != "" {
+		// cloud1-default-uuid
+		t.vnf = cloudRegionID + "-" + namespace + "-" + vnfID
+		t.lock, _ = registerVNF(t.vnf, false)
+	}
+
+	return t, nil
+}
+
+// lockVNF locks the VNF created by an operation until the operation ends, it
+// must be called before the VNF is persisted
+func (t *operationTracker) lockVNF(internalVNFID string) {
+	t.vnf = internalVNFID
+	t.lock, _ = registerVNF(internalVNFID, false)
+	t.lock.Lock()
+}
+
+// update applies fn to the operation and persists the result
+func (t *operationTracker) update(fn func(op *Operation)) {
+	t.Lock()
+	defer t.Unlock()
+
+	fn(t.op)
+
+	err := saveOperation(t.op)
+	if err != nil {
+		log.Println("Error saving operation " + t.op.ID + ": " + err.Error())
+	}
+}
+
+// progress records the result of processing a resource, it satisfies csar.ProgressFunc
+func (t *operationTracker) progress(resourceType string, name string, err error) {
+	t.update(func(op *Operation) {
+		resource := ResourceProgress{
+			ResourceType: resourceType,
+			Name:         name,
+			State:        OperationSucceeded,
+		}
+		if err != nil {
+			resource.State = OperationFailed
+			resource.Error = err.Error()
+		}
+		op.Resources = append(op.Resources, resource)
+	})
+}
+
+// snapshot returns a copy of the current operation state
+func (t *operationTracker) snapshot() Operation {
+	t.Lock()
+	defer t.Unlock()
+
+	return *t.op
+}
+
+// runOperation executes fn in the background keeping the operation state up to date
+var runOperation = func(t *operationTracker, fn func(t *operationTracker) error) {
+	go executeOperation(t, fn)
+}
+
+func executeOperation(t *operationTracker, fn func(t *operationTracker) error) {
+	// The operation stays pending while another change of the VNF runs
+	if t.lock != nil {
+		t.lock.Lock()
+	}
+
+	t.update(func(op *Operation) {
+		op.State = OperationRunning
+	})
+
+	err := fn(t)
+
+	if t.lock != nil {
+		releaseVNF(t.vnf, t.lock)
+	}
+	if t.csar != "" {
+		releaseCsar(t.csar)
+	}
+
+	t.update(func(op *Operation) {
+		op.EndTime = time.Now().UTC().Format(time.RFC3339)
+		if err != nil {
+			log.Println("Operation " + op.ID + " failed: " + err.Error())
+			op.State = OperationFailed
+			op.Error = err.Error()
+			return
+		}
+		op.State = OperationSucceeded
+	})
+}
+
+func saveOperation(op *Operation) error {
+	out, err := json.Marshal(op)
+	if err != nil {
+		return pkgerrors.Wrap(err, "Serialize operation error")
+	}
+
+	return db.DBconn.CreateEntry(operationKeyPrefix+op.ID, string(out))
+}
+
+func readOperation(id string) (Operation, bool, error) {
+	var op Operation
+
+	value, found, err := db.DBconn.ReadEntry(operationKeyPrefix + id)
+	if err != nil || found == false {
+		return op, found, err
+	}
+
+	err = json.Unmarshal([]byte(value), &op)
+	if err != nil {
+		return op, true, pkgerrors.Wrap(err, "Deserialize operation error")
+	}
+
+	return op, true, nil
+}
+
+// RecoverOperations marks as failed the operations which were still pending or
+// running when the plugin was stopped, since nothing is going to resume them
+func RecoverOperations() error {
+	keys, err := db.DBconn.ReadAll(operationKeyPrefix)
+	if err != nil {
+		return pkgerrors.Wrap(err, "Read operations error")
+	}
+
+	for _, key := range keys {
+		if key == "" {
+			continue
+		}
+
+		op, found, err := readOperation(strings.TrimPrefix(key, operationKeyPrefix))
+		if err != nil {
+			return err
+		}
+
+		if found == false || (op.State != OperationPending && op.State != OperationRunning) {
+			continue
+		}
+
+		log.Println("Marking interrupted operation " + op.ID + " as failed")
+		op.State = OperationFailed
+		op.Error = "Operation interrupted by a plugin restart"
+		op.EndTime = time.Now().UTC().Format(time.RFC3339)
+
+		err = saveOperation(&op)
+		if err != nil {
+			return pkgerrors.Wrap(err, "Recover operation error")
+		}
+	}
+
+	return nil
+}
+
+// writeOperationAccepted answers a lifecycle request with the operation tracking it
+func writeOperationAccepted(w http.ResponseWriter, t *operationTracker) {
+	resp := t.snapshot()
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Location", "/v1/operations/"+resp.ID)
+	w.WriteHeader(http.StatusAccepted)
+
+	err := json.NewEncoder(w).Encode(resp)
+	if err != nil {
+		werr := pkgerrors.Wrap(err, "Parsing output of operation error")
+		http.Error(w, werr.Error(), http.StatusInternalServerError)
+	}
+}
+
+// GetOperationHandler returns the state of an asynchronous VNF lifecycle operation
+func GetOperationHandler(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	id := vars["operationID"]
+
+	op, found, err := readOperation(id)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	if found == false {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+
+	err = json.NewEncoder(w).Encode(op)
+	if err != nil {
+		werr := pkgerrors.Wrap(err, "Parsing output of operation error")
+		http.Error(w, werr.Error(), http.StatusInternalServerError)
+	}
+}
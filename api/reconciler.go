@@ -0,0 +1,295 @@
+/*
+Copyright 2018 Intel Corporation.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package api
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+	pkgerrors "github.com/pkg/errors"
+	"k8s.io/client-go/kubernetes"
+
+	"k8-plugin-multicloud/csar"
+	"k8-plugin-multicloud/db"
+	"k8-plugin-multicloud/krd"
+)
+
+var (
+	driftReportsMutex sync.Mutex
+	// Latest drift report of each cloud region
+	driftReports = map[string]DriftReport{}
+)
+
+// StartReconcilers starts a background reconciler for every cloud region with a
+// kubeconfig file in KUBE_CONFIG_DIR. The reconcilers run every RECONCILE_INTERVAL
+// seconds, they are disabled when the variable is not set. When RECONCILE_HEAL is
+// "true" the CSAR of every drifted VNF is re-applied.
+func StartReconcilers() error {
+	value, ok := os.LookupEnv("RECONCILE_INTERVAL")
+	if !ok {
+		return nil
+	}
+
+	seconds, err := strconv.Atoi(value)
+	if err != nil || seconds <= 0 {
+		return pkgerrors.New("RECONCILE_INTERVAL must be a positive number of seconds")
+	}
+
+	heal := os.Getenv("RECONCILE_HEAL") == "true"
+
+	cloudRegionIDs, err := listCloudRegions()
+	if err != nil {
+		return err
+	}
+
+	for _, cloudRegionID := range cloudRegionIDs {
+		log.Println("Starting reconciler for cloud region " + cloudRegionID)
+		go runReconciler(cloudRegionID, time.Duration(seconds)*time.Second, heal)
+	}
+
+	return nil
+}
+
+// listCloudRegions returns the cloud regions with a kubeconfig file in KUBE_CONFIG_DIR
+func listCloudRegions() ([]string, error) {
+	files, err := ioutil.ReadDir(os.Getenv("KUBE_CONFIG_DIR"))
+	if err != nil {
+		return nil, pkgerrors.Wrap(err, "Read kubeconfig directory error")
+	}
+
+	var cloudRegionIDs []string
+	for _, file := range files {
+		if !file.IsDir() {
+			cloudRegionIDs = append(cloudRegionIDs, file.Name())
+		}
+	}
+
+	return cloudRegionIDs, nil
+}
+
+func runReconciler(cloudRegionID string, interval time.Duration, heal bool) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		_, err := reconcileCloudRegion(cloudRegionID, heal)
+		if err != nil {
+			log.Println("Reconciliation of cloud region " + cloudRegionID + " failed: " + err.Error())
+		}
+	}
+}
+
+// reconcileCloudRegion compares every VNF of a cloud region with the cluster,
+// optionally healing the drifted ones, and stores the resulting report
+var reconcileCloudRegion = func(cloudRegionID string, heal bool) (DriftReport, error) {
+	report := DriftReport{
+		CloudRegionID: cloudRegionID,
+		Time:          time.Now().UTC().Format(time.RFC3339),
+		VNFs:          []VNFDrift{},
+	}
+
+	kubeclient, err := GetVNFClient(os.Getenv("KUBE_CONFIG_DIR") + "/" + cloudRegionID)
+	if err != nil {
+		return report, err
+	}
+
+	records, err := readVNFRecords(cloudRegionID)
+	if err != nil {
+		return report, err
+	}
+
+	for _, record := range records {
+		// The manifests of the VNF cannot be rendered again without its sensitive inputs
+		if len(record.RedactedInputs) > 0 {
+			report.VNFs = append(report.VNFs, VNFDrift{
+				VNFID:     record.VNFID,
+				Namespace: record.Namespace,
+				CsarID:    record.CsarID,
+				Error:     "Drift not checked: sensitive inputs " + strings.Join(record.RedactedInputs, ", ") + " are not stored",
+			})
+			continue
+		}
+
+		// cloud1-default-uuid
+		internalVNFID := record.CloudRegionID + "-" + record.Namespace + "-" + record.VNFID
+
+		resourceNameMap, _, found, err := readVNFResources(internalVNFID)
+		if err != nil {
+			return report, err
+		}
+		if found == false {
+			// The VNF was deleted in the meantime
+			continue
+		}
+
+		drifts, err := csar.DetectDrift(record.CsarID, record.CloudRegionID, record.Namespace, record.VNFID,
+			record.Parameters, resourceNameMap, &kubeclient)
+		if err != nil {
+			report.VNFs = append(report.VNFs, VNFDrift{
+				VNFID:     record.VNFID,
+				Namespace: record.Namespace,
+				CsarID:    record.CsarID,
+				Error:     err.Error(),
+			})
+			continue
+		}
+
+		if len(drifts) == 0 {
+			continue
+		}
+
+		vnfDrift := VNFDrift{
+			VNFID:     record.VNFID,
+			Namespace: record.Namespace,
+			CsarID:    record.CsarID,
+			Resources: drifts,
+		}
+
+		// Re-applying the CSAR only restores the missing and modified resources,
+		// the extra objects are left to the user
+		if heal && needsHealing(drifts) {
+			// The pending and running operations of the VNF would be undone
+			lock, ok := registerVNF(internalVNFID, true)
+			if !ok {
+				vnfDrift.Error = "Not healed: an operation is in progress on the VNF"
+				report.VNFs = append(report.VNFs, vnfDrift)
+				continue
+			}
+
+			log.Println("Healing VNF " + record.VNFID)
+			lock.Lock()
+			err = healVNF(internalVNFID, &kubeclient)
+			releaseVNF(internalVNFID, lock)
+			if err != nil {
+				vnfDrift.Error = err.Error()
+			} else {
+				vnfDrift.Healed = true
+			}
+		}
+
+		report.VNFs = append(report.VNFs, vnfDrift)
+	}
+
+	driftReportsMutex.Lock()
+	driftReports[cloudRegionID] = report
+	driftReportsMutex.Unlock()
+
+	return report, nil
+}
+
+// needsHealing checks if some resources of a VNF are missing or differ from their manifest
+func needsHealing(drifts []krd.ResourceDrift) bool {
+	for _, drift := range drifts {
+		if !drift.Extra {
+			return true
+		}
+	}
+	return false
+}
+
+// healVNF re-applies the CSAR of a VNF and stores its resulting resources. The
+// VNF is read again as it may have been changed since its drift was detected.
+func healVNF(internalVNFID string, kubeclient *kubernetes.Clientset) error {
+	deserializedResourceNameMap, record, found, err := readVNFResources(internalVNFID)
+	if err != nil {
+		return pkgerrors.Wrap(err, "Heal VNF error")
+	}
+
+	if found == false || record.CsarID == "" {
+		return pkgerrors.New("Heal VNF error: VNF " + internalVNFID + " not found")
+	}
+
+	if len(record.RedactedInputs) > 0 {
+		return pkgerrors.New("Heal VNF error: sensitive inputs " + strings.Join(record.RedactedInputs, ", ") +
+			" of VNF " + internalVNFID + " are not stored, update the VNF instead")
+	}
+
+	resourceNameMap, dependencies, err := csar.UpdateVNF(record.CsarID, record.CloudRegionID, record.Namespace, record.VNFID,
+		record.Parameters, deserializedResourceNameMap, record.Dependencies, nil, kubeclient)
+	if err != nil {
+		return pkgerrors.Wrap(err, "Heal VNF error")
+	}
+
+	out, err := json.Marshal(resourceNameMap)
+	if err != nil {
+		return pkgerrors.Wrap(err, "Heal VNF error")
+	}
+
+	err = db.DBconn.CreateEntry(internalVNFID, string(out))
+	if err != nil {
+		return pkgerrors.Wrap(err, "Heal VNF error")
+	}
+
+	record.Dependencies = dependencies
+	return saveVNFRecord(record)
+}
+
+// DriftReportHandler returns the latest drift report of a cloud region. A new
+// report is generated when none exists yet or when the refresh query parameter
+// is "true".
+func DriftReportHandler(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	cloudRegionID := vars["cloudRegionID"]
+
+	driftReportsMutex.Lock()
+	report, ok := driftReports[cloudRegionID]
+	driftReportsMutex.Unlock()
+
+	if !ok || r.URL.Query().Get("refresh") == "true" {
+		var err error
+		report, err = reconcileCloudRegion(cloudRegionID, false)
+		if err != nil {
+			werr := pkgerrors.Wrap(err, "Drift report error")
+			http.Error(w, werr.Error(), http.StatusInternalServerError)
+			return
+		}
+	}
+
+	writeDriftReport(w, report)
+}
+
+// HealDriftHandler re-applies the CSAR of the drifted VNFs of a cloud region and
+// returns the resulting drift report
+func HealDriftHandler(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	cloudRegionID := vars["cloudRegionID"]
+
+	report, err := reconcileCloudRegion(cloudRegionID, true)
+	if err != nil {
+		werr := pkgerrors.Wrap(err, "Heal drift error")
+		http.Error(w, werr.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	writeDriftReport(w, report)
+}
+
+func writeDriftReport(w http.ResponseWriter, report DriftReport) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+
+	err := json.NewEncoder(w).Encode(report)
+	if err != nil {
+		werr := pkgerrors.Wrap(err, "Parsing output of drift report error")
+		http.Error(w, werr.Error(), http.StatusInternalServerError)
+	}
+}
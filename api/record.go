@@ -0,0 +1,159 @@
+/*
+Copyright 2018 Intel Corporation.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package api
+
+import (
+	"encoding/json"
+	"sort"
+	"strings"
+
+	pkgerrors "github.com/pkg/errors"
+
+	"k8-plugin-multicloud/csar"
+	"k8-plugin-multicloud/db"
+)
+
+// vnfRecordKeyPrefix is prepended to the internal VNF ID to build the database
+// key of its VNFRecord. The resource name map keeps using the internal VNF ID.
+const vnfRecordKeyPrefix = "vnf_records/"
+
+func saveVNFRecord(record VNFRecord) error {
+	out, err := json.Marshal(record)
+	if err != nil {
+		return pkgerrors.Wrap(err, "Serialize VNF record error")
+	}
+
+	// cloud1-default-uuid
+	internalVNFID := record.CloudRegionID + "-" + record.Namespace + "-" + record.VNFID
+
+	return db.DBconn.CreateEntry(vnfRecordKeyPrefix+internalVNFID, string(out))
+}
+
+func readVNFRecord(internalVNFID string) (VNFRecord, bool, error) {
+	var record VNFRecord
+
+	value, found, err := db.DBconn.ReadEntry(vnfRecordKeyPrefix + internalVNFID)
+	if err != nil || found == false {
+		return record, found, err
+	}
+
+	err = json.Unmarshal([]byte(value), &record)
+	if err != nil {
+		return record, true, pkgerrors.Wrap(err, "Deserialize VNF record error")
+	}
+
+	return record, true, nil
+}
+
+// readVNFResources returns the internal names of the resources of a VNF by type
+// and its record. VNFs created before the records were introduced have an empty
+// record.
+func readVNFResources(internalVNFID string) (map[string][]string, VNFRecord, bool, error) {
+	// key: cloud1-default-uuid
+	// value: "{"deployment":<>,"service":<>}"
+	serializedResourceNameMap, found, err := db.DBconn.ReadEntry(internalVNFID)
+	if err != nil || found == false {
+		return nil, VNFRecord{}, found, err
+	}
+
+	deserializedResourceNameMap := make(map[string][]string)
+	err = json.Unmarshal([]byte(serializedResourceNameMap), &deserializedResourceNameMap)
+	if err != nil {
+		return nil, VNFRecord{}, true, pkgerrors.Wrap(err, "Deserialize VNF resources error")
+	}
+
+	record, _, err := readVNFRecord(internalVNFID)
+	if err != nil {
+		return nil, VNFRecord{}, true, err
+	}
+
+	return deserializedResourceNameMap, record, true, nil
+}
+
+// redactParameters returns the parameters of a VNF without the values of the
+// sensitive inputs of its CSAR, which are not stored, and the names of the
+// inputs removed
+func redactParameters(csarID string, params csar.InstanceParameters) (csar.InstanceParameters, []string, error) {
+	sensitive, err := csar.SensitiveInputs(csarID)
+	if err != nil {
+		return params, nil, err
+	}
+
+	var redacted []string
+	inputs := make(map[string]interface{})
+	for name, value := range params.Inputs {
+		inputs[name] = value
+	}
+	for _, name := range sensitive {
+		if _, ok := inputs[name]; ok {
+			delete(inputs, name)
+			redacted = append(redacted, name)
+		}
+	}
+
+	if len(redacted) == 0 {
+		return params, nil, nil
+	}
+
+	sort.Strings(redacted)
+	params.Inputs = inputs
+	return params, redacted, nil
+}
+
+func deleteVNFRecord(internalVNFID string) error {
+	return db.DBconn.DeleteEntry(vnfRecordKeyPrefix + internalVNFID)
+}
+
+// readAllVNFRecords returns the records of all the VNFs
+func readAllVNFRecords() ([]VNFRecord, error) {
+	keys, err := db.DBconn.ReadAll(vnfRecordKeyPrefix)
+	if err != nil {
+		return nil, pkgerrors.Wrap(err, "Read VNF records error")
+	}
+
+	var records []VNFRecord
+	for _, key := range keys {
+		if !strings.HasPrefix(key, vnfRecordKeyPrefix) {
+			continue
+		}
+
+		record, found, err := readVNFRecord(key[len(vnfRecordKeyPrefix):])
+		if err != nil {
+			return nil, err
+		}
+
+		if found {
+			records = append(records, record)
+		}
+	}
+
+	return records, nil
+}
+
+// readVNFRecords returns the records of all the VNFs created in a cloud region
+func readVNFRecords(cloudRegionID string) ([]VNFRecord, error) {
+	records, err := readAllVNFRecords()
+	if err != nil {
+		return nil, err
+	}
+
+	var regionRecords []VNFRecord
+	for _, record := range records {
+		if record.CloudRegionID == cloudRegionID {
+			regionRecords = append(regionRecords, record)
+		}
+	}
+
+	return regionRecords, nil
+}
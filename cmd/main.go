@@ -40,6 +40,16 @@ func main() {
 		log.Fatal(err)
 	}
 
+	err = api.StartReconcilers()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	err = api.StartGarbageCollectors()
+	if err != nil {
+		log.Fatal(err)
+	}
+
 	router := api.NewRouter(kubeconfig)
 	loggedRouter := handlers.LoggingHandler(os.Stdout, router)
 	log.Println("Starting Kubernetes Multicloud API")
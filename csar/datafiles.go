@@ -0,0 +1,110 @@
+/*
+Copyright 2018 Intel Corporation.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package csar
+
+import (
+	"encoding/json"
+	"path"
+	"regexp"
+	"unicode/utf8"
+
+	pkgerrors "github.com/pkg/errors"
+	coreV1 "k8s.io/api/core/v1"
+	metaV1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// Resource types which can be built from the data files of a package
+const (
+	configMapResourceType = "configmap"
+	secretResourceType    = "secret"
+)
+
+// dataSource is the source of the manifests built from data files, they are
+// described in the metadata of the package
+const dataSource = "metadata.yaml"
+
+// dataKeyPattern matches the keys allowed in ConfigMaps and Secrets
+var dataKeyPattern = regexp.MustCompile(`^[-._a-zA-Z0-9]+$`)
+
+// dataManifest builds the ConfigMap or Secret of a resource from its data
+// files, stored as they are in the package. Each file is a key named after the
+// file. The content of the files is never part of the errors returned.
+//
+//	resources:
+//	- name: vfw-config
+//	  type: configmap
+//	  data_files:
+//	  - config/vfw.conf
+func dataManifest(csarPackage Package, resource MetadataResource) (manifest, error) {
+	data := make(map[string][]byte)
+	for _, filename := range resource.DataFiles {
+		key := path.Base(filename)
+		if !dataKeyPattern.MatchString(key) {
+			return manifest{}, pkgerrors.New("Data file " + filename + " is not a valid key")
+		}
+		if _, ok := data[key]; ok {
+			return manifest{}, pkgerrors.New("Duplicated data file " + key)
+		}
+
+		rawBytes, err := csarPackage.ReadFile(filename)
+		if err != nil {
+			return manifest{}, err
+		}
+		data[key] = rawBytes
+	}
+
+	var object interface{}
+	switch resource.Type {
+	case configMapResourceType:
+		configMap := &coreV1.ConfigMap{
+			TypeMeta:   metaV1.TypeMeta{APIVersion: "v1", Kind: "ConfigMap"},
+			ObjectMeta: metaV1.ObjectMeta{Name: resource.Name},
+			Data:       make(map[string]string),
+		}
+		for key, value := range data {
+			if utf8.Valid(value) {
+				configMap.Data[key] = string(value)
+				continue
+			}
+			if configMap.BinaryData == nil {
+				configMap.BinaryData = make(map[string][]byte)
+			}
+			configMap.BinaryData[key] = value
+		}
+		object = configMap
+	case secretResourceType:
+		object = &coreV1.Secret{
+			TypeMeta:   metaV1.TypeMeta{APIVersion: "v1", Kind: "Secret"},
+			ObjectMeta: metaV1.ObjectMeta{Name: resource.Name},
+			Type:       coreV1.SecretTypeOpaque,
+			Data:       data,
+		}
+	default:
+		return manifest{}, pkgerrors.New("Data files are only supported by configmap and secret resources")
+	}
+
+	// JSON documents are valid YAML documents
+	rawBytes, err := json.Marshal(object)
+	if err != nil {
+		return manifest{}, pkgerrors.Wrap(err, "Error building "+resource.Type+" "+resource.Name)
+	}
+
+	return manifest{
+		resourceType: pluginType(resource.Type),
+		source:       dataSource,
+		data:         rawBytes,
+		kind:         resource.Type,
+		name:         resource.Name,
+	}, nil
+}
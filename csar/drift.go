@@ -0,0 +1,171 @@
+/*
+Copyright 2018 Intel Corporation.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package csar
+
+import (
+	"sort"
+
+	pkgerrors "github.com/pkg/errors"
+	metaV1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+
+	"k8-plugin-multicloud/krd"
+)
+
+// DetectDrift compares every resource described in the CSAR of a VNF with its
+// live state in the cluster using the DiffResource function of each plugin. It
+// returns the resources which are missing or differ from their manifest, rendered
+// with the parameters the VNF was instantiated with, followed by the objects
+// labeled as owned by the VNF which are not in resourceNameMap, its record.
+var DetectDrift = func(csarID string, cloudRegionID string, namespace string, externalVNFID string,
+	params InstanceParameters, resourceNameMap map[string][]string,
+	kubeclient *kubernetes.Clientset) ([]krd.ResourceDrift, error) {
+
+	// cloud1-default-uuid
+	internalVNFID := cloudRegionID + "-" + namespace + "-" + externalVNFID
+
+	csarPackage, err := OpenPackage(csarID)
+	if err != nil {
+		return nil, err
+	}
+	defer csarPackage.Close()
+
+	seqFile, err := csarPackage.Metadata()
+	if err != nil {
+		return nil, pkgerrors.Wrap(err, "Error while reading Metadata File of CSAR "+csarID)
+	}
+
+	values, err := newTemplateValues(csarID, seqFile.Inputs, params)
+	if err != nil {
+		return nil, err
+	}
+
+	values.Namespace = namespace
+
+	manifests := make(map[string][]manifest)
+	for _, resource := range seqFile.Resources {
+		objects, errs := renderResource(csarPackage, resource, values)
+		if len(errs) > 0 {
+			return nil, errs[0]
+		}
+		manifests[resource.Name] = objects
+	}
+
+	kubedata := krd.GenericKubeResourceData{
+		Namespace:     namespace,
+		InternalVNFID: internalVNFID,
+		ExternalVNFID: externalVNFID,
+		CloudRegionID: cloudRegionID,
+		CsarID:        csarID,
+	}
+	kubedata.Names = vnfNames(manifests, kubedata)
+	networks := vnfNetworks(params)
+
+	var drifts []krd.ResourceDrift
+
+	for _, resource := range seqFile.Resources {
+		for _, object := range manifests[resource.Name] {
+			typePlugin, ok := krd.LoadedPlugins[object.resourceType]
+			if !ok {
+				return nil, pkgerrors.New("No plugin for resource " + object.resourceType + " found")
+			}
+
+			symDiffResourceFunc, err := typePlugin.Lookup("DiffResource")
+			if err != nil {
+				return nil, pkgerrors.Wrap(err, "Error fetching "+object.resourceType+" plugin")
+			}
+
+			data, err := rewriteManifest(object, &kubedata, networks)
+			if err != nil {
+				return nil, err
+			}
+
+			genericKubeData := kubedata
+			genericKubeData.YamlFilePath = csarPackage.Path(object.source)
+			genericKubeData.YamlData = data
+			genericKubeData.Retain = object.retain
+
+			drift, err := symDiffResourceFunc.(func(*krd.GenericKubeResourceData, *kubernetes.Clientset) (*krd.ResourceDrift, error))(
+				&genericKubeData, kubeclient)
+			if err != nil {
+				return nil, pkgerrors.Wrap(err, "Error in plugin "+object.resourceType+" plugin")
+			}
+
+			if drift.Missing || len(drift.Differences) > 0 {
+				drift.ResourceType = object.resourceType
+				drifts = append(drifts, *drift)
+			}
+		}
+	}
+
+	extras, err := extraObjects(internalVNFID, resourceNameMap, kubeclient)
+	if err != nil {
+		return nil, err
+	}
+
+	return append(drifts, extras...), nil
+}
+
+// extraObjects lists the objects owned by a VNF through the ListOwnedResources
+// function of the plugins and returns the ones missing from its record, left
+// behind by a failed delete or update for instance
+func extraObjects(internalVNFID string, resourceNameMap map[string][]string,
+	kubeclient *kubernetes.Clientset) ([]krd.ResourceDrift, error) {
+
+	// Sorted to produce a stable report
+	var resourceTypes []string
+	for resourceType := range krd.LoadedPlugins {
+		resourceTypes = append(resourceTypes, resourceType)
+	}
+	sort.Strings(resourceTypes)
+
+	var extras []krd.ResourceDrift
+
+	for _, resourceType := range resourceTypes {
+		symListOwnedFunc, err := krd.LoadedPlugins[resourceType].Lookup("ListOwnedResources")
+		if err != nil {
+			// The plugin does not create labeled resources
+			continue
+		}
+
+		items, err := symListOwnedFunc.(func(*kubernetes.Clientset) ([]metaV1.ObjectMeta, error))(kubeclient)
+		if err != nil {
+			return nil, pkgerrors.Wrap(err, "Error in plugin "+resourceType+" plugin")
+		}
+
+		recorded := make(map[string]bool)
+		for _, name := range resourceNameMap[resourceType] {
+			recorded[name] = true
+		}
+
+		for _, item := range items {
+			// cloud1-default-uuid
+			owner := item.Annotations[krd.InternalVNFIDAnnotation]
+			if owner == "" {
+				owner = item.Labels[krd.CloudRegionIDLabel] + "-" + item.Namespace + "-" + item.Labels[krd.VNFIDLabel]
+			}
+			if owner != internalVNFID || recorded[item.Name] {
+				continue
+			}
+
+			extras = append(extras, krd.ResourceDrift{
+				ResourceType: resourceType,
+				Name:         item.Name,
+				Extra:        true,
+			})
+		}
+	}
+
+	return extras, nil
+}
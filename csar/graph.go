@@ -0,0 +1,285 @@
+/*
+Copyright 2018 Intel Corporation.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package csar
+
+import (
+	"sort"
+	"strings"
+
+	pkgerrors "github.com/pkg/errors"
+)
+
+// ResourceDependencies maps every object created for a VNF to the objects it
+// depends on. Objects are identified by "<resource type>/<internal name>".
+type ResourceDependencies map[string][]string
+
+// ResourceObjects maps every resource of the metadata file of a VNF to the
+// objects created for it, identified by "<resource type>/<internal name>"
+type ResourceObjects map[string][]string
+
+func (r createdResource) key() string {
+	return r.resourceType + "/" + r.name
+}
+
+// resourceFunc creates or updates the object described by a manifest and
+// returns its internal name
+type resourceFunc func(object manifest) (string, error)
+
+// resourceLevels validates the dependencies between the resources of a metadata
+// file and sorts them topologically. The resources of a level only depend on
+// resources of the previous levels, so they can be processed concurrently.
+func resourceLevels(resources []MetadataResource) ([][]MetadataResource, error) {
+	index := make(map[string]int)
+	for i, resource := range resources {
+		if resource.Name == "" {
+			return nil, pkgerrors.New("Resource without name found")
+		}
+		if _, ok := index[resource.Name]; ok {
+			return nil, pkgerrors.New("Duplicated resource " + resource.Name)
+		}
+		index[resource.Name] = i
+	}
+
+	// Number of dependencies of each resource which are not sorted yet
+	pending := make([]int, len(resources))
+	dependents := make(map[string][]int)
+	for i, resource := range resources {
+		for _, dependency := range resource.DependsOn {
+			if _, ok := index[dependency]; !ok {
+				return nil, pkgerrors.New("Resource " + resource.Name + " depends on unknown resource " + dependency)
+			}
+			pending[i]++
+			dependents[dependency] = append(dependents[dependency], i)
+		}
+	}
+
+	var current []int
+	for i := range resources {
+		if pending[i] == 0 {
+			current = append(current, i)
+		}
+	}
+
+	var levels [][]MetadataResource
+	sorted := 0
+	for len(current) > 0 {
+		var level []MetadataResource
+		var next []int
+		for _, i := range current {
+			level = append(level, resources[i])
+			sorted++
+			for _, j := range dependents[resources[i].Name] {
+				pending[j]--
+				if pending[j] == 0 {
+					next = append(next, j)
+				}
+			}
+		}
+		// Keep the order of the metadata file inside a level
+		sort.Ints(next)
+		levels = append(levels, level)
+		current = next
+	}
+
+	if sorted != len(resources) {
+		var cycle []string
+		for i, resource := range resources {
+			if pending[i] > 0 {
+				cycle = append(cycle, resource.Name)
+			}
+		}
+		return nil, pkgerrors.New("Dependency cycle between resources: " + strings.Join(cycle, ", "))
+	}
+
+	return levels, nil
+}
+
+// applyResources calls apply for every manifest of the resources on a pool of
+// workers. The manifests of a resource are processed once all the manifests of
+// the resources it depends on succeeded and no manifest is processed after the
+// first failure. It returns the objects processed successfully, by resource
+// name and in an order compatible with the dependencies, and the first error.
+func applyResources(levels [][]MetadataResource, manifests map[string][]manifest,
+	apply resourceFunc) (map[string][]createdResource, []createdResource, error) {
+
+	type task struct {
+		resource MetadataResource
+		object   manifest
+	}
+
+	var tasks []task
+	var requirements [][]int
+	resourceTasks := make(map[string][]int)
+
+	for _, level := range levels {
+		for _, resource := range level {
+			var required []int
+			for _, dependency := range resource.DependsOn {
+				required = append(required, resourceTasks[dependency]...)
+			}
+			for _, object := range manifests[resource.Name] {
+				resourceTasks[resource.Name] = append(resourceTasks[resource.Name], len(tasks))
+				tasks = append(tasks, task{resource: resource, object: object})
+				requirements = append(requirements, required)
+			}
+		}
+	}
+
+	names := make([]string, len(tasks))
+	errs := runTasks(requirements, workerCount(), func(i int) error {
+		var err error
+		names[i], err = apply(tasks[i].object)
+		return err
+	})
+
+	objects := make(map[string][]createdResource)
+	var ordered []createdResource
+	for i, t := range tasks {
+		if errs[i] != nil {
+			continue
+		}
+		object := createdResource{resourceType: t.object.resourceType, name: names[i]}
+		objects[t.resource.Name] = append(objects[t.resource.Name], object)
+		ordered = append(ordered, object)
+	}
+
+	return objects, ordered, firstError(errs)
+}
+
+// resourceObjects returns the objects created for every resource
+func resourceObjects(objects map[string][]createdResource) ResourceObjects {
+	result := make(ResourceObjects)
+	for resourceName, created := range objects {
+		for _, object := range created {
+			result[resourceName] = append(result[resourceName], object.key())
+		}
+	}
+	return result
+}
+
+// resourceNameMap groups the internal names of the objects by resource type
+func resourceNameMap(ordered []createdResource) map[string][]string {
+	/*
+		{
+			"deployment": ["cloud1-default-uuid-sisedeploy1", "cloud1-default-uuid-sisedeploy2", ... ]
+			"service": ["cloud1-default-uuid-sisesvc1", "cloud1-default-uuid-sisesvc2", ... ]
+		}
+	*/
+	nameMap := make(map[string][]string)
+	for _, resource := range ordered {
+		nameMap[resource.resourceType] = append(nameMap[resource.resourceType], resource.name)
+	}
+	return nameMap
+}
+
+// objectDependencies makes every object of a resource depend on the objects of
+// the resources listed in its depends_on
+func objectDependencies(resources []MetadataResource, objects map[string][]createdResource) ResourceDependencies {
+	dependencies := make(ResourceDependencies)
+	for _, resource := range resources {
+		var required []string
+		for _, dependency := range resource.DependsOn {
+			for _, object := range objects[dependency] {
+				required = append(required, object.key())
+			}
+		}
+		if len(required) == 0 {
+			continue
+		}
+		for _, object := range objects[resource.Name] {
+			dependencies[object.key()] = required
+		}
+	}
+	return dependencies
+}
+
+// deletionTasks lists the objects of a VNF and, for each of them, the objects
+// which must be deleted before it because they depend on it. Dependencies on
+// objects which are not part of data are ignored.
+func deletionTasks(data map[string][]string, dependencies ResourceDependencies) ([]createdResource, [][]int) {
+	var resourceTypes []string
+	for resourceType := range data {
+		resourceTypes = append(resourceTypes, resourceType)
+	}
+	sort.Strings(resourceTypes)
+
+	var objects []createdResource
+	index := make(map[string]int)
+	for _, resourceType := range resourceTypes {
+		for _, name := range data[resourceType] {
+			object := createdResource{resourceType: resourceType, name: name}
+			if _, ok := index[object.key()]; ok {
+				continue
+			}
+			index[object.key()] = len(objects)
+			objects = append(objects, object)
+		}
+	}
+
+	// Number of objects depending on each object which are not deleted yet
+	blockers := make([]int, len(objects))
+	required := make([][]int, len(objects))
+	for i, object := range objects {
+		for _, dependency := range dependencies[object.key()] {
+			j, ok := index[dependency]
+			if !ok || j == i {
+				continue
+			}
+			blockers[j]++
+			required[i] = append(required[i], j)
+		}
+	}
+
+	// Assign a deletion level to every object, objects of a cycle in the stored
+	// dependencies share the last level
+	levels := make([]int, len(objects))
+	assigned := make([]bool, len(objects))
+	remaining := len(objects)
+	for level := 0; remaining > 0; level++ {
+		var current []int
+		for i := range objects {
+			if !assigned[i] && blockers[i] == 0 {
+				current = append(current, i)
+			}
+		}
+
+		if len(current) == 0 {
+			for i := range objects {
+				if !assigned[i] {
+					current = append(current, i)
+				}
+			}
+		}
+
+		for _, i := range current {
+			assigned[i] = true
+			levels[i] = level
+			remaining--
+			for _, j := range required[i] {
+				blockers[j]--
+			}
+		}
+	}
+
+	requirements := make([][]int, len(objects))
+	for i := range objects {
+		for _, j := range required[i] {
+			if levels[i] < levels[j] {
+				requirements[j] = append(requirements[j], i)
+			}
+		}
+	}
+
+	return objects, requirements
+}
@@ -0,0 +1,431 @@
+/*
+Copyright 2018 Intel Corporation.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package csar
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"path"
+	"reflect"
+	"regexp"
+	"sort"
+	"strings"
+	"text/template"
+	"text/template/parse"
+
+	pkgerrors "github.com/pkg/errors"
+	"gopkg.in/yaml.v2"
+)
+
+const (
+	// helmResourceType is the type of the resources rendered from a Helm chart
+	helmResourceType = "helm"
+	// helmReleaseService is the .Release.Service given to the chart templates
+	helmReleaseService = "k8plugin"
+	// missingValueFunc is appended to the actions of the chart templates
+	missingValueFunc = "k8pluginMissingValue"
+)
+
+// undefinedFunction matches the error of a template calling an unknown function
+var undefinedFunction = regexp.MustCompile(`function "([^"]+)" not defined`)
+
+// chartMetadata is the content of the Chart.yaml of a chart
+type chartMetadata struct {
+	Name       string `yaml:"name"`
+	Version    string `yaml:"version"`
+	AppVersion string `yaml:"appVersion"`
+}
+
+// renderChart renders the Helm chart of a resource locally, without Tiller, and
+// returns one manifest per object routed to the plugin of its kind. The values
+// of the chart are overridden by the values file of the resource, which is
+// rendered with the instance values first.
+func renderChart(csarPackage Package, resource MetadataResource, values templateValues) ([]manifest, error) {
+	if resource.Chart == "" {
+		return nil, pkgerrors.New("Helm resource without chart")
+	}
+
+	files, err := readChart(csarPackage, resource.Chart)
+	if err != nil {
+		return nil, err
+	}
+
+	rawBytes, ok := files["Chart.yaml"]
+	if !ok {
+		return nil, pkgerrors.New("Chart " + resource.Chart + " has no Chart.yaml")
+	}
+
+	var metadata chartMetadata
+	err = yaml.Unmarshal(rawBytes, &metadata)
+	if err != nil {
+		return nil, pkgerrors.Wrap(err, "Invalid Chart.yaml in chart "+resource.Chart)
+	}
+
+	chartValues, err := readValues(files["values.yaml"])
+	if err != nil {
+		return nil, pkgerrors.Wrap(err, "Invalid values.yaml in chart "+resource.Chart)
+	}
+
+	if resource.ValuesFile != "" {
+		rawBytes, err := csarPackage.ReadFile(resource.ValuesFile)
+		if err != nil {
+			return nil, err
+		}
+
+		if resource.Template {
+			rawBytes, err = renderTemplate(resource.ValuesFile, rawBytes, values)
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		overrides, err := readValues(rawBytes)
+		if err != nil {
+			return nil, pkgerrors.Wrap(err, "Invalid values file "+resource.ValuesFile)
+		}
+		chartValues = mergeValues(chartValues, overrides)
+	}
+
+	var names []string
+	for name := range files {
+		if strings.HasPrefix(name, "charts/") {
+			return nil, pkgerrors.New("Subcharts of chart " + resource.Chart + " are not supported")
+		}
+		if strings.HasPrefix(name, "templates/") {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+
+	tmpl := template.New(metadata.Name).Option("missingkey=zero").Funcs(chartFuncs())
+	tmpl.Funcs(template.FuncMap{
+		"include": func(name string, data interface{}) (string, error) {
+			var out bytes.Buffer
+			err := tmpl.ExecuteTemplate(&out, name, data)
+			return out.String(), err
+		},
+	})
+
+	for _, name := range names {
+		_, err := tmpl.New(name).Parse(string(files[name]))
+		if err != nil {
+			if match := undefinedFunction.FindStringSubmatch(err.Error()); match != nil {
+				return nil, pkgerrors.New("Template function " + match[1] + " used in " + name +
+					" of chart " + resource.Chart + " is not supported")
+			}
+			return nil, pkgerrors.Wrap(err, "Error parsing template "+name+" of chart "+resource.Chart)
+		}
+	}
+
+	// Templates defined in the files are parsed with them
+	for _, t := range tmpl.Templates() {
+		if t.Tree != nil {
+			printMissingValuesEmpty(t.Tree.Root)
+		}
+	}
+
+	var manifests []manifest
+	for _, name := range names {
+		base := path.Base(name)
+		if strings.HasPrefix(base, "_") || base == "NOTES.txt" {
+			continue
+		}
+
+		data := map[string]interface{}{
+			"Values": chartValues,
+			"Release": map[string]interface{}{
+				"Name":      resource.Name,
+				"Namespace": values.Namespace,
+				"Service":   helmReleaseService,
+				"Revision":  1,
+				"IsInstall": true,
+				"IsUpgrade": false,
+			},
+			"Chart": map[string]interface{}{
+				"Name":       metadata.Name,
+				"Version":    metadata.Version,
+				"AppVersion": metadata.AppVersion,
+			},
+			"Template": map[string]interface{}{
+				"Name":     path.Join(metadata.Name, name),
+				"BasePath": path.Join(metadata.Name, "templates"),
+			},
+		}
+
+		var out bytes.Buffer
+		err := tmpl.ExecuteTemplate(&out, name, data)
+		if err != nil {
+			return nil, pkgerrors.Wrap(err, "Error rendering template "+name+" of chart "+resource.Chart)
+		}
+
+		objects, err := splitManifests(path.Join(resource.Chart, name), out.Bytes(), "")
+		if err != nil {
+			return nil, err
+		}
+		manifests = append(manifests, objects...)
+	}
+
+	if len(manifests) == 0 {
+		return nil, pkgerrors.New("Chart " + resource.Chart + " renders no object")
+	}
+
+	return manifests, nil
+}
+
+// readChart returns the files of a chart stored in the package, either as a
+// directory or as a .tgz archive, by name relative to the root of the chart
+func readChart(csarPackage Package, chart string) (map[string][]byte, error) {
+	if strings.HasSuffix(chart, ".tgz") || strings.HasSuffix(chart, ".tar.gz") {
+		rawBytes, err := csarPackage.ReadFile(chart)
+		if err != nil {
+			return nil, err
+		}
+		return readChartArchive(chart, rawBytes)
+	}
+
+	packageFiles, err := csarPackage.Files()
+	if err != nil {
+		return nil, err
+	}
+
+	prefix := path.Join(csarPackage.MetadataDir(), chart) + "/"
+	files := make(map[string][]byte)
+	for _, name := range packageFiles {
+		if !strings.HasPrefix(name, prefix) {
+			continue
+		}
+
+		rawBytes, err := csarPackage.ReadPackageFile(name)
+		if err != nil {
+			return nil, err
+		}
+		files[strings.TrimPrefix(name, prefix)] = rawBytes
+	}
+
+	if len(files) == 0 {
+		return nil, pkgerrors.New("Chart " + chart + " not found")
+	}
+
+	return files, nil
+}
+
+// readChartArchive returns the files of a packaged chart, whose entries are
+// stored under a directory named after the chart
+func readChartArchive(chart string, rawBytes []byte) (map[string][]byte, error) {
+	gzipReader, err := gzip.NewReader(bytes.NewReader(rawBytes))
+	if err != nil {
+		return nil, pkgerrors.Wrap(err, "Error reading chart "+chart)
+	}
+	defer gzipReader.Close()
+
+	files := make(map[string][]byte)
+	tarReader := tar.NewReader(gzipReader)
+	for {
+		header, err := tarReader.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, pkgerrors.Wrap(err, "Error reading chart "+chart)
+		}
+
+		if header.Typeflag != tar.TypeReg && header.Typeflag != tar.TypeRegA {
+			continue
+		}
+
+		parts := strings.SplitN(path.Clean(header.Name), "/", 2)
+		if len(parts) != 2 {
+			continue
+		}
+
+		content, err := ioutil.ReadAll(tarReader)
+		if err != nil {
+			return nil, pkgerrors.Wrap(err, "Error reading chart "+chart)
+		}
+		files[parts[1]] = content
+	}
+
+	return files, nil
+}
+
+// readValues decodes a values file, an empty file has no values
+func readValues(rawBytes []byte) (map[string]interface{}, error) {
+	var values map[string]interface{}
+
+	err := yaml.Unmarshal(rawBytes, &values)
+	if err != nil {
+		return nil, err
+	}
+
+	normalized, _ := normalizeValue(values).(map[string]interface{})
+	if normalized == nil {
+		normalized = make(map[string]interface{})
+	}
+	return normalized, nil
+}
+
+// mergeValues returns the values overridden by overrides, maps are merged recursively
+func mergeValues(values map[string]interface{}, overrides map[string]interface{}) map[string]interface{} {
+	merged := make(map[string]interface{}, len(values))
+	for key, value := range values {
+		merged[key] = value
+	}
+
+	for key, override := range overrides {
+		base, baseIsMap := merged[key].(map[string]interface{})
+		overrideMap, overrideIsMap := override.(map[string]interface{})
+		if baseIsMap && overrideIsMap {
+			merged[key] = mergeValues(base, overrideMap)
+			continue
+		}
+		merged[key] = override
+	}
+
+	return merged
+}
+
+// chartFuncs returns the subset of the Helm template functions supported when
+// rendering charts
+func chartFuncs() template.FuncMap {
+	return template.FuncMap{
+		"toYaml": func(value interface{}) string {
+			out, err := yaml.Marshal(value)
+			if err != nil {
+				return ""
+			}
+			return strings.TrimSuffix(string(out), "\n")
+		},
+		"toJson": func(value interface{}) string {
+			out, err := json.Marshal(value)
+			if err != nil {
+				return ""
+			}
+			return string(out)
+		},
+		"indent": func(spaces int, text string) string {
+			padding := strings.Repeat(" ", spaces)
+			return padding + strings.Replace(text, "\n", "\n"+padding, -1)
+		},
+		"nindent": func(spaces int, text string) string {
+			padding := strings.Repeat(" ", spaces)
+			return "\n" + padding + strings.Replace(text, "\n", "\n"+padding, -1)
+		},
+		"quote": func(value interface{}) string {
+			return fmt.Sprintf("%q", fmt.Sprint(value))
+		},
+		"squote": func(value interface{}) string {
+			return "'" + fmt.Sprint(value) + "'"
+		},
+		"default": func(defaultValue interface{}, value ...interface{}) interface{} {
+			if len(value) == 0 || isEmpty(value[0]) {
+				return defaultValue
+			}
+			return value[0]
+		},
+		"empty": isEmpty,
+		"required": func(message string, value interface{}) (interface{}, error) {
+			if isEmpty(value) {
+				return nil, pkgerrors.New(message)
+			}
+			return value, nil
+		},
+		"toString": func(value interface{}) string {
+			return fmt.Sprint(value)
+		},
+		"trunc": func(length int, text string) string {
+			if len(text) > length {
+				return text[:length]
+			}
+			return text
+		},
+		"trim":       strings.TrimSpace,
+		"trimSuffix": func(suffix string, text string) string { return strings.TrimSuffix(text, suffix) },
+		"trimPrefix": func(prefix string, text string) string { return strings.TrimPrefix(text, prefix) },
+		"upper":      strings.ToUpper,
+		"lower":      strings.ToLower,
+		"replace":    func(old string, new string, text string) string { return strings.Replace(text, old, new, -1) },
+		"contains":   func(substr string, text string) bool { return strings.Contains(text, substr) },
+		"hasPrefix":  func(prefix string, text string) bool { return strings.HasPrefix(text, prefix) },
+		"hasSuffix":  func(suffix string, text string) bool { return strings.HasSuffix(text, suffix) },
+		"hasKey": func(values map[string]interface{}, key string) bool {
+			_, ok := values[key]
+			return ok
+		},
+		"b64enc": func(text string) string {
+			return base64.StdEncoding.EncodeToString([]byte(text))
+		},
+		missingValueFunc: func(value interface{}) interface{} {
+			if value == nil {
+				return ""
+			}
+			return value
+		},
+	}
+}
+
+// printMissingValuesEmpty makes the actions of a template print the missing
+// values as empty strings, like Helm does, instead of "<no value>". The values
+// printed by an action are passed to missingValueFunc.
+func printMissingValuesEmpty(node parse.Node) {
+	switch n := node.(type) {
+	case *parse.ListNode:
+		if n == nil {
+			return
+		}
+		for _, child := range n.Nodes {
+			printMissingValuesEmpty(child)
+		}
+	case *parse.ActionNode:
+		// Variable declarations print nothing
+		if len(n.Pipe.Decl) > 0 {
+			return
+		}
+		n.Pipe.Cmds = append(n.Pipe.Cmds, &parse.CommandNode{
+			NodeType: parse.NodeCommand,
+			Pos:      n.Pos,
+			Args:     []parse.Node{parse.NewIdentifier(missingValueFunc).SetPos(n.Pos)},
+		})
+	case *parse.IfNode:
+		printMissingValuesEmpty(n.List)
+		printMissingValuesEmpty(n.ElseList)
+	case *parse.RangeNode:
+		printMissingValuesEmpty(n.List)
+		printMissingValuesEmpty(n.ElseList)
+	case *parse.WithNode:
+		printMissingValuesEmpty(n.List)
+		printMissingValuesEmpty(n.ElseList)
+	}
+}
+
+// isEmpty tells whether a value is the zero value of its type
+func isEmpty(value interface{}) bool {
+	if value == nil {
+		return true
+	}
+
+	v := reflect.ValueOf(value)
+	switch v.Kind() {
+	case reflect.Array, reflect.Map, reflect.Slice, reflect.String:
+		return v.Len() == 0
+	case reflect.Ptr, reflect.Interface:
+		return v.IsNil()
+	}
+	return reflect.DeepEqual(value, reflect.Zero(v.Type()).Interface())
+}
@@ -0,0 +1,70 @@
+/*
+Copyright 2018 Intel Corporation.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package csar
+
+import (
+	"encoding/json"
+
+	pkgerrors "github.com/pkg/errors"
+	networkingV1 "k8s.io/api/networking/v1"
+	metaV1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"k8-plugin-multicloud/krd"
+)
+
+// Resource added to the metadata of a package with network isolation
+const (
+	networkPolicyResourceType = "networkpolicy"
+	isolationResourceName     = "network-isolation"
+)
+
+// isolationResource returns the resource creating the network policy which
+// isolates the pods of a VNF, it does not depend on the other resources
+func isolationResource() MetadataResource {
+	return MetadataResource{
+		Name:      isolationResourceName,
+		Type:      networkPolicyResourceType,
+		isolation: true,
+	}
+}
+
+// isolationManifest describes the network policy isolating the pods of a VNF.
+// The pods of a VNF are only known once it is created, the networkpolicy plugin
+// completes the annotated policy with the VNF ID.
+func isolationManifest() (manifest, error) {
+	policy := &networkingV1.NetworkPolicy{
+		TypeMeta: metaV1.TypeMeta{APIVersion: "networking.k8s.io/v1", Kind: "NetworkPolicy"},
+		ObjectMeta: metaV1.ObjectMeta{
+			Name:        isolationResourceName,
+			Annotations: map[string]string{krd.IsolationAnnotation: "true"},
+		},
+		Spec: networkingV1.NetworkPolicySpec{
+			PolicyTypes: []networkingV1.PolicyType{networkingV1.PolicyTypeIngress, networkingV1.PolicyTypeEgress},
+		},
+	}
+
+	// JSON documents are valid YAML documents
+	rawBytes, err := json.Marshal(policy)
+	if err != nil {
+		return manifest{}, pkgerrors.Wrap(err, "Error building network isolation policy")
+	}
+
+	return manifest{
+		resourceType: networkPolicyResourceType,
+		source:       dataSource,
+		data:         rawBytes,
+		kind:         policy.Kind,
+		name:         policy.Name,
+	}, nil
+}
@@ -0,0 +1,261 @@
+/*
+Copyright 2018 Intel Corporation.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package csar
+
+import (
+	"bufio"
+	"bytes"
+	"strings"
+
+	pkgerrors "github.com/pkg/errors"
+	"gopkg.in/yaml.v2"
+
+	"k8-plugin-multicloud/krd"
+)
+
+// manifest is a rendered Kubernetes object of a CSAR resource, applied by the
+// plugin of its resource type
+type manifest struct {
+	resourceType string
+	// source is the file of the package the object comes from
+	source string
+	data   []byte
+	// kind and name identify the object in the package
+	kind string
+	name string
+	// retain keeps the object when its VNF is deleted
+	retain bool
+}
+
+// renderResource returns the manifests of a resource and the errors found. The
+// files of the resource are split into one manifest per object, after being
+// rendered with the instance values when the resource is a template. A Helm
+// chart is rendered the same way. The data files of a configmap or a secret
+// resource are never rendered.
+func renderResource(csarPackage Package, resource MetadataResource, values templateValues) ([]manifest, []error) {
+	manifests, errs := renderObjects(csarPackage, resource, values)
+	if !resource.Retain {
+		return manifests, errs
+	}
+
+	// Only the volumes are kept when the VNF is deleted
+	for i := range manifests {
+		if manifests[i].resourceType != persistentVolumeClaimResourceType {
+			errs = append(errs, pkgerrors.New("Only persistentvolumeclaim objects can be retained, "+
+				manifests[i].resourceType+" found in "+manifests[i].source))
+			continue
+		}
+		manifests[i].retain = true
+	}
+
+	return manifests, errs
+}
+
+// renderObjects returns the manifests of a resource without retain policy
+func renderObjects(csarPackage Package, resource MetadataResource, values templateValues) ([]manifest, []error) {
+	if resource.isolation {
+		object, err := isolationManifest()
+		if err != nil {
+			return nil, []error{err}
+		}
+		return []manifest{object}, nil
+	}
+
+	if resource.Type == helmResourceType {
+		manifests, err := renderChart(csarPackage, resource, values)
+		if err != nil {
+			return nil, []error{err}
+		}
+		return manifests, nil
+	}
+
+	var manifests []manifest
+	var errs []error
+	for _, filename := range resource.Files {
+		rawBytes, err := csarPackage.ReadFile(filename)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+
+		if resource.Template {
+			rawBytes, err = renderTemplate(filename, rawBytes, values)
+			if err != nil {
+				errs = append(errs, err)
+				continue
+			}
+		}
+
+		objects, err := splitManifests(filename, rawBytes, resource.Type)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		if len(objects) == 0 {
+			errs = append(errs, pkgerrors.New("File "+filename+" describes no object"))
+			continue
+		}
+		manifests = append(manifests, objects...)
+	}
+
+	if len(resource.DataFiles) > 0 {
+		object, err := dataManifest(csarPackage, resource)
+		if err != nil {
+			errs = append(errs, err)
+		} else {
+			manifests = append(manifests, object)
+		}
+	}
+
+	return manifests, errs
+}
+
+// splitManifests returns one manifest per document of a rendered file, routed
+// to the plugin of its kind or to the generic plugin. Documents without kind are
+// given to the plugin of the resource type, when the resource has one.
+func splitManifests(source string, rawBytes []byte, resourceType string) ([]manifest, error) {
+	var manifests []manifest
+
+	for _, document := range splitDocuments(rawBytes) {
+		kind, name, err := documentObject(document)
+		if err != nil {
+			if resourceType == "" || pkgerrors.Cause(err) != errNoKind {
+				return nil, pkgerrors.Wrap(err, "Error in "+source)
+			}
+			kind = resourceType
+		}
+
+		documentType := kindResourceType(kind)
+		if documentType == "namespace" {
+			return nil, pkgerrors.New("Namespace objects are not supported in " + source +
+				", the VNF namespace is created by the plugin")
+		}
+
+		manifests = append(manifests, manifest{
+			resourceType: pluginType(documentType),
+			source:       source,
+			data:         document,
+			kind:         kind,
+			name:         name,
+		})
+	}
+
+	return manifests, nil
+}
+
+// genericResourceType is the type of the plugin handling the kinds without a
+// specific plugin
+const genericResourceType = "generic"
+
+// persistentVolumeClaimResourceType is the only resource type which can be retained
+const persistentVolumeClaimResourceType = "persistentvolumeclaim"
+
+// errNoKind is returned for YAML documents which do not describe their kind
+var errNoKind = pkgerrors.New("YAML document without kind")
+
+// kindResourceType returns the resource type of a kind
+func kindResourceType(kind string) string {
+	return strings.ToLower(kind)
+}
+
+// pluginType returns the type of the plugin handling a resource type, the
+// generic plugin handles the types without a specific plugin when it is loaded
+func pluginType(resourceType string) string {
+	if _, ok := krd.LoadedPlugins[resourceType]; !ok {
+		if _, ok := krd.LoadedPlugins[genericResourceType]; ok {
+			return genericResourceType
+		}
+	}
+	return resourceType
+}
+
+// documentObject returns the kind and the name of the object described in a
+// YAML document
+func documentObject(rawBytes []byte) (string, string, error) {
+	var object struct {
+		Kind     string `yaml:"kind"`
+		Metadata struct {
+			Name string `yaml:"name"`
+		} `yaml:"metadata"`
+	}
+
+	err := yaml.Unmarshal(rawBytes, &object)
+	if err != nil {
+		return "", "", pkgerrors.Wrap(err, "Invalid YAML document")
+	}
+
+	if object.Kind == "" {
+		return "", object.Metadata.Name, errNoKind
+	}
+
+	return object.Kind, object.Metadata.Name, nil
+}
+
+// splitDocuments returns the documents of a YAML stream separated by "---"
+// lines, which may be followed by a comment or by the start of the document,
+// or ended by "..." lines. Documents with only comments or blank lines are
+// skipped.
+func splitDocuments(rawBytes []byte) [][]byte {
+	var documents [][]byte
+	var current bytes.Buffer
+	empty := true
+
+	flush := func() {
+		if !empty {
+			documents = append(documents, append([]byte(nil), current.Bytes()...))
+		}
+		current.Reset()
+		empty = true
+	}
+
+	scanner := bufio.NewScanner(bytes.NewReader(rawBytes))
+	scanner.Buffer(make([]byte, 64*1024), len(rawBytes)+1)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if marker, rest := documentMarker(line); marker != "" {
+			flush()
+			if marker == "---" && rest != "" && !strings.HasPrefix(rest, "#") {
+				// --- !!map or --- |
+				line = rest
+			} else {
+				continue
+			}
+		}
+
+		trimmed := strings.TrimSpace(line)
+		if trimmed != "" && !strings.HasPrefix(trimmed, "#") {
+			empty = false
+		}
+		current.WriteString(line)
+		current.WriteString("\n")
+	}
+	flush()
+
+	return documents
+}
+
+// documentMarker returns the "---" or "..." marker starting a line, and what
+// follows it, when the marker stands alone or is followed by a blank
+func documentMarker(line string) (string, string) {
+	for _, marker := range []string{"---", "..."} {
+		if !strings.HasPrefix(line, marker) {
+			continue
+		}
+		rest := line[len(marker):]
+		if rest == "" || rest[0] == ' ' || rest[0] == '\t' {
+			return marker, strings.TrimSpace(rest)
+		}
+	}
+	return "", ""
+}
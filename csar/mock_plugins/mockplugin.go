@@ -1,6 +1,10 @@
 package main
 
 import (
+	"errors"
+	"strings"
+
+	metaV1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/client-go/kubernetes"
 
 	"github.com/shank7485/k8-plugin-multicloud/krd"
@@ -10,6 +14,14 @@ func main() {}
 
 // CreateResource object in a specific Kubernetes resource
 func CreateResource(kubedata *krd.GenericKubeResourceData, kubeclient *kubernetes.Clientset) (string, error) {
+	if strings.HasSuffix(kubedata.YamlFilePath, "failure.yaml") {
+		return "", errors.New("Mock creation failure")
+	}
+	return "externalUUID", nil
+}
+
+// UpdateResource object in a specific Kubernetes resource
+func UpdateResource(kubedata *krd.GenericKubeResourceData, kubeclient *kubernetes.Clientset) (string, error) {
 	return "externalUUID", nil
 }
 
@@ -28,3 +40,47 @@ func DeleteResource(name string, namespace string, kubeclient *kubernetes.Client
 func GetResource(namespace string, client *kubernetes.Clientset) (bool, error) {
 	return true, nil
 }
+
+// IsReady existing resource
+func IsReady(name string, namespace string, kubeclient *kubernetes.Clientset) (bool, error) {
+	if strings.HasSuffix(name, "-failed") {
+		return false, &krd.ResourceFailedError{Reason: "Mock readiness failure"}
+	}
+	return true, nil
+}
+
+// GetResourceStatus existing resource
+func GetResourceStatus(name string, namespace string, kubeclient *kubernetes.Clientset) (*krd.ResourceStatus, error) {
+	return &krd.ResourceStatus{Name: name, Present: true, Ready: true}, nil
+}
+
+// DiffResource existing resource
+func DiffResource(kubedata *krd.GenericKubeResourceData, kubeclient *kubernetes.Clientset) (*krd.ResourceDrift, error) {
+	return &krd.ResourceDrift{Name: "externalUUID"}, nil
+}
+
+// ListOwnedResources existing resources
+func ListOwnedResources(kubeclient *kubernetes.Clientset) ([]metaV1.ObjectMeta, error) {
+	return []metaV1.ObjectMeta{
+		{
+			Name:        "externalUUID",
+			Namespace:   "default",
+			Annotations: map[string]string{krd.InternalVNFIDAnnotation: "cloud1-default-uuid"},
+		},
+		{
+			Name:        "leftoverUUID",
+			Namespace:   "default",
+			Annotations: map[string]string{krd.InternalVNFIDAnnotation: "cloud1-default-uuid"},
+		},
+		{
+			Name:        "otherUUID",
+			Namespace:   "default",
+			Annotations: map[string]string{krd.InternalVNFIDAnnotation: "cloud1-default-other"},
+		},
+	}, nil
+}
+
+// ValidateResource manifest
+func ValidateResource(kubedata *krd.GenericKubeResourceData) error {
+	return nil
+}
@@ -0,0 +1,203 @@
+/*
+Copyright 2018 Intel Corporation.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package csar
+
+import (
+	"encoding/json"
+	"net"
+	"strings"
+
+	pkgerrors "github.com/pkg/errors"
+)
+
+// networksAnnotation lists the networks Multus attaches a pod to, besides the
+// default network of the cluster
+const networksAnnotation = "k8s.v1.cni.cncf.io/networks"
+
+// networkKind is the kind of the networks Multus attaches pods to
+const networkKind = "NetworkAttachmentDefinition"
+
+// workloadResourceTypes are the resource types whose pods can be attached to networks
+var workloadResourceTypes = map[string]bool{
+	"pod":                   true,
+	"deployment":            true,
+	"replicaset":            true,
+	"replicationcontroller": true,
+	"statefulset":           true,
+	"daemonset":             true,
+	"job":                   true,
+	"cronjob":               true,
+}
+
+// NetworkAttachment attaches the pods of a workload of a VNF to a network, a
+// NetworkAttachmentDefinition of its namespace or of its CSAR. The pods of
+// every workload are attached when no workload is named.
+type NetworkAttachment struct {
+	Name         string `json:"name"`
+	Interface    string `json:"interface,omitempty"`
+	IPAddress    string `json:"ip_address,omitempty"`
+	MACAddress   string `json:"mac_address,omitempty"`
+	WorkloadName string `json:"workload_name,omitempty"`
+}
+
+// selection returns the element of the networks annotation attaching a pod to the network
+func (n NetworkAttachment) selection() node {
+	selection := node{"name": n.Name}
+	if n.Interface != "" {
+		selection["interface"] = n.Interface
+	}
+	if n.IPAddress != "" {
+		selection["ips"] = []string{n.IPAddress}
+	}
+	if n.MACAddress != "" {
+		selection["mac"] = n.MACAddress
+	}
+	return selection
+}
+
+// vnfNetworks returns the networks the workloads of a VNF are attached to. The
+// OAM IP address is given to the connection point of its workload, a network
+// attachment named after the connection point or its interface.
+func vnfNetworks(params InstanceParameters) []NetworkAttachment {
+	networks := append([]NetworkAttachment(nil), params.Networks...)
+
+	oam := params.OAMIP
+	if oam.IPAddress == "" || oam.ConnectionPoint == "" || oam.WorkloadName == "" {
+		return networks
+	}
+
+	connectionPoint := func(network NetworkAttachment) bool {
+		return network.Name == oam.ConnectionPoint || network.Interface == oam.ConnectionPoint
+	}
+
+	for i := range networks {
+		if networks[i].WorkloadName == oam.WorkloadName && connectionPoint(networks[i]) {
+			networks[i].IPAddress = oam.IPAddress
+			return networks
+		}
+	}
+
+	// The workload is given its own attachment to a network of every workload
+	oamNetwork := NetworkAttachment{Name: oam.ConnectionPoint}
+	for _, network := range networks {
+		if network.WorkloadName == "" && connectionPoint(network) {
+			oamNetwork = network
+			break
+		}
+	}
+	oamNetwork.WorkloadName = oam.WorkloadName
+	oamNetwork.IPAddress = oam.IPAddress
+
+	return append(networks, oamNetwork)
+}
+
+// workloadNetworks returns the networks a workload is attached to. An attachment
+// to a network of every workload is replaced by the attachment of the workload
+// to the same network and interface.
+func workloadNetworks(networks []NetworkAttachment, workload string) []NetworkAttachment {
+	overridden := func(general NetworkAttachment) bool {
+		for _, network := range networks {
+			if network.WorkloadName == workload && network.Name == general.Name && network.Interface == general.Interface {
+				return true
+			}
+		}
+		return false
+	}
+
+	var result []NetworkAttachment
+	for _, network := range networks {
+		if network.WorkloadName == workload || (network.WorkloadName == "" && !overridden(network)) {
+			result = append(result, network)
+		}
+	}
+
+	return result
+}
+
+// validateNetworks returns the problems of the networks requested for the
+// workloads of a VNF
+func validateNetworks(networks []NetworkAttachment, manifests map[string][]manifest) []string {
+	workloads := make(map[string]bool)
+	for _, objects := range manifests {
+		for _, object := range objects {
+			if workloadResourceTypes[kindResourceType(object.kind)] {
+				workloads[object.name] = true
+			}
+		}
+	}
+
+	var problems []string
+	for _, network := range networks {
+		if network.Name == "" {
+			problems = append(problems, "Network attachment without name")
+			continue
+		}
+
+		if network.IPAddress != "" && net.ParseIP(network.IPAddress) == nil {
+			if _, _, err := net.ParseCIDR(network.IPAddress); err != nil {
+				problems = append(problems, "Invalid IP address "+network.IPAddress+" on network "+network.Name)
+			}
+		}
+
+		if network.MACAddress != "" {
+			if _, err := net.ParseMAC(network.MACAddress); err != nil {
+				problems = append(problems, "Invalid MAC address "+network.MACAddress+" on network "+network.Name)
+			}
+		}
+
+		if network.WorkloadName != "" && !workloads[network.WorkloadName] {
+			problems = append(problems, "Network "+network.Name+" is attached to workload "+network.WorkloadName+
+				" which is not described in the CSAR")
+		}
+	}
+
+	return problems
+}
+
+// parseNetworks returns the elements of a networks annotation, a JSON list or
+// a comma separated list of namespace/name@interface
+func parseNetworks(value string) ([]node, error) {
+	var elements []node
+
+	value = strings.TrimSpace(value)
+	if strings.HasPrefix(value, "[") {
+		err := json.Unmarshal([]byte(value), &elements)
+		if err != nil {
+			return nil, pkgerrors.Wrap(err, "Invalid "+networksAnnotation+" annotation")
+		}
+		return elements, nil
+	}
+
+	for _, item := range strings.Split(value, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+
+		element := node{}
+		if i := strings.LastIndex(item, "@"); i >= 0 {
+			element["interface"] = item[i+1:]
+			item = item[:i]
+		}
+		if i := strings.Index(item, "/"); i >= 0 {
+			element["namespace"] = item[:i]
+			item = item[i+1:]
+		}
+		element["name"] = item
+
+		elements = append(elements, element)
+	}
+
+	return elements, nil
+}
@@ -0,0 +1,346 @@
+/*
+Copyright 2018 Intel Corporation.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package csar
+
+import (
+	"archive/zip"
+	"bufio"
+	"bytes"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	pkgerrors "github.com/pkg/errors"
+	"gopkg.in/yaml.v2"
+)
+
+const (
+	// toscaMetaPath is the location of the TOSCA.meta file inside a CSAR archive
+	toscaMetaPath = "TOSCA-Metadata/TOSCA.meta"
+	// entryDefinitionsKey is the TOSCA.meta keyname of the main service template
+	entryDefinitionsKey = "Entry-Definitions"
+	// kubernetesMetadataKey is an optional TOSCA.meta keyname with the location of
+	// the metadata.yaml describing the Kubernetes artifacts
+	kubernetesMetadataKey = "Kubernetes-Metadata"
+)
+
+// Package gives access to the files of a CSAR
+type Package interface {
+	// Metadata returns the metadata.yaml of the package
+	Metadata() (MetadataFile, error)
+	// ReadFile returns the content of a file listed in the metadata
+	ReadFile(name string) ([]byte, error)
+	// Path describes where a file listed in the metadata is stored
+	Path(name string) string
+	// Files lists every file of the package, relative to its root
+	Files() ([]string, error)
+	// ReadPackageFile returns the content of a file relative to the root of the package
+	ReadPackageFile(name string) ([]byte, error)
+	// MetadataDir returns the directory of the metadata.yaml, relative to the root
+	// of the package. The files listed in the metadata are relative to it.
+	MetadataDir() string
+	Close() error
+}
+
+// OpenPackage opens a CSAR stored in CSAR_DIR, either unpacked in a directory
+// named after its ID or as a <csarID>.csar or <csarID>.zip archive
+var OpenPackage = func(csarID string) (Package, error) {
+	basePath := os.Getenv("CSAR_DIR") + "/" + csarID
+
+	if info, err := os.Stat(basePath); err == nil && info.IsDir() {
+		return &directoryPackage{path: basePath}, nil
+	}
+
+	for _, extension := range []string{".csar", ".zip"} {
+		if _, err := os.Stat(basePath + extension); err == nil {
+			return openArchivePackage(basePath + extension)
+		}
+	}
+
+	return nil, pkgerrors.New("CSAR " + csarID + " not found")
+}
+
+// StorePackage saves the content of a CSAR archive in CSAR_DIR as <csarID>.csar
+// and returns its size
+var StorePackage = func(csarID string, content io.Reader) (int64, error) {
+	csarDir := os.Getenv("CSAR_DIR")
+
+	// Write to a temporary file first so a partial upload is never visible
+	file, err := ioutil.TempFile(csarDir, ".upload-")
+	if err != nil {
+		return 0, pkgerrors.Wrap(err, "Error creating CSAR file")
+	}
+
+	size, err := io.Copy(file, content)
+	if cerr := file.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(file.Name())
+		return 0, pkgerrors.Wrap(err, "Error writing CSAR file")
+	}
+
+	err = os.Rename(file.Name(), csarDir+"/"+csarID+".csar")
+	if err != nil {
+		os.Remove(file.Name())
+		return 0, pkgerrors.Wrap(err, "Error storing CSAR file")
+	}
+
+	return size, nil
+}
+
+// RemovePackage deletes a CSAR from CSAR_DIR, whether it is a directory or an archive
+var RemovePackage = func(csarID string) error {
+	basePath := os.Getenv("CSAR_DIR") + "/" + csarID
+
+	for _, csarPath := range []string{basePath, basePath + ".csar", basePath + ".zip"} {
+		if _, err := os.Stat(csarPath); err != nil {
+			continue
+		}
+
+		err := os.RemoveAll(csarPath)
+		if err != nil {
+			return pkgerrors.Wrap(err, "Error removing CSAR "+csarPath)
+		}
+	}
+
+	return nil
+}
+
+// directoryPackage is a CSAR unpacked on the file system with its metadata.yaml
+// at the root of the directory
+type directoryPackage struct {
+	path string
+}
+
+func (p *directoryPackage) Metadata() (MetadataFile, error) {
+	return ReadMetadataFile(p.path + "/metadata.yaml")
+}
+
+func (p *directoryPackage) ReadFile(name string) ([]byte, error) {
+	filePath := p.Path(name)
+
+	_, err := os.Stat(filePath)
+	if os.IsNotExist(err) {
+		return nil, pkgerrors.New("File " + filePath + " does not exists")
+	}
+
+	return ioutil.ReadFile(filePath)
+}
+
+func (p *directoryPackage) Path(name string) string {
+	return p.path + "/" + name
+}
+
+func (p *directoryPackage) Files() ([]string, error) {
+	var files []string
+
+	err := filepath.Walk(p.path, func(filePath string, info os.FileInfo, err error) error {
+		if err != nil || info.IsDir() {
+			return err
+		}
+
+		name, err := filepath.Rel(p.path, filePath)
+		if err != nil {
+			return err
+		}
+		files = append(files, filepath.ToSlash(name))
+		return nil
+	})
+	if err != nil {
+		return nil, pkgerrors.Wrap(err, "Error listing files of "+p.path)
+	}
+
+	return files, nil
+}
+
+func (p *directoryPackage) ReadPackageFile(name string) ([]byte, error) {
+	return ioutil.ReadFile(p.path + "/" + name)
+}
+
+func (p *directoryPackage) MetadataDir() string {
+	return "."
+}
+
+func (p *directoryPackage) Close() error {
+	return nil
+}
+
+// archivePackage is a CSAR zip archive described by its TOSCA.meta file. The
+// files listed in the metadata are relative to the directory of metadata.yaml.
+type archivePackage struct {
+	path         string
+	reader       *zip.ReadCloser
+	files        map[string]*zip.File
+	metadataPath string
+}
+
+func openArchivePackage(archivePath string) (Package, error) {
+	reader, err := zip.OpenReader(archivePath)
+	if err != nil {
+		return nil, pkgerrors.Wrap(err, "Error opening CSAR archive "+archivePath)
+	}
+
+	p := &archivePackage{
+		path:   archivePath,
+		reader: reader,
+		files:  make(map[string]*zip.File),
+	}
+	for _, file := range reader.File {
+		p.files[path.Clean(file.Name)] = file
+	}
+
+	err = p.locateMetadata()
+	if err != nil {
+		reader.Close()
+		return nil, pkgerrors.Wrap(err, "Invalid CSAR archive "+archivePath)
+	}
+
+	return p, nil
+}
+
+// locateMetadata finds the metadata.yaml of the archive using TOSCA.meta. It is
+// either given by the Kubernetes-Metadata keyname or stored next to the entry
+// definitions or at the root of the archive.
+func (p *archivePackage) locateMetadata() error {
+	rawBytes, err := p.readEntry(toscaMetaPath)
+	if err != nil {
+		return err
+	}
+
+	meta, err := parseToscaMeta(rawBytes)
+	if err != nil {
+		return err
+	}
+
+	entryDefinitions, ok := meta[entryDefinitionsKey]
+	if !ok {
+		return pkgerrors.New(toscaMetaPath + " has no " + entryDefinitionsKey)
+	}
+
+	if _, ok := p.files[path.Clean(entryDefinitions)]; !ok {
+		return pkgerrors.New("Entry definitions " + entryDefinitions + " not found")
+	}
+
+	if metadataPath, ok := meta[kubernetesMetadataKey]; ok {
+		if _, ok := p.files[path.Clean(metadataPath)]; !ok {
+			return pkgerrors.New("Kubernetes metadata " + metadataPath + " not found")
+		}
+		p.metadataPath = path.Clean(metadataPath)
+		return nil
+	}
+
+	for _, candidate := range []string{path.Join(path.Dir(entryDefinitions), "metadata.yaml"), "metadata.yaml"} {
+		if _, ok := p.files[candidate]; ok {
+			p.metadataPath = candidate
+			return nil
+		}
+	}
+
+	return pkgerrors.New("No metadata.yaml found next to " + entryDefinitions + " or at the root of the archive")
+}
+
+// readEntry returns the content of a file of the archive
+func (p *archivePackage) readEntry(name string) ([]byte, error) {
+	file, ok := p.files[path.Clean(name)]
+	if !ok {
+		return nil, pkgerrors.New("File " + name + " not found in " + p.path)
+	}
+
+	reader, err := file.Open()
+	if err != nil {
+		return nil, pkgerrors.Wrap(err, "Error reading "+name+" from "+p.path)
+	}
+	defer reader.Close()
+
+	return ioutil.ReadAll(reader)
+}
+
+func (p *archivePackage) Metadata() (MetadataFile, error) {
+	var seqFile MetadataFile
+
+	rawBytes, err := p.readEntry(p.metadataPath)
+	if err != nil {
+		return seqFile, pkgerrors.Wrap(err, "Metadata YAML file read error")
+	}
+
+	err = yaml.Unmarshal(rawBytes, &seqFile)
+	if err != nil {
+		return seqFile, pkgerrors.Wrap(err, "Metadata YAML file read error")
+	}
+
+	return seqFile, nil
+}
+
+func (p *archivePackage) ReadFile(name string) ([]byte, error) {
+	return p.readEntry(path.Join(path.Dir(p.metadataPath), name))
+}
+
+func (p *archivePackage) Path(name string) string {
+	return p.path + "/" + path.Join(path.Dir(p.metadataPath), name)
+}
+
+func (p *archivePackage) Files() ([]string, error) {
+	var files []string
+	for name, file := range p.files {
+		if !file.FileInfo().IsDir() {
+			files = append(files, name)
+		}
+	}
+	sort.Strings(files)
+
+	return files, nil
+}
+
+func (p *archivePackage) ReadPackageFile(name string) ([]byte, error) {
+	return p.readEntry(name)
+}
+
+func (p *archivePackage) MetadataDir() string {
+	return path.Dir(p.metadataPath)
+}
+
+func (p *archivePackage) Close() error {
+	return p.reader.Close()
+}
+
+// parseToscaMeta reads the "keyname: value" pairs of a TOSCA.meta file
+func parseToscaMeta(rawBytes []byte) (map[string]string, error) {
+	meta := make(map[string]string)
+
+	scanner := bufio.NewScanner(bytes.NewReader(rawBytes))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+
+		separator := strings.Index(line, ":")
+		if separator < 1 {
+			return nil, pkgerrors.New("Invalid line in " + toscaMetaPath + ": " + line)
+		}
+
+		meta[strings.TrimSpace(line[:separator])] = strings.TrimSpace(line[separator+1:])
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, pkgerrors.Wrap(err, "Error reading "+toscaMetaPath)
+	}
+
+	return meta, nil
+}
@@ -17,6 +17,9 @@ import (
 	"io/ioutil"
 	"log"
 	"os"
+	"sort"
+	"strconv"
+	"strings"
 
 	"k8s.io/client-go/kubernetes"
 
@@ -28,105 +31,170 @@ import (
 	"k8-plugin-multicloud/krd"
 )
 
-// CreateVNF reads the CSAR files from the files system and creates them one by one
-var CreateVNF = func(csarID string, cloudRegionID string, namespace string, kubeclient *kubernetes.Clientset) (string, map[string][]string, error) {
+// ProgressFunc is notified every time a VNF lifecycle function finishes processing
+// a resource. The name is the internal name of the resource or, if the resource
+// could not be created, the file it was described in.
+type ProgressFunc func(resourceType string, name string, err error)
+
+func (f ProgressFunc) notify(resourceType string, name string, err error) {
+	if f != nil {
+		f(resourceType, name, err)
+	}
+}
+
+// ensureNamespace creates the namespace using the namespace plugin if it does not exist
+func ensureNamespace(namespace string, kubeclient *kubernetes.Clientset) error {
 	namespacePlugin, ok := krd.LoadedPlugins["namespace"]
 	if !ok {
-		return "", nil, pkgerrors.New("No plugin for namespace resource found")
+		return pkgerrors.New("No plugin for namespace resource found")
 	}
 
 	symGetNamespaceFunc, err := namespacePlugin.Lookup("GetResource")
 	if err != nil {
-		return "", nil, pkgerrors.Wrap(err, "Error fetching namespace plugin")
+		return pkgerrors.Wrap(err, "Error fetching namespace plugin")
 	}
 
 	present, err := symGetNamespaceFunc.(func(string, *kubernetes.Clientset) (bool, error))(
 		namespace, kubeclient)
 	if err != nil {
-		return "", nil, pkgerrors.Wrap(err, "Error in plugin namespace plugin")
+		return pkgerrors.Wrap(err, "Error in plugin namespace plugin")
 	}
 
 	if present == false {
 		symGetNamespaceFunc, err := namespacePlugin.Lookup("CreateResource")
 		if err != nil {
-			return "", nil, pkgerrors.Wrap(err, "Error fetching namespace plugin")
+			return pkgerrors.Wrap(err, "Error fetching namespace plugin")
 		}
 
 		err = symGetNamespaceFunc.(func(string, *kubernetes.Clientset) error)(
 			namespace, kubeclient)
 		if err != nil {
-			return "", nil, pkgerrors.Wrap(err, "Error creating "+namespace+" namespace")
+			return pkgerrors.Wrap(err, "Error creating "+namespace+" namespace")
 		}
 	}
 
-	var path string
+	return nil
+}
 
-	// uuid
-	externalVNFID := string(uuid.NewUUID())
+// pluginResourceFunc returns a resourceFunc which calls the given plugin
+// function, CreateResource or UpdateResource, with the rendered manifests of a
+// CSAR rewritten for the VNF and its networks
+func pluginResourceFunc(function string, csarPackage Package, kubedata krd.GenericKubeResourceData,
+	networks []NetworkAttachment, progress ProgressFunc, kubeclient *kubernetes.Clientset) resourceFunc {
 
-	// cloud1-default-uuid
-	internalVNFID := cloudRegionID + "-" + namespace + "-" + externalVNFID
+	return func(object manifest) (string, error) {
+		resourceType := object.resourceType
+		path := csarPackage.Path(object.source)
 
-	csarDirPath := os.Getenv("CSAR_DIR") + "/" + csarID
-	metadataYAMLPath := csarDirPath + "/metadata.yaml"
+		log.Println("Processing file: " + path)
 
-	seqFile, err := ReadMetadataFile(metadataYAMLPath)
-	if err != nil {
-		return "", nil, pkgerrors.Wrap(err, "Error while reading Metadata File: "+metadataYAMLPath)
+		typePlugin, ok := krd.LoadedPlugins[resourceType]
+		if !ok {
+			return "", pkgerrors.New("No plugin for resource " + resourceType + " found")
+		}
+
+		symResourceFunc, err := typePlugin.Lookup(function)
+		if err != nil {
+			return "", pkgerrors.Wrap(err, "Error fetching "+resourceType+" plugin")
+		}
+
+		data, err := rewriteManifest(object, &kubedata, networks)
+		if err != nil {
+			progress.notify(resourceType, object.source, err)
+			return "", err
+		}
+
+		genericKubeData := kubedata
+		genericKubeData.YamlFilePath = path
+		genericKubeData.YamlData = data
+		genericKubeData.Retain = object.retain
+
+		// cloud1-default-uuid-sisedeploy
+		internalResourceName, err := symResourceFunc.(func(*krd.GenericKubeResourceData, *kubernetes.Clientset) (string, error))(
+			&genericKubeData, kubeclient)
+		if err != nil {
+			progress.notify(resourceType, object.source, err)
+			return "", pkgerrors.Wrap(err, "Error in plugin "+resourceType+" plugin")
+		}
+		progress.notify(resourceType, internalResourceName, nil)
+
+		// The generic plugin qualifies the names, NetworkAttachmentDefinition.v1.k8s.cni.cncf.io/name
+		createdName := internalResourceName[strings.LastIndex(internalResourceName, "/")+1:]
+		kubedata.Names.Add(object.kind, object.name, createdName)
+
+		return internalResourceName, nil
 	}
+}
 
-	resourceYAMLNameMap := make(map[string][]string)
+// CreateVNF reads the files of a CSAR and creates them following the dependencies
+// between the resources. Resources which do not depend on each other are created
+// concurrently by a pool of CSAR_WORKERS workers. It returns the external VNF ID,
+// the internal names of the created resources by type, the dependencies
+// between them and the objects created for every resource of the metadata file.
+// The files are rendered as templates with the instance parameters.
+var CreateVNF = func(csarID string, cloudRegionID string, namespace string, params InstanceParameters,
+	progress ProgressFunc, kubeclient *kubernetes.Clientset) (string, map[string][]string, ResourceDependencies, ResourceObjects, error) {
 
-	for _, resource := range seqFile.ResourceTypePathMap {
-		for resourceName, resourceFileNames := range resource {
-			// Load/Use Deployment data/client
+	csarPackage, err := OpenPackage(csarID)
+	if err != nil {
+		return "", nil, nil, nil, err
+	}
+	defer csarPackage.Close()
 
-			var resourceNameList []string
+	err = VerifyPackage(csarPackage, cloudRegionID)
+	if err != nil {
+		return "", nil, nil, nil, pkgerrors.Wrap(err, "Verification of CSAR "+csarID+" failed")
+	}
 
-			for _, filename := range resourceFileNames {
-				path = csarDirPath + "/" + filename
+	seqFile, err := csarPackage.Metadata()
+	if err != nil {
+		return "", nil, nil, nil, pkgerrors.Wrap(err, "Error while reading Metadata File of CSAR "+csarID)
+	}
 
-				_, err = os.Stat(path)
-				if os.IsNotExist(err) {
-					return "", nil, pkgerrors.New("File " + path + "does not exists")
-				}
+	values, err := newTemplateValues(csarID, seqFile.Inputs, params)
+	if err != nil {
+		return "", nil, nil, nil, err
+	}
+	values.Namespace = namespace
+
+	// Pre-flight check, nothing is applied when the CSAR has any problem
+	manifests, problems := validatePackage(csarID, csarPackage, seqFile, values)
+	networks := vnfNetworks(params)
+	problems = append(problems, validateNetworks(networks, manifests)...)
+	if len(problems) > 0 {
+		return "", nil, nil, nil, &ValidationError{CsarID: csarID, Problems: problems}
+	}
 
-				log.Println("Processing file: " + path)
+	levels, err := resourceLevels(seqFile.Resources)
+	if err != nil {
+		return "", nil, nil, nil, pkgerrors.Wrap(err, "Invalid resources in Metadata File of CSAR "+csarID)
+	}
 
-				genericKubeData := &krd.GenericKubeResourceData{
-					YamlFilePath:  path,
-					Namespace:     namespace,
-					InternalVNFID: internalVNFID,
-				}
+	err = ensureNamespace(namespace, kubeclient)
+	if err != nil {
+		return "", nil, nil, nil, err
+	}
 
-				typePlugin, ok := krd.LoadedPlugins[resourceName]
-				if !ok {
-					return "", nil, pkgerrors.New("No plugin for resource " + resourceName + " found")
-				}
+	// uuid
+	externalVNFID := string(uuid.NewUUID())
 
-				symCreateResourceFunc, err := typePlugin.Lookup("CreateResource")
-				if err != nil {
-					return "", nil, pkgerrors.Wrap(err, "Error fetching "+resourceName+" plugin")
-				}
+	// cloud1-default-uuid
+	internalVNFID := cloudRegionID + "-" + namespace + "-" + externalVNFID
 
-				// cloud1-default-uuid-sisedeploy
-				internalResourceName, err := symCreateResourceFunc.(func(*krd.GenericKubeResourceData, *kubernetes.Clientset) (string, error))(
-					genericKubeData, kubeclient)
-				if err != nil {
-					return "", nil, pkgerrors.Wrap(err, "Error in plugin "+resourceName+" plugin")
-				}
+	kubedata := krd.GenericKubeResourceData{
+		Namespace:     namespace,
+		InternalVNFID: internalVNFID,
+		ExternalVNFID: externalVNFID,
+		CloudRegionID: cloudRegionID,
+		CsarID:        csarID,
+	}
+	kubedata.Names = vnfNames(manifests, kubedata)
 
-				// ["cloud1-default-uuid-sisedeploy1", "cloud1-default-uuid-sisedeploy2", ... ]
-				resourceNameList = append(resourceNameList, internalResourceName)
+	createFunc := pluginResourceFunc("CreateResource", csarPackage, kubedata, networks, progress, kubeclient)
 
-				/*
-					{
-						"deployment": ["cloud1-default-uuid-sisedeploy1", "cloud1-default-uuid-sisedeploy2", ... ]
-					}
-				*/
-				resourceYAMLNameMap[resourceName] = resourceNameList
-			}
-		}
+	objects, created, err := applyResources(levels, manifests, createFunc)
+	if err != nil {
+		return "", nil, nil, nil, rollbackVNF(created, namespace, kubeclient, err)
 	}
 
 	/*
@@ -135,13 +203,47 @@ var CreateVNF = func(csarID string, cloudRegionID string, namespace string, kube
 			"deployment": ["cloud1-default-uuid-sisedeploy1", "cloud1-default-uuid-sisedeploy2", ... ]
 			"service": ["cloud1-default-uuid-sisesvc1", "cloud1-default-uuid-sisesvc2", ... ]
 		},
+		{
+			"deployment/cloud1-default-uuid-sisedeploy1": ["service/cloud1-default-uuid-sisesvc1"]
+		},
+		{
+			"sise-deploy": ["deployment/cloud1-default-uuid-sisedeploy1"]
+		},
 		nil
 	*/
-	return externalVNFID, resourceYAMLNameMap, nil
+	return externalVNFID, resourceNameMap(created), objectDependencies(seqFile.Resources, objects),
+		resourceObjects(objects), nil
+}
+
+// deleteResource deletes an object using the plugin of its type
+func deleteResource(resource createdResource, namespace string, progress ProgressFunc, kubeclient *kubernetes.Clientset) error {
+	typePlugin, ok := krd.LoadedPlugins[resource.resourceType]
+	if !ok {
+		return pkgerrors.New("No plugin for resource " + resource.resourceType + " found")
+	}
+
+	symDeleteResourceFunc, err := typePlugin.Lookup("DeleteResource")
+	if err != nil {
+		return pkgerrors.Wrap(err, "Error fetching "+resource.resourceType+" plugin")
+	}
+
+	log.Println("Deleting resource: " + resource.name)
+
+	err = symDeleteResourceFunc.(func(string, string, *kubernetes.Clientset) error)(
+		resource.name, namespace, kubeclient)
+	progress.notify(resource.resourceType, resource.name, err)
+	if err != nil {
+		return pkgerrors.Wrap(err, "Error destroying "+resource.name)
+	}
+
+	return nil
 }
 
-// DestroyVNF deletes VNFs based on data passed
-var DestroyVNF = func(data map[string][]string, namespace string, kubeclient *kubernetes.Clientset) error {
+// DestroyVNF deletes VNFs based on data passed. Resources are deleted in reverse
+// order of their dependencies, independent resources are deleted concurrently
+// by a pool of CSAR_WORKERS workers.
+var DestroyVNF = func(data map[string][]string, dependencies ResourceDependencies, namespace string,
+	progress ProgressFunc, kubeclient *kubernetes.Clientset) error {
 	/* data:
 	{
 		"deployment": ["cloud1-default-uuid-sisedeploy1", "cloud1-default-uuid-sisedeploy2", ... ]
@@ -149,52 +251,240 @@ var DestroyVNF = func(data map[string][]string, namespace string, kubeclient *ku
 	},
 	*/
 
+	objects, requirements := deletionTasks(data, dependencies)
+
+	errs := runTasks(requirements, workerCount(), func(i int) error {
+		return deleteResource(objects[i], namespace, progress, kubeclient)
+	})
+
+	return firstError(errs)
+}
+
+// UpdateVNF re-applies the CSAR files of an existing VNF. Resources present in
+// the new CSAR are updated in place or created, and resources of the previous
+// deployment which are no longer part of it are deleted. It returns the new
+// resource names and dependencies of the VNF.
+var UpdateVNF = func(csarID string, cloudRegionID string, namespace string, externalVNFID string,
+	params InstanceParameters, data map[string][]string, dependencies ResourceDependencies, progress ProgressFunc,
+	kubeclient *kubernetes.Clientset) (map[string][]string, ResourceDependencies, error) {
+
+	// cloud1-default-uuid
+	internalVNFID := cloudRegionID + "-" + namespace + "-" + externalVNFID
+
+	csarPackage, err := OpenPackage(csarID)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer csarPackage.Close()
+
+	err = VerifyPackage(csarPackage, cloudRegionID)
+	if err != nil {
+		return nil, nil, pkgerrors.Wrap(err, "Verification of CSAR "+csarID+" failed")
+	}
+
+	seqFile, err := csarPackage.Metadata()
+	if err != nil {
+		return nil, nil, pkgerrors.Wrap(err, "Error while reading Metadata File of CSAR "+csarID)
+	}
+
+	values, err := newTemplateValues(csarID, seqFile.Inputs, params)
+	if err != nil {
+		return nil, nil, err
+	}
+	values.Namespace = namespace
+
+	// Pre-flight check, nothing is applied when the CSAR has any problem
+	manifests, problems := validatePackage(csarID, csarPackage, seqFile, values)
+	networks := vnfNetworks(params)
+	problems = append(problems, validateNetworks(networks, manifests)...)
+	if len(problems) > 0 {
+		return nil, nil, &ValidationError{CsarID: csarID, Problems: problems}
+	}
+
+	levels, err := resourceLevels(seqFile.Resources)
+	if err != nil {
+		return nil, nil, pkgerrors.Wrap(err, "Invalid resources in Metadata File of CSAR "+csarID)
+	}
+
+	kubedata := krd.GenericKubeResourceData{
+		Namespace:     namespace,
+		InternalVNFID: internalVNFID,
+		ExternalVNFID: externalVNFID,
+		CloudRegionID: cloudRegionID,
+		CsarID:        csarID,
+	}
+	kubedata.Names = vnfNames(manifests, kubedata)
+
+	updateFunc := pluginResourceFunc("UpdateResource", csarPackage, kubedata, networks, progress, kubeclient)
+
+	objects, updated, err := applyResources(levels, manifests, updateFunc)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	resourceYAMLNameMap := resourceNameMap(updated)
+
+	// Remove the resources which are not part of the CSAR anymore
+	removed := make(map[string][]string)
 	for resourceName, resourceList := range data {
-		typePlugin, ok := krd.LoadedPlugins[resourceName]
-		if !ok {
-			return pkgerrors.New("No plugin for resource " + resourceName + " found")
+		for _, internalResourceName := range resourceList {
+			if !containsString(resourceYAMLNameMap[resourceName], internalResourceName) {
+				removed[resourceName] = append(removed[resourceName], internalResourceName)
+			}
 		}
+	}
 
-		symDeleteResourceFunc, err := typePlugin.Lookup("DeleteResource")
-		if err != nil {
-			return pkgerrors.Wrap(err, "Error fetching "+resourceName+" plugin")
+	err = DestroyVNF(removed, dependencies, namespace, progress, kubeclient)
+	if err != nil {
+		return nil, nil, pkgerrors.Wrap(err, "Error removing resources of previous deployment")
+	}
+
+	return resourceYAMLNameMap, objectDependencies(seqFile.Resources, objects), nil
+}
+
+func containsString(list []string, value string) bool {
+	for _, item := range list {
+		if item == value {
+			return true
 		}
+	}
+	return false
+}
 
-		for _, resourceName := range resourceList {
+// MetadataFile stores the metadata of execution
+type MetadataFile struct {
+	Resources []MetadataResource `yaml:"-"`
+	Inputs    []InputParameter   `yaml:"inputs"`
+	// Readiness holds the default readiness rules of the resource types
+	Readiness map[string]ReadinessRule `yaml:"readiness"`
+	// NetworkIsolation only lets the pods of a VNF reach each other, unless
+	// network policies of the package allow more
+	NetworkIsolation bool `yaml:"network_isolation"`
+}
 
-			log.Println("Deleting resource: " + resourceName)
+// MetadataResource is a named group of files which is created once all the
+// resources it depends on have been created. The objects of its files are routed
+// to the plugin of their kind, the type is only needed for objects without kind.
+// Resources of the helm type are rendered from a chart instead of files.
+type MetadataResource struct {
+	Name      string   `yaml:"name"`
+	Type      string   `yaml:"type"`
+	Files     []string `yaml:"files"`
+	DependsOn []string `yaml:"depends_on"`
+	// Chart is the directory or .tgz archive of a Helm chart
+	Chart string `yaml:"chart"`
+	// ValuesFile overrides the values of the chart
+	ValuesFile string `yaml:"values"`
+	// DataFiles are the files a configmap or a secret resource is built from
+	DataFiles []string `yaml:"data_files"`
+	// Retain keeps the persistentvolumeclaims of the resource when the VNF is deleted
+	Retain bool `yaml:"retain"`
+	// Template renders the files of the resource, or the values file of its
+	// chart, with the instance parameters
+	Template bool `yaml:"template"`
+	// Readiness overrides the readiness rule of the types of its objects
+	Readiness *ReadinessRule `yaml:"readiness"`
+
+	// isolation marks the resource added by the network isolation option
+	isolation bool
+}
 
-			err = symDeleteResourceFunc.(func(string, string, *kubernetes.Clientset) error)(
-				resourceName, namespace, kubeclient)
-			if err != nil {
-				return pkgerrors.Wrap(err, "Error destroying "+resourceName)
-			}
+// metadataEntry is an item of the resources list of a metadata file. Besides
+// named resources, it accepts the legacy format mapping resource types to files.
+type metadataEntry struct {
+	legacy    bool
+	resources []MetadataResource
+}
+
+func (e *metadataEntry) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	// - deployment:
+	//   - deployment.yaml
+	var typeFiles map[string][]string
+	if err := unmarshal(&typeFiles); err == nil {
+		var resourceTypes []string
+		for resourceType := range typeFiles {
+			resourceTypes = append(resourceTypes, resourceType)
 		}
+		sort.Strings(resourceTypes)
+
+		e.legacy = true
+		for _, resourceType := range resourceTypes {
+			e.resources = append(e.resources, MetadataResource{
+				Type:  resourceType,
+				Files: typeFiles[resourceType],
+			})
+		}
+		return nil
 	}
 
+	// - name: sise-deploy
+	//   type: deployment
+	//   files:
+	//   - deployment.yaml
+	//   depends_on:
+	//   - sise-svc
+	var resource MetadataResource
+	if err := unmarshal(&resource); err != nil {
+		return err
+	}
+	e.resources = []MetadataResource{resource}
 	return nil
 }
 
-// MetadataFile stores the metadata of execution
-type MetadataFile struct {
-	ResourceTypePathMap []map[string][]string `yaml:"resources"`
+// UnmarshalYAML reads the resources of a metadata file. Resources in the legacy
+// format are named after their type and position and depend on every resource
+// of the previous entry, keeping the sequential order of older CSARs.
+func (m *MetadataFile) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	type plain MetadataFile
+	var raw struct {
+		plain   `yaml:",inline"`
+		Entries []metadataEntry `yaml:"resources"`
+	}
+
+	if err := unmarshal(&raw); err != nil {
+		return err
+	}
+
+	*m = MetadataFile(raw.plain)
+
+	var previous []string
+	for i, entry := range raw.Entries {
+		var names []string
+		for _, resource := range entry.resources {
+			if entry.legacy {
+				resource.Name = resource.Type + "-" + strconv.Itoa(i)
+				resource.DependsOn = previous
+			}
+			names = append(names, resource.Name)
+			m.Resources = append(m.Resources, resource)
+		}
+		previous = names
+	}
+
+	if m.NetworkIsolation {
+		m.Resources = append(m.Resources, isolationResource())
+	}
+
+	return nil
 }
 
 // ReadMetadataFile reads the metadata yaml to return the order or reads
 var ReadMetadataFile = func(yamlFilePath string) (MetadataFile, error) {
 	var seqFile MetadataFile
 
-	if _, err := os.Stat(yamlFilePath); err == nil {
-		log.Println("Reading metadata YAML: " + yamlFilePath)
-		rawBytes, err := ioutil.ReadFile(yamlFilePath)
-		if err != nil {
-			return seqFile, pkgerrors.Wrap(err, "Metadata YAML file read error")
-		}
+	if _, err := os.Stat(yamlFilePath); err != nil {
+		return seqFile, pkgerrors.New("Metadata YAML file " + yamlFilePath + " not found")
+	}
 
-		err = yaml.Unmarshal(rawBytes, &seqFile)
-		if err != nil {
-			return seqFile, pkgerrors.Wrap(err, "Metadata YAML file read error")
-		}
+	log.Println("Reading metadata YAML: " + yamlFilePath)
+	rawBytes, err := ioutil.ReadFile(yamlFilePath)
+	if err != nil {
+		return seqFile, pkgerrors.Wrap(err, "Metadata YAML file read error")
+	}
+
+	err = yaml.Unmarshal(rawBytes, &seqFile)
+	if err != nil {
+		return seqFile, pkgerrors.Wrap(err, "Metadata YAML file read error")
 	}
 
 	return seqFile, nil
@@ -13,12 +13,26 @@ limitations under the License.
 package csar
 
 import (
+	"archive/zip"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/pem"
 	"io/ioutil"
 	"k8s.io/client-go/kubernetes"
 	"log"
 	"os"
+	"path"
 	"plugin"
+	"reflect"
+	"strings"
+	"sync"
 	"testing"
+	"time"
 
 	pkgerrors "github.com/pkg/errors"
 	"gopkg.in/yaml.v2"
@@ -75,10 +89,12 @@ func TestCreateVNF(t *testing.T) {
 		return seqFile, nil
 	}
 
+	os.Setenv("CSAR_DIR", ".")
+
 	kubeclient := kubernetes.Clientset{}
 
 	t.Run("Successfully create VNF", func(t *testing.T) {
-		externaluuid, data, err := CreateVNF("uuid", "cloudregion1", "test", &kubeclient)
+		externaluuid, data, _, _, err := CreateVNF("mock_yamls", "cloudregion1", "test", InstanceParameters{}, nil, &kubeclient)
 		if err != nil {
 			t.Fatalf("TestCreateVNF returned an error (%s)", err)
 		}
@@ -92,6 +108,54 @@ func TestCreateVNF(t *testing.T) {
 
 }
 
+func TestCreateVNFRollback(t *testing.T) {
+	oldkrdPluginData := krd.LoadedPlugins
+	oldReadMetadataFile := ReadMetadataFile
+
+	defer func() {
+		krd.LoadedPlugins = oldkrdPluginData
+		ReadMetadataFile = oldReadMetadataFile
+	}()
+
+	err := LoadMockPlugins(&krd.LoadedPlugins)
+	if err != nil {
+		t.Fatalf("TestCreateVNFRollback returned an error (%s)", err)
+	}
+
+	ReadMetadataFile = func(yamlFilePath string) (MetadataFile, error) {
+		return MetadataFile{
+			Resources: []MetadataResource{
+				{Name: "sise-deploy", Type: "deployment", Files: []string{"deployment.yaml"}},
+				{Name: "sise-svc", Type: "service", Files: []string{"failure.yaml"}, DependsOn: []string{"sise-deploy"}},
+			},
+		}, nil
+	}
+
+	os.Setenv("CSAR_DIR", ".")
+
+	kubeclient := kubernetes.Clientset{}
+
+	t.Run("Rollback created resources on failure", func(t *testing.T) {
+		_, data, _, _, err := CreateVNF("mock_yamls", "cloudregion1", "test", InstanceParameters{}, nil, &kubeclient)
+		if err == nil {
+			t.Fatalf("TestCreateVNFRollback expected an error")
+		}
+
+		if data != nil {
+			t.Fatalf("TestCreateVNFRollback returned data (%s)", data)
+		}
+
+		rerr, ok := err.(*RollbackError)
+		if !ok {
+			t.Fatalf("TestCreateVNFRollback returned an unexpected error type (%s)", err)
+		}
+
+		if len(rerr.RollbackErrors) != 0 {
+			t.Fatalf("TestCreateVNFRollback returned rollback errors (%s)", rerr)
+		}
+	})
+}
+
 func TestDeleteVNF(t *testing.T) {
 	oldkrdPluginData := krd.LoadedPlugins
 
@@ -112,18 +176,1909 @@ func TestDeleteVNF(t *testing.T) {
 			"service":    []string{"cloud1-default-uuid-sisesvc"},
 		}
 
-		err := DestroyVNF(data, "test", &kubeclient)
+		err := DestroyVNF(data, nil, "test", nil, &kubeclient)
 		if err != nil {
 			t.Fatalf("TestCreateVNF returned an error (%s)", err)
 		}
 	})
+
+	t.Run("Delete dependent resources first", func(t *testing.T) {
+		data := map[string][]string{
+			"deployment": []string{"cloud1-default-uuid-sisedeploy"},
+			"service":    []string{"cloud1-default-uuid-sisesvc"},
+		}
+		dependencies := ResourceDependencies{
+			"service/cloud1-default-uuid-sisesvc": []string{"deployment/cloud1-default-uuid-sisedeploy"},
+		}
+
+		var deleted []string
+		progress := func(resourceType string, name string, err error) {
+			deleted = append(deleted, resourceType+"/"+name)
+		}
+
+		err := DestroyVNF(data, dependencies, "test", progress, &kubeclient)
+		if err != nil {
+			t.Fatalf("TestDeleteVNF returned an error (%s)", err)
+		}
+
+		expected := []string{"service/cloud1-default-uuid-sisesvc", "deployment/cloud1-default-uuid-sisedeploy"}
+		if !reflect.DeepEqual(expected, deleted) {
+			t.Fatalf("TestDeleteVNF deleted:\n result=%v\n expected=%v", deleted, expected)
+		}
+	})
+}
+
+func TestUpdateVNF(t *testing.T) {
+	oldkrdPluginData := krd.LoadedPlugins
+	oldReadMetadataFile := ReadMetadataFile
+	oldDestroyVNF := DestroyVNF
+
+	defer func() {
+		krd.LoadedPlugins = oldkrdPluginData
+		ReadMetadataFile = oldReadMetadataFile
+		DestroyVNF = oldDestroyVNF
+	}()
+
+	err := LoadMockPlugins(&krd.LoadedPlugins)
+	if err != nil {
+		t.Fatalf("TestUpdateVNF returned an error (%s)", err)
+	}
+
+	ReadMetadataFile = func(yamlFilePath string) (MetadataFile, error) {
+		var seqFile MetadataFile
+
+		rawBytes, err := ioutil.ReadFile("./mock_yamls/metadata.yaml")
+		if err != nil {
+			return seqFile, pkgerrors.Wrap(err, "Metadata YAML file read error")
+		}
+
+		err = yaml.Unmarshal(rawBytes, &seqFile)
+		if err != nil {
+			return seqFile, pkgerrors.Wrap(err, "Metadata YAML file read error")
+		}
+
+		return seqFile, nil
+	}
+
+	os.Setenv("CSAR_DIR", ".")
+
+	kubeclient := kubernetes.Clientset{}
+
+	t.Run("Successfully update VNF", func(t *testing.T) {
+		var removed map[string][]string
+		DestroyVNF = func(data map[string][]string, dependencies ResourceDependencies, namespace string, progress ProgressFunc,
+			kubeclient *kubernetes.Clientset) error {
+			removed = data
+			return nil
+		}
+
+		data := map[string][]string{
+			"deployment": []string{"externalUUID", "cloud1-default-uuid-oldeploy"},
+		}
+
+		result, _, err := UpdateVNF("mock_yamls", "cloud1", "default", "uuid", InstanceParameters{}, data, nil, nil, &kubeclient)
+		if err != nil {
+			t.Fatalf("TestUpdateVNF returned an error (%s)", err)
+		}
+
+		expected := map[string][]string{
+			"deployment": []string{"externalUUID"},
+			"service":    []string{"externalUUID"},
+		}
+		if !reflect.DeepEqual(expected, result) {
+			t.Fatalf("TestUpdateVNF returned:\n result=%v\n expected=%v", result, expected)
+		}
+
+		expectedRemoved := map[string][]string{
+			"deployment": []string{"cloud1-default-uuid-oldeploy"},
+		}
+		if !reflect.DeepEqual(expectedRemoved, removed) {
+			t.Fatalf("TestUpdateVNF removed:\n result=%v\n expected=%v", removed, expectedRemoved)
+		}
+	})
+}
+
+func TestWaitForVNF(t *testing.T) {
+	oldkrdPluginData := krd.LoadedPlugins
+	oldReadMetadataFile := ReadMetadataFile
+
+	defer func() {
+		krd.LoadedPlugins = oldkrdPluginData
+		ReadMetadataFile = oldReadMetadataFile
+	}()
+
+	err := LoadMockPlugins(&krd.LoadedPlugins)
+	if err != nil {
+		t.Fatalf("TestWaitForVNF returned an error (%s)", err)
+	}
+
+	ReadMetadataFile = func(yamlFilePath string) (MetadataFile, error) {
+		return MetadataFile{
+			Resources: []MetadataResource{
+				{Name: "sise-deploy", Type: "deployment"},
+				{Name: "sise-fast", Type: "deployment", Readiness: &ReadinessRule{Skip: true}},
+				{Name: "sise-vip", Type: "service"},
+				{Name: "sise-svc", Type: "service", Readiness: &ReadinessRule{Timeout: 1}},
+			},
+			Readiness: map[string]ReadinessRule{
+				"service": ReadinessRule{Skip: true},
+			},
+		}, nil
+	}
+
+	os.Setenv("CSAR_DIR", ".")
+
+	kubeclient := kubernetes.Clientset{}
+
+	t.Run("Successfully wait for VNF", func(t *testing.T) {
+		// A deployment and a service with the same name
+		objects := ResourceObjects{
+			"sise-deploy": []string{"deployment/cloud1-default-uuid-sise"},
+			"sise-vip":    []string{"service/cloud1-default-uuid-sise"},
+		}
+
+		result, err := WaitForVNF("mock_yamls", objects, "test", time.Second, &kubeclient)
+		if err != nil {
+			t.Fatalf("TestWaitForVNF returned an error (%s)", err)
+		}
+
+		expected := map[string]string{
+			"deployment/cloud1-default-uuid-sise": ResourceReady,
+			"service/cloud1-default-uuid-sise":    ResourceSkipped,
+		}
+		if !reflect.DeepEqual(expected, result) {
+			t.Fatalf("TestWaitForVNF returned:\n result=%v\n expected=%v", result, expected)
+		}
+	})
+	t.Run("Apply the readiness rule of each resource", func(t *testing.T) {
+		objects := ResourceObjects{
+			"sise-deploy": []string{"deployment/cloud1-default-uuid-sisedeploy"},
+			"sise-fast":   []string{"deployment/cloud1-default-uuid-sisefast"},
+			"sise-svc":    []string{"service/cloud1-default-uuid-sisesvc"},
+		}
+
+		result, err := WaitForVNF("mock_yamls", objects, "test", time.Second, &kubeclient)
+		if err != nil {
+			t.Fatalf("TestWaitForVNF returned an error (%s)", err)
+		}
+
+		// The rule of sise-svc overrides the rule of the service type
+		expected := map[string]string{
+			"deployment/cloud1-default-uuid-sisedeploy": ResourceReady,
+			"deployment/cloud1-default-uuid-sisefast":   ResourceSkipped,
+			"service/cloud1-default-uuid-sisesvc":       ResourceReady,
+		}
+		if !reflect.DeepEqual(expected, result) {
+			t.Fatalf("TestWaitForVNF returned:\n result=%v\n expected=%v", result, expected)
+		}
+	})
+	t.Run("Stop waiting for a failed resource", func(t *testing.T) {
+		objects := ResourceObjects{
+			"sise-deploy": []string{"deployment/cloud1-default-uuid-failed"},
+		}
+
+		start := time.Now()
+		result, err := WaitForVNF("mock_yamls", objects, "test", time.Minute, &kubeclient)
+		if err != nil {
+			t.Fatalf("TestWaitForVNF returned an error (%s)", err)
+		}
+
+		if time.Since(start) > 10*time.Second {
+			t.Fatalf("TestWaitForVNF waited for a failed resource")
+		}
+
+		expected := map[string]string{
+			"deployment/cloud1-default-uuid-failed": ResourceFailed,
+		}
+		if !reflect.DeepEqual(expected, result) {
+			t.Fatalf("TestWaitForVNF returned:\n result=%v\n expected=%v", result, expected)
+		}
+	})
+}
+
+func TestGetVNFStatus(t *testing.T) {
+	oldkrdPluginData := krd.LoadedPlugins
+
+	defer func() {
+		krd.LoadedPlugins = oldkrdPluginData
+	}()
+
+	err := LoadMockPlugins(&krd.LoadedPlugins)
+	if err != nil {
+		t.Fatalf("TestGetVNFStatus returned an error (%s)", err)
+	}
+
+	kubeclient := kubernetes.Clientset{}
+
+	t.Run("Successfully get VNF status", func(t *testing.T) {
+		data := map[string][]string{
+			"deployment": []string{"cloud1-default-uuid-sisedeploy"},
+			"service":    []string{"cloud1-default-uuid-sisesvc"},
+		}
+
+		state, components, err := GetVNFStatus(data, "test", &kubeclient)
+		if err != nil {
+			t.Fatalf("TestGetVNFStatus returned an error (%s)", err)
+		}
+
+		if state != VNFReady {
+			t.Fatalf("TestGetVNFStatus returned:\n result=%v\n expected=%v", state, VNFReady)
+		}
+
+		if len(components["deployment"]) != 1 || components["deployment"][0].Name != "cloud1-default-uuid-sisedeploy" {
+			t.Fatalf("TestGetVNFStatus returned unexpected components (%v)", components)
+		}
+	})
+}
+
+func TestDetectDrift(t *testing.T) {
+	oldkrdPluginData := krd.LoadedPlugins
+	oldReadMetadataFile := ReadMetadataFile
+
+	defer func() {
+		krd.LoadedPlugins = oldkrdPluginData
+		ReadMetadataFile = oldReadMetadataFile
+	}()
+
+	err := LoadMockPlugins(&krd.LoadedPlugins)
+	if err != nil {
+		t.Fatalf("TestDetectDrift returned an error (%s)", err)
+	}
+
+	ReadMetadataFile = func(yamlFilePath string) (MetadataFile, error) {
+		return MetadataFile{
+			Resources: []MetadataResource{
+				{Name: "sise-deploy", Type: "deployment", Files: []string{"deployment.yaml"}},
+			},
+		}, nil
+	}
+
+	// The mock plugin owns the same objects for every resource type
+	krd.LoadedPlugins = map[string]*plugin.Plugin{"deployment": krd.LoadedPlugins["deployment"]}
+
+	kubeclient := kubernetes.Clientset{}
+
+	t.Run("VNF without drift", func(t *testing.T) {
+		resourceNameMap := map[string][]string{"deployment": {"externalUUID", "leftoverUUID"}}
+
+		drifts, err := DetectDrift("mock_yamls", "cloud1", "default", "uuid", InstanceParameters{},
+			resourceNameMap, &kubeclient)
+		if err != nil {
+			t.Fatalf("TestDetectDrift returned an error (%s)", err)
+		}
+
+		if len(drifts) != 0 {
+			t.Fatalf("TestDetectDrift returned unexpected drifts (%v)", drifts)
+		}
+	})
+
+	t.Run("Report the objects of the VNF missing from its record", func(t *testing.T) {
+		resourceNameMap := map[string][]string{"deployment": {"externalUUID"}}
+
+		drifts, err := DetectDrift("mock_yamls", "cloud1", "default", "uuid", InstanceParameters{},
+			resourceNameMap, &kubeclient)
+		if err != nil {
+			t.Fatalf("TestDetectDrift returned an error (%s)", err)
+		}
+
+		expected := []krd.ResourceDrift{
+			{ResourceType: "deployment", Name: "leftoverUUID", Extra: true},
+		}
+		if !reflect.DeepEqual(expected, drifts) {
+			t.Fatalf("TestDetectDrift returned:\n result=%v\n expected=%v", drifts, expected)
+		}
+	})
 }
 
 func TestReadMetadataFile(t *testing.T) {
 	t.Run("Successfully read Metadata YAML file", func(t *testing.T) {
-		_, err := ReadMetadataFile("./csar/mock_yamls/metadata.yaml")
+		_, err := ReadMetadataFile("./mock_yamls/metadata.yaml")
 		if err != nil {
 			t.Fatalf("TestReadMetadataFile returned an error (%s)", err)
 		}
 	})
+
+	t.Run("Fail with a missing Metadata YAML file", func(t *testing.T) {
+		_, err := ReadMetadataFile("./mock_yamls/unknown.yaml")
+		if err == nil {
+			t.Fatalf("TestReadMetadataFile expected an error")
+		}
+	})
+}
+
+func TestValidateCSAR(t *testing.T) {
+	oldkrdPluginData := krd.LoadedPlugins
+	oldReadMetadataFile := ReadMetadataFile
+
+	defer func() {
+		krd.LoadedPlugins = oldkrdPluginData
+		ReadMetadataFile = oldReadMetadataFile
+	}()
+
+	err := LoadMockPlugins(&krd.LoadedPlugins)
+	if err != nil {
+		t.Fatalf("TestValidateCSAR returned an error (%s)", err)
+	}
+
+	ReadMetadataFile = func(yamlFilePath string) (MetadataFile, error) {
+		return MetadataFile{
+			Resources: []MetadataResource{
+				{Name: "sise-deploy", Type: "deployment", Files: []string{"deployment.yaml", "missing.yaml"}},
+				{Name: "sise-svc", Type: "unknown", Files: []string{"service.yaml"}, DependsOn: []string{"sise-config"}},
+			},
+		}, nil
+	}
+
+	os.Setenv("CSAR_DIR", ".")
+
+	expected := []string{
+		"Resource sise-svc depends on unknown resource sise-config",
+		"sise-deploy: File ./mock_yamls/missing.yaml does not exists",
+		"sise-svc: No plugin for resource unknown found",
+	}
+
+	t.Run("Report every problem", func(t *testing.T) {
+		problems := ValidateCSAR("mock_yamls")
+		if !reflect.DeepEqual(expected, problems) {
+			t.Fatalf("TestValidateCSAR returned:\n result=%v\n expected=%v", problems, expected)
+		}
+	})
+
+	t.Run("Create nothing from an invalid CSAR", func(t *testing.T) {
+		kubeclient := kubernetes.Clientset{}
+
+		_, _, _, _, err := CreateVNF("mock_yamls", "cloudregion1", "test", InstanceParameters{}, nil, &kubeclient)
+		verr, ok := err.(*ValidationError)
+		if !ok {
+			t.Fatalf("TestValidateCSAR returned an unexpected error type (%s)", err)
+		}
+
+		if !reflect.DeepEqual(expected, verr.Problems) {
+			t.Fatalf("TestValidateCSAR returned:\n result=%v\n expected=%v", verr.Problems, expected)
+		}
+	})
+}
+
+func TestTemplateInputs(t *testing.T) {
+	oldkrdPluginData := krd.LoadedPlugins
+	oldCsarDir := os.Getenv("CSAR_DIR")
+
+	defer func() {
+		krd.LoadedPlugins = oldkrdPluginData
+		os.Setenv("CSAR_DIR", oldCsarDir)
+	}()
+
+	err := LoadMockPlugins(&krd.LoadedPlugins)
+	if err != nil {
+		t.Fatalf("TestTemplateInputs returned an error (%s)", err)
+	}
+
+	csarDir, err := ioutil.TempDir("", "csar")
+	if err != nil {
+		t.Fatalf("TestTemplateInputs returned an error (%s)", err)
+	}
+	defer os.RemoveAll(csarDir)
+
+	os.Setenv("CSAR_DIR", csarDir)
+
+	files := map[string]string{
+		"metadata.yaml": `
+inputs:
+  - name: replicas
+    type: integer
+    default: 1
+  - name: image
+    type: string
+    required: true
+  - name: labels
+    type: map
+    default:
+      tier: backend
+resources:
+  - name: sise-deploy
+    template: true
+    files:
+    - deployment.yaml
+  - name: sise-svc
+    files:
+    - service.yaml
+`,
+		"service.yaml": `apiVersion: v1
+kind: Service
+metadata:
+  name: sise-svc
+  annotations:
+    description: "Rendered by the VNF as <p>{{ message }}</p>"
+spec:
+  ports:
+  - port: 80
+`,
+		"deployment.yaml": `apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: sise-deploy
+  labels:
+    tier: {{ .Values.labels.tier }}
+  annotations:
+    oam-ip: "{{ .OAMIP.IPAddress }}"
+    vnf-host: "{{ range .OOFParams }}{{ .vnfHostName }}{{ end }}"
+spec:
+  replicas: {{ .Values.replicas }}
+  template:
+    spec:
+      containers:
+      - name: sise
+        image: {{ .Values.image }}
+`,
+	}
+
+	err = os.Mkdir(csarDir+"/vfw", 0755)
+	if err != nil {
+		t.Fatalf("TestTemplateInputs returned an error (%s)", err)
+	}
+	for name, content := range files {
+		err = ioutil.WriteFile(csarDir+"/vfw/"+name, []byte(content), 0644)
+		if err != nil {
+			t.Fatalf("TestTemplateInputs returned an error (%s)", err)
+		}
+	}
+
+	csarPackage, err := OpenPackage("vfw")
+	if err != nil {
+		t.Fatalf("TestTemplateInputs returned an error (%s)", err)
+	}
+	defer csarPackage.Close()
+
+	seqFile, err := csarPackage.Metadata()
+	if err != nil {
+		t.Fatalf("TestTemplateInputs returned an error (%s)", err)
+	}
+
+	t.Run("Render a manifest with the instance parameters", func(t *testing.T) {
+		values, err := newTemplateValues("vfw", seqFile.Inputs, InstanceParameters{
+			// JSON numbers are decoded as float64
+			Inputs:    map[string]interface{}{"replicas": float64(3), "image": "sise:1.0"},
+			OOFParams: []map[string]interface{}{{"vnfHostName": "compute-1"}},
+			OAMIP:     OAMIPAddress{IPAddress: "10.10.10.5"},
+		})
+		if err != nil {
+			t.Fatalf("TestTemplateInputs returned an error (%s)", err)
+		}
+
+		rawBytes, err := csarPackage.ReadFile("deployment.yaml")
+		if err != nil {
+			t.Fatalf("TestTemplateInputs returned an error (%s)", err)
+		}
+
+		rendered, err := renderTemplate("deployment.yaml", rawBytes, values)
+		if err != nil {
+			t.Fatalf("TestTemplateInputs returned an error (%s)", err)
+		}
+
+		for _, expected := range []string{"replicas: 3\n", "image: sise:1.0\n", "tier: backend\n",
+			`oam-ip: "10.10.10.5"`, `vnf-host: "compute-1"`} {
+			if !strings.Contains(string(rendered), expected) {
+				t.Fatalf("TestTemplateInputs rendered:\n%s\n expected to contain %s", rendered, expected)
+			}
+		}
+	})
+
+	t.Run("Keep the files of a resource which is not a template", func(t *testing.T) {
+		objects, errs := renderResource(csarPackage, seqFile.Resources[1], templateValues{})
+		if len(errs) > 0 {
+			t.Fatalf("TestTemplateInputs returned an error (%s)", errs[0])
+		}
+
+		if len(objects) != 1 || !strings.Contains(string(objects[0].data), "{{ message }}") {
+			t.Fatalf("TestTemplateInputs returned:\n result=%v\n expected the service unchanged", objects)
+		}
+	})
+
+	t.Run("Report missing and mistyped inputs", func(t *testing.T) {
+		_, problems := resolveInputs(seqFile.Inputs, map[string]interface{}{
+			"replicas": 2.5,
+			"unknown":  true,
+		})
+
+		expected := []string{
+			"Input replicas must be of type integer",
+			"Missing required input image",
+			"Unknown input unknown",
+		}
+		if !reflect.DeepEqual(expected, problems) {
+			t.Fatalf("TestTemplateInputs returned:\n result=%v\n expected=%v", problems, expected)
+		}
+	})
+
+	t.Run("Reject a VNF without required inputs", func(t *testing.T) {
+		err := CheckInputs("vfw", nil)
+		if _, ok := err.(*InputError); !ok {
+			t.Fatalf("TestTemplateInputs returned an unexpected error (%v)", err)
+		}
+
+		kubeclient := kubernetes.Clientset{}
+
+		_, data, _, _, err := CreateVNF("vfw", "cloudregion1", "test", InstanceParameters{}, nil, &kubeclient)
+		if _, ok := err.(*InputError); !ok {
+			t.Fatalf("TestTemplateInputs returned an unexpected error (%v)", err)
+		}
+		if data != nil {
+			t.Fatalf("TestTemplateInputs returned data (%v)", data)
+		}
+	})
+
+	t.Run("Validate a templated CSAR", func(t *testing.T) {
+		problems := ValidateCSAR("vfw")
+		if len(problems) != 0 {
+			t.Fatalf("TestTemplateInputs returned problems (%v)", problems)
+		}
+	})
+
+	t.Run("Report invalid input declarations", func(t *testing.T) {
+		problems := validateInputs([]InputParameter{
+			{Name: "replicas", Type: "integer", Default: "one"},
+			{Name: "replicas", Type: "integer"},
+			{Name: "size", Type: "quantity"},
+		})
+
+		expected := []string{
+			"Default of input replicas must be of type integer",
+			"Duplicated input replicas",
+			"Input size has an unknown type quantity",
+		}
+		if !reflect.DeepEqual(expected, problems) {
+			t.Fatalf("TestTemplateInputs returned:\n result=%v\n expected=%v", problems, expected)
+		}
+	})
+}
+
+func TestHelmChart(t *testing.T) {
+	oldkrdPluginData := krd.LoadedPlugins
+	oldCsarDir := os.Getenv("CSAR_DIR")
+
+	defer func() {
+		krd.LoadedPlugins = oldkrdPluginData
+		os.Setenv("CSAR_DIR", oldCsarDir)
+	}()
+
+	err := LoadMockPlugins(&krd.LoadedPlugins)
+	if err != nil {
+		t.Fatalf("TestHelmChart returned an error (%s)", err)
+	}
+
+	csarDir, err := ioutil.TempDir("", "csar")
+	if err != nil {
+		t.Fatalf("TestHelmChart returned an error (%s)", err)
+	}
+	defer os.RemoveAll(csarDir)
+
+	os.Setenv("CSAR_DIR", csarDir)
+
+	files := map[string]string{
+		"metadata.yaml": `
+inputs:
+  - name: replicas
+    type: integer
+    default: 1
+resources:
+  - name: sise
+    type: helm
+    chart: charts/sise
+    values: sise-values.yaml
+    template: true
+`,
+		"sise-values.yaml": `replicas: {{ .Values.replicas }}
+`,
+		"charts/sise/Chart.yaml": `name: sise
+version: 0.1.0
+appVersion: "1.0"
+`,
+		"charts/sise/values.yaml": `replicas: 1
+image:
+  repository: sise
+  tag: latest
+`,
+		"charts/sise/templates/_helpers.tpl": `{{- define "sise.fullname" -}}
+{{ .Release.Name }}-{{ .Chart.Name }}
+{{- end -}}
+`,
+		"charts/sise/templates/NOTES.txt": `Deployed {{ .Release.Name }}`,
+		"charts/sise/templates/sise.yaml": `apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: {{ include "sise.fullname" . }}
+  namespace: {{ .Release.Namespace }}
+  annotations:
+    placeholder: "<no value>"
+    owner: "{{ .Values.owner }}"
+spec:
+  replicas: {{ .Values.replicas }}
+  template:
+    spec:
+      containers:
+      - name: sise
+        image: "{{ .Values.image.repository }}:{{ .Values.image.tag | default "latest" }}"
+---
+apiVersion: v1
+kind: Service
+metadata:
+  name: {{ include "sise.fullname" . }}
+`,
+		"charts/broken/Chart.yaml": `name: broken
+version: 0.1.0
+`,
+		"charts/broken/templates/broken.yaml": `apiVersion: v1
+kind: Service
+metadata:
+  name: {{ .Release.Name | sha256sum }}
+`,
+	}
+
+	for name, content := range files {
+		err = os.MkdirAll(path.Dir(csarDir+"/sise/"+name), 0755)
+		if err != nil {
+			t.Fatalf("TestHelmChart returned an error (%s)", err)
+		}
+		err = ioutil.WriteFile(csarDir+"/sise/"+name, []byte(content), 0644)
+		if err != nil {
+			t.Fatalf("TestHelmChart returned an error (%s)", err)
+		}
+	}
+
+	t.Run("Render a chart into objects of known kinds", func(t *testing.T) {
+		csarPackage, err := OpenPackage("sise")
+		if err != nil {
+			t.Fatalf("TestHelmChart returned an error (%s)", err)
+		}
+		defer csarPackage.Close()
+
+		seqFile, err := csarPackage.Metadata()
+		if err != nil {
+			t.Fatalf("TestHelmChart returned an error (%s)", err)
+		}
+
+		values, err := newTemplateValues("sise", seqFile.Inputs, InstanceParameters{
+			Inputs: map[string]interface{}{"replicas": float64(3)},
+		})
+		if err != nil {
+			t.Fatalf("TestHelmChart returned an error (%s)", err)
+		}
+		values.Namespace = "test"
+
+		manifests, err := renderChart(csarPackage, seqFile.Resources[0], values)
+		if err != nil {
+			t.Fatalf("TestHelmChart returned an error (%s)", err)
+		}
+
+		if len(manifests) != 2 || manifests[0].resourceType != "deployment" || manifests[1].resourceType != "service" {
+			t.Fatalf("TestHelmChart returned unexpected manifests (%v)", manifests)
+		}
+		if manifests[0].source != "charts/sise/templates/sise.yaml" {
+			t.Fatalf("TestHelmChart returned an unexpected source (%s)", manifests[0].source)
+		}
+
+		for _, expected := range []string{"name: sise-sise\n", "namespace: test\n", "replicas: 3\n",
+			`image: "sise:latest"`, `placeholder: "<no value>"`, `owner: ""`} {
+			if !strings.Contains(string(manifests[0].data), expected) {
+				t.Fatalf("TestHelmChart rendered:\n%s\n expected to contain %s", manifests[0].data, expected)
+			}
+		}
+	})
+
+	t.Run("Report unsupported template functions", func(t *testing.T) {
+		csarPackage, err := OpenPackage("sise")
+		if err != nil {
+			t.Fatalf("TestHelmChart returned an error (%s)", err)
+		}
+		defer csarPackage.Close()
+
+		resource := MetadataResource{Name: "broken", Type: helmResourceType, Chart: "charts/broken"}
+		_, err = renderChart(csarPackage, resource, templateValues{})
+		if err == nil || !strings.Contains(err.Error(), "Template function sha256sum") {
+			t.Fatalf("TestHelmChart returned an unexpected error (%v)", err)
+		}
+	})
+
+	t.Run("Create the objects of a chart", func(t *testing.T) {
+		kubeclient := kubernetes.Clientset{}
+
+		_, data, _, _, err := CreateVNF("sise", "cloudregion1", "test", InstanceParameters{}, nil, &kubeclient)
+		if err != nil {
+			t.Fatalf("TestHelmChart returned an error (%s)", err)
+		}
+
+		expected := map[string][]string{
+			"deployment": []string{"externalUUID"},
+			"service":    []string{"externalUUID"},
+		}
+		if !reflect.DeepEqual(expected, data) {
+			t.Fatalf("TestHelmChart returned:\n result=%v\n expected=%v", data, expected)
+		}
+	})
+
+	t.Run("Reject namespace objects", func(t *testing.T) {
+		err := ioutil.WriteFile(csarDir+"/sise/charts/sise/templates/extra.yaml", []byte(`apiVersion: v1
+kind: Namespace
+metadata:
+  name: other
+`), 0644)
+		if err != nil {
+			t.Fatalf("TestHelmChart returned an error (%s)", err)
+		}
+		defer os.Remove(csarDir + "/sise/charts/sise/templates/extra.yaml")
+
+		problems := ValidateCSAR("sise")
+		if len(problems) != 1 || !strings.Contains(problems[0], "Namespace objects are not supported") {
+			t.Fatalf("TestHelmChart returned unexpected problems (%v)", problems)
+		}
+	})
+}
+
+func TestMultiDocumentManifests(t *testing.T) {
+	oldkrdPluginData := krd.LoadedPlugins
+	oldCsarDir := os.Getenv("CSAR_DIR")
+
+	defer func() {
+		krd.LoadedPlugins = oldkrdPluginData
+		os.Setenv("CSAR_DIR", oldCsarDir)
+	}()
+
+	err := LoadMockPlugins(&krd.LoadedPlugins)
+	if err != nil {
+		t.Fatalf("TestMultiDocumentManifests returned an error (%s)", err)
+	}
+
+	csarDir, err := ioutil.TempDir("", "csar")
+	if err != nil {
+		t.Fatalf("TestMultiDocumentManifests returned an error (%s)", err)
+	}
+	defer os.RemoveAll(csarDir)
+
+	os.Setenv("CSAR_DIR", csarDir)
+
+	files := map[string]string{
+		"metadata.yaml": `
+resources:
+  - name: sise
+    files:
+    - all.yaml
+`,
+		"all.yaml": `# Generated by the vendor
+---
+apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: sise-deploy
+---
+# The service of sise
+apiVersion: v1
+kind: Service
+metadata:
+  name: sise-svc
+---
+`,
+	}
+
+	err = os.Mkdir(csarDir+"/sise", 0755)
+	if err != nil {
+		t.Fatalf("TestMultiDocumentManifests returned an error (%s)", err)
+	}
+	for name, content := range files {
+		err = ioutil.WriteFile(csarDir+"/sise/"+name, []byte(content), 0644)
+		if err != nil {
+			t.Fatalf("TestMultiDocumentManifests returned an error (%s)", err)
+		}
+	}
+
+	t.Run("Create every object of a file", func(t *testing.T) {
+		kubeclient := kubernetes.Clientset{}
+
+		_, data, _, _, err := CreateVNF("sise", "cloudregion1", "test", InstanceParameters{}, nil, &kubeclient)
+		if err != nil {
+			t.Fatalf("TestMultiDocumentManifests returned an error (%s)", err)
+		}
+
+		expected := map[string][]string{
+			"deployment": []string{"externalUUID"},
+			"service":    []string{"externalUUID"},
+		}
+		if !reflect.DeepEqual(expected, data) {
+			t.Fatalf("TestMultiDocumentManifests returned:\n result=%v\n expected=%v", data, expected)
+		}
+	})
+
+	testCases := []struct {
+		label         string
+		input         string
+		resourceType  string
+		expected      []string
+		expectedError string
+	}{
+		{
+			label:    "Route documents by kind",
+			input:    "kind: Deployment\n---\nkind: Service\n",
+			expected: []string{"deployment", "service"},
+		},
+		{
+			label:    "Accept comments after separators",
+			input:    "--- # Source: sise/templates/deployment.yaml\nkind: Deployment\n--- # Source: sise/templates/service.yaml\nkind: Service\n",
+			expected: []string{"deployment", "service"},
+		},
+		{
+			label:    "Accept document end markers",
+			input:    "kind: Deployment\n...\n--- \nkind: Service\n...\n",
+			expected: []string{"deployment", "service"},
+		},
+		{
+			label:    "Accept documents starting on the separator line",
+			input:    "--- {kind: Deployment}\n--- !!map\nkind: Service\n",
+			expected: []string{"deployment", "service"},
+		},
+		{
+			label:        "Use the resource type for documents without kind",
+			input:        "metadata:\n  name: sise\n",
+			resourceType: "service",
+			expected:     []string{"service"},
+		},
+		{
+			label:         "Fail with documents without kind",
+			input:         "metadata:\n  name: sise\n",
+			expectedError: "Error in all.yaml: YAML document without kind",
+		},
+		{
+			label:         "Fail with namespace objects",
+			input:         "kind: Namespace\n",
+			expectedError: "Namespace objects are not supported in all.yaml",
+		},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.label, func(t *testing.T) {
+			manifests, err := splitManifests("all.yaml", []byte(testCase.input), testCase.resourceType)
+			if err != nil {
+				if testCase.expectedError == "" || !strings.HasPrefix(err.Error(), testCase.expectedError) {
+					t.Fatalf("TestMultiDocumentManifests returned an unexpected error (%s)", err)
+				}
+				return
+			}
+			if testCase.expectedError != "" {
+				t.Fatalf("TestMultiDocumentManifests was expected to fail with %s", testCase.expectedError)
+			}
+
+			var result []string
+			for _, object := range manifests {
+				result = append(result, object.resourceType)
+			}
+			if !reflect.DeepEqual(testCase.expected, result) {
+				t.Fatalf("TestMultiDocumentManifests returned:\n result=%v\n expected=%v", result, testCase.expected)
+			}
+		})
+	}
+
+	t.Run("Route kinds without plugin to the generic plugin", func(t *testing.T) {
+		krd.LoadedPlugins["generic"] = krd.LoadedPlugins["deployment"]
+		defer delete(krd.LoadedPlugins, "generic")
+
+		manifests, err := splitManifests("all.yaml",
+			[]byte("kind: Deployment\n---\nkind: NetworkAttachmentDefinition\n"), "")
+		if err != nil {
+			t.Fatalf("TestMultiDocumentManifests returned an error (%s)", err)
+		}
+
+		if len(manifests) != 2 || manifests[0].resourceType != "deployment" || manifests[1].resourceType != "generic" {
+			t.Fatalf("TestMultiDocumentManifests returned unexpected manifests (%v)", manifests)
+		}
+	})
+
+	t.Run("Record the internal names of the created objects", func(t *testing.T) {
+		csarPackage, err := OpenPackage("sise")
+		if err != nil {
+			t.Fatalf("TestMultiDocumentManifests returned an error (%s)", err)
+		}
+		defer csarPackage.Close()
+
+		manifests, err := splitManifests("all.yaml", []byte("kind: Deployment\nmetadata:\n  name: sise-deploy\n"), "")
+		if err != nil {
+			t.Fatalf("TestMultiDocumentManifests returned an error (%s)", err)
+		}
+
+		names := krd.NewNameTable()
+		createFunc := pluginResourceFunc("CreateResource", csarPackage, krd.GenericKubeResourceData{Names: names},
+			nil, nil, &kubernetes.Clientset{})
+
+		_, err = createFunc(manifests[0])
+		if err != nil {
+			t.Fatalf("TestMultiDocumentManifests returned an error (%s)", err)
+		}
+
+		if names.Lookup("Deployment", "sise-deploy") != "externalUUID" || names.Lookup("Service", "sise-deploy") != "" {
+			t.Fatalf("TestMultiDocumentManifests did not record the name of sise-deploy")
+		}
+	})
+}
+
+func TestDataFiles(t *testing.T) {
+	oldCsarDir := os.Getenv("CSAR_DIR")
+
+	defer func() {
+		os.Setenv("CSAR_DIR", oldCsarDir)
+	}()
+
+	csarDir, err := ioutil.TempDir("", "csar")
+	if err != nil {
+		t.Fatalf("TestDataFiles returned an error (%s)", err)
+	}
+	defer os.RemoveAll(csarDir)
+
+	os.Setenv("CSAR_DIR", csarDir)
+
+	files := map[string]string{
+		"metadata.yaml":      "resources: []\n",
+		"config/vfw.conf":    "port: {{ .Values.port }}\n",
+		"config/logo.png":    "\x89PNG\xff",
+		"secrets/password":   "s3cr3t",
+		"other/vfw.conf":     "duplicated",
+		"config/invalid key": "invalid",
+	}
+
+	for name, content := range files {
+		err = os.MkdirAll(path.Dir(csarDir+"/vfw/"+name), 0755)
+		if err != nil {
+			t.Fatalf("TestDataFiles returned an error (%s)", err)
+		}
+		err = ioutil.WriteFile(csarDir+"/vfw/"+name, []byte(content), 0644)
+		if err != nil {
+			t.Fatalf("TestDataFiles returned an error (%s)", err)
+		}
+	}
+
+	csarPackage, err := OpenPackage("vfw")
+	if err != nil {
+		t.Fatalf("TestDataFiles returned an error (%s)", err)
+	}
+	defer csarPackage.Close()
+
+	t.Run("Build a ConfigMap from data files", func(t *testing.T) {
+		objects, errs := renderResource(csarPackage, MetadataResource{
+			Name:      "vfw-config",
+			Type:      "configmap",
+			DataFiles: []string{"config/vfw.conf", "config/logo.png"},
+		}, templateValues{})
+		if len(errs) > 0 || len(objects) != 1 {
+			t.Fatalf("TestDataFiles returned unexpected manifests (%v, %v)", objects, errs)
+		}
+
+		var configMap struct {
+			Kind     string            `yaml:"kind"`
+			Metadata map[string]string `yaml:"metadata"`
+			Data     map[string]string `yaml:"data"`
+			Binary   map[string]string `yaml:"binaryData"`
+		}
+		err := yaml.Unmarshal(objects[0].data, &configMap)
+		if err != nil {
+			t.Fatalf("TestDataFiles returned an error (%s)", err)
+		}
+
+		// Data files are not rendered
+		if configMap.Kind != "ConfigMap" || configMap.Metadata["name"] != "vfw-config" ||
+			configMap.Data["vfw.conf"] != "port: {{ .Values.port }}\n" ||
+			configMap.Binary["logo.png"] != base64.StdEncoding.EncodeToString([]byte("\x89PNG\xff")) {
+			t.Fatalf("TestDataFiles returned an unexpected ConfigMap:\n%s", objects[0].data)
+		}
+	})
+
+	t.Run("Build a Secret from data files", func(t *testing.T) {
+		objects, errs := renderResource(csarPackage, MetadataResource{
+			Name:      "vfw-credentials",
+			Type:      "secret",
+			DataFiles: []string{"secrets/password"},
+		}, templateValues{})
+		if len(errs) > 0 || len(objects) != 1 || objects[0].resourceType != "secret" {
+			t.Fatalf("TestDataFiles returned unexpected manifests (%v, %v)", objects, errs)
+		}
+
+		var secret struct {
+			Kind string            `yaml:"kind"`
+			Type string            `yaml:"type"`
+			Data map[string]string `yaml:"data"`
+		}
+		err := yaml.Unmarshal(objects[0].data, &secret)
+		if err != nil {
+			t.Fatalf("TestDataFiles returned an error (%s)", err)
+		}
+
+		if secret.Kind != "Secret" || secret.Type != "Opaque" ||
+			secret.Data["password"] != base64.StdEncoding.EncodeToString([]byte("s3cr3t")) {
+			t.Fatalf("TestDataFiles returned an unexpected Secret:\n%s", objects[0].data)
+		}
+	})
+
+	testCases := []struct {
+		label         string
+		resource      MetadataResource
+		expectedError string
+	}{
+		{
+			label:         "Fail with duplicated keys",
+			resource:      MetadataResource{Name: "vfw", Type: "configmap", DataFiles: []string{"config/vfw.conf", "other/vfw.conf"}},
+			expectedError: "Duplicated data file vfw.conf",
+		},
+		{
+			label:         "Fail with invalid keys",
+			resource:      MetadataResource{Name: "vfw", Type: "secret", DataFiles: []string{"config/invalid key"}},
+			expectedError: "Data file config/invalid key is not a valid key",
+		},
+		{
+			label:         "Fail with other resource types",
+			resource:      MetadataResource{Name: "vfw", Type: "deployment", DataFiles: []string{"config/vfw.conf"}},
+			expectedError: "Data files are only supported by configmap and secret resources",
+		},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.label, func(t *testing.T) {
+			_, errs := renderResource(csarPackage, testCase.resource, templateValues{})
+			if len(errs) != 1 || errs[0].Error() != testCase.expectedError {
+				t.Fatalf("TestDataFiles returned unexpected errors (%v)", errs)
+			}
+		})
+	}
+}
+
+func TestRetainedResources(t *testing.T) {
+	oldCsarDir := os.Getenv("CSAR_DIR")
+
+	defer func() {
+		os.Setenv("CSAR_DIR", oldCsarDir)
+	}()
+
+	csarDir, err := ioutil.TempDir("", "csar")
+	if err != nil {
+		t.Fatalf("TestRetainedResources returned an error (%s)", err)
+	}
+	defer os.RemoveAll(csarDir)
+
+	os.Setenv("CSAR_DIR", csarDir)
+
+	files := map[string]string{
+		"metadata.yaml":   "resources: []\n",
+		"pvc.yaml":        "kind: PersistentVolumeClaim\nmetadata:\n  name: sisedata\n",
+		"deployment.yaml": "kind: Deployment\nmetadata:\n  name: sisedeploy\n",
+	}
+
+	err = os.Mkdir(csarDir+"/sise", 0755)
+	if err != nil {
+		t.Fatalf("TestRetainedResources returned an error (%s)", err)
+	}
+	for name, content := range files {
+		err = ioutil.WriteFile(csarDir+"/sise/"+name, []byte(content), 0644)
+		if err != nil {
+			t.Fatalf("TestRetainedResources returned an error (%s)", err)
+		}
+	}
+
+	csarPackage, err := OpenPackage("sise")
+	if err != nil {
+		t.Fatalf("TestRetainedResources returned an error (%s)", err)
+	}
+	defer csarPackage.Close()
+
+	t.Run("Retain persistentvolumeclaims", func(t *testing.T) {
+		objects, errs := renderResource(csarPackage, MetadataResource{
+			Name:   "sise-data",
+			Type:   "persistentvolumeclaim",
+			Files:  []string{"pvc.yaml"},
+			Retain: true,
+		}, templateValues{})
+		if len(errs) > 0 || len(objects) != 1 || !objects[0].retain {
+			t.Fatalf("TestRetainedResources returned unexpected manifests (%v, %v)", objects, errs)
+		}
+	})
+
+	t.Run("Fail to retain other objects", func(t *testing.T) {
+		_, errs := renderResource(csarPackage, MetadataResource{
+			Name:   "sise",
+			Files:  []string{"pvc.yaml", "deployment.yaml"},
+			Retain: true,
+		}, templateValues{})
+		expected := "Only persistentvolumeclaim objects can be retained, deployment found in deployment.yaml"
+		if len(errs) != 1 || errs[0].Error() != expected {
+			t.Fatalf("TestRetainedResources returned unexpected errors (%v)", errs)
+		}
+	})
+}
+
+func TestRewriteManifests(t *testing.T) {
+	kubedata := krd.GenericKubeResourceData{
+		Namespace:     "default",
+		InternalVNFID: "cloud1-default-uuid",
+		ExternalVNFID: "uuid",
+		CloudRegionID: "cloud1",
+	}
+	kubedata.Names = vnfNames(map[string][]manifest{
+		"sise": {
+			{kind: "Deployment", name: "sisedeploy"},
+			{kind: "Service", name: "sisesvc"},
+			{kind: "ConfigMap", name: "siseconf"},
+			{kind: "Secret", name: "sisesecret"},
+			{kind: "ServiceAccount", name: "sisesa"},
+			{kind: "Role", name: "siserole"},
+		},
+		"sise-data": {
+			{kind: "PersistentVolumeClaim", name: "sisedata", retain: true},
+		},
+	}, kubedata)
+
+	testCases := []struct {
+		label    string
+		kind     string
+		input    string
+		expected string
+	}{
+		{
+			label: "Label the pods of a workload and rewrite their references",
+			kind:  "Deployment",
+			input: `kind: Deployment
+metadata:
+  name: sisedeploy
+spec:
+  selector:
+    matchLabels:
+      app: sise
+  template:
+    metadata:
+      labels:
+        app: sise
+    spec:
+      serviceAccountName: sisesa
+      containers:
+      - name: sise
+        env:
+        - name: KEY
+          valueFrom:
+            secretKeyRef:
+              name: sisesecret
+              key: key
+      volumes:
+      - name: conf
+        configMap:
+          name: siseconf
+      - name: shared
+        configMap:
+          name: shared
+      - name: data
+        persistentVolumeClaim:
+          claimName: sisedata
+`,
+			expected: `{"kind":"Deployment","metadata":{"name":"sisedeploy"},"spec":{` +
+				`"selector":{"matchLabels":{"app":"sise","k8plugin.onap.org/vnf-id":"uuid"}},` +
+				`"template":{"metadata":{"labels":{"app":"sise","k8plugin.onap.org/vnf-id":"uuid"}},"spec":{` +
+				`"containers":[{"env":[{"name":"KEY","valueFrom":{"secretKeyRef":{"key":"key","name":"cloud1-default-uuid-sisesecret"}}}],"name":"sise"}],` +
+				`"serviceAccountName":"cloud1-default-uuid-sisesa",` +
+				`"volumes":[{"configMap":{"name":"cloud1-default-uuid-siseconf"},"name":"conf"},` +
+				`{"configMap":{"name":"shared"},"name":"shared"},` +
+				`{"name":"data","persistentVolumeClaim":{"claimName":"cloud1-default-sisedata"}}]}}}}`,
+		},
+		{
+			label:    "Restrict the selector of a service",
+			kind:     "Service",
+			input:    "kind: Service\nmetadata:\n  name: sisesvc\nspec:\n  selector:\n    app: sise\n  ports:\n  - port: 8080\n",
+			expected: `{"kind":"Service","metadata":{"name":"sisesvc"},"spec":{"ports":[{"port":8080}],"selector":{"app":"sise","k8plugin.onap.org/vnf-id":"uuid"}}}`,
+		},
+		{
+			label:    "Keep services without selector",
+			kind:     "Service",
+			input:    "kind: Service\nmetadata:\n  name: sisesvc\nspec:\n  type: ExternalName\n",
+			expected: `{"kind":"Service","metadata":{"name":"sisesvc"},"spec":{"type":"ExternalName"}}`,
+		},
+		{
+			label: "Rewrite the backends of an ingress",
+			kind:  "Ingress",
+			input: "kind: Ingress\nmetadata:\n  name: siseingress\nspec:\n  backend:\n    serviceName: other\n" +
+				"  rules:\n  - http:\n      paths:\n      - backend:\n          serviceName: sisesvc\n",
+			expected: `{"kind":"Ingress","metadata":{"name":"siseingress"},"spec":{"backend":{"serviceName":"other"},` +
+				`"rules":[{"http":{"paths":[{"backend":{"serviceName":"cloud1-default-uuid-sisesvc"}}]}}]}}`,
+		},
+		{
+			label: "Rewrite the role and subjects of a binding",
+			kind:  "RoleBinding",
+			input: "kind: RoleBinding\nmetadata:\n  name: sisebinding\nroleRef:\n  kind: Role\n  name: siserole\n" +
+				"subjects:\n- kind: ServiceAccount\n  name: sisesa\n- kind: ServiceAccount\n  name: sisesa\n  namespace: kube-system\n",
+			expected: `{"kind":"RoleBinding","metadata":{"name":"sisebinding"},"roleRef":{"kind":"Role","name":"cloud1-default-uuid-siserole"},` +
+				`"subjects":[{"kind":"ServiceAccount","name":"cloud1-default-uuid-sisesa","namespace":"default"},` +
+				`{"kind":"ServiceAccount","name":"sisesa","namespace":"kube-system"}]}`,
+		},
+		{
+			label: "Rewrite the target of an autoscaler",
+			kind:  "HorizontalPodAutoscaler",
+			input: "kind: HorizontalPodAutoscaler\nmetadata:\n  name: sisehpa\nspec:\n  maxReplicas: 3\n" +
+				"  scaleTargetRef:\n    kind: Deployment\n    name: sisedeploy\n",
+			expected: `{"kind":"HorizontalPodAutoscaler","metadata":{"name":"sisehpa"},"spec":{"maxReplicas":3,` +
+				`"scaleTargetRef":{"kind":"Deployment","name":"cloud1-default-uuid-sisedeploy"}}}`,
+		},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.label, func(t *testing.T) {
+			result, err := rewriteManifest(manifest{
+				source: "sise.yaml",
+				data:   []byte(testCase.input),
+				kind:   testCase.kind,
+			}, &kubedata, nil)
+			if err != nil {
+				t.Fatalf("TestRewriteManifests returned an error (%s)", err)
+			}
+			if string(result) != testCase.expected {
+				t.Fatalf("TestRewriteManifests returned:\n%s\nexpected:\n%s", result, testCase.expected)
+			}
+		})
+	}
+}
+
+func TestNetworkAttachments(t *testing.T) {
+	manifests := map[string][]manifest{
+		"sise": {
+			{kind: "Deployment", name: "sisedeploy"},
+			{kind: "NetworkAttachmentDefinition", name: "sise-net"},
+		},
+	}
+
+	kubedata := krd.GenericKubeResourceData{
+		Namespace:     "default",
+		InternalVNFID: "cloud1-default-uuid",
+		ExternalVNFID: "uuid",
+	}
+	kubedata.Names = vnfNames(manifests, kubedata)
+
+	networks := vnfNetworks(InstanceParameters{
+		OAMIP: OAMIPAddress{ConnectionPoint: "oam", IPAddress: "10.10.10.5", WorkloadName: "sisedeploy"},
+		Networks: []NetworkAttachment{
+			{Name: "oam", Interface: "eth1"},
+			{Name: "sise-net", Interface: "net1", MACAddress: "02:00:00:00:00:01", WorkloadName: "sisedeploy"},
+		},
+	})
+
+	t.Run("Give the OAM IP address to the connection point of its workload", func(t *testing.T) {
+		expected := []NetworkAttachment{
+			{Name: "sise-net", Interface: "net1", MACAddress: "02:00:00:00:00:01", WorkloadName: "sisedeploy"},
+			{Name: "oam", Interface: "eth1", IPAddress: "10.10.10.5", WorkloadName: "sisedeploy"},
+		}
+		if result := workloadNetworks(networks, "sisedeploy"); !reflect.DeepEqual(result, expected) {
+			t.Fatalf("TestNetworkAttachments returned:\n%v\nexpected:\n%v", result, expected)
+		}
+	})
+
+	t.Run("Annotate the pods of a workload", func(t *testing.T) {
+		input := "kind: Deployment\nmetadata:\n  name: sisedeploy\nspec:\n  template:\n    metadata:\n" +
+			"      annotations:\n        k8s.v1.cni.cncf.io/networks: kube-system/macvlan@mgmt\n"
+		expected := `{"kind":"Deployment","metadata":{"name":"sisedeploy"},"spec":{"template":{"metadata":{"annotations":{` +
+			`"k8s.v1.cni.cncf.io/networks":"[{\"interface\":\"mgmt\",\"name\":\"macvlan\",\"namespace\":\"kube-system\"},` +
+			`{\"interface\":\"net1\",\"mac\":\"02:00:00:00:00:01\",\"name\":\"cloud1-default-uuid-sise-net\"},` +
+			`{\"interface\":\"eth1\",\"ips\":[\"10.10.10.5\"],\"name\":\"oam\"}]"},` +
+			`"labels":{"k8plugin.onap.org/vnf-id":"uuid"}}}}}`
+
+		result, err := rewriteManifest(manifest{
+			source: "deployment.yaml",
+			data:   []byte(input),
+			kind:   "Deployment",
+			name:   "sisedeploy",
+		}, &kubedata, networks)
+		if err != nil {
+			t.Fatalf("TestNetworkAttachments returned an error (%s)", err)
+		}
+		if string(result) != expected {
+			t.Fatalf("TestNetworkAttachments returned:\n%s\nexpected:\n%s", result, expected)
+		}
+	})
+
+	t.Run("Fail with invalid networks", func(t *testing.T) {
+		problems := validateNetworks([]NetworkAttachment{
+			{Name: "sise-net", IPAddress: "string", WorkloadName: "sisedeploy"},
+			{Name: "sise-net", MACAddress: "string"},
+			{Name: "sise-net", WorkloadName: "other"},
+			{Interface: "net1"},
+		}, manifests)
+		expected := []string{
+			"Invalid IP address string on network sise-net",
+			"Invalid MAC address string on network sise-net",
+			"Network sise-net is attached to workload other which is not described in the CSAR",
+			"Network attachment without name",
+		}
+		if !reflect.DeepEqual(problems, expected) {
+			t.Fatalf("TestNetworkAttachments returned unexpected problems (%v)", problems)
+		}
+	})
+}
+
+func TestMetadataFileResources(t *testing.T) {
+	t.Run("Read legacy and named resources", func(t *testing.T) {
+		rawBytes := []byte(`
+resources:
+  - configmap:
+    - configmap.yaml
+  - deployment:
+    - deployment.yaml
+  - name: sise-svc
+    type: service
+    files:
+    - service.yaml
+    depends_on:
+    - configmap-0
+`)
+		var seqFile MetadataFile
+		err := yaml.Unmarshal(rawBytes, &seqFile)
+		if err != nil {
+			t.Fatalf("TestMetadataFileResources returned an error (%s)", err)
+		}
+
+		expected := []MetadataResource{
+			{Name: "configmap-0", Type: "configmap", Files: []string{"configmap.yaml"}},
+			{Name: "deployment-1", Type: "deployment", Files: []string{"deployment.yaml"}, DependsOn: []string{"configmap-0"}},
+			{Name: "sise-svc", Type: "service", Files: []string{"service.yaml"}, DependsOn: []string{"configmap-0"}},
+		}
+		if !reflect.DeepEqual(expected, seqFile.Resources) {
+			t.Fatalf("TestMetadataFileResources returned:\n result=%v\n expected=%v", seqFile.Resources, expected)
+		}
+	})
+	t.Run("Add the network isolation resource", func(t *testing.T) {
+		rawBytes := []byte(`
+network_isolation: true
+resources:
+  - name: sise-deploy
+    files:
+    - deployment.yaml
+`)
+		var seqFile MetadataFile
+		err := yaml.Unmarshal(rawBytes, &seqFile)
+		if err != nil {
+			t.Fatalf("TestMetadataFileResources returned an error (%s)", err)
+		}
+
+		if len(seqFile.Resources) != 2 || !seqFile.Resources[1].isolation {
+			t.Fatalf("TestMetadataFileResources returned unexpected resources (%v)", seqFile.Resources)
+		}
+
+		objects, errs := renderResource(nil, seqFile.Resources[1], templateValues{})
+		if len(errs) > 0 || len(objects) != 1 || objects[0].resourceType != "networkpolicy" {
+			t.Fatalf("TestMetadataFileResources returned unexpected manifests (%v, %v)", objects, errs)
+		}
+
+		var policy struct {
+			Kind     string `yaml:"kind"`
+			Metadata struct {
+				Annotations map[string]string `yaml:"annotations"`
+			} `yaml:"metadata"`
+		}
+		err = yaml.Unmarshal(objects[0].data, &policy)
+		if err != nil {
+			t.Fatalf("TestMetadataFileResources returned an error (%s)", err)
+		}
+
+		if policy.Kind != "NetworkPolicy" || policy.Metadata.Annotations[krd.IsolationAnnotation] != "true" {
+			t.Fatalf("TestMetadataFileResources returned an unexpected policy:\n%s", objects[0].data)
+		}
+	})
+}
+
+func TestResourceLevels(t *testing.T) {
+	testCases := []struct {
+		label         string
+		input         []MetadataResource
+		expected      [][]string
+		expectedError string
+	}{
+		{
+			label: "Independent resources share a level",
+			input: []MetadataResource{
+				{Name: "deploy", Type: "deployment", DependsOn: []string{"config", "svc"}},
+				{Name: "svc", Type: "service"},
+				{Name: "config", Type: "configmap"},
+			},
+			expected: [][]string{{"svc", "config"}, {"deploy"}},
+		},
+		{
+			label: "Fail with a dependency cycle",
+			input: []MetadataResource{
+				{Name: "deploy", Type: "deployment", DependsOn: []string{"svc"}},
+				{Name: "svc", Type: "service", DependsOn: []string{"deploy"}},
+			},
+			expectedError: "Dependency cycle between resources: deploy, svc",
+		},
+		{
+			label: "Fail with an unknown dependency",
+			input: []MetadataResource{
+				{Name: "deploy", Type: "deployment", DependsOn: []string{"svc"}},
+			},
+			expectedError: "Resource deploy depends on unknown resource svc",
+		},
+		{
+			label: "Fail with duplicated names",
+			input: []MetadataResource{
+				{Name: "deploy", Type: "deployment"},
+				{Name: "deploy", Type: "service"},
+			},
+			expectedError: "Duplicated resource deploy",
+		},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.label, func(t *testing.T) {
+			levels, err := resourceLevels(testCase.input)
+			if testCase.expectedError != "" {
+				if err == nil || err.Error() != testCase.expectedError {
+					t.Fatalf("TestResourceLevels returned:\n result=%v\n expected=%v", err, testCase.expectedError)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("TestResourceLevels returned an error (%s)", err)
+			}
+
+			var result [][]string
+			for _, level := range levels {
+				var names []string
+				for _, resource := range level {
+					names = append(names, resource.Name)
+				}
+				result = append(result, names)
+			}
+			if !reflect.DeepEqual(testCase.expected, result) {
+				t.Fatalf("TestResourceLevels returned:\n result=%v\n expected=%v", result, testCase.expected)
+			}
+		})
+	}
+}
+
+func TestApplyResources(t *testing.T) {
+	oldWorkers := os.Getenv("CSAR_WORKERS")
+
+	defer func() {
+		os.Setenv("CSAR_WORKERS", oldWorkers)
+	}()
+
+	os.Setenv("CSAR_WORKERS", "2")
+
+	levels, err := resourceLevels([]MetadataResource{
+		{Name: "deploy", Type: "deployment", Files: []string{"deploy.yaml"}, DependsOn: []string{"config", "svc"}},
+		{Name: "config", Type: "configmap", Files: []string{"config1.yaml", "config2.yaml"}},
+		{Name: "svc", Type: "service", Files: []string{"svc.yaml"}},
+		{Name: "job", Type: "job", Files: []string{"job.yaml"}, DependsOn: []string{"deploy"}},
+	})
+	if err != nil {
+		t.Fatalf("TestApplyResources returned an error (%s)", err)
+	}
+
+	manifests := make(map[string][]manifest)
+	for _, level := range levels {
+		for _, resource := range level {
+			for _, filename := range resource.Files {
+				manifests[resource.Name] = append(manifests[resource.Name],
+					manifest{resourceType: resource.Type, source: filename})
+			}
+		}
+	}
+
+	required := map[string][]string{
+		"deploy.yaml": []string{"config1.yaml", "config2.yaml", "svc.yaml"},
+		"job.yaml":    []string{"deploy.yaml"},
+	}
+
+	t.Run("Honour dependencies and worker count", func(t *testing.T) {
+		var mutex sync.Mutex
+		running, maxRunning := 0, 0
+		finished := make(map[string]bool)
+
+		objects, ordered, err := applyResources(levels, manifests, func(object manifest) (string, error) {
+			filename := object.source
+			mutex.Lock()
+			for _, dependency := range required[filename] {
+				if !finished[dependency] {
+					t.Errorf("TestApplyResources processed %s before %s", filename, dependency)
+				}
+			}
+			running++
+			if running > maxRunning {
+				maxRunning = running
+			}
+			mutex.Unlock()
+
+			time.Sleep(10 * time.Millisecond)
+
+			mutex.Lock()
+			running--
+			finished[filename] = true
+			mutex.Unlock()
+			return "uuid-" + filename, nil
+		})
+		if err != nil {
+			t.Fatalf("TestApplyResources returned an error (%s)", err)
+		}
+
+		if maxRunning > 2 {
+			t.Fatalf("TestApplyResources ran %d files concurrently with 2 workers", maxRunning)
+		}
+
+		expected := map[string][]string{
+			"configmap":  []string{"uuid-config1.yaml", "uuid-config2.yaml"},
+			"service":    []string{"uuid-svc.yaml"},
+			"deployment": []string{"uuid-deploy.yaml"},
+			"job":        []string{"uuid-job.yaml"},
+		}
+		if result := resourceNameMap(ordered); !reflect.DeepEqual(expected, result) {
+			t.Fatalf("TestApplyResources returned:\n result=%v\n expected=%v", result, expected)
+		}
+
+		if len(objects["config"]) != 2 || objects["job"][0].name != "uuid-job.yaml" {
+			t.Fatalf("TestApplyResources returned unexpected objects (%v)", objects)
+		}
+	})
+
+	t.Run("Stop after the first failure", func(t *testing.T) {
+		var mutex sync.Mutex
+		var processed []string
+
+		_, ordered, err := applyResources(levels, manifests, func(object manifest) (string, error) {
+			filename := object.source
+			mutex.Lock()
+			processed = append(processed, filename)
+			mutex.Unlock()
+
+			if filename == "svc.yaml" {
+				return "", pkgerrors.New("create service failed")
+			}
+			return "uuid-" + filename, nil
+		})
+		if err == nil || err.Error() != "create service failed" {
+			t.Fatalf("TestApplyResources returned an unexpected error (%v)", err)
+		}
+
+		for _, filename := range processed {
+			if filename == "deploy.yaml" || filename == "job.yaml" {
+				t.Fatalf("TestApplyResources processed %s after a failure", filename)
+			}
+		}
+
+		for _, object := range ordered {
+			if object.resourceType != "configmap" {
+				t.Fatalf("TestApplyResources returned an unexpected object (%v)", object)
+			}
+		}
+	})
+}
+
+func TestDeletionTasks(t *testing.T) {
+	data := map[string][]string{
+		"deployment": []string{"uuid-deploy"},
+		"service":    []string{"uuid-svc"},
+		"configmap":  []string{"uuid-config"},
+	}
+	dependencies := ResourceDependencies{
+		"deployment/uuid-deploy": []string{"configmap/uuid-config", "service/uuid-svc"},
+		"service/uuid-svc":       []string{"deployment/uuid-unknown"},
+	}
+
+	t.Run("Delete dependents first", func(t *testing.T) {
+		objects, requirements := deletionTasks(data, dependencies)
+
+		var order []string
+		errs := runTasks(requirements, 1, func(i int) error {
+			order = append(order, objects[i].key())
+			return nil
+		})
+		if err := firstError(errs); err != nil {
+			t.Fatalf("TestDeletionTasks returned an error (%s)", err)
+		}
+
+		expected := []string{"deployment/uuid-deploy", "configmap/uuid-config", "service/uuid-svc"}
+		if !reflect.DeepEqual(expected, order) {
+			t.Fatalf("TestDeletionTasks returned:\n result=%v\n expected=%v", order, expected)
+		}
+	})
+}
+
+func writeArchive(archivePath string, files map[string]string) error {
+	archive, err := os.Create(archivePath)
+	if err != nil {
+		return err
+	}
+	defer archive.Close()
+
+	writer := zip.NewWriter(archive)
+	for name, content := range files {
+		file, err := writer.Create(name)
+		if err != nil {
+			return err
+		}
+		_, err = file.Write([]byte(content))
+		if err != nil {
+			return err
+		}
+	}
+	return writer.Close()
+}
+
+func TestOpenPackage(t *testing.T) {
+	oldkrdPluginData := krd.LoadedPlugins
+	oldCsarDir := os.Getenv("CSAR_DIR")
+
+	defer func() {
+		krd.LoadedPlugins = oldkrdPluginData
+		os.Setenv("CSAR_DIR", oldCsarDir)
+	}()
+
+	err := LoadMockPlugins(&krd.LoadedPlugins)
+	if err != nil {
+		t.Fatalf("TestOpenPackage returned an error (%s)", err)
+	}
+
+	csarDir, err := ioutil.TempDir("", "csar")
+	if err != nil {
+		t.Fatalf("TestOpenPackage returned an error (%s)", err)
+	}
+	defer os.RemoveAll(csarDir)
+
+	os.Setenv("CSAR_DIR", csarDir)
+
+	deployment, err := ioutil.ReadFile("./mock_yamls/deployment.yaml")
+	if err != nil {
+		t.Fatalf("TestOpenPackage returned an error (%s)", err)
+	}
+
+	err = writeArchive(csarDir+"/vfw.csar", map[string]string{
+		"TOSCA-Metadata/TOSCA.meta": "TOSCA-Meta-File-Version: 1.0\nCSAR-Version: 1.1\n" +
+			"Created-By: ONAP\nEntry-Definitions: Definitions/MainServiceTemplate.yaml\n",
+		"Definitions/MainServiceTemplate.yaml": "tosca_definitions_version: tosca_simple_yaml_1_0\n",
+		"Definitions/metadata.yaml":            "resources:\n  - deployment:\n    - k8s/deployment.yaml\n",
+		"Definitions/k8s/deployment.yaml":      string(deployment),
+	})
+	if err != nil {
+		t.Fatalf("TestOpenPackage returned an error (%s)", err)
+	}
+
+	err = writeArchive(csarDir+"/invalid.zip", map[string]string{
+		"TOSCA-Metadata/TOSCA.meta": "TOSCA-Meta-File-Version: 1.0\n",
+	})
+	if err != nil {
+		t.Fatalf("TestOpenPackage returned an error (%s)", err)
+	}
+
+	t.Run("Read files of an archive", func(t *testing.T) {
+		csarPackage, err := OpenPackage("vfw")
+		if err != nil {
+			t.Fatalf("TestOpenPackage returned an error (%s)", err)
+		}
+		defer csarPackage.Close()
+
+		seqFile, err := csarPackage.Metadata()
+		if err != nil {
+			t.Fatalf("TestOpenPackage returned an error (%s)", err)
+		}
+
+		if len(seqFile.Resources) != 1 || seqFile.Resources[0].Files[0] != "k8s/deployment.yaml" {
+			t.Fatalf("TestOpenPackage returned unexpected resources (%v)", seqFile.Resources)
+		}
+
+		content, err := csarPackage.ReadFile("k8s/deployment.yaml")
+		if err != nil {
+			t.Fatalf("TestOpenPackage returned an error (%s)", err)
+		}
+
+		if string(content) != string(deployment) {
+			t.Fatalf("TestOpenPackage returned unexpected content (%s)", content)
+		}
+	})
+
+	t.Run("Create VNF from an archive", func(t *testing.T) {
+		kubeclient := kubernetes.Clientset{}
+
+		_, data, _, _, err := CreateVNF("vfw", "cloudregion1", "test", InstanceParameters{}, nil, &kubeclient)
+		if err != nil {
+			t.Fatalf("TestOpenPackage returned an error (%s)", err)
+		}
+
+		if len(data["deployment"]) != 1 {
+			t.Fatalf("TestOpenPackage returned unexpected data (%v)", data)
+		}
+	})
+
+	t.Run("Fail without entry definitions", func(t *testing.T) {
+		_, err := OpenPackage("invalid")
+		if err == nil {
+			t.Fatalf("TestOpenPackage expected an error")
+		}
+	})
+
+	t.Run("Fail with an unknown CSAR", func(t *testing.T) {
+		_, err := OpenPackage("unknown")
+		if err == nil {
+			t.Fatalf("TestOpenPackage expected an error")
+		}
+	})
+}
+
+// signedArchiveFiles returns the files of a CSAR with a manifest listing their
+// digests and the manifest signature made with a key
+func signedArchiveFiles(t *testing.T, files map[string]string, key *rsa.PrivateKey) map[string]string {
+	manifest := "metadata:\n  vnf_product_name: vFW\n  vnf_package_version: 1.0\n\n"
+	for name, content := range files {
+		sum := sha256.Sum256([]byte(content))
+		manifest += "Source: " + name + "\nAlgorithm: SHA-256\nHash: " + hex.EncodeToString(sum[:]) + "\n\n"
+	}
+
+	digest := sha256.Sum256([]byte(manifest))
+	signature, err := rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, digest[:])
+	if err != nil {
+		t.Fatalf("TestVerifyPackage returned an error (%s)", err)
+	}
+
+	signed := map[string]string{
+		"vfw.mf":     manifest,
+		"vfw.mf.sig": base64.StdEncoding.EncodeToString(signature),
+	}
+	for name, content := range files {
+		signed[name] = content
+	}
+	return signed
+}
+
+func TestVerifyPackage(t *testing.T) {
+	oldkrdPluginData := krd.LoadedPlugins
+	oldCsarDir := os.Getenv("CSAR_DIR")
+	oldKeysDir := os.Getenv("CSAR_TRUSTED_KEYS_DIR")
+	oldSignedRegions := os.Getenv("CSAR_SIGNED_REGIONS")
+
+	defer func() {
+		krd.LoadedPlugins = oldkrdPluginData
+		os.Setenv("CSAR_DIR", oldCsarDir)
+		os.Setenv("CSAR_TRUSTED_KEYS_DIR", oldKeysDir)
+		os.Setenv("CSAR_SIGNED_REGIONS", oldSignedRegions)
+	}()
+
+	err := LoadMockPlugins(&krd.LoadedPlugins)
+	if err != nil {
+		t.Fatalf("TestVerifyPackage returned an error (%s)", err)
+	}
+
+	csarDir, err := ioutil.TempDir("", "csar")
+	if err != nil {
+		t.Fatalf("TestVerifyPackage returned an error (%s)", err)
+	}
+	defer os.RemoveAll(csarDir)
+
+	keysDir, err := ioutil.TempDir("", "keys")
+	if err != nil {
+		t.Fatalf("TestVerifyPackage returned an error (%s)", err)
+	}
+	defer os.RemoveAll(keysDir)
+
+	trustedKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("TestVerifyPackage returned an error (%s)", err)
+	}
+	untrustedKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("TestVerifyPackage returned an error (%s)", err)
+	}
+
+	publicKey, err := x509.MarshalPKIXPublicKey(&trustedKey.PublicKey)
+	if err != nil {
+		t.Fatalf("TestVerifyPackage returned an error (%s)", err)
+	}
+	err = ioutil.WriteFile(keysDir+"/vendor.pem",
+		pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: publicKey}), 0644)
+	if err != nil {
+		t.Fatalf("TestVerifyPackage returned an error (%s)", err)
+	}
+
+	os.Setenv("CSAR_DIR", csarDir)
+	os.Setenv("CSAR_TRUSTED_KEYS_DIR", keysDir)
+	os.Setenv("CSAR_SIGNED_REGIONS", "secure1, secure2")
+
+	deployment, err := ioutil.ReadFile("./mock_yamls/deployment.yaml")
+	if err != nil {
+		t.Fatalf("TestVerifyPackage returned an error (%s)", err)
+	}
+
+	files := map[string]string{
+		"TOSCA-Metadata/TOSCA.meta": "TOSCA-Meta-File-Version: 1.0\nCSAR-Version: 1.1\n" +
+			"Created-By: ONAP\nEntry-Definitions: Definitions/MainServiceTemplate.yaml\n",
+		"Definitions/MainServiceTemplate.yaml": "tosca_definitions_version: tosca_simple_yaml_1_0\n",
+		"Definitions/metadata.yaml":            "resources:\n  - deployment:\n    - k8s/deployment.yaml\n",
+		"Definitions/k8s/deployment.yaml":      string(deployment),
+	}
+
+	signed := signedArchiveFiles(t, files, trustedKey)
+
+	tampered := signedArchiveFiles(t, files, trustedKey)
+	tampered["Definitions/k8s/deployment.yaml"] += "  replicas: 10\n"
+
+	unlisted := signedArchiveFiles(t, files, trustedKey)
+	unlisted["Definitions/k8s/extra.yaml"] = "kind: Service\n"
+
+	untrusted := signedArchiveFiles(t, files, untrustedKey)
+
+	for name, content := range map[string]map[string]string{
+		"signed":    signed,
+		"tampered":  tampered,
+		"unlisted":  unlisted,
+		"untrusted": untrusted,
+		"unsigned":  files,
+	} {
+		err = writeArchive(csarDir+"/"+name+".csar", content)
+		if err != nil {
+			t.Fatalf("TestVerifyPackage returned an error (%s)", err)
+		}
+	}
+
+	testCases := []struct {
+		label         string
+		csarID        string
+		cloudRegionID string
+		expectedError string
+	}{
+		{
+			label:         "Accept a signed CSAR",
+			csarID:        "signed",
+			cloudRegionID: "secure1",
+		},
+		{
+			label:         "Reject a CSAR with a modified file",
+			csarID:        "tampered",
+			cloudRegionID: "cloudregion1",
+			expectedError: "Digest of file Definitions/k8s/deployment.yaml does not match manifest vfw.mf",
+		},
+		{
+			label:         "Reject a file missing from the manifest",
+			csarID:        "unlisted",
+			cloudRegionID: "cloudregion1",
+			expectedError: "File Definitions/k8s/extra.yaml is not listed in manifest vfw.mf",
+		},
+		{
+			label:         "Reject a CSAR signed by an untrusted key",
+			csarID:        "untrusted",
+			cloudRegionID: "cloudregion1",
+			expectedError: "CSAR manifest signature does not match any trusted key",
+		},
+		{
+			label:         "Reject an unsigned CSAR in a region requiring signatures",
+			csarID:        "unsigned",
+			cloudRegionID: "secure2",
+			expectedError: "CSAR has no manifest, signed CSARs are required in cloud region secure2",
+		},
+		{
+			label:         "Accept an unsigned CSAR in other regions",
+			csarID:        "unsigned",
+			cloudRegionID: "cloudregion1",
+		},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.label, func(t *testing.T) {
+			csarPackage, err := OpenPackage(testCase.csarID)
+			if err != nil {
+				t.Fatalf("TestVerifyPackage returned an error (%s)", err)
+			}
+			defer csarPackage.Close()
+
+			err = VerifyPackage(csarPackage, testCase.cloudRegionID)
+			if testCase.expectedError == "" {
+				if err != nil {
+					t.Fatalf("TestVerifyPackage returned an error (%s)", err)
+				}
+				return
+			}
+			if err == nil || err.Error() != testCase.expectedError {
+				t.Fatalf("TestVerifyPackage returned an unexpected error (%v)", err)
+			}
+		})
+	}
+
+	t.Run("Create VNF from a tampered CSAR", func(t *testing.T) {
+		kubeclient := kubernetes.Clientset{}
+
+		_, _, _, _, err := CreateVNF("tampered", "secure1", "test", InstanceParameters{}, nil, &kubeclient)
+		if err == nil {
+			t.Fatalf("TestVerifyPackage expected an error")
+		}
+	})
 }
@@ -0,0 +1,171 @@
+/*
+Copyright 2018 Intel Corporation.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package csar
+
+import (
+	"log"
+	"strings"
+	"sync"
+	"time"
+
+	pkgerrors "github.com/pkg/errors"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/kubernetes"
+
+	"k8-plugin-multicloud/krd"
+)
+
+// Readiness states reported for every resource of a VNF
+const (
+	ResourceReady    = "ready"
+	ResourceNotReady = "not_ready"
+	ResourceFailed   = "failed"
+	ResourceSkipped  = "skipped"
+)
+
+// ReadinessRule describes how to wait for the objects of a resource, or for the
+// objects of a given type
+type ReadinessRule struct {
+	// Skip disables the readiness check
+	Skip bool `yaml:"skip"`
+	// Timeout in seconds, overrides the timeout of the request
+	Timeout int `yaml:"timeout"`
+}
+
+// readinessPollInterval is the time between two readiness checks of a resource
+var readinessPollInterval = 5 * time.Second
+
+// WaitForVNF waits until every object of a VNF is ready using the IsReady
+// function of each plugin and the readiness rules of the CSAR metadata. The rule
+// of a resource applies to its objects, the rule of their type otherwise. It
+// returns the readiness state of each object keyed by its type and name, for
+// instance deployment/cloud1-default-uuid-sisedeploy. Objects which did not
+// become ready before the timeout are reported as not ready. Once an object
+// failed, like a failed Job, the VNF is not waited for anymore.
+var WaitForVNF = func(csarID string, objects ResourceObjects, namespace string, timeout time.Duration,
+	kubeclient *kubernetes.Clientset) (map[string]string, error) {
+
+	csarPackage, err := OpenPackage(csarID)
+	if err != nil {
+		return nil, err
+	}
+	defer csarPackage.Close()
+
+	seqFile, err := csarPackage.Metadata()
+	if err != nil {
+		return nil, pkgerrors.Wrap(err, "Error while reading Metadata File of CSAR "+csarID)
+	}
+
+	rules := make(map[string]ReadinessRule)
+	for _, resource := range seqFile.Resources {
+		if resource.Readiness == nil {
+			continue
+		}
+		for _, key := range objects[resource.Name] {
+			rules[key] = *resource.Readiness
+		}
+	}
+
+	var (
+		mutex     sync.Mutex
+		waitGroup sync.WaitGroup
+		// Set once a resource failed, the VNF will not become ready
+		failed bool
+	)
+	readiness := make(map[string]string)
+	waited := make(map[string]bool)
+
+	for _, keys := range objects {
+		for _, key := range keys {
+			if waited[key] {
+				continue
+			}
+			waited[key] = true
+
+			// deployment/cloud1-default-uuid-sisedeploy
+			i := strings.Index(key, "/")
+			resourceType, name := key[:i], key[i+1:]
+
+			rule, ok := rules[key]
+			if !ok {
+				rule = seqFile.Readiness[resourceType]
+			}
+
+			typePlugin, ok := krd.LoadedPlugins[resourceType]
+			if !ok {
+				return nil, pkgerrors.New("No plugin for resource " + resourceType + " found")
+			}
+
+			symIsReadyFunc, err := typePlugin.Lookup("IsReady")
+			if rule.Skip || err != nil {
+				// Plugins without readiness semantics are ready once created
+				mutex.Lock()
+				readiness[key] = ResourceSkipped
+				mutex.Unlock()
+				continue
+			}
+			isReady := symIsReadyFunc.(func(string, string, *kubernetes.Clientset) (bool, error))
+
+			resourceTimeout := timeout
+			if rule.Timeout > 0 {
+				resourceTimeout = time.Duration(rule.Timeout) * time.Second
+			}
+
+			waitGroup.Add(1)
+			go func(key string, name string) {
+				defer waitGroup.Done()
+
+				log.Println("Waiting for resource: " + name)
+				err := wait.PollImmediate(readinessPollInterval, resourceTimeout, func() (bool, error) {
+					mutex.Lock()
+					stop := failed
+					mutex.Unlock()
+					if stop {
+						return false, pkgerrors.New("Another resource of the VNF failed")
+					}
+
+					ready, err := isReady(name, namespace, kubeclient)
+					if err != nil {
+						if _, ok := pkgerrors.Cause(err).(*krd.ResourceFailedError); ok {
+							return false, err
+						}
+						// Transient errors are retried until the timeout
+						log.Println("Readiness check of " + name + " failed: " + err.Error())
+						return false, nil
+					}
+					return ready, nil
+				})
+
+				state := ResourceReady
+				if _, ok := pkgerrors.Cause(err).(*krd.ResourceFailedError); ok {
+					log.Println("Resource " + name + " failed: " + err.Error())
+					state = ResourceFailed
+				} else if err != nil {
+					state = ResourceNotReady
+				}
+
+				mutex.Lock()
+				if state == ResourceFailed {
+					failed = true
+				}
+				readiness[key] = state
+				mutex.Unlock()
+			}(key, name)
+		}
+	}
+
+	waitGroup.Wait()
+
+	return readiness, nil
+}
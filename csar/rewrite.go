@@ -0,0 +1,353 @@
+/*
+Copyright 2018 Intel Corporation.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package csar
+
+import (
+	"bytes"
+	"encoding/json"
+
+	pkgerrors "github.com/pkg/errors"
+	"k8s.io/apimachinery/pkg/util/yaml"
+
+	"k8-plugin-multicloud/krd"
+)
+
+// Several instances of a CSAR can be created in the same namespace. The plugins
+// prefix the name of every object with the internal VNF ID, so the objects are
+// rewritten before being handed to the plugins: the pods of a VNF are labeled
+// with its ID, the selectors of the VNF only match its own pods and the
+// references between the objects of the VNF use their internal names. Objects
+// which are not part of the CSAR keep being referenced by their name. The pods
+// of the workloads are also attached to the networks requested for the VNF.
+
+// vnfNames returns the table of the internal names the plugins create the
+// objects of a VNF with
+func vnfNames(manifests map[string][]manifest, kubedata krd.GenericKubeResourceData) *krd.NameTable {
+	names := krd.NewNameTable()
+
+	for _, objects := range manifests {
+		for _, object := range objects {
+			// cloud1-default-uuid-sisedeploy
+			internalName := kubedata.InternalVNFID + "-" + object.name
+			if object.retain {
+				// Retained volumes outlive their VNF, cloud1-default-sisevolume
+				internalName = kubedata.CloudRegionID + "-" + kubedata.Namespace + "-" + object.name
+			}
+			names.Add(object.kind, object.name, internalName)
+		}
+	}
+
+	return names
+}
+
+// rewriteManifest returns the document of a manifest rewritten for the VNF
+// described by kubedata, as JSON
+func rewriteManifest(object manifest, kubedata *krd.GenericKubeResourceData,
+	networks []NetworkAttachment) ([]byte, error) {
+
+	jsonBytes, err := yaml.ToJSON(object.data)
+	if err != nil {
+		return nil, pkgerrors.Wrap(err, "Invalid YAML document in "+object.source)
+	}
+
+	// Numbers are kept as they are written
+	var document node
+	decoder := json.NewDecoder(bytes.NewReader(jsonBytes))
+	decoder.UseNumber()
+	err = decoder.Decode(&document)
+	if err != nil {
+		return nil, pkgerrors.Wrap(err, "Invalid YAML document in "+object.source)
+	}
+
+	namespace := kubedata.Namespace
+	if namespace == "" {
+		namespace = "default"
+	}
+
+	r := &rewriter{
+		names:     kubedata.Names,
+		namespace: namespace,
+		vnfID:     kubedata.ExternalVNFID,
+		networks:  workloadNetworks(networks, object.name),
+	}
+	r.rewrite(object.kind, document)
+	if r.err != nil {
+		return nil, pkgerrors.Wrap(r.err, "Error rewriting "+object.source)
+	}
+
+	rawBytes, err := json.Marshal(document)
+	if err != nil {
+		return nil, pkgerrors.Wrap(err, "Error rewriting "+object.source)
+	}
+
+	return rawBytes, nil
+}
+
+// node is an object of a decoded document
+type node map[string]interface{}
+
+// child returns the object found at key, or nil
+func (n node) child(key string) node {
+	value, _ := n[key].(map[string]interface{})
+	return value
+}
+
+// children returns the objects of the list found at key
+func (n node) children(key string) []node {
+	list, _ := n[key].([]interface{})
+
+	var result []node
+	for _, item := range list {
+		if value, ok := item.(map[string]interface{}); ok {
+			result = append(result, value)
+		}
+	}
+
+	return result
+}
+
+type rewriter struct {
+	names     *krd.NameTable
+	namespace string
+	vnfID     string
+	// Networks the pods of the object are attached to
+	networks []NetworkAttachment
+	// First error met, the objects are rewritten in place
+	err error
+}
+
+// rewrite rewrites an object of the given kind in place
+func (r *rewriter) rewrite(kind string, object node) {
+	spec := object.child("spec")
+
+	switch kindResourceType(kind) {
+	case "pod":
+		r.label(object.child("metadata"), "labels")
+		r.attach(object.child("metadata"))
+		r.podSpec(spec)
+	case "deployment", "replicaset", "daemonset", "job":
+		r.selector(spec.child("selector"))
+		r.template(spec.child("template"))
+	case "statefulset":
+		r.selector(spec.child("selector"))
+		r.template(spec.child("template"))
+		r.rename(spec, "serviceName", "Service")
+	case "replicationcontroller":
+		r.mark(spec.child("selector"))
+		r.template(spec.child("template"))
+	case "cronjob":
+		jobSpec := spec.child("jobTemplate").child("spec")
+		r.selector(jobSpec.child("selector"))
+		r.template(jobSpec.child("template"))
+	case "service":
+		// Services without selector have their endpoints managed by the VNF
+		if selector := spec.child("selector"); len(selector) > 0 {
+			r.mark(selector)
+		}
+	case "poddisruptionbudget":
+		r.selector(spec.child("selector"))
+	case "networkpolicy":
+		r.selector(spec.child("podSelector"))
+		for _, rule := range spec.children("ingress") {
+			r.peers(rule.children("from"))
+		}
+		for _, rule := range spec.children("egress") {
+			r.peers(rule.children("to"))
+		}
+	case "ingress":
+		r.backend(spec.child("backend"))
+		for _, rule := range spec.children("rules") {
+			for _, path := range rule.child("http").children("paths") {
+				r.backend(path.child("backend"))
+			}
+		}
+		for _, tls := range spec.children("tls") {
+			r.rename(tls, "secretName", "Secret")
+		}
+	case "horizontalpodautoscaler":
+		target := spec.child("scaleTargetRef")
+		if targetKind, ok := target["kind"].(string); ok {
+			r.rename(target, "name", targetKind)
+		}
+	case "rolebinding", "clusterrolebinding":
+		// Role or ClusterRole, built-in ClusterRoles like view are not renamed
+		roleRef := object.child("roleRef")
+		if roleKind, ok := roleRef["kind"].(string); ok {
+			r.rename(roleRef, "name", roleKind)
+		}
+		for _, subject := range object.children("subjects") {
+			r.subject(subject)
+		}
+	}
+}
+
+// rename replaces the name found at key by the internal name of the object of
+// the given kind, when the VNF has one
+func (r *rewriter) rename(object node, key string, kind string) {
+	name, ok := object[key].(string)
+	if !ok {
+		return
+	}
+
+	if internalName := r.names.Lookup(kind, name); internalName != "" {
+		object[key] = internalName
+	}
+}
+
+// mark adds the label of the VNF to a set of labels
+func (r *rewriter) mark(labels node) {
+	if labels != nil {
+		labels[krd.VNFIDLabel] = r.vnfID
+	}
+}
+
+// label adds the label of the VNF to the labels found at key
+func (r *rewriter) label(object node, key string) {
+	if object == nil {
+		return
+	}
+
+	if object.child(key) == nil {
+		object[key] = map[string]interface{}{}
+	}
+	r.mark(object.child(key))
+}
+
+// selector restricts a label selector to the pods of the VNF
+func (r *rewriter) selector(selector node) {
+	r.label(selector, "matchLabels")
+}
+
+// peers restricts the pods allowed by a network policy to the pods of the VNF,
+// unless they are selected in other namespaces
+func (r *rewriter) peers(peers []node) {
+	for _, peer := range peers {
+		if peer.child("namespaceSelector") == nil {
+			r.selector(peer.child("podSelector"))
+		}
+	}
+}
+
+// template labels the pods of a workload and rewrites their references
+func (r *rewriter) template(template node) {
+	if template == nil {
+		return
+	}
+
+	if template.child("metadata") == nil {
+		template["metadata"] = map[string]interface{}{}
+	}
+	r.label(template.child("metadata"), "labels")
+	r.attach(template.child("metadata"))
+	r.podSpec(template.child("spec"))
+}
+
+// attach adds the networks of a workload to the networks annotation of its
+// pods. The networks described in the CSAR are given their internal name.
+func (r *rewriter) attach(metadata node) {
+	if metadata == nil {
+		return
+	}
+
+	value, _ := metadata.child("annotations")[networksAnnotation].(string)
+	if value == "" && len(r.networks) == 0 {
+		return
+	}
+
+	elements, err := parseNetworks(value)
+	if err != nil {
+		r.err = err
+		return
+	}
+	for _, network := range r.networks {
+		elements = append(elements, network.selection())
+	}
+
+	for _, element := range elements {
+		namespace, _ := element["namespace"].(string)
+		if namespace == "" || namespace == r.namespace {
+			r.rename(element, "name", networkKind)
+		}
+	}
+
+	rawBytes, err := json.Marshal(elements)
+	if err != nil {
+		r.err = err
+		return
+	}
+
+	if metadata.child("annotations") == nil {
+		metadata["annotations"] = map[string]interface{}{}
+	}
+	metadata.child("annotations")[networksAnnotation] = string(rawBytes)
+}
+
+// podSpec rewrites the references of a pod to the ServiceAccounts, ConfigMaps,
+// Secrets and PersistentVolumeClaims of the VNF
+func (r *rewriter) podSpec(spec node) {
+	r.rename(spec, "serviceAccountName", "ServiceAccount")
+	r.rename(spec, "serviceAccount", "ServiceAccount")
+
+	for _, secret := range spec.children("imagePullSecrets") {
+		r.rename(secret, "name", "Secret")
+	}
+
+	for _, volume := range spec.children("volumes") {
+		r.rename(volume.child("configMap"), "name", "ConfigMap")
+		r.rename(volume.child("secret"), "secretName", "Secret")
+		r.rename(volume.child("persistentVolumeClaim"), "claimName", "PersistentVolumeClaim")
+		for _, source := range volume.child("projected").children("sources") {
+			r.rename(source.child("configMap"), "name", "ConfigMap")
+			r.rename(source.child("secret"), "name", "Secret")
+		}
+	}
+
+	containers := append(spec.children("initContainers"), spec.children("containers")...)
+	for _, container := range containers {
+		for _, env := range container.children("env") {
+			valueFrom := env.child("valueFrom")
+			r.rename(valueFrom.child("configMapKeyRef"), "name", "ConfigMap")
+			r.rename(valueFrom.child("secretKeyRef"), "name", "Secret")
+		}
+		for _, envFrom := range container.children("envFrom") {
+			r.rename(envFrom.child("configMapRef"), "name", "ConfigMap")
+			r.rename(envFrom.child("secretRef"), "name", "Secret")
+		}
+	}
+}
+
+// backend rewrites the service of an ingress backend, extensions/v1beta1 or
+// networking.k8s.io/v1
+func (r *rewriter) backend(backend node) {
+	r.rename(backend, "serviceName", "Service")
+	r.rename(backend.child("service"), "name", "Service")
+}
+
+// subject rewrites a ServiceAccount subject of a binding in the namespace of the VNF
+func (r *rewriter) subject(subject node) {
+	if subject["kind"] != "ServiceAccount" {
+		return
+	}
+
+	namespace, _ := subject["namespace"].(string)
+	if namespace != "" && namespace != r.namespace {
+		return
+	}
+
+	name, _ := subject["name"].(string)
+	if internalName := r.names.Lookup("ServiceAccount", name); internalName != "" {
+		subject["name"] = internalName
+		subject["namespace"] = r.namespace
+	}
+}
@@ -0,0 +1,69 @@
+/*
+Copyright 2018 Intel Corporation.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package csar
+
+import (
+	"log"
+	"strings"
+
+	"k8s.io/client-go/kubernetes"
+)
+
+// createdResource identifies an object created by a plugin for a VNF
+type createdResource struct {
+	resourceType string
+	name         string
+}
+
+// RollbackError is returned when the creation of a VNF failed and the resources
+// created up to that point were removed. It holds the original error and the
+// errors raised while removing the resources, if any.
+type RollbackError struct {
+	Err            error
+	RollbackErrors []error
+}
+
+func (e *RollbackError) Error() string {
+	if len(e.RollbackErrors) == 0 {
+		return e.Err.Error() + " (rollback succeeded)"
+	}
+
+	var msgs []string
+	for _, err := range e.RollbackErrors {
+		msgs = append(msgs, err.Error())
+	}
+	return e.Err.Error() + " (rollback errors: " + strings.Join(msgs, "; ") + ")"
+}
+
+// Cause returns the error which triggered the rollback
+func (e *RollbackError) Cause() error {
+	return e.Err
+}
+
+// rollbackVNF deletes the created resources in reverse order of creation and
+// returns a RollbackError wrapping the error which caused the rollback
+func rollbackVNF(created []createdResource, namespace string, kubeclient *kubernetes.Clientset, cause error) error {
+	rerr := &RollbackError{Err: cause}
+
+	for i := len(created) - 1; i >= 0; i-- {
+		log.Println("Rolling back resource: " + created[i].name)
+
+		err := deleteResource(created[i], namespace, nil, kubeclient)
+		if err != nil {
+			rerr.RollbackErrors = append(rerr.RollbackErrors, err)
+		}
+	}
+
+	return rerr
+}
@@ -0,0 +1,71 @@
+/*
+Copyright 2018 Intel Corporation.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package csar
+
+import (
+	pkgerrors "github.com/pkg/errors"
+	"k8s.io/client-go/kubernetes"
+
+	"k8-plugin-multicloud/krd"
+)
+
+// Aggregated states of a VNF
+const (
+	// VNFReady means every component is present and ready
+	VNFReady = "ready"
+	// VNFNotReady means every component is present but some are not ready
+	VNFNotReady = "not_ready"
+	// VNFDegraded means some components are missing from the cluster
+	VNFDegraded = "degraded"
+)
+
+// GetVNFStatus fetches the live state of every component of a VNF from the
+// cluster and aggregates it into a VNF state
+var GetVNFStatus = func(data map[string][]string, namespace string,
+	kubeclient *kubernetes.Clientset) (string, map[string][]krd.ResourceStatus, error) {
+
+	state := VNFReady
+	components := make(map[string][]krd.ResourceStatus)
+
+	for resourceType, resourceList := range data {
+		typePlugin, ok := krd.LoadedPlugins[resourceType]
+		if !ok {
+			return "", nil, pkgerrors.New("No plugin for resource " + resourceType + " found")
+		}
+
+		symGetStatusFunc, err := typePlugin.Lookup("GetResourceStatus")
+		if err != nil {
+			return "", nil, pkgerrors.Wrap(err, "Error fetching "+resourceType+" plugin")
+		}
+
+		for _, name := range resourceList {
+			status, err := symGetStatusFunc.(func(string, string, *kubernetes.Clientset) (*krd.ResourceStatus, error))(
+				name, namespace, kubeclient)
+			if err != nil {
+				return "", nil, pkgerrors.Wrap(err, "Error getting status of "+name)
+			}
+
+			switch {
+			case !status.Present:
+				state = VNFDegraded
+			case !status.Ready && state == VNFReady:
+				state = VNFNotReady
+			}
+
+			components[resourceType] = append(components[resourceType], *status)
+		}
+	}
+
+	return state, components, nil
+}
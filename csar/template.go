@@ -0,0 +1,327 @@
+/*
+Copyright 2018 Intel Corporation.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package csar
+
+import (
+	"bytes"
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+	"text/template"
+
+	pkgerrors "github.com/pkg/errors"
+)
+
+// Types of the inputs declared in the metadata of a CSAR
+const (
+	inputString  = "string"
+	inputInteger = "integer"
+	inputFloat   = "float"
+	inputBoolean = "boolean"
+	inputList    = "list"
+	inputMap     = "map"
+)
+
+// InputParameter is an input declared in the metadata of a CSAR. The values of
+// sensitive inputs, passwords for instance, are not stored with the VNF.
+type InputParameter struct {
+	Name        string      `yaml:"name"`
+	Type        string      `yaml:"type"`
+	Description string      `yaml:"description"`
+	Default     interface{} `yaml:"default"`
+	Required    bool        `yaml:"required"`
+	Sensitive   bool        `yaml:"sensitive"`
+}
+
+// OAMIPAddress is the management network address of a VNF instance
+type OAMIPAddress struct {
+	ConnectionPoint string `json:"connection_point"`
+	IPAddress       string `json:"ip_address"`
+	WorkloadName    string `json:"workload_name"`
+}
+
+// InstanceParameters are the values given to instantiate a VNF. They are
+// rendered in the manifests of its CSAR.
+type InstanceParameters struct {
+	Inputs    map[string]interface{}   `json:"inputs,omitempty"`
+	OOFParams []map[string]interface{} `json:"oof_parameters,omitempty"`
+	OAMIP     OAMIPAddress             `json:"oam_ip_address"`
+	Networks  []NetworkAttachment      `json:"networks,omitempty"`
+}
+
+// InputError is returned when the inputs given to instantiate a CSAR are
+// missing or do not match the type declared in its metadata
+type InputError struct {
+	CsarID   string
+	Problems []string
+}
+
+func (e *InputError) Error() string {
+	return "Invalid inputs for CSAR " + e.CsarID + ": " + strings.Join(e.Problems, "; ")
+}
+
+// templateValues is the data the manifests of a CSAR are rendered with. The
+// OOF parameters and the namespace may be empty, when validating a CSAR for instance.
+//
+//	replicas: {{ .Values.replicas }}
+//	vnfHostName: {{ range .OOFParams }}{{ .vnfHostName }}{{ end }}
+//	oamIP: {{ .OAMIP.IPAddress }}
+type templateValues struct {
+	Values    map[string]interface{}
+	OOFParams []map[string]interface{}
+	OAMIP     OAMIPAddress
+	Namespace string
+}
+
+// CheckInputs verifies the inputs given to instantiate a CSAR against the
+// inputs declared in its metadata and returns an *InputError on mismatch
+var CheckInputs = func(csarID string, inputs map[string]interface{}) error {
+	csarPackage, err := OpenPackage(csarID)
+	if err != nil {
+		return err
+	}
+	defer csarPackage.Close()
+
+	seqFile, err := csarPackage.Metadata()
+	if err != nil {
+		return pkgerrors.Wrap(err, "Error while reading Metadata File of CSAR "+csarID)
+	}
+
+	_, problems := resolveInputs(seqFile.Inputs, inputs)
+	if len(problems) > 0 {
+		return &InputError{CsarID: csarID, Problems: problems}
+	}
+
+	return nil
+}
+
+// SensitiveInputs returns the names of the sensitive inputs declared in the
+// metadata of a CSAR
+var SensitiveInputs = func(csarID string) ([]string, error) {
+	csarPackage, err := OpenPackage(csarID)
+	if err != nil {
+		return nil, err
+	}
+	defer csarPackage.Close()
+
+	seqFile, err := csarPackage.Metadata()
+	if err != nil {
+		return nil, pkgerrors.Wrap(err, "Error while reading Metadata File of CSAR "+csarID)
+	}
+
+	var names []string
+	for _, input := range seqFile.Inputs {
+		if input.Sensitive {
+			names = append(names, input.Name)
+		}
+	}
+
+	return names, nil
+}
+
+// newTemplateValues resolves the inputs of a CSAR with the instance parameters
+func newTemplateValues(csarID string, declared []InputParameter, params InstanceParameters) (templateValues, error) {
+	values, problems := resolveInputs(declared, params.Inputs)
+	if len(problems) > 0 {
+		return templateValues{}, &InputError{CsarID: csarID, Problems: problems}
+	}
+
+	return templateValues{
+		Values:    values,
+		OOFParams: params.OOFParams,
+		OAMIP:     params.OAMIP,
+	}, nil
+}
+
+// resolveInputs returns the value of every declared input, taken from the
+// given inputs or from its default, and the problems found. Inputs which are
+// not given and have no default get the zero value of their type.
+func resolveInputs(declared []InputParameter, inputs map[string]interface{}) (map[string]interface{}, []string) {
+	var problems []string
+	values := make(map[string]interface{})
+
+	names := make(map[string]bool)
+	for _, input := range declared {
+		names[input.Name] = true
+
+		value, ok := inputs[input.Name]
+		if !ok || value == nil {
+			value = input.Default
+		}
+
+		if value == nil {
+			if input.Required {
+				problems = append(problems, "Missing required input "+input.Name)
+			}
+			values[input.Name] = zeroInput(input.Type)
+			continue
+		}
+
+		converted, ok := convertInput(input.Type, value)
+		if !ok {
+			problems = append(problems, "Input "+input.Name+" must be of type "+input.Type)
+			continue
+		}
+		values[input.Name] = converted
+	}
+
+	var unknown []string
+	for name := range inputs {
+		if !names[name] {
+			unknown = append(unknown, name)
+		}
+	}
+	sort.Strings(unknown)
+	for _, name := range unknown {
+		problems = append(problems, "Unknown input "+name)
+	}
+
+	return values, problems
+}
+
+// validateInputs returns the problems found in the inputs declared by a CSAR
+func validateInputs(declared []InputParameter) []string {
+	var problems []string
+
+	names := make(map[string]bool)
+	for _, input := range declared {
+		if input.Name == "" {
+			problems = append(problems, "Input of type "+input.Type+" without name found")
+			continue
+		}
+		if names[input.Name] {
+			problems = append(problems, "Duplicated input "+input.Name)
+		}
+		names[input.Name] = true
+
+		if zeroInput(input.Type) == nil {
+			problems = append(problems, "Input "+input.Name+" has an unknown type "+input.Type)
+			continue
+		}
+
+		if input.Default != nil {
+			if _, ok := convertInput(input.Type, input.Default); !ok {
+				problems = append(problems, "Default of input "+input.Name+" must be of type "+input.Type)
+			}
+		}
+	}
+
+	return problems
+}
+
+// zeroInput returns the zero value of an input type, nil for unknown types
+func zeroInput(inputType string) interface{} {
+	switch inputType {
+	case inputString:
+		return ""
+	case inputInteger:
+		return int64(0)
+	case inputFloat:
+		return float64(0)
+	case inputBoolean:
+		return false
+	case inputList:
+		return []interface{}{}
+	case inputMap:
+		return map[string]interface{}{}
+	}
+	return nil
+}
+
+// convertInput checks that a value decoded from JSON or YAML has the given
+// input type and converts it to the type used in templates
+func convertInput(inputType string, value interface{}) (interface{}, bool) {
+	value = normalizeValue(value)
+
+	switch inputType {
+	case inputString:
+		v, ok := value.(string)
+		return v, ok
+	case inputInteger:
+		switch v := value.(type) {
+		case int:
+			return int64(v), true
+		case int64:
+			return v, true
+		case float64:
+			// JSON numbers are decoded as float64
+			if v == math.Trunc(v) && math.Abs(v) < 1<<53 {
+				return int64(v), true
+			}
+		}
+	case inputFloat:
+		switch v := value.(type) {
+		case int:
+			return float64(v), true
+		case int64:
+			return float64(v), true
+		case float64:
+			return v, true
+		}
+	case inputBoolean:
+		v, ok := value.(bool)
+		return v, ok
+	case inputList:
+		v, ok := value.([]interface{})
+		return v, ok
+	case inputMap:
+		v, ok := value.(map[string]interface{})
+		return v, ok
+	}
+	return nil, false
+}
+
+// normalizeValue converts the maps decoded from YAML to maps with string keys,
+// which templates can index by field name
+func normalizeValue(value interface{}) interface{} {
+	switch v := value.(type) {
+	case map[interface{}]interface{}:
+		m := make(map[string]interface{}, len(v))
+		for key, item := range v {
+			m[fmt.Sprint(key)] = normalizeValue(item)
+		}
+		return m
+	case map[string]interface{}:
+		m := make(map[string]interface{}, len(v))
+		for key, item := range v {
+			m[key] = normalizeValue(item)
+		}
+		return m
+	case []interface{}:
+		l := make([]interface{}, len(v))
+		for i, item := range v {
+			l[i] = normalizeValue(item)
+		}
+		return l
+	}
+	return value
+}
+
+// renderTemplate renders a manifest of a CSAR with the instance values.
+// Referencing an undeclared input is an error.
+func renderTemplate(name string, rawBytes []byte, values templateValues) ([]byte, error) {
+	tmpl, err := template.New(name).Option("missingkey=error").Parse(string(rawBytes))
+	if err != nil {
+		return nil, pkgerrors.Wrap(err, "Error parsing template "+name)
+	}
+
+	var out bytes.Buffer
+	err = tmpl.Execute(&out, values)
+	if err != nil {
+		return nil, pkgerrors.Wrap(err, "Error rendering template "+name)
+	}
+
+	return out.Bytes(), nil
+}
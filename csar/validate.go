@@ -0,0 +1,167 @@
+/*
+Copyright 2018 Intel Corporation.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package csar
+
+import (
+	"strings"
+
+	pkgerrors "github.com/pkg/errors"
+
+	"k8-plugin-multicloud/krd"
+)
+
+// ValidationError is returned when a CSAR is not valid. It lists every problem
+// found instead of the first one.
+type ValidationError struct {
+	CsarID   string
+	Problems []string
+}
+
+func (e *ValidationError) Error() string {
+	return "CSAR " + e.CsarID + " is invalid: " + strings.Join(e.Problems, "; ")
+}
+
+// ValidateCSAR checks that a CSAR can be deployed without creating anything:
+// its metadata exists, the dependencies between resources are valid, every
+// referenced file exists, every resource type has a loaded plugin and every
+// manifest decodes to the kind its plugin expects. The digests and signature of
+// the package manifest are verified too. It returns the problems found.
+var ValidateCSAR = func(csarID string) []string {
+	csarPackage, err := OpenPackage(csarID)
+	if err != nil {
+		return []string{err.Error()}
+	}
+	defer csarPackage.Close()
+
+	var problems []string
+
+	// The cloud region is unknown, signatures are only checked when trusted keys are configured
+	err = VerifyPackage(csarPackage, "")
+	if err != nil {
+		problems = append(problems, err.Error())
+	}
+
+	seqFile, err := csarPackage.Metadata()
+	if err != nil {
+		return append(problems, err.Error())
+	}
+
+	// Inputs which are not given get their default or the zero value of their type
+	values, _ := resolveInputs(seqFile.Inputs, nil)
+
+	_, resourceProblems := validatePackage(csarID, csarPackage, seqFile, templateValues{Values: values})
+
+	return append(problems, resourceProblems...)
+}
+
+// validatePackage renders the resources of a package with the given values and
+// returns their manifests by resource name and the problems found
+func validatePackage(csarID string, csarPackage Package, seqFile MetadataFile,
+	values templateValues) (map[string][]manifest, []string) {
+
+	var problems []string
+	manifests := make(map[string][]manifest)
+
+	if len(seqFile.Resources) == 0 {
+		problems = append(problems, "Metadata File describes no resources")
+	}
+
+	problems = append(problems, validateInputs(seqFile.Inputs)...)
+
+	problems = append(problems, validateDependencies(seqFile.Resources)...)
+
+	for _, resource := range seqFile.Resources {
+		objects, errs := renderResource(csarPackage, resource, values)
+		manifests[resource.Name] = objects
+
+		if resource.Type != "" && resource.Type != helmResourceType {
+			if _, ok := krd.LoadedPlugins[pluginType(resource.Type)]; !ok {
+				problems = append(problems, resource.Name+": No plugin for resource "+resource.Type+" found")
+				objects = nil
+			}
+		}
+
+		for _, err := range errs {
+			problems = append(problems, resource.Name+": "+err.Error())
+		}
+
+		for _, object := range objects {
+			err := validateManifest(csarID, csarPackage, object)
+			if err != nil {
+				problems = append(problems, resource.Name+": "+err.Error())
+			}
+		}
+	}
+
+	return manifests, problems
+}
+
+// validateManifest checks a manifest with the ValidateResource function of its
+// plugin. Plugins without ValidateResource only get their files checked.
+func validateManifest(csarID string, csarPackage Package, object manifest) error {
+	typePlugin, ok := krd.LoadedPlugins[object.resourceType]
+	if !ok {
+		return pkgerrors.New("No plugin for resource " + object.resourceType + " of " + object.source + " found")
+	}
+
+	symValidateResourceFunc, err := typePlugin.Lookup("ValidateResource")
+	if err != nil {
+		return nil
+	}
+
+	genericKubeData := &krd.GenericKubeResourceData{
+		YamlFilePath: csarPackage.Path(object.source),
+		YamlData:     object.data,
+		CsarID:       csarID,
+	}
+
+	return symValidateResourceFunc.(func(*krd.GenericKubeResourceData) error)(genericKubeData)
+}
+
+// validateDependencies returns every naming and dependency problem of the
+// resources, the dependency cycles are only looked for when there is none
+func validateDependencies(resources []MetadataResource) []string {
+	var problems []string
+
+	names := make(map[string]bool)
+	for _, resource := range resources {
+		if resource.Name == "" {
+			problems = append(problems, "Resource of type "+resource.Type+" without name found")
+			continue
+		}
+		if names[resource.Name] {
+			problems = append(problems, "Duplicated resource "+resource.Name)
+		}
+		names[resource.Name] = true
+	}
+
+	for _, resource := range resources {
+		for _, dependency := range resource.DependsOn {
+			if !names[dependency] {
+				problems = append(problems, "Resource "+resource.Name+" depends on unknown resource "+dependency)
+			}
+		}
+	}
+
+	if len(problems) > 0 {
+		return problems
+	}
+
+	_, err := resourceLevels(resources)
+	if err != nil {
+		problems = append(problems, err.Error())
+	}
+
+	return problems
+}
@@ -0,0 +1,293 @@
+/*
+Copyright 2018 Intel Corporation.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package csar
+
+import (
+	"bufio"
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/asn1"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	pkgerrors "github.com/pkg/errors"
+)
+
+const (
+	// manifestExtension identifies the SOL004 manifest at the root of a package
+	manifestExtension = ".mf"
+	// signatureExtension is appended to the manifest name to find its detached signature
+	signatureExtension = ".sig"
+	// manifestAlgorithm is the only digest algorithm supported in manifests
+	manifestAlgorithm = "SHA-256"
+)
+
+// verificationRequired tells whether the CSARs deployed in a cloud region must
+// be signed. CSAR_SIGNED_REGIONS lists those regions separated by commas, "*"
+// matches every region.
+func verificationRequired(cloudRegionID string) bool {
+	for _, region := range strings.Split(os.Getenv("CSAR_SIGNED_REGIONS"), ",") {
+		region = strings.TrimSpace(region)
+		if region == "*" || (region != "" && region == cloudRegionID) {
+			return true
+		}
+	}
+	return false
+}
+
+// VerifyPackage checks the integrity and the origin of a CSAR. When the package
+// has a manifest, the SHA-256 digest of every file must match and every file
+// must be listed. In the cloud regions listed in CSAR_SIGNED_REGIONS, the
+// manifest and its detached signature are mandatory and the signature must be
+// made by one of the keys or certificates stored in CSAR_TRUSTED_KEYS_DIR.
+// Elsewhere a signature is only checked when trusted keys are configured.
+var VerifyPackage = func(csarPackage Package, cloudRegionID string) error {
+	required := verificationRequired(cloudRegionID)
+
+	files, err := csarPackage.Files()
+	if err != nil {
+		return err
+	}
+
+	var manifests []string
+	for _, name := range files {
+		if path.Dir(name) == "." && path.Ext(name) == manifestExtension {
+			manifests = append(manifests, name)
+		}
+	}
+
+	if len(manifests) == 0 {
+		if required {
+			return pkgerrors.New("CSAR has no manifest, signed CSARs are required in cloud region " + cloudRegionID)
+		}
+		return nil
+	}
+
+	if len(manifests) > 1 {
+		return pkgerrors.New("CSAR has more than one manifest: " + strings.Join(manifests, ", "))
+	}
+
+	manifestName := manifests[0]
+	signatureName := manifestName + signatureExtension
+
+	manifest, err := csarPackage.ReadPackageFile(manifestName)
+	if err != nil {
+		return pkgerrors.Wrap(err, "Error reading manifest "+manifestName)
+	}
+
+	err = verifyDigests(csarPackage, files, manifestName, signatureName, manifest)
+	if err != nil {
+		return err
+	}
+
+	trustedKeysDir := os.Getenv("CSAR_TRUSTED_KEYS_DIR")
+	if !required && trustedKeysDir == "" {
+		return nil
+	}
+
+	signature, err := csarPackage.ReadPackageFile(signatureName)
+	if err != nil {
+		if required {
+			return pkgerrors.New("CSAR manifest is not signed, signed CSARs are required in cloud region " + cloudRegionID)
+		}
+		return nil
+	}
+
+	keys, err := loadTrustedKeys(trustedKeysDir)
+	if err != nil {
+		return err
+	}
+
+	return verifySignature(manifest, signature, keys)
+}
+
+// verifyDigests checks that every file of the package, besides the manifest and
+// its signature, is listed in the manifest with a matching digest
+func verifyDigests(csarPackage Package, files []string, manifestName string, signatureName string,
+	manifest []byte) error {
+
+	digests, err := parseManifest(manifest)
+	if err != nil {
+		return pkgerrors.Wrap(err, "Invalid manifest "+manifestName)
+	}
+
+	for _, name := range files {
+		if name == manifestName || name == signatureName {
+			continue
+		}
+		if _, ok := digests[name]; !ok {
+			return pkgerrors.New("File " + name + " is not listed in manifest " + manifestName)
+		}
+	}
+
+	for name, digest := range digests {
+		content, err := csarPackage.ReadPackageFile(name)
+		if err != nil {
+			return pkgerrors.New("File " + name + " listed in manifest " + manifestName + " not found")
+		}
+
+		sum := sha256.Sum256(content)
+		if hex.EncodeToString(sum[:]) != digest {
+			return pkgerrors.New("Digest of file " + name + " does not match manifest " + manifestName)
+		}
+	}
+
+	return nil
+}
+
+// parseManifest returns the SHA-256 digests of the Source entries of a manifest
+//
+//	Source: Definitions/MainServiceTemplate.yaml
+//	Algorithm: SHA-256
+//	Hash: 09e5a788acb180162c51679ae4c998039fa6644505db2415e35107d1ee213943
+func parseManifest(manifest []byte) (map[string]string, error) {
+	digests := make(map[string]string)
+	var source, algorithm string
+
+	scanner := bufio.NewScanner(bytes.NewReader(manifest))
+	for scanner.Scan() {
+		line := scanner.Text()
+		// Skip the metadata block, its keys are indented
+		if strings.TrimSpace(line) == "" || strings.HasPrefix(line, " ") || strings.HasPrefix(line, "\t") {
+			continue
+		}
+
+		separator := strings.Index(line, ":")
+		if separator < 1 {
+			continue
+		}
+		key := strings.TrimSpace(line[:separator])
+		value := strings.TrimSpace(line[separator+1:])
+
+		switch key {
+		case "Source":
+			name := path.Clean(value)
+			if path.IsAbs(name) || strings.HasPrefix(name, "../") {
+				return nil, pkgerrors.New("Source " + value + " is outside of the package")
+			}
+			source, algorithm = name, ""
+		case "Algorithm":
+			algorithm = value
+		case "Hash":
+			if source == "" {
+				return nil, pkgerrors.New("Hash without Source")
+			}
+			if algorithm != manifestAlgorithm {
+				return nil, pkgerrors.New("Unsupported algorithm " + algorithm + " for " + source)
+			}
+			digests[source] = strings.ToLower(value)
+			source = ""
+		}
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	return digests, nil
+}
+
+// loadTrustedKeys reads the public keys of the PEM encoded certificates and
+// public keys stored in a directory. Expired certificates are ignored.
+func loadTrustedKeys(dir string) ([]crypto.PublicKey, error) {
+	if dir == "" {
+		return nil, pkgerrors.New("CSAR_TRUSTED_KEYS_DIR is not set")
+	}
+
+	entries, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, pkgerrors.Wrap(err, "Error reading trusted keys")
+	}
+
+	var keys []crypto.PublicKey
+	for _, entry := range entries {
+		if entry.IsDir() {
+			continue
+		}
+
+		rawBytes, err := ioutil.ReadFile(filepath.Join(dir, entry.Name()))
+		if err != nil {
+			return nil, pkgerrors.Wrap(err, "Error reading trusted key "+entry.Name())
+		}
+
+		for block, rest := pem.Decode(rawBytes); block != nil; block, rest = pem.Decode(rest) {
+			switch block.Type {
+			case "CERTIFICATE":
+				cert, err := x509.ParseCertificate(block.Bytes)
+				if err != nil {
+					return nil, pkgerrors.Wrap(err, "Invalid trusted certificate "+entry.Name())
+				}
+				now := time.Now()
+				if now.Before(cert.NotBefore) || now.After(cert.NotAfter) {
+					continue
+				}
+				keys = append(keys, cert.PublicKey)
+			case "PUBLIC KEY":
+				key, err := x509.ParsePKIXPublicKey(block.Bytes)
+				if err != nil {
+					return nil, pkgerrors.Wrap(err, "Invalid trusted public key "+entry.Name())
+				}
+				keys = append(keys, key)
+			}
+		}
+	}
+
+	if len(keys) == 0 {
+		return nil, pkgerrors.New("No trusted keys found in " + dir)
+	}
+
+	return keys, nil
+}
+
+// verifySignature checks that the signature of the manifest, raw or base64
+// encoded, was made by one of the keys with SHA-256 and RSA PKCS #1 v1.5 or ECDSA
+func verifySignature(manifest []byte, signature []byte, keys []crypto.PublicKey) error {
+	// base64 tools wrap their output in lines
+	encoded := strings.Join(strings.Fields(string(signature)), "")
+	if decoded, err := base64.StdEncoding.DecodeString(encoded); err == nil {
+		signature = decoded
+	}
+
+	digest := sha256.Sum256(manifest)
+
+	for _, key := range keys {
+		switch k := key.(type) {
+		case *rsa.PublicKey:
+			if rsa.VerifyPKCS1v15(k, crypto.SHA256, digest[:], signature) == nil {
+				return nil
+			}
+		case *ecdsa.PublicKey:
+			var esig struct {
+				R, S *big.Int
+			}
+			if _, err := asn1.Unmarshal(signature, &esig); err == nil && ecdsa.Verify(k, digest[:], esig.R, esig.S) {
+				return nil
+			}
+		}
+	}
+
+	return pkgerrors.New("CSAR manifest signature does not match any trusted key")
+}
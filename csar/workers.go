@@ -0,0 +1,132 @@
+/*
+Copyright 2018 Intel Corporation.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package csar
+
+import (
+	"os"
+	"strconv"
+
+	pkgerrors "github.com/pkg/errors"
+)
+
+// defaultWorkers is the number of plugin calls run concurrently when
+// CSAR_WORKERS is not set
+const defaultWorkers = 8
+
+// errCancelled is reported for the tasks which were not started because
+// another task failed
+var errCancelled = pkgerrors.New("Cancelled after a previous failure")
+
+// workerCount returns the size of the worker pool used by the VNF lifecycle
+// functions, read from the CSAR_WORKERS environment variable
+func workerCount() int {
+	workers, err := strconv.Atoi(os.Getenv("CSAR_WORKERS"))
+	if err != nil || workers < 1 {
+		return defaultWorkers
+	}
+	return workers
+}
+
+// runTasks runs the tasks on a pool of workers. A task is started once all the
+// tasks listed in its requirements have succeeded, in index order among the
+// tasks which are ready. No task is started after the first failure. It returns
+// the error of every task, nil when it succeeded and errCancelled when it was
+// never started.
+func runTasks(requirements [][]int, workers int, run func(int) error) []error {
+	errs := make([]error, len(requirements))
+	started := make([]bool, len(requirements))
+
+	// Number of requirements of each task which did not succeed yet
+	pending := make([]int, len(requirements))
+	dependents := make([][]int, len(requirements))
+	var ready []int
+	for i, required := range requirements {
+		pending[i] = len(required)
+		for _, j := range required {
+			dependents[j] = append(dependents[j], i)
+		}
+		if pending[i] == 0 {
+			ready = append(ready, i)
+		}
+	}
+
+	done := make(chan int)
+	running := 0
+	failed := false
+
+	for {
+		for !failed && running < workers && len(ready) > 0 {
+			i := ready[0]
+			ready = ready[1:]
+			started[i] = true
+			running++
+			go func(i int) {
+				errs[i] = run(i)
+				done <- i
+			}(i)
+		}
+
+		if running == 0 {
+			break
+		}
+
+		i := <-done
+		running--
+		if errs[i] != nil {
+			failed = true
+			continue
+		}
+
+		var unblocked []int
+		for _, j := range dependents[i] {
+			pending[j]--
+			if pending[j] == 0 {
+				unblocked = append(unblocked, j)
+			}
+		}
+		ready = insertSorted(ready, unblocked)
+	}
+
+	for i := range errs {
+		if !started[i] {
+			errs[i] = errCancelled
+		}
+	}
+
+	return errs
+}
+
+// insertSorted merges the values into the sorted list keeping it sorted
+func insertSorted(list []int, values []int) []int {
+	for _, value := range values {
+		position := len(list)
+		for position > 0 && list[position-1] > value {
+			position--
+		}
+		list = append(list, 0)
+		copy(list[position+1:], list[position:])
+		list[position] = value
+	}
+	return list
+}
+
+// firstError returns the first error of a task which was started
+func firstError(errs []error) error {
+	for _, err := range errs {
+		if err != nil && err != errCancelled {
+			return err
+		}
+	}
+	return nil
+}
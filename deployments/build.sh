@@ -17,7 +17,7 @@ function generate_binary {
     rm -f k8plugin
     rm -f *.so
     $GOPATH/bin/dep ensure -v
-    for plugin in deployment namespace service; do
+    for plugin in deployment namespace service configmap secret statefulset daemonset job cronjob persistentvolumeclaim ingress networkpolicy serviceaccount role rolebinding clusterrole clusterrolebinding horizontalpodautoscaler poddisruptionbudget generic; do
         CGO_ENABLED=1 GOOS=linux GOARCH=amd64 go build -buildmode=plugin -a -tags netgo -o ./$plugin.so ../plugins/$plugin/plugin.go
     done
     CGO_ENABLED=1 GOOS=linux GOARCH=amd64 go build -a -tags netgo -o ./k8plugin ../cmd/main.go
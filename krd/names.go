@@ -0,0 +1,61 @@
+/*
+Copyright 2018 Intel Corporation.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package krd
+
+import (
+	"strings"
+	"sync"
+)
+
+// NameTable maps the objects of a VNF, by kind and name in its CSAR, to their
+// internal names. It is filled before the objects are created, the references
+// between them are rewritten with it whatever the order they are created in.
+type NameTable struct {
+	mutex sync.Mutex
+	names map[string]string
+}
+
+// NewNameTable returns an empty NameTable
+func NewNameTable() *NameTable {
+	return &NameTable{names: make(map[string]string)}
+}
+
+func nameKey(kind string, name string) string {
+	// deployment/sisedeploy
+	return strings.ToLower(kind) + "/" + name
+}
+
+// Add records the internal name of an object, it does nothing on a nil table
+func (t *NameTable) Add(kind string, name string, internalName string) {
+	if t == nil || name == "" {
+		return
+	}
+
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	t.names[nameKey(kind, name)] = internalName
+}
+
+// Lookup returns the internal name of an object of the VNF, or an empty
+// string when there is none. Objects are never found in a nil table,
+// like when a CSAR is only validated.
+func (t *NameTable) Lookup(kind string, name string) string {
+	if t == nil {
+		return ""
+	}
+
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	return t.names[nameKey(kind, name)]
+}
@@ -1,13 +1,38 @@
 package krd
 
 import (
+	"io/ioutil"
+	"os"
 	"plugin"
 
+	pkgerrors "github.com/pkg/errors"
 	appsV1 "k8s.io/api/apps/v1"
+	autoscalingV2beta1 "k8s.io/api/autoscaling/v2beta1"
+	batchV1 "k8s.io/api/batch/v1"
+	batchV1beta1 "k8s.io/api/batch/v1beta1"
 	coreV1 "k8s.io/api/core/v1"
+	extensionsV1beta1 "k8s.io/api/extensions/v1beta1"
+	networkingV1 "k8s.io/api/networking/v1"
+	policyV1beta1 "k8s.io/api/policy/v1beta1"
+	rbacV1 "k8s.io/api/rbac/v1"
+	metaV1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/client-go/kubernetes"
 )
 
+// Labels and annotations stamped by the plugins on every resource they create
+const (
+	VNFIDLabel              = "k8plugin.onap.org/vnf-id"
+	CSARIDLabel             = "k8plugin.onap.org/csar-id"
+	CloudRegionIDLabel      = "k8plugin.onap.org/cloud-region-id"
+	InternalVNFIDAnnotation = "k8plugin.onap.org/internal-vnf-id"
+	// RetainLabel marks the resources kept when their VNF is deleted
+	RetainLabel = "k8plugin.onap.org/retain"
+	// ReleasedAnnotation holds the time a retained resource was released by its VNF
+	ReleasedAnnotation = "k8plugin.onap.org/released-at"
+	// IsolationAnnotation marks the network policy isolating the pods of a VNF
+	IsolationAnnotation = "k8plugin.onap.org/network-isolation"
+)
+
 // LoadedPlugins stores references to the stored plugins
 var LoadedPlugins = map[string]*plugin.Plugin{}
 
@@ -21,11 +46,65 @@ type KubeResourceClient interface {
 
 // GenericKubeResourceData is a struct which stores all supported Kubernetes plugin types
 type GenericKubeResourceData struct {
-	YamlFilePath  string
+	YamlFilePath string
+	// YamlData holds the content of the YAML file when it is not read from YamlFilePath
+	YamlData      []byte
 	Namespace     string
 	InternalVNFID string
+	ExternalVNFID string
+	CloudRegionID string
+	CsarID        string
+	// Retain keeps the resource when its VNF is deleted
+	Retain bool
+	// Names holds the internal names of the objects of the VNF
+	Names *NameTable
 
 	// Add additional Kubernetes plugins below kinds
-	DeploymentData *appsV1.Deployment
-	ServiceData    *coreV1.Service
+	DeploymentData         *appsV1.Deployment
+	ServiceData            *coreV1.Service
+	ConfigMapData          *coreV1.ConfigMap
+	SecretData             *coreV1.Secret
+	StatefulSetData        *appsV1.StatefulSet
+	DaemonSetData          *appsV1.DaemonSet
+	JobData                *batchV1.Job
+	CronJobData            *batchV1beta1.CronJob
+	PVCData                *coreV1.PersistentVolumeClaim
+	IngressData            *extensionsV1beta1.Ingress
+	NetworkPolicyData      *networkingV1.NetworkPolicy
+	ServiceAccountData     *coreV1.ServiceAccount
+	RoleData               *rbacV1.Role
+	RoleBindingData        *rbacV1.RoleBinding
+	ClusterRoleData        *rbacV1.ClusterRole
+	ClusterRoleBindingData *rbacV1.ClusterRoleBinding
+	HPAData                *autoscalingV2beta1.HorizontalPodAutoscaler
+	PDBData                *policyV1beta1.PodDisruptionBudget
+}
+
+// SetOwnership stamps the labels and annotations identifying the VNF which owns a resource
+func SetOwnership(meta *metaV1.ObjectMeta, kubedata *GenericKubeResourceData) {
+	if meta.Labels == nil {
+		meta.Labels = make(map[string]string)
+	}
+	meta.Labels[VNFIDLabel] = kubedata.ExternalVNFID
+	meta.Labels[CSARIDLabel] = kubedata.CsarID
+	meta.Labels[CloudRegionIDLabel] = kubedata.CloudRegionID
+
+	if meta.Annotations == nil {
+		meta.Annotations = make(map[string]string)
+	}
+	meta.Annotations[InternalVNFIDAnnotation] = kubedata.InternalVNFID
+}
+
+// ReadYAML returns the YAML content of a resource, either passed in YamlData
+// or read from YamlFilePath
+func ReadYAML(kubedata *GenericKubeResourceData) ([]byte, error) {
+	if kubedata.YamlData != nil {
+		return kubedata.YamlData, nil
+	}
+
+	if _, err := os.Stat(kubedata.YamlFilePath); err != nil {
+		return nil, pkgerrors.New("File " + kubedata.YamlFilePath + " not found")
+	}
+
+	return ioutil.ReadFile(kubedata.YamlFilePath)
 }
@@ -0,0 +1,25 @@
+/*
+Copyright 2018 Intel Corporation.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package krd
+
+import (
+	"os"
+)
+
+// ClusterRBACAllowed checks if VNFs may create ClusterRoles and
+// ClusterRoleBindings, which grant permissions beyond their namespace. An
+// administrator allows it by setting ALLOW_CLUSTER_RBAC to true.
+func ClusterRBACAllowed() bool {
+	return os.Getenv("ALLOW_CLUSTER_RBAC") == "true"
+}
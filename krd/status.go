@@ -0,0 +1,149 @@
+/*
+Copyright 2018 Intel Corporation.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package krd
+
+import (
+	"bytes"
+	"sort"
+
+	pkgerrors "github.com/pkg/errors"
+	metaV1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+// maxStatusEvents is the number of recent events reported for each resource
+const maxStatusEvents = 5
+
+// ResourceStatus describes the live state of a resource in the cluster as
+// reported by the GetResourceStatus function of a plugin
+type ResourceStatus struct {
+	Name              string      `json:"name"`
+	Present           bool        `json:"present"`
+	Ready             bool        `json:"ready"`
+	Replicas          int32       `json:"replicas,omitempty"`
+	AvailableReplicas int32       `json:"available_replicas,omitempty"`
+	Pods              []PodStatus `json:"pods,omitempty"`
+	ClusterIP         string      `json:"cluster_ip,omitempty"`
+	Ports             []string    `json:"ports,omitempty"`
+	Addresses         []string    `json:"addresses,omitempty"`
+	Events            []string    `json:"events,omitempty"`
+}
+
+// PodStatus contains the phase of a pod backing a resource
+type PodStatus struct {
+	Name  string `json:"name"`
+	Phase string `json:"phase"`
+}
+
+// ResourceDrift describes the differences between the manifest of a resource
+// and its live state as reported by the DiffResource function of a plugin
+type ResourceDrift struct {
+	ResourceType string `json:"resource_type"`
+	Name         string `json:"name"`
+	Missing      bool   `json:"missing"`
+	// Extra marks an object labeled as owned by the VNF which is not in its record
+	Extra       bool     `json:"extra"`
+	Differences []string `json:"differences,omitempty"`
+}
+
+// ResourceFailedError is returned by the IsReady function of a plugin when a
+// resource failed and will never become ready, like a failed Job
+type ResourceFailedError struct {
+	Reason string
+}
+
+func (e *ResourceFailedError) Error() string {
+	return e.Reason
+}
+
+// DiffData compares the data of a ConfigMap or a Secret with its live state.
+// Only the keys are reported, the values may be secret.
+func DiffData(field string, expected map[string][]byte, live map[string][]byte) []string {
+	var keys []string
+	for key := range expected {
+		keys = append(keys, key)
+	}
+	for key := range live {
+		if _, ok := expected[key]; !ok {
+			keys = append(keys, key)
+		}
+	}
+	// Sorted to produce a stable report
+	sort.Strings(keys)
+
+	var differences []string
+	for _, key := range keys {
+		expectedValue, expectedOk := expected[key]
+		liveValue, liveOk := live[key]
+		switch {
+		case !liveOk:
+			differences = append(differences, field+"."+key+": not found")
+		case !expectedOk:
+			differences = append(differences, field+"."+key+": not expected")
+		case !bytes.Equal(expectedValue, liveValue):
+			differences = append(differences, field+"."+key+": content differs")
+		}
+	}
+
+	return differences
+}
+
+// GetPodStatuses returns the phase of the pods matching the selector of a workload
+func GetPodStatuses(labelSelector *metaV1.LabelSelector, namespace string, kubeclient *kubernetes.Clientset) ([]PodStatus, error) {
+	selector, err := metaV1.LabelSelectorAsSelector(labelSelector)
+	if err != nil {
+		return nil, pkgerrors.Wrap(err, "Pod selector error")
+	}
+
+	pods, err := kubeclient.CoreV1().Pods(namespace).List(metaV1.ListOptions{
+		LabelSelector: selector.String(),
+	})
+	if err != nil {
+		return nil, pkgerrors.Wrap(err, "Get Pod list error")
+	}
+
+	var result []PodStatus
+	for _, pod := range pods.Items {
+		result = append(result, PodStatus{
+			Name:  pod.Name,
+			Phase: string(pod.Status.Phase),
+		})
+	}
+
+	return result, nil
+}
+
+// GetRecentEvents returns the most recent events involving a resource
+func GetRecentEvents(name string, kind string, namespace string, kubeclient *kubernetes.Clientset) ([]string, error) {
+	opts := metaV1.ListOptions{
+		FieldSelector: "involvedObject.name=" + name + ",involvedObject.kind=" + kind,
+	}
+
+	list, err := kubeclient.CoreV1().Events(namespace).List(opts)
+	if err != nil {
+		return nil, pkgerrors.Wrap(err, "Get Event list error")
+	}
+
+	events := list.Items
+	sort.Slice(events, func(i, j int) bool {
+		return events[i].LastTimestamp.After(events[j].LastTimestamp.Time)
+	})
+
+	var result []string
+	for i := 0; i < len(events) && i < maxStatusEvents; i++ {
+		result = append(result, events[i].Type+" "+events[i].Reason+": "+events[i].Message)
+	}
+
+	return result, nil
+}
@@ -0,0 +1,234 @@
+package main
+
+import (
+	"log"
+	"reflect"
+
+	"k8s.io/client-go/kubernetes"
+
+	pkgerrors "github.com/pkg/errors"
+
+	rbacV1 "k8s.io/api/rbac/v1"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	metaV1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/scheme"
+
+	"k8-plugin-multicloud/krd"
+)
+
+// readClusterRole reads and decodes the ClusterRole YAML file referenced by
+// kubedata. ClusterRoles are not namespaced, they are only accepted when
+// allowed by the administrator.
+func readClusterRole(kubedata *krd.GenericKubeResourceData) error {
+	if !krd.ClusterRBACAllowed() {
+		return pkgerrors.New("ClusterRole resources are not allowed, see ALLOW_CLUSTER_RBAC")
+	}
+
+	if kubedata.Namespace == "" {
+		kubedata.Namespace = "default"
+	}
+
+	log.Println("Reading clusterrole YAML")
+	rawBytes, err := krd.ReadYAML(kubedata)
+	if err != nil {
+		return pkgerrors.Wrap(err, "ClusterRole YAML file read error")
+	}
+
+	log.Println("Decoding clusterrole YAML")
+	decode := scheme.Codecs.UniversalDeserializer().Decode
+	obj, _, err := decode(rawBytes, nil, nil)
+	if err != nil {
+		return pkgerrors.Wrap(err, "Deserialize clusterrole error")
+	}
+
+	switch o := obj.(type) {
+	case *rbacV1.ClusterRole:
+		kubedata.ClusterRoleData = o
+	default:
+		return pkgerrors.New(kubedata.YamlFilePath + " contains another resource different than ClusterRole")
+	}
+
+	// The internal VNF ID contains the namespace, the name is unique in the cluster
+	kubedata.ClusterRoleData.Namespace = ""
+	kubedata.ClusterRoleData.Name = kubedata.InternalVNFID + "-" + kubedata.ClusterRoleData.Name
+	krd.SetOwnership(&kubedata.ClusterRoleData.ObjectMeta, kubedata)
+
+	return nil
+}
+
+// ValidateResource checks that the YAML file describes a ClusterRole without creating it
+func ValidateResource(kubedata *krd.GenericKubeResourceData) error {
+	return readClusterRole(kubedata)
+}
+
+// CreateResource object in a specific Kubernetes ClusterRole
+func CreateResource(kubedata *krd.GenericKubeResourceData, kubeclient *kubernetes.Clientset) (string, error) {
+	err := readClusterRole(kubedata)
+	if err != nil {
+		return "", err
+	}
+
+	result, err := kubeclient.RbacV1().ClusterRoles().Create(kubedata.ClusterRoleData)
+	if err != nil {
+		return "", pkgerrors.Wrap(err, "Create ClusterRole error")
+	}
+
+	return result.GetObjectMeta().GetName(), nil
+}
+
+// UpdateResource updates an existing Kubernetes ClusterRole in place or creates it
+// when it is not present yet
+func UpdateResource(kubedata *krd.GenericKubeResourceData, kubeclient *kubernetes.Clientset) (string, error) {
+	err := readClusterRole(kubedata)
+	if err != nil {
+		return "", err
+	}
+
+	clusterRoles := kubeclient.RbacV1().ClusterRoles()
+
+	existing, err := clusterRoles.Get(kubedata.ClusterRoleData.Name, metaV1.GetOptions{})
+	if err != nil {
+		if !k8serrors.IsNotFound(err) {
+			return "", pkgerrors.Wrap(err, "Get ClusterRole error")
+		}
+
+		log.Println("Creating clusterrole: " + kubedata.ClusterRoleData.Name)
+		result, err := clusterRoles.Create(kubedata.ClusterRoleData)
+		if err != nil {
+			return "", pkgerrors.Wrap(err, "Create ClusterRole error")
+		}
+		return result.GetObjectMeta().GetName(), nil
+	}
+
+	log.Println("Updating clusterrole: " + kubedata.ClusterRoleData.Name)
+	kubedata.ClusterRoleData.ResourceVersion = existing.ResourceVersion
+
+	result, err := clusterRoles.Update(kubedata.ClusterRoleData)
+	if err != nil {
+		return "", pkgerrors.Wrap(err, "Update ClusterRole error")
+	}
+
+	return result.GetObjectMeta().GetName(), nil
+}
+
+// ListResources of existing clusterroles, they are not hosted in a namespace
+func ListResources(limit int64, namespace string, kubeclient *kubernetes.Clientset) (*[]string, error) {
+	opts := metaV1.ListOptions{
+		Limit: limit,
+	}
+	opts.APIVersion = "rbac.authorization.k8s.io/v1"
+	opts.Kind = "ClusterRole"
+
+	list, err := kubeclient.RbacV1().ClusterRoles().List(opts)
+	if err != nil {
+		return nil, pkgerrors.Wrap(err, "Get ClusterRole list error")
+	}
+
+	result := make([]string, 0, limit)
+	if list != nil {
+		for _, clusterRole := range list.Items {
+			result = append(result, clusterRole.Name)
+		}
+	}
+
+	return &result, nil
+}
+
+// ListOwnedResources returns the metadata of the clusterroles created by the plugin
+func ListOwnedResources(kubeclient *kubernetes.Clientset) ([]metaV1.ObjectMeta, error) {
+	opts := metaV1.ListOptions{
+		LabelSelector: krd.VNFIDLabel,
+	}
+
+	list, err := kubeclient.RbacV1().ClusterRoles().List(opts)
+	if err != nil {
+		return nil, pkgerrors.Wrap(err, "Get ClusterRole list error")
+	}
+
+	var result []metaV1.ObjectMeta
+	for _, item := range list.Items {
+		result = append(result, item.ObjectMeta)
+	}
+
+	return result, nil
+}
+
+// DeleteResource deletes an existing Kubernetes clusterrole, the namespace is ignored
+func DeleteResource(name string, namespace string, kubeclient *kubernetes.Clientset) error {
+	log.Println("Deleting clusterrole: " + name)
+
+	deletePolicy := metaV1.DeletePropagationForeground
+	err := kubeclient.RbacV1().ClusterRoles().Delete(name, &metaV1.DeleteOptions{
+		PropagationPolicy: &deletePolicy,
+	})
+	if err != nil {
+		return pkgerrors.Wrap(err, "Delete ClusterRole error")
+	}
+
+	return nil
+}
+
+// GetResource existing clusterrole, the namespace is ignored
+func GetResource(name string, namespace string, kubeclient *kubernetes.Clientset) (string, error) {
+	_, err := kubeclient.RbacV1().ClusterRoles().Get(name, metaV1.GetOptions{})
+	if err != nil {
+		if k8serrors.IsNotFound(err) {
+			return "", nil
+		}
+		return "", pkgerrors.Wrap(err, "Get ClusterRole error")
+	}
+
+	return name, nil
+}
+
+// IsReady checks if a ClusterRole exists, it is enforced as soon as it is created
+func IsReady(name string, namespace string, kubeclient *kubernetes.Clientset) (bool, error) {
+	found, err := GetResource(name, namespace, kubeclient)
+	if err != nil {
+		return false, err
+	}
+
+	return found != "", nil
+}
+
+// GetResourceStatus returns the live state of a ClusterRole
+func GetResourceStatus(name string, namespace string, kubeclient *kubernetes.Clientset) (*krd.ResourceStatus, error) {
+	status := &krd.ResourceStatus{Name: name}
+
+	found, err := GetResource(name, namespace, kubeclient)
+	if err != nil {
+		return nil, err
+	}
+
+	status.Present = found != ""
+	status.Ready = status.Present
+
+	return status, nil
+}
+
+// DiffResource compares the ClusterRole described in kubedata with the one
+// running in the cluster
+func DiffResource(kubedata *krd.GenericKubeResourceData, kubeclient *kubernetes.Clientset) (*krd.ResourceDrift, error) {
+	err := readClusterRole(kubedata)
+	if err != nil {
+		return nil, err
+	}
+
+	expected := kubedata.ClusterRoleData
+	drift := &krd.ResourceDrift{Name: expected.Name}
+
+	live, err := kubeclient.RbacV1().ClusterRoles().Get(expected.Name, metaV1.GetOptions{})
+	if err != nil {
+		if k8serrors.IsNotFound(err) {
+			drift.Missing = true
+			return drift, nil
+		}
+		return nil, pkgerrors.Wrap(err, "Get ClusterRole error")
+	}
+
+	if !reflect.DeepEqual(expected.Rules, live.Rules) {
+		drift.Differences = append(drift.Differences, "rules: content differs")
+	}
+
+	return drift, nil
+}
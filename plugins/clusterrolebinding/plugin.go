@@ -0,0 +1,240 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"reflect"
+
+	"k8s.io/client-go/kubernetes"
+
+	pkgerrors "github.com/pkg/errors"
+
+	rbacV1 "k8s.io/api/rbac/v1"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	metaV1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/scheme"
+
+	"k8-plugin-multicloud/krd"
+)
+
+// readClusterRoleBinding reads and decodes the ClusterRoleBinding YAML file
+// referenced by kubedata. ClusterRoleBindings are not namespaced, they are only
+// accepted when allowed by the administrator.
+func readClusterRoleBinding(kubedata *krd.GenericKubeResourceData) error {
+	if !krd.ClusterRBACAllowed() {
+		return pkgerrors.New("ClusterRoleBinding resources are not allowed, see ALLOW_CLUSTER_RBAC")
+	}
+
+	if kubedata.Namespace == "" {
+		kubedata.Namespace = "default"
+	}
+
+	log.Println("Reading clusterrolebinding YAML")
+	rawBytes, err := krd.ReadYAML(kubedata)
+	if err != nil {
+		return pkgerrors.Wrap(err, "ClusterRoleBinding YAML file read error")
+	}
+
+	log.Println("Decoding clusterrolebinding YAML")
+	decode := scheme.Codecs.UniversalDeserializer().Decode
+	obj, _, err := decode(rawBytes, nil, nil)
+	if err != nil {
+		return pkgerrors.Wrap(err, "Deserialize clusterrolebinding error")
+	}
+
+	switch o := obj.(type) {
+	case *rbacV1.ClusterRoleBinding:
+		kubedata.ClusterRoleBindingData = o
+	default:
+		return pkgerrors.New(kubedata.YamlFilePath + " contains another resource different than ClusterRoleBinding")
+	}
+
+	// The internal VNF ID contains the namespace, the name is unique in the cluster
+	kubedata.ClusterRoleBindingData.Namespace = ""
+	kubedata.ClusterRoleBindingData.Name = kubedata.InternalVNFID + "-" + kubedata.ClusterRoleBindingData.Name
+	krd.SetOwnership(&kubedata.ClusterRoleBindingData.ObjectMeta, kubedata)
+
+	return nil
+}
+
+// ValidateResource checks that the YAML file describes a ClusterRoleBinding without creating it
+func ValidateResource(kubedata *krd.GenericKubeResourceData) error {
+	return readClusterRoleBinding(kubedata)
+}
+
+// CreateResource object in a specific Kubernetes ClusterRoleBinding
+func CreateResource(kubedata *krd.GenericKubeResourceData, kubeclient *kubernetes.Clientset) (string, error) {
+	err := readClusterRoleBinding(kubedata)
+	if err != nil {
+		return "", err
+	}
+
+	result, err := kubeclient.RbacV1().ClusterRoleBindings().Create(kubedata.ClusterRoleBindingData)
+	if err != nil {
+		return "", pkgerrors.Wrap(err, "Create ClusterRoleBinding error")
+	}
+
+	return result.GetObjectMeta().GetName(), nil
+}
+
+// UpdateResource updates an existing Kubernetes ClusterRoleBinding in place or creates
+// it when it is not present yet. The role of a binding cannot be changed.
+func UpdateResource(kubedata *krd.GenericKubeResourceData, kubeclient *kubernetes.Clientset) (string, error) {
+	err := readClusterRoleBinding(kubedata)
+	if err != nil {
+		return "", err
+	}
+
+	clusterRoleBindings := kubeclient.RbacV1().ClusterRoleBindings()
+
+	existing, err := clusterRoleBindings.Get(kubedata.ClusterRoleBindingData.Name, metaV1.GetOptions{})
+	if err != nil {
+		if !k8serrors.IsNotFound(err) {
+			return "", pkgerrors.Wrap(err, "Get ClusterRoleBinding error")
+		}
+
+		log.Println("Creating clusterrolebinding: " + kubedata.ClusterRoleBindingData.Name)
+		result, err := clusterRoleBindings.Create(kubedata.ClusterRoleBindingData)
+		if err != nil {
+			return "", pkgerrors.Wrap(err, "Create ClusterRoleBinding error")
+		}
+		return result.GetObjectMeta().GetName(), nil
+	}
+
+	log.Println("Updating clusterrolebinding: " + kubedata.ClusterRoleBindingData.Name)
+	kubedata.ClusterRoleBindingData.ResourceVersion = existing.ResourceVersion
+
+	result, err := clusterRoleBindings.Update(kubedata.ClusterRoleBindingData)
+	if err != nil {
+		return "", pkgerrors.Wrap(err, "Update ClusterRoleBinding error")
+	}
+
+	return result.GetObjectMeta().GetName(), nil
+}
+
+// ListResources of existing clusterrolebindings, they are not hosted in a namespace
+func ListResources(limit int64, namespace string, kubeclient *kubernetes.Clientset) (*[]string, error) {
+	opts := metaV1.ListOptions{
+		Limit: limit,
+	}
+	opts.APIVersion = "rbac.authorization.k8s.io/v1"
+	opts.Kind = "ClusterRoleBinding"
+
+	list, err := kubeclient.RbacV1().ClusterRoleBindings().List(opts)
+	if err != nil {
+		return nil, pkgerrors.Wrap(err, "Get ClusterRoleBinding list error")
+	}
+
+	result := make([]string, 0, limit)
+	if list != nil {
+		for _, clusterRoleBinding := range list.Items {
+			result = append(result, clusterRoleBinding.Name)
+		}
+	}
+
+	return &result, nil
+}
+
+// ListOwnedResources returns the metadata of the clusterrolebindings created by the plugin
+func ListOwnedResources(kubeclient *kubernetes.Clientset) ([]metaV1.ObjectMeta, error) {
+	opts := metaV1.ListOptions{
+		LabelSelector: krd.VNFIDLabel,
+	}
+
+	list, err := kubeclient.RbacV1().ClusterRoleBindings().List(opts)
+	if err != nil {
+		return nil, pkgerrors.Wrap(err, "Get ClusterRoleBinding list error")
+	}
+
+	var result []metaV1.ObjectMeta
+	for _, item := range list.Items {
+		result = append(result, item.ObjectMeta)
+	}
+
+	return result, nil
+}
+
+// DeleteResource deletes an existing Kubernetes clusterrolebinding, the namespace is ignored
+func DeleteResource(name string, namespace string, kubeclient *kubernetes.Clientset) error {
+	log.Println("Deleting clusterrolebinding: " + name)
+
+	deletePolicy := metaV1.DeletePropagationForeground
+	err := kubeclient.RbacV1().ClusterRoleBindings().Delete(name, &metaV1.DeleteOptions{
+		PropagationPolicy: &deletePolicy,
+	})
+	if err != nil {
+		return pkgerrors.Wrap(err, "Delete ClusterRoleBinding error")
+	}
+
+	return nil
+}
+
+// GetResource existing clusterrolebinding, the namespace is ignored
+func GetResource(name string, namespace string, kubeclient *kubernetes.Clientset) (string, error) {
+	_, err := kubeclient.RbacV1().ClusterRoleBindings().Get(name, metaV1.GetOptions{})
+	if err != nil {
+		if k8serrors.IsNotFound(err) {
+			return "", nil
+		}
+		return "", pkgerrors.Wrap(err, "Get ClusterRoleBinding error")
+	}
+
+	return name, nil
+}
+
+// IsReady checks if a ClusterRoleBinding exists, it is enforced as soon as it is created
+func IsReady(name string, namespace string, kubeclient *kubernetes.Clientset) (bool, error) {
+	found, err := GetResource(name, namespace, kubeclient)
+	if err != nil {
+		return false, err
+	}
+
+	return found != "", nil
+}
+
+// GetResourceStatus returns the live state of a ClusterRoleBinding
+func GetResourceStatus(name string, namespace string, kubeclient *kubernetes.Clientset) (*krd.ResourceStatus, error) {
+	status := &krd.ResourceStatus{Name: name}
+
+	found, err := GetResource(name, namespace, kubeclient)
+	if err != nil {
+		return nil, err
+	}
+
+	status.Present = found != ""
+	status.Ready = status.Present
+
+	return status, nil
+}
+
+// DiffResource compares the ClusterRoleBinding described in kubedata with the one
+// running in the cluster
+func DiffResource(kubedata *krd.GenericKubeResourceData, kubeclient *kubernetes.Clientset) (*krd.ResourceDrift, error) {
+	err := readClusterRoleBinding(kubedata)
+	if err != nil {
+		return nil, err
+	}
+
+	expected := kubedata.ClusterRoleBindingData
+	drift := &krd.ResourceDrift{Name: expected.Name}
+
+	live, err := kubeclient.RbacV1().ClusterRoleBindings().Get(expected.Name, metaV1.GetOptions{})
+	if err != nil {
+		if k8serrors.IsNotFound(err) {
+			drift.Missing = true
+			return drift, nil
+		}
+		return nil, pkgerrors.Wrap(err, "Get ClusterRoleBinding error")
+	}
+
+	if expected.RoleRef.Kind != live.RoleRef.Kind || expected.RoleRef.Name != live.RoleRef.Name {
+		drift.Differences = append(drift.Differences, fmt.Sprintf("roleRef: expected %s %s, found %s %s",
+			expected.RoleRef.Kind, expected.RoleRef.Name, live.RoleRef.Kind, live.RoleRef.Name))
+	}
+
+	if !reflect.DeepEqual(expected.Subjects, live.Subjects) {
+		drift.Differences = append(drift.Differences, "subjects: content differs")
+	}
+
+	return drift, nil
+}
@@ -0,0 +1,257 @@
+package main
+
+import (
+	"log"
+
+	"k8s.io/client-go/kubernetes"
+
+	pkgerrors "github.com/pkg/errors"
+
+	coreV1 "k8s.io/api/core/v1"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	metaV1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/scheme"
+
+	"k8-plugin-multicloud/krd"
+)
+
+// readConfigMap reads and decodes the ConfigMap YAML file referenced by kubedata
+func readConfigMap(kubedata *krd.GenericKubeResourceData) error {
+	if kubedata.Namespace == "" {
+		kubedata.Namespace = "default"
+	}
+
+	log.Println("Reading configmap YAML")
+	rawBytes, err := krd.ReadYAML(kubedata)
+	if err != nil {
+		return pkgerrors.Wrap(err, "ConfigMap YAML file read error")
+	}
+
+	log.Println("Decoding configmap YAML")
+	decode := scheme.Codecs.UniversalDeserializer().Decode
+	obj, _, err := decode(rawBytes, nil, nil)
+	if err != nil {
+		return pkgerrors.Wrap(err, "Deserialize configmap error")
+	}
+
+	switch o := obj.(type) {
+	case *coreV1.ConfigMap:
+		kubedata.ConfigMapData = o
+	default:
+		return pkgerrors.New(kubedata.YamlFilePath + " contains another resource different than ConfigMap")
+	}
+
+	kubedata.ConfigMapData.Namespace = kubedata.Namespace
+	kubedata.ConfigMapData.Name = kubedata.InternalVNFID + "-" + kubedata.ConfigMapData.Name
+	krd.SetOwnership(&kubedata.ConfigMapData.ObjectMeta, kubedata)
+
+	return nil
+}
+
+// ValidateResource checks that the YAML file describes a ConfigMap without creating it
+func ValidateResource(kubedata *krd.GenericKubeResourceData) error {
+	return readConfigMap(kubedata)
+}
+
+// CreateResource object in a specific Kubernetes ConfigMap
+func CreateResource(kubedata *krd.GenericKubeResourceData, kubeclient *kubernetes.Clientset) (string, error) {
+	err := readConfigMap(kubedata)
+	if err != nil {
+		return "", err
+	}
+
+	result, err := kubeclient.CoreV1().ConfigMaps(kubedata.Namespace).Create(kubedata.ConfigMapData)
+	if err != nil {
+		return "", pkgerrors.Wrap(err, "Create ConfigMap error")
+	}
+
+	return result.GetObjectMeta().GetName(), nil
+}
+
+// UpdateResource updates an existing Kubernetes ConfigMap in place or creates it
+// when it is not present yet
+func UpdateResource(kubedata *krd.GenericKubeResourceData, kubeclient *kubernetes.Clientset) (string, error) {
+	err := readConfigMap(kubedata)
+	if err != nil {
+		return "", err
+	}
+
+	configMaps := kubeclient.CoreV1().ConfigMaps(kubedata.Namespace)
+
+	existing, err := configMaps.Get(kubedata.ConfigMapData.Name, metaV1.GetOptions{})
+	if err != nil {
+		if !k8serrors.IsNotFound(err) {
+			return "", pkgerrors.Wrap(err, "Get ConfigMap error")
+		}
+
+		log.Println("Creating configmap: " + kubedata.ConfigMapData.Name)
+		result, err := configMaps.Create(kubedata.ConfigMapData)
+		if err != nil {
+			return "", pkgerrors.Wrap(err, "Create ConfigMap error")
+		}
+		return result.GetObjectMeta().GetName(), nil
+	}
+
+	log.Println("Updating configmap: " + kubedata.ConfigMapData.Name)
+	kubedata.ConfigMapData.ResourceVersion = existing.ResourceVersion
+
+	result, err := configMaps.Update(kubedata.ConfigMapData)
+	if err != nil {
+		return "", pkgerrors.Wrap(err, "Update ConfigMap error")
+	}
+	return result.GetObjectMeta().GetName(), nil
+}
+
+// ListResources of existing configmaps hosted in a specific Kubernetes namespace
+func ListResources(limit int64, namespace string, kubeclient *kubernetes.Clientset) (*[]string, error) {
+	if namespace == "" {
+		namespace = "default"
+	}
+
+	opts := metaV1.ListOptions{
+		Limit: limit,
+	}
+	opts.APIVersion = "v1"
+	opts.Kind = "ConfigMap"
+
+	list, err := kubeclient.CoreV1().ConfigMaps(namespace).List(opts)
+	if err != nil {
+		return nil, pkgerrors.Wrap(err, "Get ConfigMap list error")
+	}
+
+	result := make([]string, 0, limit)
+	if list != nil {
+		for _, configMap := range list.Items {
+			result = append(result, configMap.Name)
+		}
+	}
+
+	return &result, nil
+}
+
+// ListOwnedResources returns the metadata of the configmaps created by the plugin in all namespaces
+func ListOwnedResources(kubeclient *kubernetes.Clientset) ([]metaV1.ObjectMeta, error) {
+	opts := metaV1.ListOptions{
+		LabelSelector: krd.VNFIDLabel,
+	}
+
+	list, err := kubeclient.CoreV1().ConfigMaps(metaV1.NamespaceAll).List(opts)
+	if err != nil {
+		return nil, pkgerrors.Wrap(err, "Get ConfigMap list error")
+	}
+
+	var result []metaV1.ObjectMeta
+	for _, item := range list.Items {
+		result = append(result, item.ObjectMeta)
+	}
+
+	return result, nil
+}
+
+// DeleteResource deletes an existing Kubernetes configmap
+func DeleteResource(name string, namespace string, kubeclient *kubernetes.Clientset) error {
+	if namespace == "" {
+		namespace = "default"
+	}
+
+	log.Println("Deleting configmap: " + name)
+
+	deletePolicy := metaV1.DeletePropagationForeground
+	err := kubeclient.CoreV1().ConfigMaps(namespace).Delete(name, &metaV1.DeleteOptions{
+		PropagationPolicy: &deletePolicy,
+	})
+	if err != nil {
+		return pkgerrors.Wrap(err, "Delete ConfigMap error")
+	}
+
+	return nil
+}
+
+// GetResource existing configmap hosted in a specific Kubernetes namespace
+func GetResource(name string, namespace string, kubeclient *kubernetes.Clientset) (string, error) {
+	if namespace == "" {
+		namespace = "default"
+	}
+
+	_, err := kubeclient.CoreV1().ConfigMaps(namespace).Get(name, metaV1.GetOptions{})
+	if err != nil {
+		if k8serrors.IsNotFound(err) {
+			return "", nil
+		}
+		return "", pkgerrors.Wrap(err, "Get ConfigMap error")
+	}
+
+	return name, nil
+}
+
+// IsReady checks if a ConfigMap exists, it is usable as soon as it is created
+func IsReady(name string, namespace string, kubeclient *kubernetes.Clientset) (bool, error) {
+	found, err := GetResource(name, namespace, kubeclient)
+	if err != nil {
+		return false, err
+	}
+
+	return found != "", nil
+}
+
+// GetResourceStatus returns the live state of a ConfigMap
+func GetResourceStatus(name string, namespace string, kubeclient *kubernetes.Clientset) (*krd.ResourceStatus, error) {
+	if namespace == "" {
+		namespace = "default"
+	}
+
+	status := &krd.ResourceStatus{Name: name}
+
+	found, err := GetResource(name, namespace, kubeclient)
+	if err != nil {
+		return nil, err
+	}
+	if found == "" {
+		return status, nil
+	}
+
+	status.Present = true
+	status.Ready = true
+
+	status.Events, err = krd.GetRecentEvents(name, "ConfigMap", namespace, kubeclient)
+	if err != nil {
+		return nil, err
+	}
+
+	return status, nil
+}
+
+// DiffResource compares the ConfigMap described in kubedata with the one
+// running in the cluster
+func DiffResource(kubedata *krd.GenericKubeResourceData, kubeclient *kubernetes.Clientset) (*krd.ResourceDrift, error) {
+	err := readConfigMap(kubedata)
+	if err != nil {
+		return nil, err
+	}
+
+	expected := kubedata.ConfigMapData
+	drift := &krd.ResourceDrift{Name: expected.Name}
+
+	live, err := kubeclient.CoreV1().ConfigMaps(kubedata.Namespace).Get(expected.Name, metaV1.GetOptions{})
+	if err != nil {
+		if k8serrors.IsNotFound(err) {
+			drift.Missing = true
+			return drift, nil
+		}
+		return nil, pkgerrors.Wrap(err, "Get ConfigMap error")
+	}
+
+	drift.Differences = append(drift.Differences, krd.DiffData("data", stringData(expected.Data), stringData(live.Data))...)
+	drift.Differences = append(drift.Differences, krd.DiffData("binaryData", expected.BinaryData, live.BinaryData)...)
+
+	return drift, nil
+}
+
+// stringData converts the text data of a ConfigMap to compare it like binary data
+func stringData(data map[string]string) map[string][]byte {
+	result := make(map[string][]byte, len(data))
+	for key, value := range data {
+		result[key] = []byte(value)
+	}
+	return result
+}
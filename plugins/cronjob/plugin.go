@@ -0,0 +1,275 @@
+package main
+
+import (
+	"fmt"
+	"log"
+
+	"k8s.io/client-go/kubernetes"
+
+	pkgerrors "github.com/pkg/errors"
+
+	batchV1beta1 "k8s.io/api/batch/v1beta1"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	metaV1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/scheme"
+
+	"k8-plugin-multicloud/krd"
+)
+
+// readCronJob reads and decodes the CronJob YAML file referenced by kubedata
+func readCronJob(kubedata *krd.GenericKubeResourceData) error {
+	if kubedata.Namespace == "" {
+		kubedata.Namespace = "default"
+	}
+
+	log.Println("Reading cronjob YAML")
+	rawBytes, err := krd.ReadYAML(kubedata)
+	if err != nil {
+		return pkgerrors.Wrap(err, "CronJob YAML file read error")
+	}
+
+	log.Println("Decoding cronjob YAML")
+	decode := scheme.Codecs.UniversalDeserializer().Decode
+	obj, _, err := decode(rawBytes, nil, nil)
+	if err != nil {
+		return pkgerrors.Wrap(err, "Deserialize cronjob error")
+	}
+
+	switch o := obj.(type) {
+	case *batchV1beta1.CronJob:
+		kubedata.CronJobData = o
+	default:
+		return pkgerrors.New(kubedata.YamlFilePath + " contains another resource different than CronJob")
+	}
+
+	kubedata.CronJobData.Namespace = kubedata.Namespace
+	kubedata.CronJobData.Name = kubedata.InternalVNFID + "-" + kubedata.CronJobData.Name
+	krd.SetOwnership(&kubedata.CronJobData.ObjectMeta, kubedata)
+
+	return nil
+}
+
+// ValidateResource checks that the YAML file describes a CronJob without creating it
+func ValidateResource(kubedata *krd.GenericKubeResourceData) error {
+	return readCronJob(kubedata)
+}
+
+// CreateResource object in a specific Kubernetes CronJob
+func CreateResource(kubedata *krd.GenericKubeResourceData, kubeclient *kubernetes.Clientset) (string, error) {
+	err := readCronJob(kubedata)
+	if err != nil {
+		return "", err
+	}
+
+	result, err := kubeclient.BatchV1beta1().CronJobs(kubedata.Namespace).Create(kubedata.CronJobData)
+	if err != nil {
+		return "", pkgerrors.Wrap(err, "Create CronJob error")
+	}
+
+	return result.GetObjectMeta().GetName(), nil
+}
+
+// UpdateResource updates an existing Kubernetes CronJob in place or creates it
+// when it is not present yet
+func UpdateResource(kubedata *krd.GenericKubeResourceData, kubeclient *kubernetes.Clientset) (string, error) {
+	err := readCronJob(kubedata)
+	if err != nil {
+		return "", err
+	}
+
+	cronJobs := kubeclient.BatchV1beta1().CronJobs(kubedata.Namespace)
+
+	existing, err := cronJobs.Get(kubedata.CronJobData.Name, metaV1.GetOptions{})
+	if err != nil {
+		if !k8serrors.IsNotFound(err) {
+			return "", pkgerrors.Wrap(err, "Get CronJob error")
+		}
+
+		log.Println("Creating cronjob: " + kubedata.CronJobData.Name)
+		result, err := cronJobs.Create(kubedata.CronJobData)
+		if err != nil {
+			return "", pkgerrors.Wrap(err, "Create CronJob error")
+		}
+		return result.GetObjectMeta().GetName(), nil
+	}
+
+	log.Println("Updating cronjob: " + kubedata.CronJobData.Name)
+	kubedata.CronJobData.ResourceVersion = existing.ResourceVersion
+
+	result, err := cronJobs.Update(kubedata.CronJobData)
+	if err != nil {
+		return "", pkgerrors.Wrap(err, "Update CronJob error")
+	}
+
+	return result.GetObjectMeta().GetName(), nil
+}
+
+// ListResources of existing cronjobs hosted in a specific Kubernetes namespace
+func ListResources(limit int64, namespace string, kubeclient *kubernetes.Clientset) (*[]string, error) {
+	if namespace == "" {
+		namespace = "default"
+	}
+
+	opts := metaV1.ListOptions{
+		Limit: limit,
+	}
+	opts.APIVersion = "batch/v1beta1"
+	opts.Kind = "CronJob"
+
+	list, err := kubeclient.BatchV1beta1().CronJobs(namespace).List(opts)
+	if err != nil {
+		return nil, pkgerrors.Wrap(err, "Get CronJob list error")
+	}
+
+	result := make([]string, 0, limit)
+	if list != nil {
+		for _, cronJob := range list.Items {
+			result = append(result, cronJob.Name)
+		}
+	}
+
+	return &result, nil
+}
+
+// ListOwnedResources returns the metadata of the cronjobs created by the plugin in all namespaces
+func ListOwnedResources(kubeclient *kubernetes.Clientset) ([]metaV1.ObjectMeta, error) {
+	opts := metaV1.ListOptions{
+		LabelSelector: krd.VNFIDLabel,
+	}
+
+	list, err := kubeclient.BatchV1beta1().CronJobs(metaV1.NamespaceAll).List(opts)
+	if err != nil {
+		return nil, pkgerrors.Wrap(err, "Get CronJob list error")
+	}
+
+	var result []metaV1.ObjectMeta
+	for _, item := range list.Items {
+		result = append(result, item.ObjectMeta)
+	}
+
+	return result, nil
+}
+
+// DeleteResource deletes an existing Kubernetes cronjob with the jobs and pods
+// it created, which the API orphans unless a propagation policy is given
+func DeleteResource(name string, namespace string, kubeclient *kubernetes.Clientset) error {
+	if namespace == "" {
+		namespace = "default"
+	}
+
+	log.Println("Deleting cronjob: " + name)
+
+	deletePolicy := metaV1.DeletePropagationForeground
+	err := kubeclient.BatchV1beta1().CronJobs(namespace).Delete(name, &metaV1.DeleteOptions{
+		PropagationPolicy: &deletePolicy,
+	})
+	if err != nil {
+		return pkgerrors.Wrap(err, "Delete CronJob error")
+	}
+
+	return nil
+}
+
+// GetResource existing cronjob hosted in a specific Kubernetes namespace
+func GetResource(name string, namespace string, kubeclient *kubernetes.Clientset) (string, error) {
+	if namespace == "" {
+		namespace = "default"
+	}
+
+	_, err := kubeclient.BatchV1beta1().CronJobs(namespace).Get(name, metaV1.GetOptions{})
+	if err != nil {
+		if k8serrors.IsNotFound(err) {
+			return "", nil
+		}
+		return "", pkgerrors.Wrap(err, "Get CronJob error")
+	}
+
+	return name, nil
+}
+
+// IsReady checks if a CronJob exists, it is ready as soon as it is scheduled
+func IsReady(name string, namespace string, kubeclient *kubernetes.Clientset) (bool, error) {
+	found, err := GetResource(name, namespace, kubeclient)
+	if err != nil {
+		return false, err
+	}
+
+	return found != "", nil
+}
+
+// GetResourceStatus returns the live state of a CronJob
+func GetResourceStatus(name string, namespace string, kubeclient *kubernetes.Clientset) (*krd.ResourceStatus, error) {
+	if namespace == "" {
+		namespace = "default"
+	}
+
+	status := &krd.ResourceStatus{Name: name}
+
+	cronJob, err := kubeclient.BatchV1beta1().CronJobs(namespace).Get(name, metaV1.GetOptions{})
+	if err != nil {
+		if k8serrors.IsNotFound(err) {
+			return status, nil
+		}
+		return nil, pkgerrors.Wrap(err, "Get CronJob error")
+	}
+
+	status.Present = true
+	status.Ready = true
+	// Jobs running at the moment
+	status.Replicas = int32(len(cronJob.Status.Active))
+
+	status.Events, err = krd.GetRecentEvents(name, "CronJob", namespace, kubeclient)
+	if err != nil {
+		return nil, err
+	}
+
+	return status, nil
+}
+
+// DiffResource compares the CronJob described in kubedata with the one
+// running in the cluster
+func DiffResource(kubedata *krd.GenericKubeResourceData, kubeclient *kubernetes.Clientset) (*krd.ResourceDrift, error) {
+	err := readCronJob(kubedata)
+	if err != nil {
+		return nil, err
+	}
+
+	expected := kubedata.CronJobData
+	drift := &krd.ResourceDrift{Name: expected.Name}
+
+	live, err := kubeclient.BatchV1beta1().CronJobs(kubedata.Namespace).Get(expected.Name, metaV1.GetOptions{})
+	if err != nil {
+		if k8serrors.IsNotFound(err) {
+			drift.Missing = true
+			return drift, nil
+		}
+		return nil, pkgerrors.Wrap(err, "Get CronJob error")
+	}
+
+	if expected.Spec.Schedule != live.Spec.Schedule {
+		drift.Differences = append(drift.Differences, fmt.Sprintf("spec.schedule: expected %s, found %s",
+			expected.Spec.Schedule, live.Spec.Schedule))
+	}
+
+	if isSuspended(expected) != isSuspended(live) {
+		drift.Differences = append(drift.Differences, fmt.Sprintf("spec.suspend: expected %t, found %t",
+			isSuspended(expected), isSuspended(live)))
+	}
+
+	liveImages := make(map[string]string)
+	for _, container := range live.Spec.JobTemplate.Spec.Template.Spec.Containers {
+		liveImages[container.Name] = container.Image
+	}
+	for _, container := range expected.Spec.JobTemplate.Spec.Template.Spec.Containers {
+		if image, ok := liveImages[container.Name]; !ok || image != container.Image {
+			drift.Differences = append(drift.Differences, fmt.Sprintf("container %s image: expected %s, found %s",
+				container.Name, container.Image, image))
+		}
+	}
+
+	return drift, nil
+}
+
+func isSuspended(cronJob *batchV1beta1.CronJob) bool {
+	return cronJob.Spec.Suspend != nil && *cronJob.Spec.Suspend
+}
@@ -0,0 +1,281 @@
+package main
+
+import (
+	"fmt"
+	"log"
+
+	"k8s.io/client-go/kubernetes"
+
+	pkgerrors "github.com/pkg/errors"
+
+	appsV1 "k8s.io/api/apps/v1"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	metaV1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/scheme"
+
+	"k8-plugin-multicloud/krd"
+)
+
+// readDaemonSet reads and decodes the DaemonSet YAML file referenced by kubedata
+func readDaemonSet(kubedata *krd.GenericKubeResourceData) error {
+	if kubedata.Namespace == "" {
+		kubedata.Namespace = "default"
+	}
+
+	log.Println("Reading daemonset YAML")
+	rawBytes, err := krd.ReadYAML(kubedata)
+	if err != nil {
+		return pkgerrors.Wrap(err, "DaemonSet YAML file read error")
+	}
+
+	log.Println("Decoding daemonset YAML")
+	decode := scheme.Codecs.UniversalDeserializer().Decode
+	obj, _, err := decode(rawBytes, nil, nil)
+	if err != nil {
+		return pkgerrors.Wrap(err, "Deserialize daemonset error")
+	}
+
+	switch o := obj.(type) {
+	case *appsV1.DaemonSet:
+		kubedata.DaemonSetData = o
+	default:
+		return pkgerrors.New(kubedata.YamlFilePath + " contains another resource different than DaemonSet")
+	}
+
+	kubedata.DaemonSetData.Namespace = kubedata.Namespace
+	kubedata.DaemonSetData.Name = kubedata.InternalVNFID + "-" + kubedata.DaemonSetData.Name
+	krd.SetOwnership(&kubedata.DaemonSetData.ObjectMeta, kubedata)
+
+	return nil
+}
+
+// ValidateResource checks that the YAML file describes a DaemonSet without creating it
+func ValidateResource(kubedata *krd.GenericKubeResourceData) error {
+	return readDaemonSet(kubedata)
+}
+
+// CreateResource object in a specific Kubernetes DaemonSet
+func CreateResource(kubedata *krd.GenericKubeResourceData, kubeclient *kubernetes.Clientset) (string, error) {
+	err := readDaemonSet(kubedata)
+	if err != nil {
+		return "", err
+	}
+
+	result, err := kubeclient.AppsV1().DaemonSets(kubedata.Namespace).Create(kubedata.DaemonSetData)
+	if err != nil {
+		return "", pkgerrors.Wrap(err, "Create DaemonSet error")
+	}
+
+	return result.GetObjectMeta().GetName(), nil
+}
+
+// UpdateResource updates an existing Kubernetes DaemonSet in place or creates it
+// when it is not present yet
+func UpdateResource(kubedata *krd.GenericKubeResourceData, kubeclient *kubernetes.Clientset) (string, error) {
+	err := readDaemonSet(kubedata)
+	if err != nil {
+		return "", err
+	}
+
+	daemonSets := kubeclient.AppsV1().DaemonSets(kubedata.Namespace)
+
+	existing, err := daemonSets.Get(kubedata.DaemonSetData.Name, metaV1.GetOptions{})
+	if err != nil {
+		if !k8serrors.IsNotFound(err) {
+			return "", pkgerrors.Wrap(err, "Get DaemonSet error")
+		}
+
+		log.Println("Creating daemonset: " + kubedata.DaemonSetData.Name)
+		result, err := daemonSets.Create(kubedata.DaemonSetData)
+		if err != nil {
+			return "", pkgerrors.Wrap(err, "Create DaemonSet error")
+		}
+		return result.GetObjectMeta().GetName(), nil
+	}
+
+	log.Println("Updating daemonset: " + kubedata.DaemonSetData.Name)
+	kubedata.DaemonSetData.ResourceVersion = existing.ResourceVersion
+
+	result, err := daemonSets.Update(kubedata.DaemonSetData)
+	if err != nil {
+		return "", pkgerrors.Wrap(err, "Update DaemonSet error")
+	}
+
+	return result.GetObjectMeta().GetName(), nil
+}
+
+// ListResources of existing daemonsets hosted in a specific Kubernetes namespace
+func ListResources(limit int64, namespace string, kubeclient *kubernetes.Clientset) (*[]string, error) {
+	if namespace == "" {
+		namespace = "default"
+	}
+
+	opts := metaV1.ListOptions{
+		Limit: limit,
+	}
+	opts.APIVersion = "apps/v1"
+	opts.Kind = "DaemonSet"
+
+	list, err := kubeclient.AppsV1().DaemonSets(namespace).List(opts)
+	if err != nil {
+		return nil, pkgerrors.Wrap(err, "Get DaemonSet list error")
+	}
+
+	result := make([]string, 0, limit)
+	if list != nil {
+		for _, daemonSet := range list.Items {
+			result = append(result, daemonSet.Name)
+		}
+	}
+
+	return &result, nil
+}
+
+// ListOwnedResources returns the metadata of the daemonsets created by the plugin in all namespaces
+func ListOwnedResources(kubeclient *kubernetes.Clientset) ([]metaV1.ObjectMeta, error) {
+	opts := metaV1.ListOptions{
+		LabelSelector: krd.VNFIDLabel,
+	}
+
+	list, err := kubeclient.AppsV1().DaemonSets(metaV1.NamespaceAll).List(opts)
+	if err != nil {
+		return nil, pkgerrors.Wrap(err, "Get DaemonSet list error")
+	}
+
+	var result []metaV1.ObjectMeta
+	for _, item := range list.Items {
+		result = append(result, item.ObjectMeta)
+	}
+
+	return result, nil
+}
+
+// DeleteResource deletes an existing Kubernetes daemonset and its pods
+func DeleteResource(name string, namespace string, kubeclient *kubernetes.Clientset) error {
+	if namespace == "" {
+		namespace = "default"
+	}
+
+	log.Println("Deleting daemonset: " + name)
+
+	deletePolicy := metaV1.DeletePropagationForeground
+	err := kubeclient.AppsV1().DaemonSets(namespace).Delete(name, &metaV1.DeleteOptions{
+		PropagationPolicy: &deletePolicy,
+	})
+	if err != nil {
+		return pkgerrors.Wrap(err, "Delete DaemonSet error")
+	}
+
+	return nil
+}
+
+// GetResource existing daemonset hosted in a specific Kubernetes namespace
+func GetResource(name string, namespace string, kubeclient *kubernetes.Clientset) (string, error) {
+	if namespace == "" {
+		namespace = "default"
+	}
+
+	_, err := kubeclient.AppsV1().DaemonSets(namespace).Get(name, metaV1.GetOptions{})
+	if err != nil {
+		if k8serrors.IsNotFound(err) {
+			return "", nil
+		}
+		return "", pkgerrors.Wrap(err, "Get DaemonSet error")
+	}
+
+	return name, nil
+}
+
+// isAvailable checks if a DaemonSet runs its latest spec on every node it is
+// scheduled on and all its pods are ready
+func isAvailable(daemonSet *appsV1.DaemonSet) bool {
+	// Status not updated yet for the latest spec
+	if daemonSet.Status.ObservedGeneration < daemonSet.Generation {
+		return false
+	}
+
+	desired := daemonSet.Status.DesiredNumberScheduled
+	return daemonSet.Status.UpdatedNumberScheduled == desired && daemonSet.Status.NumberReady == desired
+}
+
+// IsReady checks if the pods of a DaemonSet are ready on every node
+func IsReady(name string, namespace string, kubeclient *kubernetes.Clientset) (bool, error) {
+	if namespace == "" {
+		namespace = "default"
+	}
+
+	daemonSet, err := kubeclient.AppsV1().DaemonSets(namespace).Get(name, metaV1.GetOptions{})
+	if err != nil {
+		return false, pkgerrors.Wrap(err, "Get DaemonSet error")
+	}
+
+	return isAvailable(daemonSet), nil
+}
+
+// GetResourceStatus returns the live state of a DaemonSet and its pods
+func GetResourceStatus(name string, namespace string, kubeclient *kubernetes.Clientset) (*krd.ResourceStatus, error) {
+	if namespace == "" {
+		namespace = "default"
+	}
+
+	status := &krd.ResourceStatus{Name: name}
+
+	daemonSet, err := kubeclient.AppsV1().DaemonSets(namespace).Get(name, metaV1.GetOptions{})
+	if err != nil {
+		if k8serrors.IsNotFound(err) {
+			return status, nil
+		}
+		return nil, pkgerrors.Wrap(err, "Get DaemonSet error")
+	}
+
+	status.Present = true
+	status.Ready = isAvailable(daemonSet)
+	status.Replicas = daemonSet.Status.DesiredNumberScheduled
+	status.AvailableReplicas = daemonSet.Status.NumberAvailable
+
+	status.Pods, err = krd.GetPodStatuses(daemonSet.Spec.Selector, namespace, kubeclient)
+	if err != nil {
+		return nil, err
+	}
+
+	status.Events, err = krd.GetRecentEvents(name, "DaemonSet", namespace, kubeclient)
+	if err != nil {
+		return nil, err
+	}
+
+	return status, nil
+}
+
+// DiffResource compares the DaemonSet described in kubedata with the one
+// running in the cluster
+func DiffResource(kubedata *krd.GenericKubeResourceData, kubeclient *kubernetes.Clientset) (*krd.ResourceDrift, error) {
+	err := readDaemonSet(kubedata)
+	if err != nil {
+		return nil, err
+	}
+
+	expected := kubedata.DaemonSetData
+	drift := &krd.ResourceDrift{Name: expected.Name}
+
+	live, err := kubeclient.AppsV1().DaemonSets(kubedata.Namespace).Get(expected.Name, metaV1.GetOptions{})
+	if err != nil {
+		if k8serrors.IsNotFound(err) {
+			drift.Missing = true
+			return drift, nil
+		}
+		return nil, pkgerrors.Wrap(err, "Get DaemonSet error")
+	}
+
+	liveImages := make(map[string]string)
+	for _, container := range live.Spec.Template.Spec.Containers {
+		liveImages[container.Name] = container.Image
+	}
+	for _, container := range expected.Spec.Template.Spec.Containers {
+		if image, ok := liveImages[container.Name]; !ok || image != container.Image {
+			drift.Differences = append(drift.Differences, fmt.Sprintf("container %s image: expected %s, found %s",
+				container.Name, container.Image, image))
+		}
+	}
+
+	return drift, nil
+}
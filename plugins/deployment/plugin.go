@@ -1,53 +1,65 @@
 package main
 
 import (
-	"io/ioutil"
+	"fmt"
 	"log"
-	"os"
 
 	"k8s.io/client-go/kubernetes"
 
 	pkgerrors "github.com/pkg/errors"
 
 	appsV1 "k8s.io/api/apps/v1"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
 	metaV1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/client-go/kubernetes/scheme"
 
 	"k8-plugin-multicloud/krd"
 )
 
-// CreateResource object in a specific Kubernetes Deployment
-func CreateResource(kubedata *krd.GenericKubeResourceData, kubeclient *kubernetes.Clientset) (string, error) {
+// readDeployment reads and decodes the Deployment YAML file referenced by kubedata
+func readDeployment(kubedata *krd.GenericKubeResourceData) error {
 	if kubedata.Namespace == "" {
 		kubedata.Namespace = "default"
 	}
 
-	if _, err := os.Stat(kubedata.YamlFilePath); err != nil {
-		return "", pkgerrors.New("File " + kubedata.YamlFilePath + " not found")
-	}
-
 	log.Println("Reading deployment YAML")
-	rawBytes, err := ioutil.ReadFile(kubedata.YamlFilePath)
+	rawBytes, err := krd.ReadYAML(kubedata)
 	if err != nil {
-		return "", pkgerrors.Wrap(err, "Deployment YAML file read error")
+		return pkgerrors.Wrap(err, "Deployment YAML file read error")
 	}
 
 	log.Println("Decoding deployment YAML")
 	decode := scheme.Codecs.UniversalDeserializer().Decode
 	obj, _, err := decode(rawBytes, nil, nil)
 	if err != nil {
-		return "", pkgerrors.Wrap(err, "Deserialize deployment error")
+		return pkgerrors.Wrap(err, "Deserialize deployment error")
 	}
 
 	switch o := obj.(type) {
 	case *appsV1.Deployment:
 		kubedata.DeploymentData = o
 	default:
-		return "", pkgerrors.New(kubedata.YamlFilePath + " contains another resource different than Deployment")
+		return pkgerrors.New(kubedata.YamlFilePath + " contains another resource different than Deployment")
 	}
 
 	kubedata.DeploymentData.Namespace = kubedata.Namespace
 	kubedata.DeploymentData.Name = kubedata.InternalVNFID + "-" + kubedata.DeploymentData.Name
+	krd.SetOwnership(&kubedata.DeploymentData.ObjectMeta, kubedata)
+
+	return nil
+}
+
+// ValidateResource checks that the YAML file describes a Deployment without creating it
+func ValidateResource(kubedata *krd.GenericKubeResourceData) error {
+	return readDeployment(kubedata)
+}
+
+// CreateResource object in a specific Kubernetes Deployment
+func CreateResource(kubedata *krd.GenericKubeResourceData, kubeclient *kubernetes.Clientset) (string, error) {
+	err := readDeployment(kubedata)
+	if err != nil {
+		return "", err
+	}
 
 	result, err := kubeclient.AppsV1().Deployments(kubedata.Namespace).Create(kubedata.DeploymentData)
 	if err != nil {
@@ -57,6 +69,41 @@ func CreateResource(kubedata *krd.GenericKubeResourceData, kubeclient *kubernete
 	return result.GetObjectMeta().GetName(), nil
 }
 
+// UpdateResource updates an existing Kubernetes Deployment in place or creates it
+// when it is not present yet
+func UpdateResource(kubedata *krd.GenericKubeResourceData, kubeclient *kubernetes.Clientset) (string, error) {
+	err := readDeployment(kubedata)
+	if err != nil {
+		return "", err
+	}
+
+	deployments := kubeclient.AppsV1().Deployments(kubedata.Namespace)
+
+	existing, err := deployments.Get(kubedata.DeploymentData.Name, metaV1.GetOptions{})
+	if err != nil {
+		if !k8serrors.IsNotFound(err) {
+			return "", pkgerrors.Wrap(err, "Get Deployment error")
+		}
+
+		log.Println("Creating deployment: " + kubedata.DeploymentData.Name)
+		result, err := deployments.Create(kubedata.DeploymentData)
+		if err != nil {
+			return "", pkgerrors.Wrap(err, "Create Deployment error")
+		}
+		return result.GetObjectMeta().GetName(), nil
+	}
+
+	log.Println("Updating deployment: " + kubedata.DeploymentData.Name)
+	kubedata.DeploymentData.ResourceVersion = existing.ResourceVersion
+
+	result, err := deployments.Update(kubedata.DeploymentData)
+	if err != nil {
+		return "", pkgerrors.Wrap(err, "Update Deployment error")
+	}
+
+	return result.GetObjectMeta().GetName(), nil
+}
+
 // ListResources of existing deployments hosted in a specific Kubernetes Deployment
 func ListResources(limit int64, namespace string, kubeclient *kubernetes.Clientset) (*[]string, error) {
 	if namespace == "" {
@@ -84,6 +131,25 @@ func ListResources(limit int64, namespace string, kubeclient *kubernetes.Clients
 	return &result, nil
 }
 
+// ListOwnedResources returns the metadata of the deployments created by the plugin in all namespaces
+func ListOwnedResources(kubeclient *kubernetes.Clientset) ([]metaV1.ObjectMeta, error) {
+	opts := metaV1.ListOptions{
+		LabelSelector: krd.VNFIDLabel,
+	}
+
+	list, err := kubeclient.AppsV1().Deployments(metaV1.NamespaceAll).List(opts)
+	if err != nil {
+		return nil, pkgerrors.Wrap(err, "Get Deployment list error")
+	}
+
+	var result []metaV1.ObjectMeta
+	for _, item := range list.Items {
+		result = append(result, item.ObjectMeta)
+	}
+
+	return result, nil
+}
+
 // DeleteResource existing deployments hosting in a specific Kubernetes Deployment
 func DeleteResource(name string, namespace string, kubeclient *kubernetes.Clientset) error {
 	if namespace == "" {
@@ -128,3 +194,107 @@ func GetResource(name string, namespace string, kubeclient *kubernetes.Clientset
 	}
 	return "", nil
 }
+
+// isAvailable checks if all the replicas of the latest spec of a Deployment are available
+func isAvailable(deployment *appsV1.Deployment) bool {
+	// Status not updated yet for the latest spec
+	if deployment.Status.ObservedGeneration < deployment.Generation {
+		return false
+	}
+
+	return deployment.Status.AvailableReplicas == desiredReplicas(deployment)
+}
+
+func desiredReplicas(deployment *appsV1.Deployment) int32 {
+	if deployment.Spec.Replicas != nil {
+		return *deployment.Spec.Replicas
+	}
+	return 1
+}
+
+// IsReady checks if all the replicas of a Deployment are available
+func IsReady(name string, namespace string, kubeclient *kubernetes.Clientset) (bool, error) {
+	if namespace == "" {
+		namespace = "default"
+	}
+
+	deployment, err := kubeclient.AppsV1().Deployments(namespace).Get(name, metaV1.GetOptions{})
+	if err != nil {
+		return false, pkgerrors.Wrap(err, "Get Deployment error")
+	}
+
+	return isAvailable(deployment), nil
+}
+
+// GetResourceStatus returns the live state of a Deployment and its pods
+func GetResourceStatus(name string, namespace string, kubeclient *kubernetes.Clientset) (*krd.ResourceStatus, error) {
+	if namespace == "" {
+		namespace = "default"
+	}
+
+	status := &krd.ResourceStatus{Name: name}
+
+	deployment, err := kubeclient.AppsV1().Deployments(namespace).Get(name, metaV1.GetOptions{})
+	if err != nil {
+		if k8serrors.IsNotFound(err) {
+			return status, nil
+		}
+		return nil, pkgerrors.Wrap(err, "Get Deployment error")
+	}
+
+	status.Present = true
+	status.Ready = isAvailable(deployment)
+	status.Replicas = desiredReplicas(deployment)
+	status.AvailableReplicas = deployment.Status.AvailableReplicas
+
+	status.Pods, err = krd.GetPodStatuses(deployment.Spec.Selector, namespace, kubeclient)
+	if err != nil {
+		return nil, err
+	}
+
+	status.Events, err = krd.GetRecentEvents(name, "Deployment", namespace, kubeclient)
+	if err != nil {
+		return nil, err
+	}
+
+	return status, nil
+}
+
+// DiffResource compares the Deployment described in kubedata with the one
+// running in the cluster
+func DiffResource(kubedata *krd.GenericKubeResourceData, kubeclient *kubernetes.Clientset) (*krd.ResourceDrift, error) {
+	err := readDeployment(kubedata)
+	if err != nil {
+		return nil, err
+	}
+
+	expected := kubedata.DeploymentData
+	drift := &krd.ResourceDrift{Name: expected.Name}
+
+	live, err := kubeclient.AppsV1().Deployments(kubedata.Namespace).Get(expected.Name, metaV1.GetOptions{})
+	if err != nil {
+		if k8serrors.IsNotFound(err) {
+			drift.Missing = true
+			return drift, nil
+		}
+		return nil, pkgerrors.Wrap(err, "Get Deployment error")
+	}
+
+	if desiredReplicas(expected) != desiredReplicas(live) {
+		drift.Differences = append(drift.Differences, fmt.Sprintf("spec.replicas: expected %d, found %d",
+			desiredReplicas(expected), desiredReplicas(live)))
+	}
+
+	liveImages := make(map[string]string)
+	for _, container := range live.Spec.Template.Spec.Containers {
+		liveImages[container.Name] = container.Image
+	}
+	for _, container := range expected.Spec.Template.Spec.Containers {
+		if image, ok := liveImages[container.Name]; !ok || image != container.Image {
+			drift.Differences = append(drift.Differences, fmt.Sprintf("container %s image: expected %s, found %s",
+				container.Name, container.Image, image))
+		}
+	}
+
+	return drift, nil
+}
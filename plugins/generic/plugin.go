@@ -0,0 +1,560 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+
+	pkgerrors "github.com/pkg/errors"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metaV1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/util/yaml"
+	"k8s.io/client-go/discovery"
+	cached "k8s.io/client-go/discovery/cached"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/restmapper"
+
+	"k8-plugin-multicloud/krd"
+)
+
+// The generic plugin handles the kinds without a specific plugin, custom
+// resources included. Objects are sent with the dynamic client to the resource
+// serving their kind, mapped with the discovery API of the cluster. Since the
+// kind of an object can't be derived from its name, the names returned by the
+// plugin are qualified with the kind, version and group of the object:
+//
+//	NetworkAttachmentDefinition.v1.k8s.cni.cncf.io/cloud1-default-uuid-sriov
+
+// objectRef identifies an object handled by the generic plugin
+type objectRef struct {
+	APIVersion string
+	Kind       string
+	Name       string
+}
+
+// String returns the qualified name of the object
+func (r objectRef) String() string {
+	version := r.APIVersion
+	if i := strings.Index(version, "/"); i >= 0 {
+		// k8s.cni.cncf.io/v1 -> v1.k8s.cni.cncf.io
+		version = version[i+1:] + "." + version[:i]
+	}
+	return r.Kind + "." + version + "/" + r.Name
+}
+
+// parseObjectRef reads a qualified name returned by the plugin
+func parseObjectRef(name string) (objectRef, error) {
+	parts := strings.SplitN(name, "/", 2)
+	if len(parts) != 2 {
+		return objectRef{}, pkgerrors.New(name + " is not a qualified name of the generic plugin")
+	}
+
+	gvk := strings.SplitN(parts[0], ".", 3)
+	if len(gvk) < 2 || gvk[0] == "" || gvk[1] == "" {
+		return objectRef{}, pkgerrors.New(name + " is not a qualified name of the generic plugin")
+	}
+
+	ref := objectRef{Kind: gvk[0], APIVersion: gvk[1], Name: parts[1]}
+	if len(gvk) == 3 {
+		ref.APIVersion = gvk[2] + "/" + gvk[1]
+	}
+	return ref, nil
+}
+
+// clusterClient is the dynamic client of a cluster and the mapping of its kinds
+// to resources. The mapping is discovered once, and again when a kind is not
+// found since custom resources can be defined at any time.
+type clusterClient struct {
+	dynamic   dynamic.Interface
+	discovery discovery.CachedDiscoveryInterface
+	mapper    *restmapper.DeferredDiscoveryRESTMapper
+}
+
+var (
+	clientsMutex sync.Mutex
+	// Clients of the clusters by API server URL
+	clients = map[string]*clusterClient{}
+)
+
+// getClient returns the client of the cluster kubeclient is connected to. The
+// plugins are only given a typed client, the dynamic client shares its
+// connection.
+func getClient(kubeclient *kubernetes.Clientset) (*clusterClient, error) {
+	restClient, ok := kubeclient.CoreV1().RESTClient().(*rest.RESTClient)
+	if !ok || restClient.Client == nil {
+		return nil, pkgerrors.New("Kubernetes client without connection")
+	}
+
+	server := restClient.Get().AbsPath("/").URL()
+	host := server.Scheme + "://" + server.Host + strings.TrimSuffix(server.Path, "/")
+
+	clientsMutex.Lock()
+	defer clientsMutex.Unlock()
+
+	if client, ok := clients[host]; ok {
+		return client, nil
+	}
+
+	dynamicClient, err := dynamic.NewForConfig(&rest.Config{
+		Host:      host,
+		Transport: restClient.Client.Transport,
+	})
+	if err != nil {
+		return nil, pkgerrors.Wrap(err, "Create dynamic client error")
+	}
+
+	cachedDiscovery := cached.NewMemCacheClient(kubeclient.Discovery())
+	client := &clusterClient{
+		dynamic:   dynamicClient,
+		discovery: cachedDiscovery,
+		mapper:    restmapper.NewDeferredDiscoveryRESTMapper(cachedDiscovery),
+	}
+	clients[host] = client
+
+	return client, nil
+}
+
+// resource returns the client of the objects of a kind in a namespace
+func (c *clusterClient) resource(ref objectRef, namespace string) (dynamic.ResourceInterface, error) {
+	gv, err := schema.ParseGroupVersion(ref.APIVersion)
+	if err != nil {
+		return nil, pkgerrors.Wrap(err, "Invalid apiVersion "+ref.APIVersion)
+	}
+	gk := schema.GroupKind{Group: gv.Group, Kind: ref.Kind}
+
+	mapping, err := c.mapper.RESTMapping(gk, gv.Version)
+	if meta.IsNoMatchError(err) {
+		// The kind may have been defined since the last discovery
+		c.mapper.Reset()
+		mapping, err = c.mapper.RESTMapping(gk, gv.Version)
+	}
+	if err != nil {
+		return nil, pkgerrors.Wrap(err, "Kind "+ref.Kind+" is not served by "+ref.APIVersion)
+	}
+
+	if mapping.Scope.Name() != meta.RESTScopeNameNamespace {
+		return nil, pkgerrors.New("Kind " + ref.Kind + " is cluster-scoped and can't be created in the namespace of a VNF")
+	}
+
+	return c.dynamic.Resource(mapping.Resource).Namespace(namespace), nil
+}
+
+// listObjects returns the metadata of the objects of every namespaced kind
+// without a specific plugin, with their qualified names. The objects of all
+// namespaces are listed when namespace is empty.
+func listObjects(namespace string, opts metaV1.ListOptions, kubeclient *kubernetes.Clientset) ([]metaV1.ObjectMeta, error) {
+	client, err := getClient(kubeclient)
+	if err != nil {
+		return nil, err
+	}
+
+	lists, err := client.discovery.ServerPreferredResources()
+	if err != nil && len(lists) == 0 {
+		return nil, pkgerrors.Wrap(err, "Discovery error")
+	}
+
+	var result []metaV1.ObjectMeta
+	for _, list := range lists {
+		gv, err := schema.ParseGroupVersion(list.GroupVersion)
+		if err != nil {
+			continue
+		}
+
+		for _, resource := range list.APIResources {
+			if !resource.Namespaced || strings.Contains(resource.Name, "/") || !hasVerbs(resource, "list", "delete") {
+				continue
+			}
+			if _, ok := krd.LoadedPlugins[strings.ToLower(resource.Kind)]; ok {
+				continue
+			}
+
+			items, err := client.dynamic.Resource(gv.WithResource(resource.Name)).Namespace(namespace).List(opts)
+			if err != nil {
+				return nil, pkgerrors.Wrap(err, "Get "+resource.Kind+" list error")
+			}
+
+			for _, item := range items.Items {
+				result = append(result, metaV1.ObjectMeta{
+					Name:              objectRef{APIVersion: list.GroupVersion, Kind: resource.Kind, Name: item.GetName()}.String(),
+					Namespace:         item.GetNamespace(),
+					Labels:            item.GetLabels(),
+					Annotations:       item.GetAnnotations(),
+					CreationTimestamp: item.GetCreationTimestamp(),
+				})
+			}
+		}
+	}
+
+	return result, nil
+}
+
+// readObject reads and decodes the YAML file referenced by kubedata, the object
+// is renamed and stamped like the objects of the other plugins
+func readObject(kubedata *krd.GenericKubeResourceData) (*unstructured.Unstructured, error) {
+	if kubedata.Namespace == "" {
+		kubedata.Namespace = "default"
+	}
+
+	log.Println("Reading generic YAML")
+	rawBytes, err := krd.ReadYAML(kubedata)
+	if err != nil {
+		return nil, pkgerrors.Wrap(err, "Generic YAML file read error")
+	}
+
+	jsonBytes, err := yaml.ToJSON(rawBytes)
+	if err != nil {
+		return nil, pkgerrors.Wrap(err, "Deserialize generic object error")
+	}
+
+	object := &unstructured.Unstructured{}
+	err = object.UnmarshalJSON(jsonBytes)
+	if err != nil {
+		return nil, pkgerrors.Wrap(err, "Deserialize generic object error")
+	}
+
+	if object.GetAPIVersion() == "" || object.GetKind() == "" || object.GetName() == "" {
+		return nil, pkgerrors.New(kubedata.YamlFilePath + " must describe the apiVersion, kind and name of the object")
+	}
+
+	object.SetNamespace(kubedata.Namespace)
+	object.SetName(kubedata.InternalVNFID + "-" + object.GetName())
+
+	meta := metaV1.ObjectMeta{
+		Labels:      object.GetLabels(),
+		Annotations: object.GetAnnotations(),
+	}
+	krd.SetOwnership(&meta, kubedata)
+	object.SetLabels(meta.Labels)
+	object.SetAnnotations(meta.Annotations)
+
+	return object, nil
+}
+
+// getObject returns the live state of an object, nil when it does not exist
+func getObject(ref objectRef, namespace string, kubeclient *kubernetes.Clientset) (*unstructured.Unstructured, error) {
+	client, err := getClient(kubeclient)
+	if err != nil {
+		return nil, err
+	}
+
+	resource, err := client.resource(ref, namespace)
+	if err != nil {
+		return nil, err
+	}
+
+	object, err := resource.Get(ref.Name, metaV1.GetOptions{})
+	if err != nil {
+		if k8serrors.IsNotFound(err) {
+			return nil, nil
+		}
+		return nil, pkgerrors.Wrap(err, "Get "+ref.Kind+" error")
+	}
+
+	return object, nil
+}
+
+// sendObject creates an object or replaces an existing one and returns its
+// qualified name
+func sendObject(object *unstructured.Unstructured, replace bool, kubeclient *kubernetes.Clientset) (string, error) {
+	ref := objectRef{APIVersion: object.GetAPIVersion(), Kind: object.GetKind(), Name: object.GetName()}
+
+	client, err := getClient(kubeclient)
+	if err != nil {
+		return "", err
+	}
+
+	resource, err := client.resource(ref, object.GetNamespace())
+	if err != nil {
+		return "", err
+	}
+
+	if replace {
+		_, err = resource.Update(object)
+	} else {
+		_, err = resource.Create(object)
+	}
+	if err != nil {
+		return "", pkgerrors.Wrap(err, "Send "+ref.Kind+" error")
+	}
+
+	return ref.String(), nil
+}
+
+// ValidateResource checks that the YAML file describes an object without creating it
+func ValidateResource(kubedata *krd.GenericKubeResourceData) error {
+	_, err := readObject(kubedata)
+	return err
+}
+
+// CreateResource object of any kind served by the cluster
+func CreateResource(kubedata *krd.GenericKubeResourceData, kubeclient *kubernetes.Clientset) (string, error) {
+	object, err := readObject(kubedata)
+	if err != nil {
+		return "", err
+	}
+
+	log.Println("Creating " + object.GetKind() + ": " + object.GetName())
+	return sendObject(object, false, kubeclient)
+}
+
+// UpdateResource replaces an existing object in place or creates it when it
+// is not present yet
+func UpdateResource(kubedata *krd.GenericKubeResourceData, kubeclient *kubernetes.Clientset) (string, error) {
+	object, err := readObject(kubedata)
+	if err != nil {
+		return "", err
+	}
+
+	ref := objectRef{APIVersion: object.GetAPIVersion(), Kind: object.GetKind(), Name: object.GetName()}
+	existing, err := getObject(ref, object.GetNamespace(), kubeclient)
+	if err != nil {
+		return "", err
+	}
+
+	if existing == nil {
+		log.Println("Creating " + ref.Kind + ": " + ref.Name)
+		return sendObject(object, false, kubeclient)
+	}
+
+	log.Println("Updating " + ref.Kind + ": " + ref.Name)
+	object.SetResourceVersion(existing.GetResourceVersion())
+	return sendObject(object, true, kubeclient)
+}
+
+// ListResources returns the qualified names of the objects of the kinds without
+// a specific plugin in a namespace
+func ListResources(limit int64, namespace string, kubeclient *kubernetes.Clientset) (*[]string, error) {
+	if namespace == "" {
+		namespace = "default"
+	}
+
+	items, err := listObjects(namespace, metaV1.ListOptions{Limit: limit}, kubeclient)
+	if err != nil {
+		return nil, err
+	}
+
+	result := make([]string, 0, limit)
+	for _, item := range items {
+		if limit > 0 && int64(len(result)) == limit {
+			break
+		}
+		result = append(result, item.Name)
+	}
+
+	return &result, nil
+}
+
+// ListOwnedResources returns the metadata of the objects created by the plugin
+// in all namespaces, for every kind without a specific plugin. The names are
+// qualified like the names returned by CreateResource.
+func ListOwnedResources(kubeclient *kubernetes.Clientset) ([]metaV1.ObjectMeta, error) {
+	opts := metaV1.ListOptions{
+		LabelSelector: krd.VNFIDLabel,
+	}
+
+	return listObjects(metaV1.NamespaceAll, opts, kubeclient)
+}
+
+// hasVerbs checks if a resource supports all the given verbs
+func hasVerbs(resource metaV1.APIResource, verbs ...string) bool {
+	for _, verb := range verbs {
+		found := false
+		for _, supported := range resource.Verbs {
+			if supported == verb {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
+
+// DeleteResource deletes an existing object from its qualified name
+func DeleteResource(name string, namespace string, kubeclient *kubernetes.Clientset) error {
+	if namespace == "" {
+		namespace = "default"
+	}
+
+	ref, err := parseObjectRef(name)
+	if err != nil {
+		return err
+	}
+
+	client, err := getClient(kubeclient)
+	if err != nil {
+		return err
+	}
+
+	resource, err := client.resource(ref, namespace)
+	if err != nil {
+		return err
+	}
+
+	log.Println("Deleting " + ref.Kind + ": " + ref.Name)
+
+	deletePolicy := metaV1.DeletePropagationForeground
+	err = resource.Delete(ref.Name, &metaV1.DeleteOptions{
+		PropagationPolicy: &deletePolicy,
+	})
+	if err != nil {
+		return pkgerrors.Wrap(err, "Delete "+ref.Kind+" error")
+	}
+
+	return nil
+}
+
+// GetResource returns the qualified name of an existing object, or an empty
+// name when it does not exist
+func GetResource(name string, namespace string, kubeclient *kubernetes.Clientset) (string, error) {
+	if namespace == "" {
+		namespace = "default"
+	}
+
+	ref, err := parseObjectRef(name)
+	if err != nil {
+		return "", err
+	}
+
+	object, err := getObject(ref, namespace, kubeclient)
+	if err != nil {
+		return "", err
+	}
+	if object == nil {
+		return "", nil
+	}
+
+	return name, nil
+}
+
+// isReady reads the Ready condition of an object, objects without such
+// condition are ready as soon as they exist
+func isReady(object *unstructured.Unstructured) bool {
+	status, _ := object.Object["status"].(map[string]interface{})
+	conditions, _ := status["conditions"].([]interface{})
+	for _, item := range conditions {
+		condition, _ := item.(map[string]interface{})
+		if condition["type"] == "Ready" {
+			return condition["status"] == "True"
+		}
+	}
+	return true
+}
+
+// IsReady checks if an object exists and reports a Ready condition, when it has one
+func IsReady(name string, namespace string, kubeclient *kubernetes.Clientset) (bool, error) {
+	if namespace == "" {
+		namespace = "default"
+	}
+
+	ref, err := parseObjectRef(name)
+	if err != nil {
+		return false, err
+	}
+
+	object, err := getObject(ref, namespace, kubeclient)
+	if err != nil {
+		return false, err
+	}
+
+	return object != nil && isReady(object), nil
+}
+
+// GetResourceStatus returns the live state of an object
+func GetResourceStatus(name string, namespace string, kubeclient *kubernetes.Clientset) (*krd.ResourceStatus, error) {
+	if namespace == "" {
+		namespace = "default"
+	}
+
+	status := &krd.ResourceStatus{Name: name}
+
+	ref, err := parseObjectRef(name)
+	if err != nil {
+		return nil, err
+	}
+
+	object, err := getObject(ref, namespace, kubeclient)
+	if err != nil {
+		return nil, err
+	}
+	if object == nil {
+		return status, nil
+	}
+
+	status.Present = true
+	status.Ready = isReady(object)
+
+	status.Events, err = krd.GetRecentEvents(ref.Name, ref.Kind, namespace, kubeclient)
+	if err != nil {
+		return nil, err
+	}
+
+	return status, nil
+}
+
+// DiffResource compares the object described in kubedata with the one running
+// in the cluster. Only the fields of the manifest are compared, the fields
+// defaulted by the cluster are ignored.
+func DiffResource(kubedata *krd.GenericKubeResourceData, kubeclient *kubernetes.Clientset) (*krd.ResourceDrift, error) {
+	expected, err := readObject(kubedata)
+	if err != nil {
+		return nil, err
+	}
+
+	ref := objectRef{APIVersion: expected.GetAPIVersion(), Kind: expected.GetKind(), Name: expected.GetName()}
+	drift := &krd.ResourceDrift{Name: ref.String()}
+
+	live, err := getObject(ref, kubedata.Namespace, kubeclient)
+	if err != nil {
+		return nil, err
+	}
+	if live == nil {
+		drift.Missing = true
+		return drift, nil
+	}
+
+	for _, field := range []string{"spec", "data"} {
+		value, ok := expected.Object[field]
+		if !ok {
+			continue
+		}
+		drift.Differences = append(drift.Differences, diffFields(field, value, live.Object[field])...)
+	}
+
+	return drift, nil
+}
+
+// diffFields returns the fields of expected which are missing or different in live
+func diffFields(prefix string, expected interface{}, live interface{}) []string {
+	expectedMap, ok := expected.(map[string]interface{})
+	if !ok {
+		if !reflect.DeepEqual(expected, live) {
+			return []string{fmt.Sprintf("%s: expected %v, found %v", prefix, expected, live)}
+		}
+		return nil
+	}
+
+	// Sorted to produce a stable report
+	var keys []string
+	for key := range expectedMap {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	liveMap, _ := live.(map[string]interface{})
+	var differences []string
+	for _, key := range keys {
+		differences = append(differences, diffFields(prefix+"."+key, expectedMap[key], liveMap[key])...)
+	}
+	return differences
+}
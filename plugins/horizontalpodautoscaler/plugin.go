@@ -0,0 +1,325 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+
+	"k8s.io/client-go/kubernetes"
+
+	pkgerrors "github.com/pkg/errors"
+
+	autoscalingV1 "k8s.io/api/autoscaling/v1"
+	autoscalingV2beta1 "k8s.io/api/autoscaling/v2beta1"
+	coreV1 "k8s.io/api/core/v1"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	metaV1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/scheme"
+
+	"k8-plugin-multicloud/krd"
+)
+
+// The HorizontalPodAutoscalers are handled as autoscaling/v2beta1 objects, which
+// can scale on several metrics. The autoscaling/v1 objects are converted, their
+// target CPU utilization is the only metric.
+
+// convertV1 returns the autoscaling/v2beta1 form of an autoscaling/v1 HorizontalPodAutoscaler
+func convertV1(hpa *autoscalingV1.HorizontalPodAutoscaler) *autoscalingV2beta1.HorizontalPodAutoscaler {
+	result := &autoscalingV2beta1.HorizontalPodAutoscaler{
+		ObjectMeta: hpa.ObjectMeta,
+		Spec: autoscalingV2beta1.HorizontalPodAutoscalerSpec{
+			ScaleTargetRef: autoscalingV2beta1.CrossVersionObjectReference{
+				Kind:       hpa.Spec.ScaleTargetRef.Kind,
+				Name:       hpa.Spec.ScaleTargetRef.Name,
+				APIVersion: hpa.Spec.ScaleTargetRef.APIVersion,
+			},
+			MinReplicas: hpa.Spec.MinReplicas,
+			MaxReplicas: hpa.Spec.MaxReplicas,
+		},
+	}
+
+	if hpa.Spec.TargetCPUUtilizationPercentage != nil {
+		result.Spec.Metrics = []autoscalingV2beta1.MetricSpec{
+			{
+				Type: autoscalingV2beta1.ResourceMetricSourceType,
+				Resource: &autoscalingV2beta1.ResourceMetricSource{
+					Name:                     coreV1.ResourceCPU,
+					TargetAverageUtilization: hpa.Spec.TargetCPUUtilizationPercentage,
+				},
+			},
+		}
+	}
+
+	return result
+}
+
+// readHPA reads and decodes the HorizontalPodAutoscaler YAML file referenced by kubedata
+func readHPA(kubedata *krd.GenericKubeResourceData) error {
+	if kubedata.Namespace == "" {
+		kubedata.Namespace = "default"
+	}
+
+	log.Println("Reading horizontalpodautoscaler YAML")
+	rawBytes, err := krd.ReadYAML(kubedata)
+	if err != nil {
+		return pkgerrors.Wrap(err, "HorizontalPodAutoscaler YAML file read error")
+	}
+
+	log.Println("Decoding horizontalpodautoscaler YAML")
+	decode := scheme.Codecs.UniversalDeserializer().Decode
+	obj, _, err := decode(rawBytes, nil, nil)
+	if err != nil {
+		return pkgerrors.Wrap(err, "Deserialize horizontalpodautoscaler error")
+	}
+
+	switch o := obj.(type) {
+	case *autoscalingV2beta1.HorizontalPodAutoscaler:
+		kubedata.HPAData = o
+	case *autoscalingV1.HorizontalPodAutoscaler:
+		kubedata.HPAData = convertV1(o)
+	default:
+		return pkgerrors.New(kubedata.YamlFilePath + " contains another resource different than HorizontalPodAutoscaler")
+	}
+
+	kubedata.HPAData.Namespace = kubedata.Namespace
+	kubedata.HPAData.Name = kubedata.InternalVNFID + "-" + kubedata.HPAData.Name
+	krd.SetOwnership(&kubedata.HPAData.ObjectMeta, kubedata)
+
+	return nil
+}
+
+// ValidateResource checks that the YAML file describes a HorizontalPodAutoscaler without creating it
+func ValidateResource(kubedata *krd.GenericKubeResourceData) error {
+	return readHPA(kubedata)
+}
+
+// CreateResource object in a specific Kubernetes HorizontalPodAutoscaler
+func CreateResource(kubedata *krd.GenericKubeResourceData, kubeclient *kubernetes.Clientset) (string, error) {
+	err := readHPA(kubedata)
+	if err != nil {
+		return "", err
+	}
+
+	result, err := kubeclient.AutoscalingV2beta1().HorizontalPodAutoscalers(kubedata.Namespace).Create(kubedata.HPAData)
+	if err != nil {
+		return "", pkgerrors.Wrap(err, "Create HorizontalPodAutoscaler error")
+	}
+
+	return result.GetObjectMeta().GetName(), nil
+}
+
+// UpdateResource updates an existing Kubernetes HorizontalPodAutoscaler in place
+// or creates it when it is not present yet
+func UpdateResource(kubedata *krd.GenericKubeResourceData, kubeclient *kubernetes.Clientset) (string, error) {
+	err := readHPA(kubedata)
+	if err != nil {
+		return "", err
+	}
+
+	hpas := kubeclient.AutoscalingV2beta1().HorizontalPodAutoscalers(kubedata.Namespace)
+
+	existing, err := hpas.Get(kubedata.HPAData.Name, metaV1.GetOptions{})
+	if err != nil {
+		if !k8serrors.IsNotFound(err) {
+			return "", pkgerrors.Wrap(err, "Get HorizontalPodAutoscaler error")
+		}
+
+		log.Println("Creating horizontalpodautoscaler: " + kubedata.HPAData.Name)
+		result, err := hpas.Create(kubedata.HPAData)
+		if err != nil {
+			return "", pkgerrors.Wrap(err, "Create HorizontalPodAutoscaler error")
+		}
+		return result.GetObjectMeta().GetName(), nil
+	}
+
+	log.Println("Updating horizontalpodautoscaler: " + kubedata.HPAData.Name)
+	kubedata.HPAData.ResourceVersion = existing.ResourceVersion
+
+	result, err := hpas.Update(kubedata.HPAData)
+	if err != nil {
+		return "", pkgerrors.Wrap(err, "Update HorizontalPodAutoscaler error")
+	}
+
+	return result.GetObjectMeta().GetName(), nil
+}
+
+// ListResources of existing horizontalpodautoscalers hosted in a specific Kubernetes namespace
+func ListResources(limit int64, namespace string, kubeclient *kubernetes.Clientset) (*[]string, error) {
+	if namespace == "" {
+		namespace = "default"
+	}
+
+	opts := metaV1.ListOptions{
+		Limit: limit,
+	}
+	opts.APIVersion = "autoscaling/v2beta1"
+	opts.Kind = "HorizontalPodAutoscaler"
+
+	list, err := kubeclient.AutoscalingV2beta1().HorizontalPodAutoscalers(namespace).List(opts)
+	if err != nil {
+		return nil, pkgerrors.Wrap(err, "Get HorizontalPodAutoscaler list error")
+	}
+
+	result := make([]string, 0, limit)
+	if list != nil {
+		for _, hpa := range list.Items {
+			result = append(result, hpa.Name)
+		}
+	}
+
+	return &result, nil
+}
+
+// ListOwnedResources returns the metadata of the horizontalpodautoscalers created by the plugin in all namespaces
+func ListOwnedResources(kubeclient *kubernetes.Clientset) ([]metaV1.ObjectMeta, error) {
+	opts := metaV1.ListOptions{
+		LabelSelector: krd.VNFIDLabel,
+	}
+
+	list, err := kubeclient.AutoscalingV2beta1().HorizontalPodAutoscalers(metaV1.NamespaceAll).List(opts)
+	if err != nil {
+		return nil, pkgerrors.Wrap(err, "Get HorizontalPodAutoscaler list error")
+	}
+
+	var result []metaV1.ObjectMeta
+	for _, item := range list.Items {
+		result = append(result, item.ObjectMeta)
+	}
+
+	return result, nil
+}
+
+// DeleteResource deletes an existing Kubernetes horizontalpodautoscaler
+func DeleteResource(name string, namespace string, kubeclient *kubernetes.Clientset) error {
+	if namespace == "" {
+		namespace = "default"
+	}
+
+	log.Println("Deleting horizontalpodautoscaler: " + name)
+
+	deletePolicy := metaV1.DeletePropagationForeground
+	err := kubeclient.AutoscalingV2beta1().HorizontalPodAutoscalers(namespace).Delete(name, &metaV1.DeleteOptions{
+		PropagationPolicy: &deletePolicy,
+	})
+	if err != nil {
+		return pkgerrors.Wrap(err, "Delete HorizontalPodAutoscaler error")
+	}
+
+	return nil
+}
+
+// GetResource existing horizontalpodautoscaler hosted in a specific Kubernetes namespace
+func GetResource(name string, namespace string, kubeclient *kubernetes.Clientset) (string, error) {
+	if namespace == "" {
+		namespace = "default"
+	}
+
+	_, err := kubeclient.AutoscalingV2beta1().HorizontalPodAutoscalers(namespace).Get(name, metaV1.GetOptions{})
+	if err != nil {
+		if k8serrors.IsNotFound(err) {
+			return "", nil
+		}
+		return "", pkgerrors.Wrap(err, "Get HorizontalPodAutoscaler error")
+	}
+
+	return name, nil
+}
+
+// IsReady checks if a HorizontalPodAutoscaler exists, it scales its target as
+// soon as it is created
+func IsReady(name string, namespace string, kubeclient *kubernetes.Clientset) (bool, error) {
+	found, err := GetResource(name, namespace, kubeclient)
+	if err != nil {
+		return false, err
+	}
+
+	return found != "", nil
+}
+
+// GetResourceStatus returns the live state of a HorizontalPodAutoscaler and
+// the replicas of its target
+func GetResourceStatus(name string, namespace string, kubeclient *kubernetes.Clientset) (*krd.ResourceStatus, error) {
+	if namespace == "" {
+		namespace = "default"
+	}
+
+	status := &krd.ResourceStatus{Name: name}
+
+	hpa, err := kubeclient.AutoscalingV2beta1().HorizontalPodAutoscalers(namespace).Get(name, metaV1.GetOptions{})
+	if err != nil {
+		if k8serrors.IsNotFound(err) {
+			return status, nil
+		}
+		return nil, pkgerrors.Wrap(err, "Get HorizontalPodAutoscaler error")
+	}
+
+	status.Present = true
+	status.Ready = true
+	status.Replicas = hpa.Status.DesiredReplicas
+	status.AvailableReplicas = hpa.Status.CurrentReplicas
+
+	status.Events, err = krd.GetRecentEvents(name, "HorizontalPodAutoscaler", namespace, kubeclient)
+	if err != nil {
+		return nil, err
+	}
+
+	return status, nil
+}
+
+// DiffResource compares the HorizontalPodAutoscaler described in kubedata with
+// the one running in the cluster
+func DiffResource(kubedata *krd.GenericKubeResourceData, kubeclient *kubernetes.Clientset) (*krd.ResourceDrift, error) {
+	err := readHPA(kubedata)
+	if err != nil {
+		return nil, err
+	}
+
+	expected := kubedata.HPAData
+	drift := &krd.ResourceDrift{Name: expected.Name}
+
+	live, err := kubeclient.AutoscalingV2beta1().HorizontalPodAutoscalers(kubedata.Namespace).Get(expected.Name, metaV1.GetOptions{})
+	if err != nil {
+		if k8serrors.IsNotFound(err) {
+			drift.Missing = true
+			return drift, nil
+		}
+		return nil, pkgerrors.Wrap(err, "Get HorizontalPodAutoscaler error")
+	}
+
+	expectedTarget := expected.Spec.ScaleTargetRef
+	liveTarget := live.Spec.ScaleTargetRef
+	if expectedTarget.Kind != liveTarget.Kind || expectedTarget.Name != liveTarget.Name {
+		drift.Differences = append(drift.Differences, fmt.Sprintf("spec.scaleTargetRef: expected %s %s, found %s %s",
+			expectedTarget.Kind, expectedTarget.Name, liveTarget.Kind, liveTarget.Name))
+	}
+
+	expectedMin, liveMin := int32(1), int32(1)
+	if expected.Spec.MinReplicas != nil {
+		expectedMin = *expected.Spec.MinReplicas
+	}
+	if live.Spec.MinReplicas != nil {
+		liveMin = *live.Spec.MinReplicas
+	}
+	if expectedMin != liveMin {
+		drift.Differences = append(drift.Differences, fmt.Sprintf("spec.minReplicas: expected %d, found %d",
+			expectedMin, liveMin))
+	}
+
+	if expected.Spec.MaxReplicas != live.Spec.MaxReplicas {
+		drift.Differences = append(drift.Differences, fmt.Sprintf("spec.maxReplicas: expected %d, found %d",
+			expected.Spec.MaxReplicas, live.Spec.MaxReplicas))
+	}
+
+	// Without metrics the cluster defaults to a target CPU utilization. The
+	// quantities are compared in their canonical form.
+	if len(expected.Spec.Metrics) > 0 {
+		expectedMetrics, _ := json.Marshal(expected.Spec.Metrics)
+		liveMetrics, _ := json.Marshal(live.Spec.Metrics)
+		if string(expectedMetrics) != string(liveMetrics) {
+			drift.Differences = append(drift.Differences, fmt.Sprintf("spec.metrics: expected %s, found %s",
+				expectedMetrics, liveMetrics))
+		}
+	}
+
+	return drift, nil
+}
@@ -0,0 +1,286 @@
+package main
+
+import (
+	"fmt"
+	"log"
+
+	"k8s.io/client-go/kubernetes"
+
+	pkgerrors "github.com/pkg/errors"
+
+	extensionsV1beta1 "k8s.io/api/extensions/v1beta1"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	metaV1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/scheme"
+
+	"k8-plugin-multicloud/krd"
+)
+
+// readIngress reads and decodes the Ingress YAML file referenced by kubedata
+func readIngress(kubedata *krd.GenericKubeResourceData) error {
+	if kubedata.Namespace == "" {
+		kubedata.Namespace = "default"
+	}
+
+	log.Println("Reading ingress YAML")
+	rawBytes, err := krd.ReadYAML(kubedata)
+	if err != nil {
+		return pkgerrors.Wrap(err, "Ingress YAML file read error")
+	}
+
+	log.Println("Decoding ingress YAML")
+	decode := scheme.Codecs.UniversalDeserializer().Decode
+	obj, _, err := decode(rawBytes, nil, nil)
+	if err != nil {
+		return pkgerrors.Wrap(err, "Deserialize ingress error")
+	}
+
+	switch o := obj.(type) {
+	case *extensionsV1beta1.Ingress:
+		kubedata.IngressData = o
+	default:
+		return pkgerrors.New(kubedata.YamlFilePath + " contains another resource different than Ingress")
+	}
+
+	ingress := kubedata.IngressData
+	ingress.Namespace = kubedata.Namespace
+	ingress.Name = kubedata.InternalVNFID + "-" + ingress.Name
+	krd.SetOwnership(&ingress.ObjectMeta, kubedata)
+
+	return nil
+}
+
+// ValidateResource checks that the YAML file describes an Ingress without creating it
+func ValidateResource(kubedata *krd.GenericKubeResourceData) error {
+	return readIngress(kubedata)
+}
+
+// CreateResource object in a specific Kubernetes Ingress
+func CreateResource(kubedata *krd.GenericKubeResourceData, kubeclient *kubernetes.Clientset) (string, error) {
+	err := readIngress(kubedata)
+	if err != nil {
+		return "", err
+	}
+
+	result, err := kubeclient.ExtensionsV1beta1().Ingresses(kubedata.Namespace).Create(kubedata.IngressData)
+	if err != nil {
+		return "", pkgerrors.Wrap(err, "Create Ingress error")
+	}
+
+	return result.GetObjectMeta().GetName(), nil
+}
+
+// UpdateResource updates an existing Kubernetes Ingress in place or creates it
+// when it is not present yet
+func UpdateResource(kubedata *krd.GenericKubeResourceData, kubeclient *kubernetes.Clientset) (string, error) {
+	err := readIngress(kubedata)
+	if err != nil {
+		return "", err
+	}
+
+	ingresses := kubeclient.ExtensionsV1beta1().Ingresses(kubedata.Namespace)
+
+	existing, err := ingresses.Get(kubedata.IngressData.Name, metaV1.GetOptions{})
+	if err != nil {
+		if !k8serrors.IsNotFound(err) {
+			return "", pkgerrors.Wrap(err, "Get Ingress error")
+		}
+
+		log.Println("Creating ingress: " + kubedata.IngressData.Name)
+		result, err := ingresses.Create(kubedata.IngressData)
+		if err != nil {
+			return "", pkgerrors.Wrap(err, "Create Ingress error")
+		}
+		return result.GetObjectMeta().GetName(), nil
+	}
+
+	log.Println("Updating ingress: " + kubedata.IngressData.Name)
+	kubedata.IngressData.ResourceVersion = existing.ResourceVersion
+
+	result, err := ingresses.Update(kubedata.IngressData)
+	if err != nil {
+		return "", pkgerrors.Wrap(err, "Update Ingress error")
+	}
+
+	return result.GetObjectMeta().GetName(), nil
+}
+
+// ListResources of existing ingresses hosted in a specific Kubernetes namespace
+func ListResources(limit int64, namespace string, kubeclient *kubernetes.Clientset) (*[]string, error) {
+	if namespace == "" {
+		namespace = "default"
+	}
+
+	opts := metaV1.ListOptions{
+		Limit: limit,
+	}
+	opts.APIVersion = "extensions/v1beta1"
+	opts.Kind = "Ingress"
+
+	list, err := kubeclient.ExtensionsV1beta1().Ingresses(namespace).List(opts)
+	if err != nil {
+		return nil, pkgerrors.Wrap(err, "Get Ingress list error")
+	}
+
+	result := make([]string, 0, limit)
+	if list != nil {
+		for _, ingress := range list.Items {
+			result = append(result, ingress.Name)
+		}
+	}
+
+	return &result, nil
+}
+
+// ListOwnedResources returns the metadata of the ingresses created by the plugin in all namespaces
+func ListOwnedResources(kubeclient *kubernetes.Clientset) ([]metaV1.ObjectMeta, error) {
+	opts := metaV1.ListOptions{
+		LabelSelector: krd.VNFIDLabel,
+	}
+
+	list, err := kubeclient.ExtensionsV1beta1().Ingresses(metaV1.NamespaceAll).List(opts)
+	if err != nil {
+		return nil, pkgerrors.Wrap(err, "Get Ingress list error")
+	}
+
+	var result []metaV1.ObjectMeta
+	for _, item := range list.Items {
+		result = append(result, item.ObjectMeta)
+	}
+
+	return result, nil
+}
+
+// DeleteResource deletes an existing Kubernetes ingress
+func DeleteResource(name string, namespace string, kubeclient *kubernetes.Clientset) error {
+	if namespace == "" {
+		namespace = "default"
+	}
+
+	log.Println("Deleting ingress: " + name)
+
+	deletePolicy := metaV1.DeletePropagationForeground
+	err := kubeclient.ExtensionsV1beta1().Ingresses(namespace).Delete(name, &metaV1.DeleteOptions{
+		PropagationPolicy: &deletePolicy,
+	})
+	if err != nil {
+		return pkgerrors.Wrap(err, "Delete Ingress error")
+	}
+
+	return nil
+}
+
+// GetResource existing ingress hosted in a specific Kubernetes namespace
+func GetResource(name string, namespace string, kubeclient *kubernetes.Clientset) (string, error) {
+	if namespace == "" {
+		namespace = "default"
+	}
+
+	_, err := kubeclient.ExtensionsV1beta1().Ingresses(namespace).Get(name, metaV1.GetOptions{})
+	if err != nil {
+		if k8serrors.IsNotFound(err) {
+			return "", nil
+		}
+		return "", pkgerrors.Wrap(err, "Get Ingress error")
+	}
+
+	return name, nil
+}
+
+// IsReady checks if an Ingress exists. Not every ingress controller publishes
+// the address of the ingresses it serves, so it is not waited for.
+func IsReady(name string, namespace string, kubeclient *kubernetes.Clientset) (bool, error) {
+	found, err := GetResource(name, namespace, kubeclient)
+	if err != nil {
+		return false, err
+	}
+
+	return found != "", nil
+}
+
+// GetResourceStatus returns the live state of an Ingress and the addresses it
+// is exposed on
+func GetResourceStatus(name string, namespace string, kubeclient *kubernetes.Clientset) (*krd.ResourceStatus, error) {
+	if namespace == "" {
+		namespace = "default"
+	}
+
+	status := &krd.ResourceStatus{Name: name}
+
+	ingress, err := kubeclient.ExtensionsV1beta1().Ingresses(namespace).Get(name, metaV1.GetOptions{})
+	if err != nil {
+		if k8serrors.IsNotFound(err) {
+			return status, nil
+		}
+		return nil, pkgerrors.Wrap(err, "Get Ingress error")
+	}
+
+	status.Present = true
+	status.Ready = true
+
+	for _, address := range ingress.Status.LoadBalancer.Ingress {
+		if address.IP != "" {
+			status.Addresses = append(status.Addresses, address.IP)
+		} else {
+			status.Addresses = append(status.Addresses, address.Hostname)
+		}
+	}
+
+	status.Events, err = krd.GetRecentEvents(name, "Ingress", namespace, kubeclient)
+	if err != nil {
+		return nil, err
+	}
+
+	return status, nil
+}
+
+// DiffResource compares the Ingress described in kubedata with the one
+// running in the cluster
+func DiffResource(kubedata *krd.GenericKubeResourceData, kubeclient *kubernetes.Clientset) (*krd.ResourceDrift, error) {
+	err := readIngress(kubedata)
+	if err != nil {
+		return nil, err
+	}
+
+	expected := kubedata.IngressData
+	drift := &krd.ResourceDrift{Name: expected.Name}
+
+	live, err := kubeclient.ExtensionsV1beta1().Ingresses(kubedata.Namespace).Get(expected.Name, metaV1.GetOptions{})
+	if err != nil {
+		if k8serrors.IsNotFound(err) {
+			drift.Missing = true
+			return drift, nil
+		}
+		return nil, pkgerrors.Wrap(err, "Get Ingress error")
+	}
+
+	expectedRules := ingressRules(expected)
+	liveRules := ingressRules(live)
+	for rule, backend := range expectedRules {
+		if found, ok := liveRules[rule]; !ok || found != backend {
+			drift.Differences = append(drift.Differences, fmt.Sprintf("rule %s: expected %s, found %s",
+				rule, backend, found))
+		}
+	}
+	for rule := range liveRules {
+		if _, ok := expectedRules[rule]; !ok {
+			drift.Differences = append(drift.Differences, fmt.Sprintf("rule %s: not expected", rule))
+		}
+	}
+
+	return drift, nil
+}
+
+// ingressRules returns the backend of every host and path served by an Ingress
+func ingressRules(ingress *extensionsV1beta1.Ingress) map[string]string {
+	rules := make(map[string]string)
+	for _, rule := range ingress.Spec.Rules {
+		if rule.HTTP == nil {
+			continue
+		}
+		for _, path := range rule.HTTP.Paths {
+			rules[rule.Host+path.Path] = path.Backend.ServiceName + ":" + path.Backend.ServicePort.String()
+		}
+	}
+	return rules
+}
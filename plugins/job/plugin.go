@@ -0,0 +1,286 @@
+package main
+
+import (
+	"fmt"
+	"log"
+
+	"k8s.io/client-go/kubernetes"
+
+	pkgerrors "github.com/pkg/errors"
+
+	batchV1 "k8s.io/api/batch/v1"
+	coreV1 "k8s.io/api/core/v1"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	metaV1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/scheme"
+
+	"k8-plugin-multicloud/krd"
+)
+
+// readJob reads and decodes the Job YAML file referenced by kubedata
+func readJob(kubedata *krd.GenericKubeResourceData) error {
+	if kubedata.Namespace == "" {
+		kubedata.Namespace = "default"
+	}
+
+	log.Println("Reading job YAML")
+	rawBytes, err := krd.ReadYAML(kubedata)
+	if err != nil {
+		return pkgerrors.Wrap(err, "Job YAML file read error")
+	}
+
+	log.Println("Decoding job YAML")
+	decode := scheme.Codecs.UniversalDeserializer().Decode
+	obj, _, err := decode(rawBytes, nil, nil)
+	if err != nil {
+		return pkgerrors.Wrap(err, "Deserialize job error")
+	}
+
+	switch o := obj.(type) {
+	case *batchV1.Job:
+		kubedata.JobData = o
+	default:
+		return pkgerrors.New(kubedata.YamlFilePath + " contains another resource different than Job")
+	}
+
+	kubedata.JobData.Namespace = kubedata.Namespace
+	kubedata.JobData.Name = kubedata.InternalVNFID + "-" + kubedata.JobData.Name
+	krd.SetOwnership(&kubedata.JobData.ObjectMeta, kubedata)
+
+	return nil
+}
+
+// ValidateResource checks that the YAML file describes a Job without creating it
+func ValidateResource(kubedata *krd.GenericKubeResourceData) error {
+	return readJob(kubedata)
+}
+
+// CreateResource object in a specific Kubernetes Job
+func CreateResource(kubedata *krd.GenericKubeResourceData, kubeclient *kubernetes.Clientset) (string, error) {
+	err := readJob(kubedata)
+	if err != nil {
+		return "", err
+	}
+
+	result, err := kubeclient.BatchV1().Jobs(kubedata.Namespace).Create(kubedata.JobData)
+	if err != nil {
+		return "", pkgerrors.Wrap(err, "Create Job error")
+	}
+
+	return result.GetObjectMeta().GetName(), nil
+}
+
+// UpdateResource creates a Job when it is not present yet. The template of a
+// Job can't be changed once created, an existing Job is kept as it is and
+// does not run again.
+func UpdateResource(kubedata *krd.GenericKubeResourceData, kubeclient *kubernetes.Clientset) (string, error) {
+	err := readJob(kubedata)
+	if err != nil {
+		return "", err
+	}
+
+	jobs := kubeclient.BatchV1().Jobs(kubedata.Namespace)
+
+	existing, err := jobs.Get(kubedata.JobData.Name, metaV1.GetOptions{})
+	if err != nil {
+		if !k8serrors.IsNotFound(err) {
+			return "", pkgerrors.Wrap(err, "Get Job error")
+		}
+
+		log.Println("Creating job: " + kubedata.JobData.Name)
+		result, err := jobs.Create(kubedata.JobData)
+		if err != nil {
+			return "", pkgerrors.Wrap(err, "Create Job error")
+		}
+		return result.GetObjectMeta().GetName(), nil
+	}
+
+	log.Println("Keeping job: " + existing.Name)
+	return existing.Name, nil
+}
+
+// ListResources of existing jobs hosted in a specific Kubernetes namespace
+func ListResources(limit int64, namespace string, kubeclient *kubernetes.Clientset) (*[]string, error) {
+	if namespace == "" {
+		namespace = "default"
+	}
+
+	opts := metaV1.ListOptions{
+		Limit: limit,
+	}
+	opts.APIVersion = "batch/v1"
+	opts.Kind = "Job"
+
+	list, err := kubeclient.BatchV1().Jobs(namespace).List(opts)
+	if err != nil {
+		return nil, pkgerrors.Wrap(err, "Get Job list error")
+	}
+
+	result := make([]string, 0, limit)
+	if list != nil {
+		for _, job := range list.Items {
+			result = append(result, job.Name)
+		}
+	}
+
+	return &result, nil
+}
+
+// ListOwnedResources returns the metadata of the jobs created by the plugin in all namespaces
+func ListOwnedResources(kubeclient *kubernetes.Clientset) ([]metaV1.ObjectMeta, error) {
+	opts := metaV1.ListOptions{
+		LabelSelector: krd.VNFIDLabel,
+	}
+
+	list, err := kubeclient.BatchV1().Jobs(metaV1.NamespaceAll).List(opts)
+	if err != nil {
+		return nil, pkgerrors.Wrap(err, "Get Job list error")
+	}
+
+	var result []metaV1.ObjectMeta
+	for _, item := range list.Items {
+		result = append(result, item.ObjectMeta)
+	}
+
+	return result, nil
+}
+
+// DeleteResource deletes an existing Kubernetes job and its pods, which the API
+// orphans unless a propagation policy is given
+func DeleteResource(name string, namespace string, kubeclient *kubernetes.Clientset) error {
+	if namespace == "" {
+		namespace = "default"
+	}
+
+	log.Println("Deleting job: " + name)
+
+	deletePolicy := metaV1.DeletePropagationForeground
+	err := kubeclient.BatchV1().Jobs(namespace).Delete(name, &metaV1.DeleteOptions{
+		PropagationPolicy: &deletePolicy,
+	})
+	if err != nil {
+		return pkgerrors.Wrap(err, "Delete Job error")
+	}
+
+	return nil
+}
+
+// GetResource existing job hosted in a specific Kubernetes namespace
+func GetResource(name string, namespace string, kubeclient *kubernetes.Clientset) (string, error) {
+	if namespace == "" {
+		namespace = "default"
+	}
+
+	_, err := kubeclient.BatchV1().Jobs(namespace).Get(name, metaV1.GetOptions{})
+	if err != nil {
+		if k8serrors.IsNotFound(err) {
+			return "", nil
+		}
+		return "", pkgerrors.Wrap(err, "Get Job error")
+	}
+
+	return name, nil
+}
+
+// jobCondition returns the status of a condition of a Job
+func jobCondition(job *batchV1.Job, conditionType batchV1.JobConditionType) bool {
+	for _, condition := range job.Status.Conditions {
+		if condition.Type == conditionType {
+			return condition.Status == coreV1.ConditionTrue
+		}
+	}
+	return false
+}
+
+func desiredCompletions(job *batchV1.Job) int32 {
+	if job.Spec.Completions != nil {
+		return *job.Spec.Completions
+	}
+	return 1
+}
+
+// IsReady checks if a Job completed, a failed Job never becomes ready
+func IsReady(name string, namespace string, kubeclient *kubernetes.Clientset) (bool, error) {
+	if namespace == "" {
+		namespace = "default"
+	}
+
+	job, err := kubeclient.BatchV1().Jobs(namespace).Get(name, metaV1.GetOptions{})
+	if err != nil {
+		return false, pkgerrors.Wrap(err, "Get Job error")
+	}
+
+	if jobCondition(job, batchV1.JobFailed) {
+		return false, &krd.ResourceFailedError{Reason: "Job " + name + " failed"}
+	}
+
+	return jobCondition(job, batchV1.JobComplete), nil
+}
+
+// GetResourceStatus returns the live state of a Job and its pods
+func GetResourceStatus(name string, namespace string, kubeclient *kubernetes.Clientset) (*krd.ResourceStatus, error) {
+	if namespace == "" {
+		namespace = "default"
+	}
+
+	status := &krd.ResourceStatus{Name: name}
+
+	job, err := kubeclient.BatchV1().Jobs(namespace).Get(name, metaV1.GetOptions{})
+	if err != nil {
+		if k8serrors.IsNotFound(err) {
+			return status, nil
+		}
+		return nil, pkgerrors.Wrap(err, "Get Job error")
+	}
+
+	status.Present = true
+	status.Ready = jobCondition(job, batchV1.JobComplete)
+	status.Replicas = desiredCompletions(job)
+	status.AvailableReplicas = job.Status.Succeeded
+
+	status.Pods, err = krd.GetPodStatuses(job.Spec.Selector, namespace, kubeclient)
+	if err != nil {
+		return nil, err
+	}
+
+	status.Events, err = krd.GetRecentEvents(name, "Job", namespace, kubeclient)
+	if err != nil {
+		return nil, err
+	}
+
+	return status, nil
+}
+
+// DiffResource compares the Job described in kubedata with the one
+// running in the cluster
+func DiffResource(kubedata *krd.GenericKubeResourceData, kubeclient *kubernetes.Clientset) (*krd.ResourceDrift, error) {
+	err := readJob(kubedata)
+	if err != nil {
+		return nil, err
+	}
+
+	expected := kubedata.JobData
+	drift := &krd.ResourceDrift{Name: expected.Name}
+
+	live, err := kubeclient.BatchV1().Jobs(kubedata.Namespace).Get(expected.Name, metaV1.GetOptions{})
+	if err != nil {
+		if k8serrors.IsNotFound(err) {
+			drift.Missing = true
+			return drift, nil
+		}
+		return nil, pkgerrors.Wrap(err, "Get Job error")
+	}
+
+	liveImages := make(map[string]string)
+	for _, container := range live.Spec.Template.Spec.Containers {
+		liveImages[container.Name] = container.Image
+	}
+	for _, container := range expected.Spec.Template.Spec.Containers {
+		if image, ok := liveImages[container.Name]; !ok || image != container.Image {
+			drift.Differences = append(drift.Differences, fmt.Sprintf("container %s image: expected %s, found %s",
+				container.Name, container.Image, image))
+		}
+	}
+
+	return drift, nil
+}
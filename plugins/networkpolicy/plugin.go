@@ -0,0 +1,298 @@
+package main
+
+import (
+	"fmt"
+	"log"
+
+	"k8s.io/client-go/kubernetes"
+
+	pkgerrors "github.com/pkg/errors"
+
+	coreV1 "k8s.io/api/core/v1"
+	networkingV1 "k8s.io/api/networking/v1"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	metaV1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	"k8s.io/client-go/kubernetes/scheme"
+
+	"k8-plugin-multicloud/krd"
+)
+
+// readNetworkPolicy reads and decodes the NetworkPolicy YAML file referenced by kubedata
+func readNetworkPolicy(kubedata *krd.GenericKubeResourceData) error {
+	if kubedata.Namespace == "" {
+		kubedata.Namespace = "default"
+	}
+
+	log.Println("Reading networkpolicy YAML")
+	rawBytes, err := krd.ReadYAML(kubedata)
+	if err != nil {
+		return pkgerrors.Wrap(err, "NetworkPolicy YAML file read error")
+	}
+
+	log.Println("Decoding networkpolicy YAML")
+	decode := scheme.Codecs.UniversalDeserializer().Decode
+	obj, _, err := decode(rawBytes, nil, nil)
+	if err != nil {
+		return pkgerrors.Wrap(err, "Deserialize networkpolicy error")
+	}
+
+	switch o := obj.(type) {
+	case *networkingV1.NetworkPolicy:
+		kubedata.NetworkPolicyData = o
+	default:
+		return pkgerrors.New(kubedata.YamlFilePath + " contains another resource different than NetworkPolicy")
+	}
+
+	kubedata.NetworkPolicyData.Namespace = kubedata.Namespace
+	kubedata.NetworkPolicyData.Name = kubedata.InternalVNFID + "-" + kubedata.NetworkPolicyData.Name
+	krd.SetOwnership(&kubedata.NetworkPolicyData.ObjectMeta, kubedata)
+
+	if kubedata.NetworkPolicyData.Annotations[krd.IsolationAnnotation] == "true" {
+		isolate(kubedata.NetworkPolicyData, kubedata)
+	}
+
+	return nil
+}
+
+// isolate turns the policy generated for a VNF with network isolation into a
+// policy which denies the ingress and egress traffic of its pods unless it is
+// exchanged with the pods of the same VNF. DNS queries are still allowed. The
+// other policies of the VNF can allow more.
+func isolate(policy *networkingV1.NetworkPolicy, kubedata *krd.GenericKubeResourceData) {
+	vnfPods := func() *metaV1.LabelSelector {
+		return &metaV1.LabelSelector{
+			MatchLabels: map[string]string{krd.VNFIDLabel: kubedata.ExternalVNFID},
+		}
+	}
+
+	dnsPort := intstr.FromInt(53)
+	udp, tcp := coreV1.ProtocolUDP, coreV1.ProtocolTCP
+
+	policy.Spec.PodSelector = *vnfPods()
+	policy.Spec.Ingress = []networkingV1.NetworkPolicyIngressRule{
+		{From: []networkingV1.NetworkPolicyPeer{{PodSelector: vnfPods()}}},
+	}
+	policy.Spec.Egress = []networkingV1.NetworkPolicyEgressRule{
+		{To: []networkingV1.NetworkPolicyPeer{{PodSelector: vnfPods()}}},
+		{Ports: []networkingV1.NetworkPolicyPort{
+			{Protocol: &udp, Port: &dnsPort},
+			{Protocol: &tcp, Port: &dnsPort},
+		}},
+	}
+	policy.Spec.PolicyTypes = []networkingV1.PolicyType{networkingV1.PolicyTypeIngress, networkingV1.PolicyTypeEgress}
+}
+
+// ValidateResource checks that the YAML file describes a NetworkPolicy without creating it
+func ValidateResource(kubedata *krd.GenericKubeResourceData) error {
+	return readNetworkPolicy(kubedata)
+}
+
+// CreateResource object in a specific Kubernetes NetworkPolicy
+func CreateResource(kubedata *krd.GenericKubeResourceData, kubeclient *kubernetes.Clientset) (string, error) {
+	err := readNetworkPolicy(kubedata)
+	if err != nil {
+		return "", err
+	}
+
+	result, err := kubeclient.NetworkingV1().NetworkPolicies(kubedata.Namespace).Create(kubedata.NetworkPolicyData)
+	if err != nil {
+		return "", pkgerrors.Wrap(err, "Create NetworkPolicy error")
+	}
+
+	return result.GetObjectMeta().GetName(), nil
+}
+
+// UpdateResource updates an existing Kubernetes NetworkPolicy in place or creates it
+// when it is not present yet
+func UpdateResource(kubedata *krd.GenericKubeResourceData, kubeclient *kubernetes.Clientset) (string, error) {
+	err := readNetworkPolicy(kubedata)
+	if err != nil {
+		return "", err
+	}
+
+	policies := kubeclient.NetworkingV1().NetworkPolicies(kubedata.Namespace)
+
+	existing, err := policies.Get(kubedata.NetworkPolicyData.Name, metaV1.GetOptions{})
+	if err != nil {
+		if !k8serrors.IsNotFound(err) {
+			return "", pkgerrors.Wrap(err, "Get NetworkPolicy error")
+		}
+
+		log.Println("Creating networkpolicy: " + kubedata.NetworkPolicyData.Name)
+		result, err := policies.Create(kubedata.NetworkPolicyData)
+		if err != nil {
+			return "", pkgerrors.Wrap(err, "Create NetworkPolicy error")
+		}
+		return result.GetObjectMeta().GetName(), nil
+	}
+
+	log.Println("Updating networkpolicy: " + kubedata.NetworkPolicyData.Name)
+	kubedata.NetworkPolicyData.ResourceVersion = existing.ResourceVersion
+
+	result, err := policies.Update(kubedata.NetworkPolicyData)
+	if err != nil {
+		return "", pkgerrors.Wrap(err, "Update NetworkPolicy error")
+	}
+
+	return result.GetObjectMeta().GetName(), nil
+}
+
+// ListResources of existing networkpolicies hosted in a specific Kubernetes namespace
+func ListResources(limit int64, namespace string, kubeclient *kubernetes.Clientset) (*[]string, error) {
+	if namespace == "" {
+		namespace = "default"
+	}
+
+	opts := metaV1.ListOptions{
+		Limit: limit,
+	}
+	opts.APIVersion = "networking.k8s.io/v1"
+	opts.Kind = "NetworkPolicy"
+
+	list, err := kubeclient.NetworkingV1().NetworkPolicies(namespace).List(opts)
+	if err != nil {
+		return nil, pkgerrors.Wrap(err, "Get NetworkPolicy list error")
+	}
+
+	result := make([]string, 0, limit)
+	if list != nil {
+		for _, policy := range list.Items {
+			result = append(result, policy.Name)
+		}
+	}
+
+	return &result, nil
+}
+
+// ListOwnedResources returns the metadata of the networkpolicies created by the plugin in all namespaces
+func ListOwnedResources(kubeclient *kubernetes.Clientset) ([]metaV1.ObjectMeta, error) {
+	opts := metaV1.ListOptions{
+		LabelSelector: krd.VNFIDLabel,
+	}
+
+	list, err := kubeclient.NetworkingV1().NetworkPolicies(metaV1.NamespaceAll).List(opts)
+	if err != nil {
+		return nil, pkgerrors.Wrap(err, "Get NetworkPolicy list error")
+	}
+
+	var result []metaV1.ObjectMeta
+	for _, item := range list.Items {
+		result = append(result, item.ObjectMeta)
+	}
+
+	return result, nil
+}
+
+// DeleteResource deletes an existing Kubernetes networkpolicy
+func DeleteResource(name string, namespace string, kubeclient *kubernetes.Clientset) error {
+	if namespace == "" {
+		namespace = "default"
+	}
+
+	log.Println("Deleting networkpolicy: " + name)
+
+	deletePolicy := metaV1.DeletePropagationForeground
+	err := kubeclient.NetworkingV1().NetworkPolicies(namespace).Delete(name, &metaV1.DeleteOptions{
+		PropagationPolicy: &deletePolicy,
+	})
+	if err != nil {
+		return pkgerrors.Wrap(err, "Delete NetworkPolicy error")
+	}
+
+	return nil
+}
+
+// GetResource existing networkpolicy hosted in a specific Kubernetes namespace
+func GetResource(name string, namespace string, kubeclient *kubernetes.Clientset) (string, error) {
+	if namespace == "" {
+		namespace = "default"
+	}
+
+	_, err := kubeclient.NetworkingV1().NetworkPolicies(namespace).Get(name, metaV1.GetOptions{})
+	if err != nil {
+		if k8serrors.IsNotFound(err) {
+			return "", nil
+		}
+		return "", pkgerrors.Wrap(err, "Get NetworkPolicy error")
+	}
+
+	return name, nil
+}
+
+// IsReady checks if a NetworkPolicy exists, it is enforced as soon as it is created
+func IsReady(name string, namespace string, kubeclient *kubernetes.Clientset) (bool, error) {
+	found, err := GetResource(name, namespace, kubeclient)
+	if err != nil {
+		return false, err
+	}
+
+	return found != "", nil
+}
+
+// GetResourceStatus returns the live state of a NetworkPolicy
+func GetResourceStatus(name string, namespace string, kubeclient *kubernetes.Clientset) (*krd.ResourceStatus, error) {
+	if namespace == "" {
+		namespace = "default"
+	}
+
+	status := &krd.ResourceStatus{Name: name}
+
+	found, err := GetResource(name, namespace, kubeclient)
+	if err != nil {
+		return nil, err
+	}
+	if found == "" {
+		return status, nil
+	}
+
+	status.Present = true
+	status.Ready = true
+
+	status.Events, err = krd.GetRecentEvents(name, "NetworkPolicy", namespace, kubeclient)
+	if err != nil {
+		return nil, err
+	}
+
+	return status, nil
+}
+
+// DiffResource compares the NetworkPolicy described in kubedata with the one
+// running in the cluster
+func DiffResource(kubedata *krd.GenericKubeResourceData, kubeclient *kubernetes.Clientset) (*krd.ResourceDrift, error) {
+	err := readNetworkPolicy(kubedata)
+	if err != nil {
+		return nil, err
+	}
+
+	expected := kubedata.NetworkPolicyData
+	drift := &krd.ResourceDrift{Name: expected.Name}
+
+	live, err := kubeclient.NetworkingV1().NetworkPolicies(kubedata.Namespace).Get(expected.Name, metaV1.GetOptions{})
+	if err != nil {
+		if k8serrors.IsNotFound(err) {
+			drift.Missing = true
+			return drift, nil
+		}
+		return nil, pkgerrors.Wrap(err, "Get NetworkPolicy error")
+	}
+
+	expectedSelector := metaV1.FormatLabelSelector(&expected.Spec.PodSelector)
+	liveSelector := metaV1.FormatLabelSelector(&live.Spec.PodSelector)
+	if expectedSelector != liveSelector {
+		drift.Differences = append(drift.Differences, fmt.Sprintf("spec.podSelector: expected %s, found %s",
+			expectedSelector, liveSelector))
+	}
+
+	if len(expected.Spec.Ingress) != len(live.Spec.Ingress) {
+		drift.Differences = append(drift.Differences, fmt.Sprintf("spec.ingress: expected %d rules, found %d",
+			len(expected.Spec.Ingress), len(live.Spec.Ingress)))
+	}
+
+	if len(expected.Spec.Egress) != len(live.Spec.Egress) {
+		drift.Differences = append(drift.Differences, fmt.Sprintf("spec.egress: expected %d rules, found %d",
+			len(expected.Spec.Egress), len(live.Spec.Egress)))
+	}
+
+	return drift, nil
+}
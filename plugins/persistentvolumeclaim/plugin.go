@@ -0,0 +1,378 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"time"
+
+	"k8s.io/client-go/kubernetes"
+
+	pkgerrors "github.com/pkg/errors"
+
+	coreV1 "k8s.io/api/core/v1"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	metaV1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/scheme"
+
+	"k8-plugin-multicloud/krd"
+)
+
+// readPVC reads and decodes the PersistentVolumeClaim YAML file referenced by
+// kubedata. Retained claims are named after the cloud region and the namespace
+// instead of the VNF, so a VNF deployed again finds the claim of the previous one.
+func readPVC(kubedata *krd.GenericKubeResourceData) error {
+	if kubedata.Namespace == "" {
+		kubedata.Namespace = "default"
+	}
+
+	log.Println("Reading persistentvolumeclaim YAML")
+	rawBytes, err := krd.ReadYAML(kubedata)
+	if err != nil {
+		return pkgerrors.Wrap(err, "PersistentVolumeClaim YAML file read error")
+	}
+
+	log.Println("Decoding persistentvolumeclaim YAML")
+	decode := scheme.Codecs.UniversalDeserializer().Decode
+	obj, _, err := decode(rawBytes, nil, nil)
+	if err != nil {
+		return pkgerrors.Wrap(err, "Deserialize persistentvolumeclaim error")
+	}
+
+	switch o := obj.(type) {
+	case *coreV1.PersistentVolumeClaim:
+		kubedata.PVCData = o
+	default:
+		return pkgerrors.New(kubedata.YamlFilePath + " contains another resource different than PersistentVolumeClaim")
+	}
+
+	kubedata.PVCData.Namespace = kubedata.Namespace
+	krd.SetOwnership(&kubedata.PVCData.ObjectMeta, kubedata)
+
+	if kubedata.Retain {
+		// cloud1-default-sisedata
+		kubedata.PVCData.Name = kubedata.CloudRegionID + "-" + kubedata.Namespace + "-" + kubedata.PVCData.Name
+		kubedata.PVCData.Labels[krd.RetainLabel] = "true"
+	} else {
+		kubedata.PVCData.Name = kubedata.InternalVNFID + "-" + kubedata.PVCData.Name
+	}
+
+	return nil
+}
+
+// isRetained checks if a claim is kept when its VNF is deleted
+func isRetained(pvc *coreV1.PersistentVolumeClaim) bool {
+	return pvc.Labels[krd.RetainLabel] == "true"
+}
+
+// adoptPVC gives a retained claim released by a deleted VNF to the VNF
+// described in kubedata
+func adoptPVC(existing *coreV1.PersistentVolumeClaim, kubedata *krd.GenericKubeResourceData,
+	kubeclient *kubernetes.Clientset) (string, error) {
+
+	if !isRetained(existing) {
+		return "", pkgerrors.New("PersistentVolumeClaim " + existing.Name + " exists and is not retained")
+	}
+
+	owner := existing.Labels[krd.VNFIDLabel]
+	if _, released := existing.Annotations[krd.ReleasedAnnotation]; !released && owner != kubedata.ExternalVNFID {
+		return "", pkgerrors.New("PersistentVolumeClaim " + existing.Name + " is used by VNF " + owner)
+	}
+
+	log.Println("Adopting retained persistentvolumeclaim: " + existing.Name)
+	krd.SetOwnership(&existing.ObjectMeta, kubedata)
+	delete(existing.Annotations, krd.ReleasedAnnotation)
+
+	result, err := kubeclient.CoreV1().PersistentVolumeClaims(existing.Namespace).Update(existing)
+	if err != nil {
+		return "", pkgerrors.Wrap(err, "Update PersistentVolumeClaim error")
+	}
+
+	return result.GetObjectMeta().GetName(), nil
+}
+
+// ValidateResource checks that the YAML file describes a PersistentVolumeClaim without creating it
+func ValidateResource(kubedata *krd.GenericKubeResourceData) error {
+	return readPVC(kubedata)
+}
+
+// CreateResource object in a specific Kubernetes PersistentVolumeClaim. A
+// retained claim released by a previous VNF is reused with its data.
+func CreateResource(kubedata *krd.GenericKubeResourceData, kubeclient *kubernetes.Clientset) (string, error) {
+	err := readPVC(kubedata)
+	if err != nil {
+		return "", err
+	}
+
+	pvcs := kubeclient.CoreV1().PersistentVolumeClaims(kubedata.Namespace)
+
+	result, err := pvcs.Create(kubedata.PVCData)
+	if err != nil {
+		if !kubedata.Retain || !k8serrors.IsAlreadyExists(err) {
+			return "", pkgerrors.Wrap(err, "Create PersistentVolumeClaim error")
+		}
+
+		existing, err := pvcs.Get(kubedata.PVCData.Name, metaV1.GetOptions{})
+		if err != nil {
+			return "", pkgerrors.Wrap(err, "Get PersistentVolumeClaim error")
+		}
+		return adoptPVC(existing, kubedata, kubeclient)
+	}
+
+	return result.GetObjectMeta().GetName(), nil
+}
+
+// UpdateResource updates an existing Kubernetes PersistentVolumeClaim or creates
+// it when it is not present yet. Only the requested storage of a bound claim
+// can be changed, its other fields are kept.
+func UpdateResource(kubedata *krd.GenericKubeResourceData, kubeclient *kubernetes.Clientset) (string, error) {
+	err := readPVC(kubedata)
+	if err != nil {
+		return "", err
+	}
+
+	pvcs := kubeclient.CoreV1().PersistentVolumeClaims(kubedata.Namespace)
+
+	existing, err := pvcs.Get(kubedata.PVCData.Name, metaV1.GetOptions{})
+	if err != nil {
+		if !k8serrors.IsNotFound(err) {
+			return "", pkgerrors.Wrap(err, "Get PersistentVolumeClaim error")
+		}
+
+		log.Println("Creating persistentvolumeclaim: " + kubedata.PVCData.Name)
+		result, err := pvcs.Create(kubedata.PVCData)
+		if err != nil {
+			return "", pkgerrors.Wrap(err, "Create PersistentVolumeClaim error")
+		}
+		return result.GetObjectMeta().GetName(), nil
+	}
+
+	if kubedata.Retain {
+		_, err = adoptPVC(existing, kubedata, kubeclient)
+		if err != nil {
+			return "", err
+		}
+		existing, err = pvcs.Get(kubedata.PVCData.Name, metaV1.GetOptions{})
+		if err != nil {
+			return "", pkgerrors.Wrap(err, "Get PersistentVolumeClaim error")
+		}
+	}
+
+	log.Println("Updating persistentvolumeclaim: " + kubedata.PVCData.Name)
+	requested, ok := kubedata.PVCData.Spec.Resources.Requests[coreV1.ResourceStorage]
+	if ok && requested.Cmp(existing.Spec.Resources.Requests[coreV1.ResourceStorage]) != 0 {
+		if existing.Spec.Resources.Requests == nil {
+			existing.Spec.Resources.Requests = coreV1.ResourceList{}
+		}
+		existing.Spec.Resources.Requests[coreV1.ResourceStorage] = requested
+	}
+	existing.Labels = kubedata.PVCData.Labels
+
+	result, err := pvcs.Update(existing)
+	if err != nil {
+		return "", pkgerrors.Wrap(err, "Update PersistentVolumeClaim error")
+	}
+
+	return result.GetObjectMeta().GetName(), nil
+}
+
+// ListResources of existing persistentvolumeclaims hosted in a specific Kubernetes namespace
+func ListResources(limit int64, namespace string, kubeclient *kubernetes.Clientset) (*[]string, error) {
+	if namespace == "" {
+		namespace = "default"
+	}
+
+	opts := metaV1.ListOptions{
+		Limit: limit,
+	}
+	opts.APIVersion = "v1"
+	opts.Kind = "PersistentVolumeClaim"
+
+	list, err := kubeclient.CoreV1().PersistentVolumeClaims(namespace).List(opts)
+	if err != nil {
+		return nil, pkgerrors.Wrap(err, "Get PersistentVolumeClaim list error")
+	}
+
+	result := make([]string, 0, limit)
+	if list != nil {
+		for _, pvc := range list.Items {
+			result = append(result, pvc.Name)
+		}
+	}
+
+	return &result, nil
+}
+
+// ListOwnedResources returns the metadata of the persistentvolumeclaims created
+// by the plugin in all namespaces. Retained claims are not listed, they must
+// survive their VNF.
+func ListOwnedResources(kubeclient *kubernetes.Clientset) ([]metaV1.ObjectMeta, error) {
+	opts := metaV1.ListOptions{
+		LabelSelector: krd.VNFIDLabel + "," + krd.RetainLabel + "!=true",
+	}
+
+	list, err := kubeclient.CoreV1().PersistentVolumeClaims(metaV1.NamespaceAll).List(opts)
+	if err != nil {
+		return nil, pkgerrors.Wrap(err, "Get PersistentVolumeClaim list error")
+	}
+
+	var result []metaV1.ObjectMeta
+	for _, item := range list.Items {
+		result = append(result, item.ObjectMeta)
+	}
+
+	return result, nil
+}
+
+// ListRetainedResources returns the metadata of the retained
+// persistentvolumeclaims released by their VNF in all namespaces
+func ListRetainedResources(kubeclient *kubernetes.Clientset) ([]metaV1.ObjectMeta, error) {
+	opts := metaV1.ListOptions{
+		LabelSelector: krd.VNFIDLabel + "," + krd.RetainLabel + "=true",
+	}
+
+	list, err := kubeclient.CoreV1().PersistentVolumeClaims(metaV1.NamespaceAll).List(opts)
+	if err != nil {
+		return nil, pkgerrors.Wrap(err, "Get PersistentVolumeClaim list error")
+	}
+
+	var result []metaV1.ObjectMeta
+	for _, item := range list.Items {
+		if _, released := item.Annotations[krd.ReleasedAnnotation]; released {
+			result = append(result, item.ObjectMeta)
+		}
+	}
+
+	return result, nil
+}
+
+// DeleteResource deletes an existing Kubernetes persistentvolumeclaim. Retained
+// claims are only marked as released and keep their data.
+func DeleteResource(name string, namespace string, kubeclient *kubernetes.Clientset) error {
+	if namespace == "" {
+		namespace = "default"
+	}
+
+	pvcs := kubeclient.CoreV1().PersistentVolumeClaims(namespace)
+
+	pvc, err := pvcs.Get(name, metaV1.GetOptions{})
+	if err != nil {
+		return pkgerrors.Wrap(err, "Get PersistentVolumeClaim error")
+	}
+
+	if isRetained(pvc) {
+		log.Println("Retaining persistentvolumeclaim: " + name)
+
+		if pvc.Annotations == nil {
+			pvc.Annotations = make(map[string]string)
+		}
+		pvc.Annotations[krd.ReleasedAnnotation] = time.Now().UTC().Format(time.RFC3339)
+
+		_, err = pvcs.Update(pvc)
+		if err != nil {
+			return pkgerrors.Wrap(err, "Update PersistentVolumeClaim error")
+		}
+		return nil
+	}
+
+	log.Println("Deleting persistentvolumeclaim: " + name)
+
+	deletePolicy := metaV1.DeletePropagationForeground
+	err = pvcs.Delete(name, &metaV1.DeleteOptions{
+		PropagationPolicy: &deletePolicy,
+	})
+	if err != nil {
+		return pkgerrors.Wrap(err, "Delete PersistentVolumeClaim error")
+	}
+
+	return nil
+}
+
+// GetResource existing persistentvolumeclaim hosted in a specific Kubernetes namespace
+func GetResource(name string, namespace string, kubeclient *kubernetes.Clientset) (string, error) {
+	if namespace == "" {
+		namespace = "default"
+	}
+
+	_, err := kubeclient.CoreV1().PersistentVolumeClaims(namespace).Get(name, metaV1.GetOptions{})
+	if err != nil {
+		if k8serrors.IsNotFound(err) {
+			return "", nil
+		}
+		return "", pkgerrors.Wrap(err, "Get PersistentVolumeClaim error")
+	}
+
+	return name, nil
+}
+
+// IsReady checks if a PersistentVolumeClaim is bound to a volume
+func IsReady(name string, namespace string, kubeclient *kubernetes.Clientset) (bool, error) {
+	if namespace == "" {
+		namespace = "default"
+	}
+
+	pvc, err := kubeclient.CoreV1().PersistentVolumeClaims(namespace).Get(name, metaV1.GetOptions{})
+	if err != nil {
+		return false, pkgerrors.Wrap(err, "Get PersistentVolumeClaim error")
+	}
+
+	return pvc.Status.Phase == coreV1.ClaimBound, nil
+}
+
+// GetResourceStatus returns the live state of a PersistentVolumeClaim
+func GetResourceStatus(name string, namespace string, kubeclient *kubernetes.Clientset) (*krd.ResourceStatus, error) {
+	if namespace == "" {
+		namespace = "default"
+	}
+
+	status := &krd.ResourceStatus{Name: name}
+
+	pvc, err := kubeclient.CoreV1().PersistentVolumeClaims(namespace).Get(name, metaV1.GetOptions{})
+	if err != nil {
+		if k8serrors.IsNotFound(err) {
+			return status, nil
+		}
+		return nil, pkgerrors.Wrap(err, "Get PersistentVolumeClaim error")
+	}
+
+	status.Present = true
+	status.Ready = pvc.Status.Phase == coreV1.ClaimBound
+
+	status.Events, err = krd.GetRecentEvents(name, "PersistentVolumeClaim", namespace, kubeclient)
+	if err != nil {
+		return nil, err
+	}
+
+	return status, nil
+}
+
+// DiffResource compares the PersistentVolumeClaim described in kubedata with
+// the one running in the cluster
+func DiffResource(kubedata *krd.GenericKubeResourceData, kubeclient *kubernetes.Clientset) (*krd.ResourceDrift, error) {
+	err := readPVC(kubedata)
+	if err != nil {
+		return nil, err
+	}
+
+	expected := kubedata.PVCData
+	drift := &krd.ResourceDrift{Name: expected.Name}
+
+	live, err := kubeclient.CoreV1().PersistentVolumeClaims(kubedata.Namespace).Get(expected.Name, metaV1.GetOptions{})
+	if err != nil {
+		if k8serrors.IsNotFound(err) {
+			drift.Missing = true
+			return drift, nil
+		}
+		return nil, pkgerrors.Wrap(err, "Get PersistentVolumeClaim error")
+	}
+
+	requested, ok := expected.Spec.Resources.Requests[coreV1.ResourceStorage]
+	if ok {
+		found := live.Spec.Resources.Requests[coreV1.ResourceStorage]
+		if requested.Cmp(found) != 0 {
+			drift.Differences = append(drift.Differences, fmt.Sprintf("spec.resources.requests.storage: expected %s, found %s",
+				requested.String(), found.String()))
+		}
+	}
+
+	return drift, nil
+}
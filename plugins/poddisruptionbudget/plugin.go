@@ -0,0 +1,286 @@
+package main
+
+import (
+	"fmt"
+	"log"
+
+	"k8s.io/client-go/kubernetes"
+
+	pkgerrors "github.com/pkg/errors"
+
+	policyV1beta1 "k8s.io/api/policy/v1beta1"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	metaV1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	"k8s.io/client-go/kubernetes/scheme"
+
+	"k8-plugin-multicloud/krd"
+)
+
+// readPDB reads and decodes the PodDisruptionBudget YAML file referenced by kubedata
+func readPDB(kubedata *krd.GenericKubeResourceData) error {
+	if kubedata.Namespace == "" {
+		kubedata.Namespace = "default"
+	}
+
+	log.Println("Reading poddisruptionbudget YAML")
+	rawBytes, err := krd.ReadYAML(kubedata)
+	if err != nil {
+		return pkgerrors.Wrap(err, "PodDisruptionBudget YAML file read error")
+	}
+
+	log.Println("Decoding poddisruptionbudget YAML")
+	decode := scheme.Codecs.UniversalDeserializer().Decode
+	obj, _, err := decode(rawBytes, nil, nil)
+	if err != nil {
+		return pkgerrors.Wrap(err, "Deserialize poddisruptionbudget error")
+	}
+
+	switch o := obj.(type) {
+	case *policyV1beta1.PodDisruptionBudget:
+		kubedata.PDBData = o
+	default:
+		return pkgerrors.New(kubedata.YamlFilePath + " contains another resource different than PodDisruptionBudget")
+	}
+
+	kubedata.PDBData.Namespace = kubedata.Namespace
+	kubedata.PDBData.Name = kubedata.InternalVNFID + "-" + kubedata.PDBData.Name
+	krd.SetOwnership(&kubedata.PDBData.ObjectMeta, kubedata)
+
+	if kubedata.PDBData.Spec.Selector == nil {
+		return pkgerrors.New(kubedata.YamlFilePath + " describes a PodDisruptionBudget without selector")
+	}
+
+	return nil
+}
+
+// ValidateResource checks that the YAML file describes a PodDisruptionBudget without creating it
+func ValidateResource(kubedata *krd.GenericKubeResourceData) error {
+	return readPDB(kubedata)
+}
+
+// CreateResource object in a specific Kubernetes PodDisruptionBudget
+func CreateResource(kubedata *krd.GenericKubeResourceData, kubeclient *kubernetes.Clientset) (string, error) {
+	err := readPDB(kubedata)
+	if err != nil {
+		return "", err
+	}
+
+	result, err := kubeclient.PolicyV1beta1().PodDisruptionBudgets(kubedata.Namespace).Create(kubedata.PDBData)
+	if err != nil {
+		return "", pkgerrors.Wrap(err, "Create PodDisruptionBudget error")
+	}
+
+	return result.GetObjectMeta().GetName(), nil
+}
+
+// UpdateResource replaces an existing Kubernetes PodDisruptionBudget, whose spec
+// cannot be updated, or creates it when it is not present yet
+func UpdateResource(kubedata *krd.GenericKubeResourceData, kubeclient *kubernetes.Clientset) (string, error) {
+	err := readPDB(kubedata)
+	if err != nil {
+		return "", err
+	}
+
+	pdbs := kubeclient.PolicyV1beta1().PodDisruptionBudgets(kubedata.Namespace)
+
+	_, err = pdbs.Get(kubedata.PDBData.Name, metaV1.GetOptions{})
+	if err == nil {
+		log.Println("Replacing poddisruptionbudget: " + kubedata.PDBData.Name)
+		err = pdbs.Delete(kubedata.PDBData.Name, &metaV1.DeleteOptions{})
+		if err != nil {
+			return "", pkgerrors.Wrap(err, "Delete PodDisruptionBudget error")
+		}
+	} else if !k8serrors.IsNotFound(err) {
+		return "", pkgerrors.Wrap(err, "Get PodDisruptionBudget error")
+	}
+
+	log.Println("Creating poddisruptionbudget: " + kubedata.PDBData.Name)
+	result, err := pdbs.Create(kubedata.PDBData)
+	if err != nil {
+		return "", pkgerrors.Wrap(err, "Create PodDisruptionBudget error")
+	}
+
+	return result.GetObjectMeta().GetName(), nil
+}
+
+// ListResources of existing poddisruptionbudgets hosted in a specific Kubernetes namespace
+func ListResources(limit int64, namespace string, kubeclient *kubernetes.Clientset) (*[]string, error) {
+	if namespace == "" {
+		namespace = "default"
+	}
+
+	opts := metaV1.ListOptions{
+		Limit: limit,
+	}
+	opts.APIVersion = "policy/v1beta1"
+	opts.Kind = "PodDisruptionBudget"
+
+	list, err := kubeclient.PolicyV1beta1().PodDisruptionBudgets(namespace).List(opts)
+	if err != nil {
+		return nil, pkgerrors.Wrap(err, "Get PodDisruptionBudget list error")
+	}
+
+	result := make([]string, 0, limit)
+	if list != nil {
+		for _, pdb := range list.Items {
+			result = append(result, pdb.Name)
+		}
+	}
+
+	return &result, nil
+}
+
+// ListOwnedResources returns the metadata of the poddisruptionbudgets created by the plugin in all namespaces
+func ListOwnedResources(kubeclient *kubernetes.Clientset) ([]metaV1.ObjectMeta, error) {
+	opts := metaV1.ListOptions{
+		LabelSelector: krd.VNFIDLabel,
+	}
+
+	list, err := kubeclient.PolicyV1beta1().PodDisruptionBudgets(metaV1.NamespaceAll).List(opts)
+	if err != nil {
+		return nil, pkgerrors.Wrap(err, "Get PodDisruptionBudget list error")
+	}
+
+	var result []metaV1.ObjectMeta
+	for _, item := range list.Items {
+		result = append(result, item.ObjectMeta)
+	}
+
+	return result, nil
+}
+
+// DeleteResource deletes an existing Kubernetes poddisruptionbudget
+func DeleteResource(name string, namespace string, kubeclient *kubernetes.Clientset) error {
+	if namespace == "" {
+		namespace = "default"
+	}
+
+	log.Println("Deleting poddisruptionbudget: " + name)
+
+	deletePolicy := metaV1.DeletePropagationForeground
+	err := kubeclient.PolicyV1beta1().PodDisruptionBudgets(namespace).Delete(name, &metaV1.DeleteOptions{
+		PropagationPolicy: &deletePolicy,
+	})
+	if err != nil {
+		return pkgerrors.Wrap(err, "Delete PodDisruptionBudget error")
+	}
+
+	return nil
+}
+
+// GetResource existing poddisruptionbudget hosted in a specific Kubernetes namespace
+func GetResource(name string, namespace string, kubeclient *kubernetes.Clientset) (string, error) {
+	if namespace == "" {
+		namespace = "default"
+	}
+
+	_, err := kubeclient.PolicyV1beta1().PodDisruptionBudgets(namespace).Get(name, metaV1.GetOptions{})
+	if err != nil {
+		if k8serrors.IsNotFound(err) {
+			return "", nil
+		}
+		return "", pkgerrors.Wrap(err, "Get PodDisruptionBudget error")
+	}
+
+	return name, nil
+}
+
+// isObserved checks if the disruption controller computed the status of the
+// latest spec of a PodDisruptionBudget
+func isObserved(pdb *policyV1beta1.PodDisruptionBudget) bool {
+	return pdb.Status.ObservedGeneration >= pdb.Generation
+}
+
+// IsReady checks if the status of a PodDisruptionBudget is computed, evictions
+// are refused until then
+func IsReady(name string, namespace string, kubeclient *kubernetes.Clientset) (bool, error) {
+	if namespace == "" {
+		namespace = "default"
+	}
+
+	pdb, err := kubeclient.PolicyV1beta1().PodDisruptionBudgets(namespace).Get(name, metaV1.GetOptions{})
+	if err != nil {
+		return false, pkgerrors.Wrap(err, "Get PodDisruptionBudget error")
+	}
+
+	return isObserved(pdb), nil
+}
+
+// GetResourceStatus returns the live state of a PodDisruptionBudget and the
+// healthy pods it protects
+func GetResourceStatus(name string, namespace string, kubeclient *kubernetes.Clientset) (*krd.ResourceStatus, error) {
+	if namespace == "" {
+		namespace = "default"
+	}
+
+	status := &krd.ResourceStatus{Name: name}
+
+	pdb, err := kubeclient.PolicyV1beta1().PodDisruptionBudgets(namespace).Get(name, metaV1.GetOptions{})
+	if err != nil {
+		if k8serrors.IsNotFound(err) {
+			return status, nil
+		}
+		return nil, pkgerrors.Wrap(err, "Get PodDisruptionBudget error")
+	}
+
+	status.Present = true
+	status.Ready = isObserved(pdb)
+	status.Replicas = pdb.Status.DesiredHealthy
+	status.AvailableReplicas = pdb.Status.CurrentHealthy
+
+	status.Events, err = krd.GetRecentEvents(name, "PodDisruptionBudget", namespace, kubeclient)
+	if err != nil {
+		return nil, err
+	}
+
+	return status, nil
+}
+
+// DiffResource compares the PodDisruptionBudget described in kubedata with the
+// one running in the cluster
+func DiffResource(kubedata *krd.GenericKubeResourceData, kubeclient *kubernetes.Clientset) (*krd.ResourceDrift, error) {
+	err := readPDB(kubedata)
+	if err != nil {
+		return nil, err
+	}
+
+	expected := kubedata.PDBData
+	drift := &krd.ResourceDrift{Name: expected.Name}
+
+	live, err := kubeclient.PolicyV1beta1().PodDisruptionBudgets(kubedata.Namespace).Get(expected.Name, metaV1.GetOptions{})
+	if err != nil {
+		if k8serrors.IsNotFound(err) {
+			drift.Missing = true
+			return drift, nil
+		}
+		return nil, pkgerrors.Wrap(err, "Get PodDisruptionBudget error")
+	}
+
+	if budget(expected.Spec.MinAvailable) != budget(live.Spec.MinAvailable) {
+		drift.Differences = append(drift.Differences, fmt.Sprintf("spec.minAvailable: expected %s, found %s",
+			budget(expected.Spec.MinAvailable), budget(live.Spec.MinAvailable)))
+	}
+
+	if budget(expected.Spec.MaxUnavailable) != budget(live.Spec.MaxUnavailable) {
+		drift.Differences = append(drift.Differences, fmt.Sprintf("spec.maxUnavailable: expected %s, found %s",
+			budget(expected.Spec.MaxUnavailable), budget(live.Spec.MaxUnavailable)))
+	}
+
+	expectedSelector := metaV1.FormatLabelSelector(expected.Spec.Selector)
+	liveSelector := metaV1.FormatLabelSelector(live.Spec.Selector)
+	if expectedSelector != liveSelector {
+		drift.Differences = append(drift.Differences, fmt.Sprintf("spec.selector: expected %s, found %s",
+			expectedSelector, liveSelector))
+	}
+
+	return drift, nil
+}
+
+// budget formats the optional number or percentage of pods of a budget
+func budget(value *intstr.IntOrString) string {
+	if value == nil {
+		return "unset"
+	}
+	return value.String()
+}
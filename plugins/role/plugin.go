@@ -0,0 +1,251 @@
+package main
+
+import (
+	"log"
+	"reflect"
+
+	"k8s.io/client-go/kubernetes"
+
+	pkgerrors "github.com/pkg/errors"
+
+	rbacV1 "k8s.io/api/rbac/v1"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	metaV1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/scheme"
+
+	"k8-plugin-multicloud/krd"
+)
+
+// readRole reads and decodes the Role YAML file referenced by kubedata
+func readRole(kubedata *krd.GenericKubeResourceData) error {
+	if kubedata.Namespace == "" {
+		kubedata.Namespace = "default"
+	}
+
+	log.Println("Reading role YAML")
+	rawBytes, err := krd.ReadYAML(kubedata)
+	if err != nil {
+		return pkgerrors.Wrap(err, "Role YAML file read error")
+	}
+
+	log.Println("Decoding role YAML")
+	decode := scheme.Codecs.UniversalDeserializer().Decode
+	obj, _, err := decode(rawBytes, nil, nil)
+	if err != nil {
+		return pkgerrors.Wrap(err, "Deserialize role error")
+	}
+
+	switch o := obj.(type) {
+	case *rbacV1.Role:
+		kubedata.RoleData = o
+	default:
+		return pkgerrors.New(kubedata.YamlFilePath + " contains another resource different than Role")
+	}
+
+	kubedata.RoleData.Namespace = kubedata.Namespace
+	kubedata.RoleData.Name = kubedata.InternalVNFID + "-" + kubedata.RoleData.Name
+	krd.SetOwnership(&kubedata.RoleData.ObjectMeta, kubedata)
+
+	return nil
+}
+
+// ValidateResource checks that the YAML file describes a Role without creating it
+func ValidateResource(kubedata *krd.GenericKubeResourceData) error {
+	return readRole(kubedata)
+}
+
+// CreateResource object in a specific Kubernetes Role
+func CreateResource(kubedata *krd.GenericKubeResourceData, kubeclient *kubernetes.Clientset) (string, error) {
+	err := readRole(kubedata)
+	if err != nil {
+		return "", err
+	}
+
+	result, err := kubeclient.RbacV1().Roles(kubedata.Namespace).Create(kubedata.RoleData)
+	if err != nil {
+		return "", pkgerrors.Wrap(err, "Create Role error")
+	}
+
+	return result.GetObjectMeta().GetName(), nil
+}
+
+// UpdateResource updates an existing Kubernetes Role in place or creates it
+// when it is not present yet
+func UpdateResource(kubedata *krd.GenericKubeResourceData, kubeclient *kubernetes.Clientset) (string, error) {
+	err := readRole(kubedata)
+	if err != nil {
+		return "", err
+	}
+
+	roles := kubeclient.RbacV1().Roles(kubedata.Namespace)
+
+	existing, err := roles.Get(kubedata.RoleData.Name, metaV1.GetOptions{})
+	if err != nil {
+		if !k8serrors.IsNotFound(err) {
+			return "", pkgerrors.Wrap(err, "Get Role error")
+		}
+
+		log.Println("Creating role: " + kubedata.RoleData.Name)
+		result, err := roles.Create(kubedata.RoleData)
+		if err != nil {
+			return "", pkgerrors.Wrap(err, "Create Role error")
+		}
+		return result.GetObjectMeta().GetName(), nil
+	}
+
+	log.Println("Updating role: " + kubedata.RoleData.Name)
+	kubedata.RoleData.ResourceVersion = existing.ResourceVersion
+
+	result, err := roles.Update(kubedata.RoleData)
+	if err != nil {
+		return "", pkgerrors.Wrap(err, "Update Role error")
+	}
+
+	return result.GetObjectMeta().GetName(), nil
+}
+
+// ListResources of existing roles hosted in a specific Kubernetes namespace
+func ListResources(limit int64, namespace string, kubeclient *kubernetes.Clientset) (*[]string, error) {
+	if namespace == "" {
+		namespace = "default"
+	}
+
+	opts := metaV1.ListOptions{
+		Limit: limit,
+	}
+	opts.APIVersion = "rbac.authorization.k8s.io/v1"
+	opts.Kind = "Role"
+
+	list, err := kubeclient.RbacV1().Roles(namespace).List(opts)
+	if err != nil {
+		return nil, pkgerrors.Wrap(err, "Get Role list error")
+	}
+
+	result := make([]string, 0, limit)
+	if list != nil {
+		for _, role := range list.Items {
+			result = append(result, role.Name)
+		}
+	}
+
+	return &result, nil
+}
+
+// ListOwnedResources returns the metadata of the roles created by the plugin in all namespaces
+func ListOwnedResources(kubeclient *kubernetes.Clientset) ([]metaV1.ObjectMeta, error) {
+	opts := metaV1.ListOptions{
+		LabelSelector: krd.VNFIDLabel,
+	}
+
+	list, err := kubeclient.RbacV1().Roles(metaV1.NamespaceAll).List(opts)
+	if err != nil {
+		return nil, pkgerrors.Wrap(err, "Get Role list error")
+	}
+
+	var result []metaV1.ObjectMeta
+	for _, item := range list.Items {
+		result = append(result, item.ObjectMeta)
+	}
+
+	return result, nil
+}
+
+// DeleteResource deletes an existing Kubernetes role
+func DeleteResource(name string, namespace string, kubeclient *kubernetes.Clientset) error {
+	if namespace == "" {
+		namespace = "default"
+	}
+
+	log.Println("Deleting role: " + name)
+
+	deletePolicy := metaV1.DeletePropagationForeground
+	err := kubeclient.RbacV1().Roles(namespace).Delete(name, &metaV1.DeleteOptions{
+		PropagationPolicy: &deletePolicy,
+	})
+	if err != nil {
+		return pkgerrors.Wrap(err, "Delete Role error")
+	}
+
+	return nil
+}
+
+// GetResource existing role hosted in a specific Kubernetes namespace
+func GetResource(name string, namespace string, kubeclient *kubernetes.Clientset) (string, error) {
+	if namespace == "" {
+		namespace = "default"
+	}
+
+	_, err := kubeclient.RbacV1().Roles(namespace).Get(name, metaV1.GetOptions{})
+	if err != nil {
+		if k8serrors.IsNotFound(err) {
+			return "", nil
+		}
+		return "", pkgerrors.Wrap(err, "Get Role error")
+	}
+
+	return name, nil
+}
+
+// IsReady checks if a Role exists, it is enforced as soon as it is created
+func IsReady(name string, namespace string, kubeclient *kubernetes.Clientset) (bool, error) {
+	found, err := GetResource(name, namespace, kubeclient)
+	if err != nil {
+		return false, err
+	}
+
+	return found != "", nil
+}
+
+// GetResourceStatus returns the live state of a Role
+func GetResourceStatus(name string, namespace string, kubeclient *kubernetes.Clientset) (*krd.ResourceStatus, error) {
+	if namespace == "" {
+		namespace = "default"
+	}
+
+	status := &krd.ResourceStatus{Name: name}
+
+	found, err := GetResource(name, namespace, kubeclient)
+	if err != nil {
+		return nil, err
+	}
+	if found == "" {
+		return status, nil
+	}
+
+	status.Present = true
+	status.Ready = true
+
+	status.Events, err = krd.GetRecentEvents(name, "Role", namespace, kubeclient)
+	if err != nil {
+		return nil, err
+	}
+
+	return status, nil
+}
+
+// DiffResource compares the Role described in kubedata with the one running
+// in the cluster
+func DiffResource(kubedata *krd.GenericKubeResourceData, kubeclient *kubernetes.Clientset) (*krd.ResourceDrift, error) {
+	err := readRole(kubedata)
+	if err != nil {
+		return nil, err
+	}
+
+	expected := kubedata.RoleData
+	drift := &krd.ResourceDrift{Name: expected.Name}
+
+	live, err := kubeclient.RbacV1().Roles(kubedata.Namespace).Get(expected.Name, metaV1.GetOptions{})
+	if err != nil {
+		if k8serrors.IsNotFound(err) {
+			drift.Missing = true
+			return drift, nil
+		}
+		return nil, pkgerrors.Wrap(err, "Get Role error")
+	}
+
+	if !reflect.DeepEqual(expected.Rules, live.Rules) {
+		drift.Differences = append(drift.Differences, "rules: content differs")
+	}
+
+	return drift, nil
+}
@@ -0,0 +1,257 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"reflect"
+
+	"k8s.io/client-go/kubernetes"
+
+	pkgerrors "github.com/pkg/errors"
+
+	rbacV1 "k8s.io/api/rbac/v1"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	metaV1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/scheme"
+
+	"k8-plugin-multicloud/krd"
+)
+
+// readRoleBinding reads and decodes the RoleBinding YAML file referenced by kubedata
+func readRoleBinding(kubedata *krd.GenericKubeResourceData) error {
+	if kubedata.Namespace == "" {
+		kubedata.Namespace = "default"
+	}
+
+	log.Println("Reading rolebinding YAML")
+	rawBytes, err := krd.ReadYAML(kubedata)
+	if err != nil {
+		return pkgerrors.Wrap(err, "RoleBinding YAML file read error")
+	}
+
+	log.Println("Decoding rolebinding YAML")
+	decode := scheme.Codecs.UniversalDeserializer().Decode
+	obj, _, err := decode(rawBytes, nil, nil)
+	if err != nil {
+		return pkgerrors.Wrap(err, "Deserialize rolebinding error")
+	}
+
+	switch o := obj.(type) {
+	case *rbacV1.RoleBinding:
+		kubedata.RoleBindingData = o
+	default:
+		return pkgerrors.New(kubedata.YamlFilePath + " contains another resource different than RoleBinding")
+	}
+
+	kubedata.RoleBindingData.Namespace = kubedata.Namespace
+	kubedata.RoleBindingData.Name = kubedata.InternalVNFID + "-" + kubedata.RoleBindingData.Name
+	krd.SetOwnership(&kubedata.RoleBindingData.ObjectMeta, kubedata)
+
+	return nil
+}
+
+// ValidateResource checks that the YAML file describes a RoleBinding without creating it
+func ValidateResource(kubedata *krd.GenericKubeResourceData) error {
+	return readRoleBinding(kubedata)
+}
+
+// CreateResource object in a specific Kubernetes RoleBinding
+func CreateResource(kubedata *krd.GenericKubeResourceData, kubeclient *kubernetes.Clientset) (string, error) {
+	err := readRoleBinding(kubedata)
+	if err != nil {
+		return "", err
+	}
+
+	result, err := kubeclient.RbacV1().RoleBindings(kubedata.Namespace).Create(kubedata.RoleBindingData)
+	if err != nil {
+		return "", pkgerrors.Wrap(err, "Create RoleBinding error")
+	}
+
+	return result.GetObjectMeta().GetName(), nil
+}
+
+// UpdateResource updates an existing Kubernetes RoleBinding in place or creates
+// it when it is not present yet. The role of a binding cannot be changed.
+func UpdateResource(kubedata *krd.GenericKubeResourceData, kubeclient *kubernetes.Clientset) (string, error) {
+	err := readRoleBinding(kubedata)
+	if err != nil {
+		return "", err
+	}
+
+	roleBindings := kubeclient.RbacV1().RoleBindings(kubedata.Namespace)
+
+	existing, err := roleBindings.Get(kubedata.RoleBindingData.Name, metaV1.GetOptions{})
+	if err != nil {
+		if !k8serrors.IsNotFound(err) {
+			return "", pkgerrors.Wrap(err, "Get RoleBinding error")
+		}
+
+		log.Println("Creating rolebinding: " + kubedata.RoleBindingData.Name)
+		result, err := roleBindings.Create(kubedata.RoleBindingData)
+		if err != nil {
+			return "", pkgerrors.Wrap(err, "Create RoleBinding error")
+		}
+		return result.GetObjectMeta().GetName(), nil
+	}
+
+	log.Println("Updating rolebinding: " + kubedata.RoleBindingData.Name)
+	kubedata.RoleBindingData.ResourceVersion = existing.ResourceVersion
+
+	result, err := roleBindings.Update(kubedata.RoleBindingData)
+	if err != nil {
+		return "", pkgerrors.Wrap(err, "Update RoleBinding error")
+	}
+
+	return result.GetObjectMeta().GetName(), nil
+}
+
+// ListResources of existing rolebindings hosted in a specific Kubernetes namespace
+func ListResources(limit int64, namespace string, kubeclient *kubernetes.Clientset) (*[]string, error) {
+	if namespace == "" {
+		namespace = "default"
+	}
+
+	opts := metaV1.ListOptions{
+		Limit: limit,
+	}
+	opts.APIVersion = "rbac.authorization.k8s.io/v1"
+	opts.Kind = "RoleBinding"
+
+	list, err := kubeclient.RbacV1().RoleBindings(namespace).List(opts)
+	if err != nil {
+		return nil, pkgerrors.Wrap(err, "Get RoleBinding list error")
+	}
+
+	result := make([]string, 0, limit)
+	if list != nil {
+		for _, roleBinding := range list.Items {
+			result = append(result, roleBinding.Name)
+		}
+	}
+
+	return &result, nil
+}
+
+// ListOwnedResources returns the metadata of the rolebindings created by the plugin in all namespaces
+func ListOwnedResources(kubeclient *kubernetes.Clientset) ([]metaV1.ObjectMeta, error) {
+	opts := metaV1.ListOptions{
+		LabelSelector: krd.VNFIDLabel,
+	}
+
+	list, err := kubeclient.RbacV1().RoleBindings(metaV1.NamespaceAll).List(opts)
+	if err != nil {
+		return nil, pkgerrors.Wrap(err, "Get RoleBinding list error")
+	}
+
+	var result []metaV1.ObjectMeta
+	for _, item := range list.Items {
+		result = append(result, item.ObjectMeta)
+	}
+
+	return result, nil
+}
+
+// DeleteResource deletes an existing Kubernetes rolebinding
+func DeleteResource(name string, namespace string, kubeclient *kubernetes.Clientset) error {
+	if namespace == "" {
+		namespace = "default"
+	}
+
+	log.Println("Deleting rolebinding: " + name)
+
+	deletePolicy := metaV1.DeletePropagationForeground
+	err := kubeclient.RbacV1().RoleBindings(namespace).Delete(name, &metaV1.DeleteOptions{
+		PropagationPolicy: &deletePolicy,
+	})
+	if err != nil {
+		return pkgerrors.Wrap(err, "Delete RoleBinding error")
+	}
+
+	return nil
+}
+
+// GetResource existing rolebinding hosted in a specific Kubernetes namespace
+func GetResource(name string, namespace string, kubeclient *kubernetes.Clientset) (string, error) {
+	if namespace == "" {
+		namespace = "default"
+	}
+
+	_, err := kubeclient.RbacV1().RoleBindings(namespace).Get(name, metaV1.GetOptions{})
+	if err != nil {
+		if k8serrors.IsNotFound(err) {
+			return "", nil
+		}
+		return "", pkgerrors.Wrap(err, "Get RoleBinding error")
+	}
+
+	return name, nil
+}
+
+// IsReady checks if a RoleBinding exists, it is enforced as soon as it is created
+func IsReady(name string, namespace string, kubeclient *kubernetes.Clientset) (bool, error) {
+	found, err := GetResource(name, namespace, kubeclient)
+	if err != nil {
+		return false, err
+	}
+
+	return found != "", nil
+}
+
+// GetResourceStatus returns the live state of a RoleBinding
+func GetResourceStatus(name string, namespace string, kubeclient *kubernetes.Clientset) (*krd.ResourceStatus, error) {
+	if namespace == "" {
+		namespace = "default"
+	}
+
+	status := &krd.ResourceStatus{Name: name}
+
+	found, err := GetResource(name, namespace, kubeclient)
+	if err != nil {
+		return nil, err
+	}
+	if found == "" {
+		return status, nil
+	}
+
+	status.Present = true
+	status.Ready = true
+
+	status.Events, err = krd.GetRecentEvents(name, "RoleBinding", namespace, kubeclient)
+	if err != nil {
+		return nil, err
+	}
+
+	return status, nil
+}
+
+// DiffResource compares the RoleBinding described in kubedata with the one
+// running in the cluster
+func DiffResource(kubedata *krd.GenericKubeResourceData, kubeclient *kubernetes.Clientset) (*krd.ResourceDrift, error) {
+	err := readRoleBinding(kubedata)
+	if err != nil {
+		return nil, err
+	}
+
+	expected := kubedata.RoleBindingData
+	drift := &krd.ResourceDrift{Name: expected.Name}
+
+	live, err := kubeclient.RbacV1().RoleBindings(kubedata.Namespace).Get(expected.Name, metaV1.GetOptions{})
+	if err != nil {
+		if k8serrors.IsNotFound(err) {
+			drift.Missing = true
+			return drift, nil
+		}
+		return nil, pkgerrors.Wrap(err, "Get RoleBinding error")
+	}
+
+	if expected.RoleRef.Kind != live.RoleRef.Kind || expected.RoleRef.Name != live.RoleRef.Name {
+		drift.Differences = append(drift.Differences, fmt.Sprintf("roleRef: expected %s %s, found %s %s",
+			expected.RoleRef.Kind, expected.RoleRef.Name, live.RoleRef.Kind, live.RoleRef.Name))
+	}
+
+	if !reflect.DeepEqual(expected.Subjects, live.Subjects) {
+		drift.Differences = append(drift.Differences, "subjects: content differs")
+	}
+
+	return drift, nil
+}
@@ -0,0 +1,260 @@
+package main
+
+import (
+	"log"
+
+	"k8s.io/client-go/kubernetes"
+
+	pkgerrors "github.com/pkg/errors"
+
+	coreV1 "k8s.io/api/core/v1"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	metaV1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/scheme"
+
+	"k8-plugin-multicloud/krd"
+)
+
+// readSecret reads and decodes the Secret YAML file referenced by kubedata. The
+// plugin only logs the names of the secrets, never their data.
+func readSecret(kubedata *krd.GenericKubeResourceData) error {
+	if kubedata.Namespace == "" {
+		kubedata.Namespace = "default"
+	}
+
+	log.Println("Reading secret YAML")
+	rawBytes, err := krd.ReadYAML(kubedata)
+	if err != nil {
+		return pkgerrors.Wrap(err, "Secret YAML file read error")
+	}
+
+	log.Println("Decoding secret YAML")
+	decode := scheme.Codecs.UniversalDeserializer().Decode
+	obj, _, err := decode(rawBytes, nil, nil)
+	if err != nil {
+		return pkgerrors.Wrap(err, "Deserialize secret error")
+	}
+
+	switch o := obj.(type) {
+	case *coreV1.Secret:
+		kubedata.SecretData = o
+	default:
+		return pkgerrors.New(kubedata.YamlFilePath + " contains another resource different than Secret")
+	}
+
+	kubedata.SecretData.Namespace = kubedata.Namespace
+	kubedata.SecretData.Name = kubedata.InternalVNFID + "-" + kubedata.SecretData.Name
+	krd.SetOwnership(&kubedata.SecretData.ObjectMeta, kubedata)
+
+	return nil
+}
+
+// ValidateResource checks that the YAML file describes a Secret without creating it
+func ValidateResource(kubedata *krd.GenericKubeResourceData) error {
+	return readSecret(kubedata)
+}
+
+// CreateResource object in a specific Kubernetes Secret
+func CreateResource(kubedata *krd.GenericKubeResourceData, kubeclient *kubernetes.Clientset) (string, error) {
+	err := readSecret(kubedata)
+	if err != nil {
+		return "", err
+	}
+
+	result, err := kubeclient.CoreV1().Secrets(kubedata.Namespace).Create(kubedata.SecretData)
+	if err != nil {
+		return "", pkgerrors.Wrap(err, "Create Secret error")
+	}
+
+	return result.GetObjectMeta().GetName(), nil
+}
+
+// UpdateResource updates an existing Kubernetes Secret in place or creates it
+// when it is not present yet
+func UpdateResource(kubedata *krd.GenericKubeResourceData, kubeclient *kubernetes.Clientset) (string, error) {
+	err := readSecret(kubedata)
+	if err != nil {
+		return "", err
+	}
+
+	secrets := kubeclient.CoreV1().Secrets(kubedata.Namespace)
+
+	existing, err := secrets.Get(kubedata.SecretData.Name, metaV1.GetOptions{})
+	if err != nil {
+		if !k8serrors.IsNotFound(err) {
+			return "", pkgerrors.Wrap(err, "Get Secret error")
+		}
+
+		log.Println("Creating secret: " + kubedata.SecretData.Name)
+		result, err := secrets.Create(kubedata.SecretData)
+		if err != nil {
+			return "", pkgerrors.Wrap(err, "Create Secret error")
+		}
+		return result.GetObjectMeta().GetName(), nil
+	}
+
+	log.Println("Updating secret: " + kubedata.SecretData.Name)
+	kubedata.SecretData.ResourceVersion = existing.ResourceVersion
+
+	result, err := secrets.Update(kubedata.SecretData)
+	if err != nil {
+		return "", pkgerrors.Wrap(err, "Update Secret error")
+	}
+	return result.GetObjectMeta().GetName(), nil
+}
+
+// ListResources of existing secrets hosted in a specific Kubernetes namespace
+func ListResources(limit int64, namespace string, kubeclient *kubernetes.Clientset) (*[]string, error) {
+	if namespace == "" {
+		namespace = "default"
+	}
+
+	opts := metaV1.ListOptions{
+		Limit: limit,
+	}
+	opts.APIVersion = "v1"
+	opts.Kind = "Secret"
+
+	list, err := kubeclient.CoreV1().Secrets(namespace).List(opts)
+	if err != nil {
+		return nil, pkgerrors.Wrap(err, "Get Secret list error")
+	}
+
+	result := make([]string, 0, limit)
+	if list != nil {
+		for _, secret := range list.Items {
+			result = append(result, secret.Name)
+		}
+	}
+
+	return &result, nil
+}
+
+// ListOwnedResources returns the metadata of the secrets created by the plugin in all namespaces
+func ListOwnedResources(kubeclient *kubernetes.Clientset) ([]metaV1.ObjectMeta, error) {
+	opts := metaV1.ListOptions{
+		LabelSelector: krd.VNFIDLabel,
+	}
+
+	list, err := kubeclient.CoreV1().Secrets(metaV1.NamespaceAll).List(opts)
+	if err != nil {
+		return nil, pkgerrors.Wrap(err, "Get Secret list error")
+	}
+
+	var result []metaV1.ObjectMeta
+	for _, item := range list.Items {
+		result = append(result, item.ObjectMeta)
+	}
+
+	return result, nil
+}
+
+// DeleteResource deletes an existing Kubernetes secret
+func DeleteResource(name string, namespace string, kubeclient *kubernetes.Clientset) error {
+	if namespace == "" {
+		namespace = "default"
+	}
+
+	log.Println("Deleting secret: " + name)
+
+	deletePolicy := metaV1.DeletePropagationForeground
+	err := kubeclient.CoreV1().Secrets(namespace).Delete(name, &metaV1.DeleteOptions{
+		PropagationPolicy: &deletePolicy,
+	})
+	if err != nil {
+		return pkgerrors.Wrap(err, "Delete Secret error")
+	}
+
+	return nil
+}
+
+// GetResource existing secret hosted in a specific Kubernetes namespace
+func GetResource(name string, namespace string, kubeclient *kubernetes.Clientset) (string, error) {
+	if namespace == "" {
+		namespace = "default"
+	}
+
+	_, err := kubeclient.CoreV1().Secrets(namespace).Get(name, metaV1.GetOptions{})
+	if err != nil {
+		if k8serrors.IsNotFound(err) {
+			return "", nil
+		}
+		return "", pkgerrors.Wrap(err, "Get Secret error")
+	}
+
+	return name, nil
+}
+
+// IsReady checks if a Secret exists, it is usable as soon as it is created
+func IsReady(name string, namespace string, kubeclient *kubernetes.Clientset) (bool, error) {
+	found, err := GetResource(name, namespace, kubeclient)
+	if err != nil {
+		return false, err
+	}
+
+	return found != "", nil
+}
+
+// GetResourceStatus returns the live state of a Secret
+func GetResourceStatus(name string, namespace string, kubeclient *kubernetes.Clientset) (*krd.ResourceStatus, error) {
+	if namespace == "" {
+		namespace = "default"
+	}
+
+	status := &krd.ResourceStatus{Name: name}
+
+	found, err := GetResource(name, namespace, kubeclient)
+	if err != nil {
+		return nil, err
+	}
+	if found == "" {
+		return status, nil
+	}
+
+	status.Present = true
+	status.Ready = true
+
+	status.Events, err = krd.GetRecentEvents(name, "Secret", namespace, kubeclient)
+	if err != nil {
+		return nil, err
+	}
+
+	return status, nil
+}
+
+// DiffResource compares the Secret described in kubedata with the one
+// running in the cluster
+func DiffResource(kubedata *krd.GenericKubeResourceData, kubeclient *kubernetes.Clientset) (*krd.ResourceDrift, error) {
+	err := readSecret(kubedata)
+	if err != nil {
+		return nil, err
+	}
+
+	expected := kubedata.SecretData
+	drift := &krd.ResourceDrift{Name: expected.Name}
+
+	live, err := kubeclient.CoreV1().Secrets(kubedata.Namespace).Get(expected.Name, metaV1.GetOptions{})
+	if err != nil {
+		if k8serrors.IsNotFound(err) {
+			drift.Missing = true
+			return drift, nil
+		}
+		return nil, pkgerrors.Wrap(err, "Get Secret error")
+	}
+
+	// The API server merges stringData into data
+	expectedData := make(map[string][]byte)
+	for key, value := range expected.Data {
+		expectedData[key] = value
+	}
+	for key, value := range expected.StringData {
+		expectedData[key] = []byte(value)
+	}
+
+	if expected.Type != "" && expected.Type != live.Type {
+		drift.Differences = append(drift.Differences, "type: expected "+string(expected.Type)+", found "+string(live.Type))
+	}
+	drift.Differences = append(drift.Differences, krd.DiffData("data", expectedData, live.Data)...)
+
+	return drift, nil
+}
@@ -1,53 +1,67 @@
 package main
 
 import (
-	"io/ioutil"
+	"fmt"
 	"log"
-	"os"
+	"reflect"
+	"strconv"
 
 	"k8s.io/client-go/kubernetes"
 
 	pkgerrors "github.com/pkg/errors"
 
 	coreV1 "k8s.io/api/core/v1"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
 	metaV1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/client-go/kubernetes/scheme"
 
 	"k8-plugin-multicloud/krd"
 )
 
-// CreateResource object in a specific Kubernetes Deployment
-func CreateResource(kubedata *krd.GenericKubeResourceData, kubeclient *kubernetes.Clientset) (string, error) {
+// readService reads and decodes the Service YAML file referenced by kubedata
+func readService(kubedata *krd.GenericKubeResourceData) error {
 	if kubedata.Namespace == "" {
 		kubedata.Namespace = "default"
 	}
 
-	if _, err := os.Stat(kubedata.YamlFilePath); err != nil {
-		return "", pkgerrors.New("File " + kubedata.YamlFilePath + " not found")
-	}
-
 	log.Println("Reading service YAML")
-	rawBytes, err := ioutil.ReadFile(kubedata.YamlFilePath)
+	rawBytes, err := krd.ReadYAML(kubedata)
 	if err != nil {
-		return "", pkgerrors.Wrap(err, "Service YAML file read error")
+		return pkgerrors.Wrap(err, "Service YAML file read error")
 	}
 
 	log.Println("Decoding service YAML")
 	decode := scheme.Codecs.UniversalDeserializer().Decode
 	obj, _, err := decode(rawBytes, nil, nil)
 	if err != nil {
-		return "", pkgerrors.Wrap(err, "Deserialize service error")
+		return pkgerrors.Wrap(err, "Deserialize service error")
 	}
 
 	switch o := obj.(type) {
 	case *coreV1.Service:
 		kubedata.ServiceData = o
 	default:
-		return "", pkgerrors.New(kubedata.YamlFilePath + " contains another resource different than Service")
+		return pkgerrors.New(kubedata.YamlFilePath + " contains another resource different than Service")
 	}
 
 	kubedata.ServiceData.Namespace = kubedata.Namespace
 	kubedata.ServiceData.Name = kubedata.InternalVNFID + "-" + kubedata.ServiceData.Name
+	krd.SetOwnership(&kubedata.ServiceData.ObjectMeta, kubedata)
+
+	return nil
+}
+
+// ValidateResource checks that the YAML file describes a Service without creating it
+func ValidateResource(kubedata *krd.GenericKubeResourceData) error {
+	return readService(kubedata)
+}
+
+// CreateResource object in a specific Kubernetes Deployment
+func CreateResource(kubedata *krd.GenericKubeResourceData, kubeclient *kubernetes.Clientset) (string, error) {
+	err := readService(kubedata)
+	if err != nil {
+		return "", err
+	}
 
 	result, err := kubeclient.CoreV1().Services(kubedata.Namespace).Create(kubedata.ServiceData)
 	if err != nil {
@@ -56,6 +70,42 @@ func CreateResource(kubedata *krd.GenericKubeResourceData, kubeclient *kubernete
 	return result.GetObjectMeta().GetName(), nil
 }
 
+// UpdateResource updates an existing Kubernetes Service in place or creates it
+// when it is not present yet
+func UpdateResource(kubedata *krd.GenericKubeResourceData, kubeclient *kubernetes.Clientset) (string, error) {
+	err := readService(kubedata)
+	if err != nil {
+		return "", err
+	}
+
+	services := kubeclient.CoreV1().Services(kubedata.Namespace)
+
+	existing, err := services.Get(kubedata.ServiceData.Name, metaV1.GetOptions{})
+	if err != nil {
+		if !k8serrors.IsNotFound(err) {
+			return "", pkgerrors.Wrap(err, "Get Service error")
+		}
+
+		log.Println("Creating service: " + kubedata.ServiceData.Name)
+		result, err := services.Create(kubedata.ServiceData)
+		if err != nil {
+			return "", pkgerrors.Wrap(err, "Create Service error")
+		}
+		return result.GetObjectMeta().GetName(), nil
+	}
+
+	log.Println("Updating service: " + kubedata.ServiceData.Name)
+	kubedata.ServiceData.ResourceVersion = existing.ResourceVersion
+	// ClusterIP is immutable once allocated
+	kubedata.ServiceData.Spec.ClusterIP = existing.Spec.ClusterIP
+
+	result, err := services.Update(kubedata.ServiceData)
+	if err != nil {
+		return "", pkgerrors.Wrap(err, "Update Service error")
+	}
+	return result.GetObjectMeta().GetName(), nil
+}
+
 // ListResources of existing deployments hosted in a specific Kubernetes Deployment
 func ListResources(limit int64, namespace string, kubeclient *kubernetes.Clientset) (*[]string, error) {
 	if namespace == "" {
@@ -80,6 +130,25 @@ func ListResources(limit int64, namespace string, kubeclient *kubernetes.Clients
 	return &result, nil
 }
 
+// ListOwnedResources returns the metadata of the services created by the plugin in all namespaces
+func ListOwnedResources(kubeclient *kubernetes.Clientset) ([]metaV1.ObjectMeta, error) {
+	opts := metaV1.ListOptions{
+		LabelSelector: krd.VNFIDLabel,
+	}
+
+	list, err := kubeclient.CoreV1().Services(metaV1.NamespaceAll).List(opts)
+	if err != nil {
+		return nil, pkgerrors.Wrap(err, "Get Service list error")
+	}
+
+	var result []metaV1.ObjectMeta
+	for _, item := range list.Items {
+		result = append(result, item.ObjectMeta)
+	}
+
+	return result, nil
+}
+
 // DeleteResource deletes an existing Kubernetes service
 func DeleteResource(name string, namespace string, kubeclient *kubernetes.Clientset) error {
 	if namespace == "" {
@@ -124,3 +193,120 @@ func GetResource(name string, namespace string, kubeclient *kubernetes.Clientset
 
 	return "", nil
 }
+
+// hasEndpoints checks if a Service has at least one ready endpoint. Services
+// without selector are ready as soon as they exist since their endpoints are
+// managed outside of Kubernetes.
+func hasEndpoints(service *coreV1.Service, kubeclient *kubernetes.Clientset) (bool, error) {
+	if len(service.Spec.Selector) == 0 || service.Spec.Type == coreV1.ServiceTypeExternalName {
+		return true, nil
+	}
+
+	endpoints, err := kubeclient.CoreV1().Endpoints(service.Namespace).Get(service.Name, metaV1.GetOptions{})
+	if err != nil {
+		if k8serrors.IsNotFound(err) {
+			return false, nil
+		}
+		return false, pkgerrors.Wrap(err, "Get Endpoints error")
+	}
+
+	for _, subset := range endpoints.Subsets {
+		if len(subset.Addresses) > 0 {
+			return true, nil
+		}
+	}
+
+	return false, nil
+}
+
+// IsReady checks if a Service has at least one ready endpoint
+func IsReady(name string, namespace string, kubeclient *kubernetes.Clientset) (bool, error) {
+	if namespace == "" {
+		namespace = "default"
+	}
+
+	service, err := kubeclient.CoreV1().Services(namespace).Get(name, metaV1.GetOptions{})
+	if err != nil {
+		return false, pkgerrors.Wrap(err, "Get Service error")
+	}
+
+	return hasEndpoints(service, kubeclient)
+}
+
+// GetResourceStatus returns the live state of a Service
+func GetResourceStatus(name string, namespace string, kubeclient *kubernetes.Clientset) (*krd.ResourceStatus, error) {
+	if namespace == "" {
+		namespace = "default"
+	}
+
+	status := &krd.ResourceStatus{Name: name}
+
+	service, err := kubeclient.CoreV1().Services(namespace).Get(name, metaV1.GetOptions{})
+	if err != nil {
+		if k8serrors.IsNotFound(err) {
+			return status, nil
+		}
+		return nil, pkgerrors.Wrap(err, "Get Service error")
+	}
+
+	status.Present = true
+	status.ClusterIP = service.Spec.ClusterIP
+	for _, port := range service.Spec.Ports {
+		status.Ports = append(status.Ports, strconv.Itoa(int(port.Port))+"/"+string(port.Protocol))
+	}
+
+	status.Ready, err = hasEndpoints(service, kubeclient)
+	if err != nil {
+		return nil, err
+	}
+
+	status.Events, err = krd.GetRecentEvents(name, "Service", namespace, kubeclient)
+	if err != nil {
+		return nil, err
+	}
+
+	return status, nil
+}
+
+// DiffResource compares the Service described in kubedata with the one
+// running in the cluster
+func DiffResource(kubedata *krd.GenericKubeResourceData, kubeclient *kubernetes.Clientset) (*krd.ResourceDrift, error) {
+	err := readService(kubedata)
+	if err != nil {
+		return nil, err
+	}
+
+	expected := kubedata.ServiceData
+	drift := &krd.ResourceDrift{Name: expected.Name}
+
+	live, err := kubeclient.CoreV1().Services(kubedata.Namespace).Get(expected.Name, metaV1.GetOptions{})
+	if err != nil {
+		if k8serrors.IsNotFound(err) {
+			drift.Missing = true
+			return drift, nil
+		}
+		return nil, pkgerrors.Wrap(err, "Get Service error")
+	}
+
+	if !reflect.DeepEqual(expected.Spec.Selector, live.Spec.Selector) {
+		drift.Differences = append(drift.Differences, fmt.Sprintf("spec.selector: expected %v, found %v",
+			expected.Spec.Selector, live.Spec.Selector))
+	}
+
+	livePorts := make(map[string]bool)
+	for _, port := range live.Spec.Ports {
+		livePorts[strconv.Itoa(int(port.Port))+"/"+string(port.Protocol)] = true
+	}
+	for _, port := range expected.Spec.Ports {
+		protocol := port.Protocol
+		if protocol == "" {
+			protocol = coreV1.ProtocolTCP
+		}
+		key := strconv.Itoa(int(port.Port)) + "/" + string(protocol)
+		if !livePorts[key] {
+			drift.Differences = append(drift.Differences, "spec.ports: "+key+" not found")
+		}
+	}
+
+	return drift, nil
+}
@@ -0,0 +1,245 @@
+package main
+
+import (
+	"log"
+
+	"k8s.io/client-go/kubernetes"
+
+	pkgerrors "github.com/pkg/errors"
+
+	coreV1 "k8s.io/api/core/v1"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	metaV1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/scheme"
+
+	"k8-plugin-multicloud/krd"
+)
+
+// readServiceAccount reads and decodes the ServiceAccount YAML file referenced by kubedata
+func readServiceAccount(kubedata *krd.GenericKubeResourceData) error {
+	if kubedata.Namespace == "" {
+		kubedata.Namespace = "default"
+	}
+
+	log.Println("Reading serviceaccount YAML")
+	rawBytes, err := krd.ReadYAML(kubedata)
+	if err != nil {
+		return pkgerrors.Wrap(err, "ServiceAccount YAML file read error")
+	}
+
+	log.Println("Decoding serviceaccount YAML")
+	decode := scheme.Codecs.UniversalDeserializer().Decode
+	obj, _, err := decode(rawBytes, nil, nil)
+	if err != nil {
+		return pkgerrors.Wrap(err, "Deserialize serviceaccount error")
+	}
+
+	switch o := obj.(type) {
+	case *coreV1.ServiceAccount:
+		kubedata.ServiceAccountData = o
+	default:
+		return pkgerrors.New(kubedata.YamlFilePath + " contains another resource different than ServiceAccount")
+	}
+
+	kubedata.ServiceAccountData.Namespace = kubedata.Namespace
+	kubedata.ServiceAccountData.Name = kubedata.InternalVNFID + "-" + kubedata.ServiceAccountData.Name
+	krd.SetOwnership(&kubedata.ServiceAccountData.ObjectMeta, kubedata)
+
+	return nil
+}
+
+// ValidateResource checks that the YAML file describes a ServiceAccount without creating it
+func ValidateResource(kubedata *krd.GenericKubeResourceData) error {
+	return readServiceAccount(kubedata)
+}
+
+// CreateResource object in a specific Kubernetes ServiceAccount
+func CreateResource(kubedata *krd.GenericKubeResourceData, kubeclient *kubernetes.Clientset) (string, error) {
+	err := readServiceAccount(kubedata)
+	if err != nil {
+		return "", err
+	}
+
+	result, err := kubeclient.CoreV1().ServiceAccounts(kubedata.Namespace).Create(kubedata.ServiceAccountData)
+	if err != nil {
+		return "", pkgerrors.Wrap(err, "Create ServiceAccount error")
+	}
+
+	return result.GetObjectMeta().GetName(), nil
+}
+
+// UpdateResource updates an existing Kubernetes ServiceAccount in place or creates it
+// when it is not present yet. The token secrets generated for it are kept.
+func UpdateResource(kubedata *krd.GenericKubeResourceData, kubeclient *kubernetes.Clientset) (string, error) {
+	err := readServiceAccount(kubedata)
+	if err != nil {
+		return "", err
+	}
+
+	serviceAccounts := kubeclient.CoreV1().ServiceAccounts(kubedata.Namespace)
+
+	existing, err := serviceAccounts.Get(kubedata.ServiceAccountData.Name, metaV1.GetOptions{})
+	if err != nil {
+		if !k8serrors.IsNotFound(err) {
+			return "", pkgerrors.Wrap(err, "Get ServiceAccount error")
+		}
+
+		log.Println("Creating serviceaccount: " + kubedata.ServiceAccountData.Name)
+		result, err := serviceAccounts.Create(kubedata.ServiceAccountData)
+		if err != nil {
+			return "", pkgerrors.Wrap(err, "Create ServiceAccount error")
+		}
+		return result.GetObjectMeta().GetName(), nil
+	}
+
+	log.Println("Updating serviceaccount: " + kubedata.ServiceAccountData.Name)
+	kubedata.ServiceAccountData.ResourceVersion = existing.ResourceVersion
+	if len(kubedata.ServiceAccountData.Secrets) == 0 {
+		kubedata.ServiceAccountData.Secrets = existing.Secrets
+	}
+
+	result, err := serviceAccounts.Update(kubedata.ServiceAccountData)
+	if err != nil {
+		return "", pkgerrors.Wrap(err, "Update ServiceAccount error")
+	}
+
+	return result.GetObjectMeta().GetName(), nil
+}
+
+// ListResources of existing serviceaccounts hosted in a specific Kubernetes namespace
+func ListResources(limit int64, namespace string, kubeclient *kubernetes.Clientset) (*[]string, error) {
+	if namespace == "" {
+		namespace = "default"
+	}
+
+	opts := metaV1.ListOptions{
+		Limit: limit,
+	}
+	opts.APIVersion = "v1"
+	opts.Kind = "ServiceAccount"
+
+	list, err := kubeclient.CoreV1().ServiceAccounts(namespace).List(opts)
+	if err != nil {
+		return nil, pkgerrors.Wrap(err, "Get ServiceAccount list error")
+	}
+
+	result := make([]string, 0, limit)
+	if list != nil {
+		for _, serviceAccount := range list.Items {
+			result = append(result, serviceAccount.Name)
+		}
+	}
+
+	return &result, nil
+}
+
+// ListOwnedResources returns the metadata of the serviceaccounts created by the plugin in all namespaces
+func ListOwnedResources(kubeclient *kubernetes.Clientset) ([]metaV1.ObjectMeta, error) {
+	opts := metaV1.ListOptions{
+		LabelSelector: krd.VNFIDLabel,
+	}
+
+	list, err := kubeclient.CoreV1().ServiceAccounts(metaV1.NamespaceAll).List(opts)
+	if err != nil {
+		return nil, pkgerrors.Wrap(err, "Get ServiceAccount list error")
+	}
+
+	var result []metaV1.ObjectMeta
+	for _, item := range list.Items {
+		result = append(result, item.ObjectMeta)
+	}
+
+	return result, nil
+}
+
+// DeleteResource deletes an existing Kubernetes serviceaccount
+func DeleteResource(name string, namespace string, kubeclient *kubernetes.Clientset) error {
+	if namespace == "" {
+		namespace = "default"
+	}
+
+	log.Println("Deleting serviceaccount: " + name)
+
+	deletePolicy := metaV1.DeletePropagationForeground
+	err := kubeclient.CoreV1().ServiceAccounts(namespace).Delete(name, &metaV1.DeleteOptions{
+		PropagationPolicy: &deletePolicy,
+	})
+	if err != nil {
+		return pkgerrors.Wrap(err, "Delete ServiceAccount error")
+	}
+
+	return nil
+}
+
+// GetResource existing serviceaccount hosted in a specific Kubernetes namespace
+func GetResource(name string, namespace string, kubeclient *kubernetes.Clientset) (string, error) {
+	if namespace == "" {
+		namespace = "default"
+	}
+
+	_, err := kubeclient.CoreV1().ServiceAccounts(namespace).Get(name, metaV1.GetOptions{})
+	if err != nil {
+		if k8serrors.IsNotFound(err) {
+			return "", nil
+		}
+		return "", pkgerrors.Wrap(err, "Get ServiceAccount error")
+	}
+
+	return name, nil
+}
+
+// IsReady checks if a ServiceAccount exists, it is usable as soon as it is created
+func IsReady(name string, namespace string, kubeclient *kubernetes.Clientset) (bool, error) {
+	found, err := GetResource(name, namespace, kubeclient)
+	if err != nil {
+		return false, err
+	}
+
+	return found != "", nil
+}
+
+// GetResourceStatus returns the live state of a ServiceAccount
+func GetResourceStatus(name string, namespace string, kubeclient *kubernetes.Clientset) (*krd.ResourceStatus, error) {
+	if namespace == "" {
+		namespace = "default"
+	}
+
+	status := &krd.ResourceStatus{Name: name}
+
+	found, err := GetResource(name, namespace, kubeclient)
+	if err != nil {
+		return nil, err
+	}
+	if found == "" {
+		return status, nil
+	}
+
+	status.Present = true
+	status.Ready = true
+
+	status.Events, err = krd.GetRecentEvents(name, "ServiceAccount", namespace, kubeclient)
+	if err != nil {
+		return nil, err
+	}
+
+	return status, nil
+}
+
+// DiffResource checks that the ServiceAccount described in kubedata is running
+// in the cluster
+func DiffResource(kubedata *krd.GenericKubeResourceData, kubeclient *kubernetes.Clientset) (*krd.ResourceDrift, error) {
+	err := readServiceAccount(kubedata)
+	if err != nil {
+		return nil, err
+	}
+
+	drift := &krd.ResourceDrift{Name: kubedata.ServiceAccountData.Name}
+
+	found, err := GetResource(drift.Name, kubedata.Namespace, kubeclient)
+	if err != nil {
+		return nil, err
+	}
+	drift.Missing = found == ""
+
+	return drift, nil
+}
@@ -0,0 +1,297 @@
+package main
+
+import (
+	"fmt"
+	"log"
+
+	"k8s.io/client-go/kubernetes"
+
+	pkgerrors "github.com/pkg/errors"
+
+	appsV1 "k8s.io/api/apps/v1"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	metaV1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/scheme"
+
+	"k8-plugin-multicloud/krd"
+)
+
+// readStatefulSet reads and decodes the StatefulSet YAML file referenced by kubedata
+func readStatefulSet(kubedata *krd.GenericKubeResourceData) error {
+	if kubedata.Namespace == "" {
+		kubedata.Namespace = "default"
+	}
+
+	log.Println("Reading statefulset YAML")
+	rawBytes, err := krd.ReadYAML(kubedata)
+	if err != nil {
+		return pkgerrors.Wrap(err, "StatefulSet YAML file read error")
+	}
+
+	log.Println("Decoding statefulset YAML")
+	decode := scheme.Codecs.UniversalDeserializer().Decode
+	obj, _, err := decode(rawBytes, nil, nil)
+	if err != nil {
+		return pkgerrors.Wrap(err, "Deserialize statefulset error")
+	}
+
+	switch o := obj.(type) {
+	case *appsV1.StatefulSet:
+		kubedata.StatefulSetData = o
+	default:
+		return pkgerrors.New(kubedata.YamlFilePath + " contains another resource different than StatefulSet")
+	}
+
+	kubedata.StatefulSetData.Namespace = kubedata.Namespace
+	kubedata.StatefulSetData.Name = kubedata.InternalVNFID + "-" + kubedata.StatefulSetData.Name
+	krd.SetOwnership(&kubedata.StatefulSetData.ObjectMeta, kubedata)
+
+	return nil
+}
+
+// ValidateResource checks that the YAML file describes a StatefulSet without creating it
+func ValidateResource(kubedata *krd.GenericKubeResourceData) error {
+	return readStatefulSet(kubedata)
+}
+
+// CreateResource object in a specific Kubernetes StatefulSet
+func CreateResource(kubedata *krd.GenericKubeResourceData, kubeclient *kubernetes.Clientset) (string, error) {
+	err := readStatefulSet(kubedata)
+	if err != nil {
+		return "", err
+	}
+
+	result, err := kubeclient.AppsV1().StatefulSets(kubedata.Namespace).Create(kubedata.StatefulSetData)
+	if err != nil {
+		return "", pkgerrors.Wrap(err, "Create StatefulSet error")
+	}
+
+	return result.GetObjectMeta().GetName(), nil
+}
+
+// UpdateResource updates an existing Kubernetes StatefulSet in place or creates it
+// when it is not present yet
+func UpdateResource(kubedata *krd.GenericKubeResourceData, kubeclient *kubernetes.Clientset) (string, error) {
+	err := readStatefulSet(kubedata)
+	if err != nil {
+		return "", err
+	}
+
+	statefulSets := kubeclient.AppsV1().StatefulSets(kubedata.Namespace)
+
+	existing, err := statefulSets.Get(kubedata.StatefulSetData.Name, metaV1.GetOptions{})
+	if err != nil {
+		if !k8serrors.IsNotFound(err) {
+			return "", pkgerrors.Wrap(err, "Get StatefulSet error")
+		}
+
+		log.Println("Creating statefulset: " + kubedata.StatefulSetData.Name)
+		result, err := statefulSets.Create(kubedata.StatefulSetData)
+		if err != nil {
+			return "", pkgerrors.Wrap(err, "Create StatefulSet error")
+		}
+		return result.GetObjectMeta().GetName(), nil
+	}
+
+	log.Println("Updating statefulset: " + kubedata.StatefulSetData.Name)
+	kubedata.StatefulSetData.ResourceVersion = existing.ResourceVersion
+
+	result, err := statefulSets.Update(kubedata.StatefulSetData)
+	if err != nil {
+		return "", pkgerrors.Wrap(err, "Update StatefulSet error")
+	}
+
+	return result.GetObjectMeta().GetName(), nil
+}
+
+// ListResources of existing statefulsets hosted in a specific Kubernetes namespace
+func ListResources(limit int64, namespace string, kubeclient *kubernetes.Clientset) (*[]string, error) {
+	if namespace == "" {
+		namespace = "default"
+	}
+
+	opts := metaV1.ListOptions{
+		Limit: limit,
+	}
+	opts.APIVersion = "apps/v1"
+	opts.Kind = "StatefulSet"
+
+	list, err := kubeclient.AppsV1().StatefulSets(namespace).List(opts)
+	if err != nil {
+		return nil, pkgerrors.Wrap(err, "Get StatefulSet list error")
+	}
+
+	result := make([]string, 0, limit)
+	if list != nil {
+		for _, statefulSet := range list.Items {
+			result = append(result, statefulSet.Name)
+		}
+	}
+
+	return &result, nil
+}
+
+// ListOwnedResources returns the metadata of the statefulsets created by the plugin in all namespaces
+func ListOwnedResources(kubeclient *kubernetes.Clientset) ([]metaV1.ObjectMeta, error) {
+	opts := metaV1.ListOptions{
+		LabelSelector: krd.VNFIDLabel,
+	}
+
+	list, err := kubeclient.AppsV1().StatefulSets(metaV1.NamespaceAll).List(opts)
+	if err != nil {
+		return nil, pkgerrors.Wrap(err, "Get StatefulSet list error")
+	}
+
+	var result []metaV1.ObjectMeta
+	for _, item := range list.Items {
+		result = append(result, item.ObjectMeta)
+	}
+
+	return result, nil
+}
+
+// DeleteResource deletes an existing Kubernetes statefulset and its pods. The
+// volumes claimed from its volumeClaimTemplates are kept by Kubernetes.
+func DeleteResource(name string, namespace string, kubeclient *kubernetes.Clientset) error {
+	if namespace == "" {
+		namespace = "default"
+	}
+
+	log.Println("Deleting statefulset: " + name)
+
+	deletePolicy := metaV1.DeletePropagationForeground
+	err := kubeclient.AppsV1().StatefulSets(namespace).Delete(name, &metaV1.DeleteOptions{
+		PropagationPolicy: &deletePolicy,
+	})
+	if err != nil {
+		return pkgerrors.Wrap(err, "Delete StatefulSet error")
+	}
+
+	return nil
+}
+
+// GetResource existing statefulset hosted in a specific Kubernetes namespace
+func GetResource(name string, namespace string, kubeclient *kubernetes.Clientset) (string, error) {
+	if namespace == "" {
+		namespace = "default"
+	}
+
+	_, err := kubeclient.AppsV1().StatefulSets(namespace).Get(name, metaV1.GetOptions{})
+	if err != nil {
+		if k8serrors.IsNotFound(err) {
+			return "", nil
+		}
+		return "", pkgerrors.Wrap(err, "Get StatefulSet error")
+	}
+
+	return name, nil
+}
+
+// isAvailable checks if all the replicas of a StatefulSet are ready and run
+// its latest revision
+func isAvailable(statefulSet *appsV1.StatefulSet) bool {
+	// Status not updated yet for the latest spec
+	if statefulSet.Status.ObservedGeneration < statefulSet.Generation {
+		return false
+	}
+
+	if statefulSet.Status.UpdateRevision != "" && statefulSet.Status.CurrentRevision != statefulSet.Status.UpdateRevision {
+		return false
+	}
+
+	return statefulSet.Status.ReadyReplicas == desiredReplicas(statefulSet)
+}
+
+func desiredReplicas(statefulSet *appsV1.StatefulSet) int32 {
+	if statefulSet.Spec.Replicas != nil {
+		return *statefulSet.Spec.Replicas
+	}
+	return 1
+}
+
+// IsReady checks if all the replicas of a StatefulSet are ready
+func IsReady(name string, namespace string, kubeclient *kubernetes.Clientset) (bool, error) {
+	if namespace == "" {
+		namespace = "default"
+	}
+
+	statefulSet, err := kubeclient.AppsV1().StatefulSets(namespace).Get(name, metaV1.GetOptions{})
+	if err != nil {
+		return false, pkgerrors.Wrap(err, "Get StatefulSet error")
+	}
+
+	return isAvailable(statefulSet), nil
+}
+
+// GetResourceStatus returns the live state of a StatefulSet and its pods
+func GetResourceStatus(name string, namespace string, kubeclient *kubernetes.Clientset) (*krd.ResourceStatus, error) {
+	if namespace == "" {
+		namespace = "default"
+	}
+
+	status := &krd.ResourceStatus{Name: name}
+
+	statefulSet, err := kubeclient.AppsV1().StatefulSets(namespace).Get(name, metaV1.GetOptions{})
+	if err != nil {
+		if k8serrors.IsNotFound(err) {
+			return status, nil
+		}
+		return nil, pkgerrors.Wrap(err, "Get StatefulSet error")
+	}
+
+	status.Present = true
+	status.Ready = isAvailable(statefulSet)
+	status.Replicas = desiredReplicas(statefulSet)
+	status.AvailableReplicas = statefulSet.Status.ReadyReplicas
+
+	status.Pods, err = krd.GetPodStatuses(statefulSet.Spec.Selector, namespace, kubeclient)
+	if err != nil {
+		return nil, err
+	}
+
+	status.Events, err = krd.GetRecentEvents(name, "StatefulSet", namespace, kubeclient)
+	if err != nil {
+		return nil, err
+	}
+
+	return status, nil
+}
+
+// DiffResource compares the StatefulSet described in kubedata with the one
+// running in the cluster
+func DiffResource(kubedata *krd.GenericKubeResourceData, kubeclient *kubernetes.Clientset) (*krd.ResourceDrift, error) {
+	err := readStatefulSet(kubedata)
+	if err != nil {
+		return nil, err
+	}
+
+	expected := kubedata.StatefulSetData
+	drift := &krd.ResourceDrift{Name: expected.Name}
+
+	live, err := kubeclient.AppsV1().StatefulSets(kubedata.Namespace).Get(expected.Name, metaV1.GetOptions{})
+	if err != nil {
+		if k8serrors.IsNotFound(err) {
+			drift.Missing = true
+			return drift, nil
+		}
+		return nil, pkgerrors.Wrap(err, "Get StatefulSet error")
+	}
+
+	if desiredReplicas(expected) != desiredReplicas(live) {
+		drift.Differences = append(drift.Differences, fmt.Sprintf("spec.replicas: expected %d, found %d",
+			desiredReplicas(expected), desiredReplicas(live)))
+	}
+
+	liveImages := make(map[string]string)
+	for _, container := range live.Spec.Template.Spec.Containers {
+		liveImages[container.Name] = container.Image
+	}
+	for _, container := range expected.Spec.Template.Spec.Containers {
+		if image, ok := liveImages[container.Name]; !ok || image != container.Image {
+			drift.Differences = append(drift.Differences, fmt.Sprintf("container %s image: expected %s, found %s",
+				container.Name, container.Image, image))
+		}
+	}
+
+	return drift, nil
+}